@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+type exportCommand struct {
+	modemFilter
+	Format    string `long:"format" choice:"csv" choice:"xlsx" choice:"json" default:"csv" description:"file format"`
+	Columns   string `long:"columns" description:"comma separated columns in output order, the shipping manifest columns if not set"`
+	Limit     int    `long:"limit" description:"export at most this many modems, all if not set"`
+	File      string `short:"f" long:"file" description:"file to write, standard output if not set"`
+	Passwords bool   `long:"passwords" description:"add the admin user and password of each modem for a customer handover, needs the admin role"`
+}
+
+func (c *exportCommand) Execute(args []string) error {
+	q, err := c.query()
+	if err != nil {
+		return err
+	}
+	q.Limit = c.Limit
+
+	var columns []string
+	if c.Columns != "" {
+		columns = strings.Split(c.Columns, ",")
+	}
+
+	cl, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	if c.File == "" {
+		if c.Format == "xlsx" {
+			return fmt.Errorf("use --file to write an xlsx export")
+		}
+		_, err := cl.ExportModems(context.Background(), q, c.Format, columns, c.Passwords, os.Stdout)
+		return err
+	}
+
+	f, err := os.Create(c.File)
+	if err != nil {
+		return err
+	}
+	n, err := cl.ExportModems(context.Background(), q, c.Format, columns, c.Passwords, f)
+	if err != nil {
+		f.Close()
+		os.Remove(c.File)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d bytes to %s\n", n, c.File)
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ebobo/modem_prod_go/pkg/client"
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+// maxUnexpectedShown is how many unexpected modems the table output lists
+const maxUnexpectedShown = 10
+
+type importCommand struct {
+	Upsert bool   `long:"upsert" description:"replace units that are expected already instead of skipping them"`
+	DryRun bool   `long:"dry-run" description:"validate and show what would be imported without storing anything"`
+	Batch  string `long:"batch" description:"batch of the units that have none in the file"`
+	Args   struct {
+		File string `positional-arg-name:"file" description:"CSV file with a header row, or JSON file if it ends in .json"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func (c *importCommand) Execute(args []string) error {
+	f, err := os.Open(c.Args.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	opts := client.ImportOptions{Mode: model.ImportInsert, DryRun: c.DryRun, Batch: c.Batch}
+	if c.Upsert {
+		opts.Mode = model.ImportUpsert
+	}
+
+	cl, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	var result model.ImportResult
+	if strings.EqualFold(filepath.Ext(c.Args.File), ".json") {
+		var units []model.ExpectedUnit
+		if err := json.NewDecoder(f).Decode(&units); err != nil {
+			return fmt.Errorf("%s: %w", c.Args.File, err)
+		}
+		result, err = cl.ImportModems(context.Background(), units, opts)
+	} else {
+		result, err = cl.ImportModemsCSV(context.Background(), f, opts)
+	}
+	if err != nil {
+		var apiErr *client.Error
+		if errors.As(err, &apiErr) {
+			for _, d := range apiErr.Details {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", d.Field, d.Message)
+			}
+		}
+		return err
+	}
+	return writeImportResult(os.Stdout, opt.Output, result)
+}
+
+// writeImportResult writes the rows of an import and a summary
+func writeImportResult(w io.Writer, format string, result model.ImportResult) error {
+	switch format {
+	case "json":
+		return writeJSON(w, result)
+
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"row", "mac_address", "action", "discovered", "mismatches", "errors"})
+		for _, r := range result.Rows {
+			cw.Write([]string{fmt.Sprint(r.Row), r.MacAddress, r.Action, fmt.Sprint(r.Discovered), strings.Join(r.Mismatches, " "), formatFieldErrors(r.Errors)})
+		}
+		cw.Flush()
+		return cw.Error()
+
+	default:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ROW\tMAC_ADDRESS\tACTION\tDISCOVERED\tMISMATCHES\tERRORS")
+		for _, r := range result.Rows {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%t\t%s\t%s\n", r.Row, r.MacAddress, r.Action, r.Discovered, strings.Join(r.Mismatches, " "), formatFieldErrors(r.Errors))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+
+		status := "imported"
+		if !result.Committed {
+			status = "nothing imported"
+		}
+		if result.DryRun {
+			status = "dry run, " + status
+		}
+		fmt.Fprintf(w, "\n%s: %d inserted, %d updated, %d unchanged, %d skipped, %d invalid\n",
+			status, result.Inserted, result.Updated, result.Unchanged, result.Skipped, result.Invalid)
+		if n := len(result.Unexpected); n > 0 {
+			shown := result.Unexpected
+			if n > maxUnexpectedShown {
+				shown = append(shown[:maxUnexpectedShown:maxUnexpectedShown], "...")
+			}
+			fmt.Fprintf(w, "%d discovered modems are not expected: %s\n", n, strings.Join(shown, ", "))
+		}
+		return nil
+	}
+}
+
+func formatFieldErrors(errs []model.FieldError) string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Field + " " + e.Message
+	}
+	return strings.Join(msgs, "; ")
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/ebobo/modem_prod_go/pkg/client"
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+type printLabelCommand struct {
+	Template string `long:"template" description:"label template, the server's default if not set"`
+	Printer  string `long:"printer" description:"printer address, the server's printer if not set"`
+	Copies   int    `long:"copies" default:"1" description:"number of labels per modem"`
+	ZPL      bool   `long:"zpl" description:"write the ZPL of the labels to standard output instead of printing them"`
+	macArgs
+}
+
+func (c *printLabelCommand) Execute(args []string) error {
+	if c.ZPL {
+		cl, err := newClient()
+		if err != nil {
+			return err
+		}
+		for _, mac := range c.Args.MacAddresses {
+			ctx, cancel := requestContext()
+			zpl, err := cl.RenderLabel(ctx, mac, c.Template)
+			cancel()
+			if err != nil {
+				return fmt.Errorf("%s: %w", mac, err)
+			}
+			os.Stdout.Write(zpl)
+		}
+		return nil
+	}
+
+	req := client.PrintRequest{Template: c.Template, Printer: c.Printer, Copies: c.Copies}
+	return forEachModem(c.Args.MacAddresses, "label queued", func(cl *client.Client, mac string) error {
+		ctx, cancel := requestContext()
+		defer cancel()
+		_, err := cl.PrintLabel(ctx, mac, req)
+		return err
+	})
+}
+
+type reprintLabelCommand struct {
+	Printer string `long:"printer" description:"printer address, the printer of the job if not set"`
+	Copies  int    `long:"copies" default:"1" description:"number of labels"`
+	Args    struct {
+		JobIDs []int64 `positional-arg-name:"job" required:"1"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func (c *reprintLabelCommand) Execute(args []string) error {
+	cl, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	req := client.PrintRequest{Printer: c.Printer, Copies: c.Copies}
+	failed := 0
+	for _, id := range c.Args.JobIDs {
+		ctx, cancel := requestContext()
+		job, err := cl.ReprintLabel(ctx, id, req)
+		cancel()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "job %d: %v\n", id, err)
+			failed++
+			continue
+		}
+		fmt.Printf("job %d: reprint queued as job %d for %s\n", id, job.ID, job.MacAddress)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d reprints failed", failed, len(c.Args.JobIDs))
+	}
+	return nil
+}
+
+type labelJobsCommand struct {
+	MacAddress string `long:"mac" description:"only jobs of this modem"`
+	Status     string `long:"status" choice:"queued" choice:"printed" choice:"failed" description:"only jobs with this status"`
+}
+
+func (c *labelJobsCommand) Execute(args []string) error {
+	cl, err := newClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := requestContext()
+	defer cancel()
+
+	jobs, err := cl.ListLabelJobs(ctx, c.MacAddress, c.Status)
+	if err != nil {
+		return err
+	}
+	return writeLabelJobs(os.Stdout, opt.Output, jobs)
+}
+
+// writeLabelJobs writes label print jobs in the given output format
+func writeLabelJobs(w io.Writer, format string, jobs []model.LabelJob) error {
+	switch format {
+	case "json":
+		return writeJSON(w, jobs)
+
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"id", "mac_address", "template", "printer", "copies", "status", "error", "reprint_of", "actor", "created_at", "printed_at"})
+		for _, j := range jobs {
+			cw.Write([]string{strconv.FormatInt(j.ID, 10), j.MacAddress, j.Template, j.Printer, strconv.Itoa(j.Copies), j.Status, j.Error,
+				strconv.FormatInt(j.ReprintOf, 10), j.Actor, formatTime(j.CreatedAt), formatTime(j.PrintedAt)})
+		}
+		cw.Flush()
+		return cw.Error()
+
+	default:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tMAC_ADDRESS\tTEMPLATE\tPRINTER\tCOPIES\tSTATUS\tCREATED\tERROR")
+		for _, j := range jobs {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n", j.ID, j.MacAddress, j.Template, j.Printer, j.Copies, j.Status, formatTime(j.CreatedAt), j.Error)
+		}
+		return tw.Flush()
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/ebobo/modem_prod_go/pkg/client"
+)
+
+var opt struct {
+	Server  string        `short:"s" long:"server" env:"MODEMCTL_SERVER" default:"http://localhost:9090" description:"address of the modem production server"`
+	APIKey  string        `long:"api-key" env:"MODEMCTL_API_KEY" description:"api key"`
+	Output  string        `short:"o" long:"output" choice:"table" choice:"json" choice:"csv" default:"table" description:"output format"`
+	Timeout time.Duration `long:"timeout" default:"30s" description:"timeout of a request"`
+
+	CACert string `long:"ca-cert" env:"MODEMCTL_CA_CERT" description:"CA certificate to verify the server with"`
+	Cert   string `long:"cert" env:"MODEMCTL_CERT" description:"client certificate"`
+	Key    string `long:"key" env:"MODEMCTL_KEY" description:"client certificate key"`
+}
+
+func main() {
+	parser := flags.NewParser(&opt, flags.HelpFlag|flags.PassDoubleDash)
+	parser.LongDescription = "modemctl talks to the REST API of the modem production server"
+
+	commands := []struct {
+		name, short, long string
+		data              interface{}
+	}{
+		{"list", "List modems", "List the modems matching the filters", &listCommand{}},
+		{"show", "Show a modem", "Show a modem and its change history", &showCommand{}},
+		{"set-state", "Set the state of a modem", "Set the state of a modem to unknown, normal, busy or error", &setStateCommand{}},
+		{"reread", "Read modems again", "Make the server read the info of the modems again", &rereadCommand{}},
+		{"reupgrade", "Upgrade modems again", "Make the server upgrade the modems again", &reupgradeCommand{}},
+		{"power-cycle", "Power cycle modems", "Turn the PoE power of the modems' switch ports off and on", &powerCycleCommand{}},
+		{"print-label", "Print modem labels", "Print the labels of the modems, with IMEI, serial and MAC barcodes", &printLabelCommand{}},
+		{"reprint-label", "Reprint labels", "Print the labels of earlier print jobs again, as they were printed then", &reprintLabelCommand{}},
+		{"label-jobs", "List label print jobs", "List the most recent label print jobs", &labelJobsCommand{}},
+		{"profiles", "List config profiles", "List the config profiles that can be applied to modems", &profilesCommand{}},
+		{"put-profile", "Add or replace a config profile", "Save a config profile from a YAML or JSON file with its description, UCI settings and admin password", &putProfileCommand{}},
+		{"provision", "Apply config profiles to modems", "Apply the config profile of the modems' work order, or the given one, over SSH and verify it", &provisionCommand{}},
+		{"config-diff", "Compare a modem's config with its profile", "Read the settings of the config profile from a modem and show those that differ", &configDiffCommand{}},
+		{"password", "Show the admin password of a modem", "Show the admin password last set on a modem when it was provisioned, needs the admin role", &passwordCommand{}},
+		{"test", "Run functional tests on modems", "Run the configured functional test steps on modems over SSH, the outcome is saved as their test status", &testCommand{}},
+		{"test-results", "Show the test results of a modem", "Show the steps, measurements and errors of the latest test run of a modem, or all runs", &testResultsCommand{}},
+		{"sim-activate", "Activate SIMs", "Queue the activation of the modems' SIMs at the carrier, failed requests are retried", &simActivateCommand{}},
+		{"sim-suspend", "Suspend SIMs", "Queue the suspension of the modems' SIMs at the carrier", &simSuspendCommand{}},
+		{"sim-status", "Show the status of SIMs", "Show the status of the modems' SIMs as the carrier reports it now", &simStatusCommand{}},
+		{"sim-jobs", "List SIM jobs", "List the most recent SIM activation and suspension jobs", &simJobsCommand{}},
+		{"export", "Export modems", "Write the modems matching the filters to a CSV, Excel or JSON file, e.g. a shipping manifest", &exportCommand{}},
+		{"report", "Write production travelers", "Write the traveler of a modem, or a zip file with those of a work order, as PDF or HTML for the customer", &reportCommand{}},
+		{"import", "Import expected units", "Import the list of MACs, serials and IMEIs a supplier sends before a batch arrives", &importCommand{}},
+		{"reconcile", "Compare expected and discovered units", "List the expected units that are missing or differ from the discovered modems, and the modems that are not expected", &reconcileCommand{}},
+		{"watch", "Watch live events", "Print events as they happen until interrupted", &watchCommand{}},
+	}
+	for _, c := range commands {
+		if _, err := parser.AddCommand(c.name, c.short, c.long, c.data); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	if _, err := parser.Parse(); err != nil {
+		var flagsErr *flags.Error
+		if errors.As(err, &flagsErr) {
+			if flagsErr.Type == flags.ErrHelp {
+				fmt.Println(err)
+				os.Exit(0)
+			}
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+// newClient returns a client for the configured server
+func newClient() (*client.Client, error) {
+	c := client.New(opt.Server, opt.APIKey)
+	c.HTTPClient.Timeout = opt.Timeout
+
+	if opt.CACert == "" && opt.Cert == "" {
+		return c, nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if opt.CACert != "" {
+		pem, err := os.ReadFile(opt.CACert)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", opt.CACert)
+		}
+	}
+	if opt.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(opt.Cert, opt.Key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	c.HTTPClient.Transport = transport
+	return c, nil
+}
+
+// requestContext bounds a single request by the configured timeout
+func requestContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), opt.Timeout)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ebobo/modem_prod_go/pkg/client"
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+// modemFilter are the modem filter options shared by the commands listing modems
+type modemFilter struct {
+	State       string `long:"state" description:"state name or number"`
+	Model       string `long:"model" description:"model"`
+	Firmware    string `long:"firmware" description:"firmware version"`
+	Upgraded    string `long:"upgraded" choice:"true" choice:"false" description:"upgraded or not"`
+	SIMProvider string `long:"sim-provider" description:"SIM provider"`
+	TestStatus  string `long:"test-status" choice:"running" choice:"passed" choice:"failed" description:"outcome of the latest functional test"`
+	SwitchPort  string `long:"switch-port" description:"switch port"`
+	Sort        string `long:"sort" description:"field to sort by, e.g. --sort=-switch_port sorts descending"`
+}
+
+func (f modemFilter) query() (client.ModemQuery, error) {
+	q := client.ModemQuery{
+		Model:       f.Model,
+		Firmware:    f.Firmware,
+		SIMProvider: f.SIMProvider,
+		TestStatus:  f.TestStatus,
+		SortBy:      strings.TrimPrefix(f.Sort, "-"),
+		Descending:  strings.HasPrefix(f.Sort, "-"),
+	}
+	if f.State != "" {
+		state, err := model.ParseState(f.State)
+		if err != nil {
+			return q, err
+		}
+		q.State = &state
+	}
+	if f.Upgraded != "" {
+		upgraded := f.Upgraded == "true"
+		q.Upgraded = &upgraded
+	}
+	if f.SwitchPort != "" {
+		port, err := strconv.Atoi(f.SwitchPort)
+		if err != nil {
+			return q, fmt.Errorf("invalid switch port %q", f.SwitchPort)
+		}
+		q.SwitchPort = &port
+	}
+	return q, nil
+}
+
+type listCommand struct {
+	modemFilter
+	Limit int `long:"limit" description:"show at most this many modems, all if not set"`
+}
+
+func (c *listCommand) Execute(args []string) error {
+	q, err := c.query()
+	if err != nil {
+		return err
+	}
+	cl, err := newClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := requestContext()
+	defer cancel()
+
+	var modems []model.Modem
+	if c.Limit > 0 {
+		q.Limit = c.Limit
+		page, err := cl.ListModems(ctx, q)
+		if err != nil {
+			return err
+		}
+		modems = page.Modems
+		if opt.Output == "table" && page.Total > len(modems) {
+			defer fmt.Fprintf(os.Stderr, "showing %d of %d modems\n", len(modems), page.Total)
+		}
+	} else {
+		modems, err = cl.ListAllModems(ctx, q)
+		if err != nil {
+			return err
+		}
+	}
+	return writeModems(os.Stdout, opt.Output, modems)
+}
+
+// macArgs are the mac addresses commands act on
+type macArgs struct {
+	Args struct {
+		MacAddresses []string `positional-arg-name:"mac" required:"1"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+type showCommand struct {
+	History bool `long:"history" description:"also show the change history"`
+	Args    struct {
+		MacAddress string `positional-arg-name:"mac"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func (c *showCommand) Execute(args []string) error {
+	cl, err := newClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := requestContext()
+	defer cancel()
+
+	modem, err := cl.GetModem(ctx, c.Args.MacAddress)
+	if err != nil {
+		return err
+	}
+	if err := writeModem(os.Stdout, opt.Output, modem); err != nil {
+		return err
+	}
+
+	if !c.History {
+		return nil
+	}
+	events, err := cl.ModemHistory(ctx, c.Args.MacAddress, 0, 0)
+	if err != nil {
+		return err
+	}
+	if opt.Output == "table" {
+		fmt.Println()
+	}
+	return writeHistory(os.Stdout, opt.Output, events)
+}
+
+type setStateCommand struct {
+	Args struct {
+		MacAddress string `positional-arg-name:"mac"`
+		State      string `positional-arg-name:"state"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func (c *setStateCommand) Execute(args []string) error {
+	state, err := model.ParseState(c.Args.State)
+	if err != nil {
+		return err
+	}
+	cl, err := newClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := requestContext()
+	defer cancel()
+
+	if err := cl.SetModemState(ctx, c.Args.MacAddress, state); err != nil {
+		return err
+	}
+	fmt.Printf("%s: state set to %s\n", c.Args.MacAddress, model.StateName(state))
+	return nil
+}
+
+type rereadCommand struct{ macArgs }
+
+func (c *rereadCommand) Execute(args []string) error {
+	return forEachModem(c.Args.MacAddresses, "read requested", func(cl *client.Client, mac string) error {
+		ctx, cancel := requestContext()
+		defer cancel()
+		_, err := cl.RereadModem(ctx, mac)
+		return err
+	})
+}
+
+type reupgradeCommand struct{ macArgs }
+
+func (c *reupgradeCommand) Execute(args []string) error {
+	return forEachModem(c.Args.MacAddresses, "upgrade requested", func(cl *client.Client, mac string) error {
+		ctx, cancel := requestContext()
+		defer cancel()
+		_, err := cl.ReupgradeModem(ctx, mac)
+		return err
+	})
+}
+
+type powerCycleCommand struct{ macArgs }
+
+func (c *powerCycleCommand) Execute(args []string) error {
+	return forEachModem(c.Args.MacAddresses, "power cycle started", func(cl *client.Client, mac string) error {
+		ctx, cancel := requestContext()
+		defer cancel()
+		return cl.PowerCycleModem(ctx, mac)
+	})
+}
+
+// forEachModem runs action for each mac address and reports the outcome of each.
+// It continues after failures and returns an error if any of them failed.
+func forEachModem(macs []string, done string, action func(cl *client.Client, mac string) error) error {
+	cl, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, mac := range macs {
+		if err := action(cl, mac); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", mac, err)
+			failed++
+			continue
+		}
+		fmt.Printf("%s: %s\n", mac, done)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d modems failed", failed, len(macs))
+	}
+	return nil
+}
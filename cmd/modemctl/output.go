@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+// modemColumn is one column of modem output, table columns are a subset of the CSV columns
+type modemColumn struct {
+	header string
+	table  bool
+	value  func(m model.Modem) string
+}
+
+var modemColumns = []modemColumn{
+	{"mac_address", true, func(m model.Modem) string { return m.MacAddress }},
+	{"switch_port", true, func(m model.Modem) string { return strconv.Itoa(m.SwitchPort) }},
+	{"model", true, func(m model.Modem) string { return m.Model }},
+	{"state", true, func(m model.Modem) string { return model.StateName(m.State) }},
+	{"firmware", true, func(m model.Modem) string { return m.Firmware }},
+	{"upgraded", true, func(m model.Modem) string { return strconv.FormatBool(m.Upgraded) }},
+	{"progress", true, func(m model.Modem) string { return strconv.Itoa(m.Progress) }},
+	{"ipv6", false, func(m model.Modem) string { return m.IPV6 }},
+	{"serial", false, func(m model.Modem) string { return m.Serial }},
+	{"kernel", false, func(m model.Modem) string { return m.Kernel }},
+	{"fail_count", false, func(m model.Modem) string { return strconv.Itoa(m.FailCount) }},
+	{"sim_provider", false, func(m model.Modem) string { return m.SIMProvider }},
+	{"sim_status", false, func(m model.Modem) string { return strconv.FormatBool(m.SIMStatus) }},
+	{"test_status", true, func(m model.Modem) string { return m.TestStatus }},
+	{"imei", true, func(m model.Modem) string { return m.IMEI }},
+	{"iccid", false, func(m model.Modem) string { return m.ICCID }},
+	{"imsi", false, func(m model.Modem) string { return m.IMSI }},
+	{"last_updated", true, func(m model.Modem) string { return formatTime(m.LastUpdated) }},
+	{"version", false, func(m model.Modem) string { return strconv.Itoa(m.Version) }},
+}
+
+// writeModems writes modems in the given output format
+func writeModems(w io.Writer, format string, modems []model.Modem) error {
+	switch format {
+	case "json":
+		return writeJSON(w, modems)
+
+	case "csv":
+		cw := csv.NewWriter(w)
+		var header []string
+		for _, c := range modemColumns {
+			header = append(header, c.header)
+		}
+		cw.Write(header)
+		for _, m := range modems {
+			var row []string
+			for _, c := range modemColumns {
+				row = append(row, c.value(m))
+			}
+			cw.Write(row)
+		}
+		cw.Flush()
+		return cw.Error()
+
+	default:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		var header []string
+		for _, c := range modemColumns {
+			if c.table {
+				header = append(header, strings.ToUpper(c.header))
+			}
+		}
+		fmt.Fprintln(tw, strings.Join(header, "\t"))
+		for _, m := range modems {
+			var row []string
+			for _, c := range modemColumns {
+				if c.table {
+					row = append(row, c.value(m))
+				}
+			}
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		return tw.Flush()
+	}
+}
+
+// writeModem writes all fields of one modem
+func writeModem(w io.Writer, format string, modem model.Modem) error {
+	if format != "table" {
+		return writeModems(w, format, []model.Modem{modem})
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, c := range modemColumns {
+		fmt.Fprintf(tw, "%s:\t%s\n", c.header, c.value(modem))
+	}
+	return tw.Flush()
+}
+
+// writeHistory writes the change history of a modem
+func writeHistory(w io.Writer, format string, events []model.ModemEvent) error {
+	switch format {
+	case "json":
+		return writeJSON(w, events)
+
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"timestamp", "field", "old_value", "new_value", "source", "actor"})
+		for _, e := range events {
+			cw.Write([]string{formatTime(e.Timestamp), e.Field, e.OldValue, e.NewValue, e.Source, e.Actor})
+		}
+		cw.Flush()
+		return cw.Error()
+
+	default:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "TIME\tFIELD\tOLD\tNEW\tSOURCE\tACTOR")
+		for _, e := range events {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", formatTime(e.Timestamp), e.Field, e.OldValue, e.NewValue, e.Source, e.Actor)
+		}
+		return tw.Flush()
+	}
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// formatTime formats a unix timestamp in local time
+func formatTime(timestamp int) string {
+	if timestamp == 0 {
+		return "-"
+	}
+	return time.Unix(int64(timestamp), 0).Format("2006-01-02 15:04:05")
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/ebobo/modem_prod_go/pkg/client"
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+type profilesCommand struct{}
+
+func (c *profilesCommand) Execute(args []string) error {
+	cl, err := newClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := requestContext()
+	defer cancel()
+
+	profiles, err := cl.ListConfigProfiles(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch opt.Output {
+	case "json":
+		return writeJSON(os.Stdout, profiles)
+
+	case "csv":
+		cw := csv.NewWriter(os.Stdout)
+		cw.Write([]string{"name", "description", "settings", "admin_password_set", "unique_password", "updated_at"})
+		for _, p := range profiles {
+			cw.Write([]string{p.Name, p.Description, strconv.Itoa(len(p.Settings)), strconv.FormatBool(p.AdminPasswordSet), strconv.FormatBool(p.UniquePassword), formatTime(p.UpdatedAt)})
+		}
+		cw.Flush()
+		return cw.Error()
+
+	default:
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tSETTINGS\tPASSWORD\tUPDATED\tDESCRIPTION")
+		for _, p := range profiles {
+			password := "-"
+			switch {
+			case p.UniquePassword:
+				password = "unique"
+			case p.AdminPasswordSet:
+				password = "set"
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", p.Name, len(p.Settings), password, formatTime(p.UpdatedAt), p.Description)
+		}
+		return tw.Flush()
+	}
+}
+
+type putProfileCommand struct {
+	Args struct {
+		Name string `positional-arg-name:"name" required:"yes"`
+		File string `positional-arg-name:"file" required:"yes" description:"YAML or JSON file with description, settings and admin_password or unique_password, - for standard input"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func (c *putProfileCommand) Execute(args []string) error {
+	var data []byte
+	var err error
+	if c.Args.File == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(c.Args.File)
+	}
+	if err != nil {
+		return err
+	}
+
+	p, err := parseProfile(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", c.Args.File, err)
+	}
+	p.Name = c.Args.Name
+
+	cl, err := newClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := requestContext()
+	defer cancel()
+
+	p, err = cl.PutConfigProfile(ctx, p)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("saved profile %s with %d settings\n", p.Name, len(p.Settings))
+	return nil
+}
+
+// parseProfile parses a profile written as YAML or JSON, with the field names of the API
+func parseProfile(data []byte) (model.ConfigProfile, error) {
+	var p model.ConfigProfile
+
+	// YAML is decoded generically and passed through JSON, so both formats use the JSON field names
+	var v interface{}
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return p, err
+	}
+	if v == nil {
+		return p, errors.New("the profile is empty")
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return p, err
+	}
+	err = json.Unmarshal(b, &p)
+	return p, err
+}
+
+type provisionCommand struct {
+	Profile string `long:"profile" description:"config profile, the profile of each modem's work order if not set"`
+	Order   int64  `long:"order" description:"provision the modems assigned to this work order instead of the given ones"`
+	Args    struct {
+		MacAddresses []string `positional-arg-name:"mac"`
+	} `positional-args:"yes"`
+}
+
+func (c *provisionCommand) Execute(args []string) error {
+	macs, err := orderOrModems(c.Order, c.Args.MacAddresses, "provision")
+	if err != nil {
+		return err
+	}
+
+	return forEachModem(macs, "provisioning started", func(cl *client.Client, mac string) error {
+		ctx, cancel := requestContext()
+		defer cancel()
+		_, err := cl.Provision(ctx, mac, c.Profile)
+		return err
+	})
+}
+
+// orderOrModems returns the given modems, or those assigned to the work order if it is not 0
+func orderOrModems(order int64, macs []string, action string) ([]string, error) {
+	if order == 0 {
+		if len(macs) == 0 {
+			return nil, fmt.Errorf("give the modems to %s, or --order", action)
+		}
+		return macs, nil
+	}
+	if len(macs) > 0 {
+		return nil, errors.New("give either --order or modems, not both")
+	}
+
+	cl, err := newClient()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := requestContext()
+	defer cancel()
+	modems, err := cl.OrderModems(ctx, order)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range modems {
+		macs = append(macs, m.MacAddress)
+	}
+	if len(macs) == 0 {
+		return nil, fmt.Errorf("no modems are assigned to order %d", order)
+	}
+	return macs, nil
+}
+
+type configDiffCommand struct {
+	Profile string `long:"profile" description:"config profile, the profile of the modem's work order if not set"`
+	Args    struct {
+		MacAddress string `positional-arg-name:"mac" required:"yes"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func (c *configDiffCommand) Execute(args []string) error {
+	cl, err := newClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := requestContext()
+	defer cancel()
+
+	diffs, err := cl.ConfigDiff(ctx, c.Args.MacAddress, c.Profile)
+	if err != nil {
+		return err
+	}
+	if err := writeConfigDiffs(os.Stdout, opt.Output, diffs); err != nil {
+		return err
+	}
+
+	differ := 0
+	for _, d := range diffs {
+		if !d.Match {
+			differ++
+		}
+	}
+	if differ > 0 {
+		return fmt.Errorf("%d of %d settings differ", differ, len(diffs))
+	}
+	return nil
+}
+
+// diffStatus describes a compared setting in one word
+func diffStatus(d model.ConfigDiff) string {
+	switch {
+	case d.Match:
+		return "ok"
+	case d.Missing:
+		return "missing"
+	default:
+		return "differs"
+	}
+}
+
+// writeConfigDiffs writes the compared settings of a modem in the given output format
+func writeConfigDiffs(w io.Writer, format string, diffs []model.ConfigDiff) error {
+	switch format {
+	case "json":
+		return writeJSON(w, diffs)
+
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"key", "desired", "actual", "status"})
+		for _, d := range diffs {
+			cw.Write([]string{d.Key, d.Desired, d.Actual, diffStatus(d)})
+		}
+		cw.Flush()
+		return cw.Error()
+
+	default:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "KEY\tDESIRED\tACTUAL\tSTATUS")
+		for _, d := range diffs {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", d.Key, d.Desired, d.Actual, diffStatus(d))
+		}
+		return tw.Flush()
+	}
+}
+
+type passwordCommand struct {
+	Args struct {
+		MacAddress string `positional-arg-name:"mac" required:"yes"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func (c *passwordCommand) Execute(args []string) error {
+	cl, err := newClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := requestContext()
+	defer cancel()
+
+	p, err := cl.GetModemPassword(ctx, c.Args.MacAddress)
+	if err != nil {
+		return err
+	}
+
+	switch opt.Output {
+	case "json":
+		return writeJSON(os.Stdout, p)
+
+	case "csv":
+		cw := csv.NewWriter(os.Stdout)
+		cw.Write([]string{"mac_address", "user", "password", "profile", "set_at"})
+		cw.Write([]string{p.MacAddress, p.User, p.Password, p.Profile, formatTime(p.SetAt)})
+		cw.Flush()
+		return cw.Error()
+
+	default:
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "USER\tPASSWORD\tPROFILE\tSET")
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", p.User, p.Password, p.Profile, formatTime(p.SetAt))
+		return tw.Flush()
+	}
+}
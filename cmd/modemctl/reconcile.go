@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+	"github.com/ebobo/modem_prod_go/pkg/reconcile"
+)
+
+type reconcileCommand struct {
+	Batch string `long:"batch" description:"only report missing and mismatched units of this batch"`
+}
+
+func (c *reconcileCommand) Execute(args []string) error {
+	cl, err := newClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := requestContext()
+	defer cancel()
+
+	report, err := cl.Reconciliation(ctx, c.Batch)
+	if err != nil {
+		return err
+	}
+	return writeReport(os.Stdout, opt.Output, report)
+}
+
+// reportRows flattens a report to one discrepancy per row
+func reportRows(report reconcile.Report) []model.Discrepancy {
+	var rows []model.Discrepancy
+	for _, u := range report.Missing {
+		rows = append(rows, model.Discrepancy{MacAddress: u.MacAddress, Kind: model.DiscrepancyMissing, Batch: u.Batch})
+	}
+	for _, m := range report.Unexpected {
+		rows = append(rows, model.Discrepancy{MacAddress: m.MacAddress, Kind: model.DiscrepancyUnexpected})
+	}
+	return append(rows, report.Mismatches...)
+}
+
+// writeReport writes the discrepancies of a reconciliation and a summary
+func writeReport(w io.Writer, format string, report reconcile.Report) error {
+	switch format {
+	case "json":
+		return writeJSON(w, report)
+
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"mac_address", "kind", "field", "expected", "actual", "batch"})
+		for _, d := range reportRows(report) {
+			cw.Write([]string{d.MacAddress, d.Kind, d.Field, d.Expected, d.Actual, d.Batch})
+		}
+		cw.Flush()
+		return cw.Error()
+
+	default:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "MAC_ADDRESS\tKIND\tFIELD\tEXPECTED\tACTUAL\tBATCH")
+		for _, d := range reportRows(report) {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", d.MacAddress, d.Kind, d.Field, d.Expected, d.Actual, d.Batch)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "\n%d expected, %d discovered, %d matched, %d missing, %d unexpected, %d mismatches\n",
+			report.Expected, report.Discovered, report.Matched, len(report.Missing), len(report.Unexpected), len(report.Mismatches))
+		return nil
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ebobo/modem_prod_go/pkg/report"
+)
+
+type reportCommand struct {
+	Format string `long:"format" choice:"pdf" choice:"html" default:"pdf" description:"file format"`
+	Order  int64  `long:"order" description:"write a zip file with the travelers of all modems of this work order"`
+	File   string `short:"f" long:"file" description:"file to write, - for standard output, traveler-<mac>.<format> or order-<id>-travelers.zip if not set"`
+	Args   struct {
+		MacAddress string `positional-arg-name:"mac"`
+	} `positional-args:"yes"`
+}
+
+func (c *reportCommand) Execute(args []string) error {
+	if (c.Order == 0) == (c.Args.MacAddress == "") {
+		return fmt.Errorf("give either a modem or --order")
+	}
+	cl, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	get := func(w io.Writer) (int64, error) {
+		return cl.GetModemReport(context.Background(), c.Args.MacAddress, c.Format, w)
+	}
+	file := report.Filename(c.Args.MacAddress, c.Format)
+	if c.Order != 0 {
+		get = func(w io.Writer) (int64, error) {
+			return cl.GetOrderReports(context.Background(), c.Order, c.Format, w)
+		}
+		file = fmt.Sprintf("order-%d-travelers.zip", c.Order)
+	}
+	if c.File == "-" {
+		_, err := get(os.Stdout)
+		return err
+	}
+	if c.File != "" {
+		file = c.File
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	n, err := get(f)
+	if err != nil {
+		f.Close()
+		os.Remove(file)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "wrote %d bytes to %s\n", n, file)
+	return nil
+}
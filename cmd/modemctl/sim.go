@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/ebobo/modem_prod_go/pkg/client"
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+type simActivateCommand struct {
+	Order int64 `long:"order" description:"activate the SIMs of the modems assigned to this work order instead of the given ones"`
+	Args  struct {
+		MacAddresses []string `positional-arg-name:"mac"`
+	} `positional-args:"yes"`
+}
+
+func (c *simActivateCommand) Execute(args []string) error {
+	macs, err := orderOrModems(c.Order, c.Args.MacAddresses, "activate the SIMs of")
+	if err != nil {
+		return err
+	}
+
+	return forEachModem(macs, "SIM activation queued", func(cl *client.Client, mac string) error {
+		ctx, cancel := requestContext()
+		defer cancel()
+		_, err := cl.ActivateSIM(ctx, mac)
+		return err
+	})
+}
+
+type simSuspendCommand struct {
+	macArgs
+}
+
+func (c *simSuspendCommand) Execute(args []string) error {
+	return forEachModem(c.Args.MacAddresses, "SIM suspension queued", func(cl *client.Client, mac string) error {
+		ctx, cancel := requestContext()
+		defer cancel()
+		_, err := cl.SuspendSIM(ctx, mac)
+		return err
+	})
+}
+
+type simStatusCommand struct {
+	macArgs
+}
+
+func (c *simStatusCommand) Execute(args []string) error {
+	cl, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	states := []model.SIMState{}
+	failed := 0
+	for _, mac := range c.Args.MacAddresses {
+		ctx, cancel := requestContext()
+		state, err := cl.GetSIM(ctx, mac)
+		cancel()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", mac, err)
+			failed++
+			continue
+		}
+		states = append(states, state)
+	}
+	if err := writeSIMStates(os.Stdout, opt.Output, states); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d modems failed", failed, len(c.Args.MacAddresses))
+	}
+	return nil
+}
+
+// writeSIMStates writes SIM states in the given output format
+func writeSIMStates(w io.Writer, format string, states []model.SIMState) error {
+	switch format {
+	case "json":
+		return writeJSON(w, states)
+
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"mac_address", "iccid", "provider", "status"})
+		for _, s := range states {
+			cw.Write([]string{s.MacAddress, s.ICCID, s.Provider, s.Status})
+		}
+		cw.Flush()
+		return cw.Error()
+
+	default:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "MAC_ADDRESS\tICCID\tPROVIDER\tSTATUS")
+		for _, s := range states {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", s.MacAddress, s.ICCID, s.Provider, s.Status)
+		}
+		return tw.Flush()
+	}
+}
+
+type simJobsCommand struct {
+	MacAddress string `long:"mac" description:"only jobs of this modem"`
+	Status     string `long:"status" choice:"queued" choice:"done" choice:"failed" description:"only jobs with this status"`
+}
+
+func (c *simJobsCommand) Execute(args []string) error {
+	cl, err := newClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := requestContext()
+	defer cancel()
+
+	jobs, err := cl.ListSIMJobs(ctx, c.MacAddress, c.Status)
+	if err != nil {
+		return err
+	}
+	return writeSIMJobs(os.Stdout, opt.Output, jobs)
+}
+
+// writeSIMJobs writes SIM jobs in the given output format
+func writeSIMJobs(w io.Writer, format string, jobs []model.SIMJob) error {
+	switch format {
+	case "json":
+		return writeJSON(w, jobs)
+
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"id", "mac_address", "iccid", "provider", "action", "status", "sim_status", "attempts", "error", "actor", "created_at", "updated_at", "next_attempt_at"})
+		for _, j := range jobs {
+			cw.Write([]string{strconv.FormatInt(j.ID, 10), j.MacAddress, j.ICCID, j.Provider, j.Action, j.Status, j.SIMStatus,
+				strconv.Itoa(j.Attempts), j.Error, j.Actor, formatTime(j.CreatedAt), formatTime(j.UpdatedAt), formatTime(j.NextAttemptAt)})
+		}
+		cw.Flush()
+		return cw.Error()
+
+	default:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tMAC_ADDRESS\tICCID\tACTION\tSTATUS\tSIM_STATUS\tATTEMPTS\tUPDATED\tERROR")
+		for _, j := range jobs {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n", j.ID, j.MacAddress, j.ICCID, j.Action, j.Status, j.SIMStatus,
+				j.Attempts, formatTime(j.UpdatedAt), j.Error)
+		}
+		return tw.Flush()
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ebobo/modem_prod_go/pkg/client"
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+type testCommand struct {
+	Order int64 `long:"order" description:"test the modems assigned to this work order instead of the given ones"`
+	Args  struct {
+		MacAddresses []string `positional-arg-name:"mac"`
+	} `positional-args:"yes"`
+}
+
+func (c *testCommand) Execute(args []string) error {
+	macs, err := orderOrModems(c.Order, c.Args.MacAddresses, "test")
+	if err != nil {
+		return err
+	}
+
+	return forEachModem(macs, "test started", func(cl *client.Client, mac string) error {
+		ctx, cancel := requestContext()
+		defer cancel()
+		_, err := cl.TestModem(ctx, mac)
+		return err
+	})
+}
+
+type testResultsCommand struct {
+	All  bool `long:"all" description:"show all test runs, not only the latest"`
+	Args struct {
+		MacAddress string `positional-arg-name:"mac" required:"yes"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func (c *testResultsCommand) Execute(args []string) error {
+	cl, err := newClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := requestContext()
+	defer cancel()
+
+	var runs []model.TestRun
+	if c.All {
+		runs, err = cl.ListTestRuns(ctx, c.Args.MacAddress)
+	} else {
+		var run model.TestRun
+		run, err = cl.GetTestRun(ctx, c.Args.MacAddress)
+		runs = []model.TestRun{run}
+	}
+	if err != nil {
+		return err
+	}
+	return writeTestRuns(os.Stdout, opt.Output, runs)
+}
+
+// formatMeasurements describes measurements in one line with the limits they
+// were checked against, e.g. rssi=-67dBm(-95..)
+func formatMeasurements(measurements model.Measurements) string {
+	parts := make([]string, len(measurements))
+	for i, m := range measurements {
+		parts[i] = m.Name + "=" + strconv.FormatFloat(m.Value, 'f', -1, 64) + m.Unit
+		if m.Min != nil || m.Max != nil {
+			parts[i] += "(" + formatLimit(m.Min) + ".." + formatLimit(m.Max) + ")"
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// formatLimit formats one end of a limit, an open end is empty
+func formatLimit(limit *float64) string {
+	if limit == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*limit, 'f', -1, 64)
+}
+
+// testOutcome describes whether a step passed in one word
+func testOutcome(passed bool) string {
+	if passed {
+		return model.TestPassed
+	}
+	return model.TestFailed
+}
+
+// writeTestRuns writes test runs with one row per step in the given output format
+func writeTestRuns(w io.Writer, format string, runs []model.TestRun) error {
+	switch format {
+	case "json":
+		return writeJSON(w, runs)
+
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"run", "run_status", "step", "result", "attempts", "duration_ms", "measurements", "error", "started_at"})
+		for _, run := range runs {
+			for _, r := range run.Results {
+				cw.Write([]string{strconv.FormatInt(run.ID, 10), run.Status, r.Step, testOutcome(r.Passed), strconv.Itoa(r.Attempts),
+					strconv.FormatInt(r.DurationMs, 10), formatMeasurements(r.Measurements), r.Error, formatTime(r.StartedAt)})
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+
+	default:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for _, run := range runs {
+			fmt.Fprintf(tw, "run %d %s, started %s by %s", run.ID, run.Status, formatTime(run.StartedAt), run.Actor)
+			if run.Error != "" {
+				fmt.Fprintf(tw, ": %s", run.Error)
+			}
+			fmt.Fprintln(tw)
+			fmt.Fprintln(tw, "STEP\tRESULT\tATTEMPTS\tDURATION\tMEASUREMENTS\tERROR")
+			for _, r := range run.Results {
+				duration := time.Duration(r.DurationMs) * time.Millisecond
+				fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\n", r.Step, testOutcome(r.Passed), r.Attempts, duration, formatMeasurements(r.Measurements), r.Error)
+			}
+			fmt.Fprintln(tw)
+		}
+		return tw.Flush()
+	}
+}
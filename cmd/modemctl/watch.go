@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/ebobo/modem_prod_go/pkg/client"
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+type watchCommand struct {
+	MacAddresses []string `long:"mac" description:"only events of this modem, can be repeated"`
+	Types        []string `long:"type" description:"only events of this type, e.g. modem.updated or discovery.*, can be repeated"`
+	SinceID      uint64   `long:"since-id" description:"start after this event id to see events that were missed"`
+}
+
+func (c *watchCommand) Execute(args []string) error {
+	cl, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	// Stop cleanly on Ctrl-C
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	filter := client.EventFilter{
+		MacAddresses: c.MacAddresses,
+		Types:        c.Types,
+		LastID:       c.SinceID,
+	}
+	for {
+		err := cl.WatchEvents(ctx, filter, func(e client.Event) error {
+			filter.LastID = e.ID
+			return printEvent(e)
+		})
+		if ctx.Err() != nil {
+			return nil
+		}
+		// Catch up from the last event received if the stream fell behind
+		if errors.Is(err, client.ErrEventsLagged) {
+			fmt.Fprintln(os.Stderr, "fell behind, resuming after event", filter.LastID)
+			continue
+		}
+		return err
+	}
+}
+
+func printEvent(e client.Event) error {
+	if opt.Output == "json" {
+		return json.NewEncoder(os.Stdout).Encode(e)
+	}
+
+	t := time.Unix(e.Timestamp, 0).Format("15:04:05")
+	fmt.Printf("%s  %-6d %-18s %-17s %s\n", t, e.ID, e.Type, e.MacAddress, eventSummary(e))
+	return nil
+}
+
+// eventSummary describes the data of an event in one line
+func eventSummary(e client.Event) string {
+	var change model.ModemChange
+	if json.Unmarshal(e.Data, &change) == nil && len(change.Changes) > 0 {
+		summary := ""
+		for i, c := range change.Changes {
+			if i > 0 {
+				summary += ", "
+			}
+			summary += fmt.Sprintf("%s: %s -> %s", c.Field, c.OldValue, c.NewValue)
+		}
+		return summary + " by " + change.Changes[0].Actor
+	}
+
+	var discrepancy model.Discrepancy
+	if json.Unmarshal(e.Data, &discrepancy) == nil && discrepancy.Kind != "" {
+		if discrepancy.Kind != model.DiscrepancyMismatch {
+			return discrepancy.Kind
+		}
+		return fmt.Sprintf("%s %s, supplier list has %s", discrepancy.Field, discrepancy.Actual, discrepancy.Expected)
+	}
+
+	var job model.LabelJob
+	if json.Unmarshal(e.Data, &job) == nil && job.Copies > 0 {
+		summary := fmt.Sprintf("label job %d %s on %s", job.ID, job.Status, job.Printer)
+		if job.Error != "" {
+			summary += ": " + job.Error
+		}
+		return summary
+	}
+
+	var simJob model.SIMJob
+	if json.Unmarshal(e.Data, &simJob) == nil && simJob.Action != "" {
+		summary := fmt.Sprintf("SIM job %d %s %s, attempt %d", simJob.ID, simJob.Action, simJob.Status, simJob.Attempts)
+		if simJob.SIMStatus != "" {
+			summary += ", SIM is " + simJob.SIMStatus
+		}
+		if simJob.Error != "" {
+			summary += ": " + simJob.Error
+		}
+		return summary
+	}
+
+	var provisioning model.Provisioning
+	if json.Unmarshal(e.Data, &provisioning) == nil && provisioning.Profile != "" {
+		summary := fmt.Sprintf("profile %s %s", provisioning.Profile, provisioning.Status)
+		if provisioning.Error != "" {
+			summary += ": " + provisioning.Error
+		}
+		return summary
+	}
+
+	var run model.TestRun
+	if json.Unmarshal(e.Data, &run) == nil && run.ID != 0 && run.Results != nil {
+		summary := fmt.Sprintf("test run %d %s, %d steps", run.ID, run.Status, len(run.Results))
+		for _, result := range run.Results {
+			if !result.Passed {
+				summary += ", " + result.Step + ": " + result.Error
+			}
+		}
+		if run.Error != "" {
+			summary += ": " + run.Error
+		}
+		return summary
+	}
+
+	var order model.Order
+	if json.Unmarshal(e.Data, &order) == nil && order.ID != 0 {
+		return fmt.Sprintf("order %d %s: %d/%d assigned, %d completed, %d failed",
+			order.ID, order.Status, order.Assigned, order.Quantity, order.Completed, order.Failed)
+	}
+
+	var modem model.Modem
+	if json.Unmarshal(e.Data, &modem) == nil && modem.MacAddress != "" {
+		return fmt.Sprintf("port %d, %s, state %s", modem.SwitchPort, modem.Model, model.StateName(modem.State))
+	}
+	return ""
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// genCertCommand generates certificates signed by a local CA for bench setups.
+// The CA is created in the output directory on first use and reused after that,
+// so server and client certificates generated later trust each other.
+type genCertCommand struct {
+	OutDir             string   `long:"out-dir" default:"certs" description:"directory for the CA and generated certificates"`
+	Hosts              []string `long:"host" default:"localhost" description:"DNS name or IP address of the server, can be repeated"`
+	Client             string   `long:"client" description:"generate a client certificate with this common name instead of a server certificate"`
+	OrganizationalUnit []string `long:"ou" description:"organizational unit of the client certificate, can be repeated and mapped to a role"`
+	Days               int      `long:"days" default:"365" description:"validity of the certificate in days"`
+}
+
+func (c *genCertCommand) Execute(args []string) error {
+	err := os.MkdirAll(c.OutDir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	caCert, caKey, err := c.loadOrCreateCA()
+	if err != nil {
+		return err
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(0, 0, c.Days),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+
+	name := "server"
+	if c.Client != "" {
+		name = c.Client
+		template.Subject = pkix.Name{CommonName: c.Client, OrganizationalUnit: c.OrganizationalUnit}
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	} else {
+		template.Subject = pkix.Name{CommonName: c.Hosts[0]}
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		for _, host := range c.Hosts {
+			if ip := net.ParseIP(host); ip != nil {
+				template.IPAddresses = append(template.IPAddresses, ip)
+			} else {
+				template.DNSNames = append(template.DNSNames, host)
+			}
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return fmt.Errorf("unable to create certificate: %w", err)
+	}
+
+	certFile := filepath.Join(c.OutDir, name+".pem")
+	keyFile := filepath.Join(c.OutDir, name+"-key.pem")
+	if err := writeCertificate(certFile, der); err != nil {
+		return err
+	}
+	if err := writeKey(keyFile, key); err != nil {
+		return err
+	}
+
+	log.Printf("wrote %s and %s, valid until %s", certFile, keyFile, template.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+// loadOrCreateCA reads ca.pem and ca-key.pem from the output directory, creating them if they do not exist
+func (c *genCertCommand) loadOrCreateCA() (*x509.Certificate, crypto.Signer, error) {
+	certFile := filepath.Join(c.OutDir, "ca.pem")
+	keyFile := filepath.Join(c.OutDir, "ca-key.pem")
+
+	certPEM, err := os.ReadFile(certFile)
+	if err == nil {
+		return loadCA(certPEM, keyFile)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "modem_prod bench CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to create CA: %w", err)
+	}
+	if err := writeCertificate(certFile, der); err != nil {
+		return nil, nil, err
+	}
+	if err := writeKey(keyFile, key); err != nil {
+		return nil, nil, err
+	}
+	log.Printf("created CA %s, give it to clients as their trusted CA and to the server as --client-ca", certFile)
+
+	cert, err := x509.ParseCertificate(der)
+	return cert, key, err
+}
+
+func loadCA(certPEM []byte, keyFile string) (*x509.Certificate, crypto.Signer, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, nil, errors.New("no certificate found in CA file")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to parse CA: %w", err)
+	}
+
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, _ = pem.Decode(keyPEM)
+	if block == nil {
+		return nil, nil, errors.New("no key found in CA key file")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to parse CA key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, errors.New("CA key can not sign")
+	}
+	return cert, signer, nil
+}
+
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func writeCertificate(file string, der []byte) error {
+	return os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644)
+}
+
+func writeKey(file string, key *ecdsa.PrivateKey) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+}
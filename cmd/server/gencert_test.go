@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readTestCert(t *testing.T, file string) *x509.Certificate {
+	t.Helper()
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		t.Fatalf("no certificate in %s", file)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestGenCert(t *testing.T) {
+	dir := t.TempDir()
+
+	server := &genCertCommand{OutDir: dir, Hosts: []string{"bench.local", "192.168.2.10"}, Days: 30}
+	if err := server.Execute(nil); err != nil {
+		t.Fatal(err)
+	}
+	ca, err := os.ReadFile(filepath.Join(dir, "ca.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the CA is reused, so the certificates trust each other
+	client := &genCertCommand{OutDir: dir, Client: "bench-01", OrganizationalUnit: []string{"operators"}, Days: 30}
+	if err := client.Execute(nil); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := os.ReadFile(filepath.Join(dir, "ca.pem")); !bytes.Equal(again, ca) {
+		t.Fatal("CA created again")
+	}
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca)
+
+	cert := readTestCert(t, filepath.Join(dir, "server.pem"))
+	for _, host := range server.Hosts {
+		_, err := cert.Verify(x509.VerifyOptions{DNSName: host, Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}})
+		if err != nil {
+			t.Errorf("server certificate for %s: %v", host, err)
+		}
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err == nil {
+		t.Error("server certificate is a client certificate")
+	}
+
+	cert = readTestCert(t, filepath.Join(dir, "bench-01.pem"))
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err != nil {
+		t.Errorf("client certificate: %v", err)
+	}
+	if cert.Subject.CommonName != "bench-01" || len(cert.Subject.OrganizationalUnit) != 1 || cert.Subject.OrganizationalUnit[0] != "operators" {
+		t.Errorf("client subject %s", cert.Subject)
+	}
+	if days := cert.NotAfter.Sub(cert.NotBefore).Hours() / 24; days < 30 || days > 31 {
+		t.Errorf("valid for %.1f days", days)
+	}
+
+	// the keys match and only the owner can read them
+	for _, name := range []string{"ca", "server", "bench-01"} {
+		keyFile := filepath.Join(dir, name+"-key.pem")
+		if _, err := tls.LoadX509KeyPair(filepath.Join(dir, name+".pem"), keyFile); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		info, err := os.Stat(keyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0o600 {
+			t.Errorf("%s mode %v", keyFile, info.Mode().Perm())
+		}
+	}
+}
+
+func TestGenCertBrokenCA(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "ca.pem"), []byte("not a certificate"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &genCertCommand{OutDir: dir, Hosts: []string{"localhost"}, Days: 1}
+	if err := c.Execute(nil); err == nil {
+		t.Error("generated a certificate with a broken CA")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "server.pem")); err == nil {
+		t.Error("wrote a certificate")
+	}
+}
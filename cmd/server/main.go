@@ -1,31 +1,97 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
 
+	"github.com/ebobo/modem_prod_go/pkg/config"
+	"github.com/ebobo/modem_prod_go/pkg/logging"
+	"github.com/ebobo/modem_prod_go/pkg/model"
 	"github.com/ebobo/modem_prod_go/pkg/server"
 	sqlitestore "github.com/ebobo/modem_prod_go/pkg/store/sqlite"
 	"github.com/ebobo/modem_prod_go/pkg/utility"
 	"github.com/jessevdk/go-flags"
 )
 
+// configFileOption is the option naming the configuration file, it is parsed
+// on its own first so flags and environment variables can override the file
+type configFileOption struct {
+	ConfigFile string `short:"c" long:"config" env:"CONFIG_FILE" description:"YAML configuration file"`
+}
+
 var opt struct {
-	HTTPAddr   string `short:"h" long:"http-addr" default:":9090" description:"http listen address" required:"yes"`
-	SqliteFile string `long:"sqlite-file" env:"SQLITE_FILE" default:"modems.db" description:"sqlite file"`
+	configFileOption
+	PrintConfig bool `long:"print-config" description:"print the configuration and exit"`
+
+	config.Config
 }
 
 func main() {
-	_, err := flags.ParseArgs(&opt, os.Args)
+	// Defaults, then the configuration file, then environment and flags
+	var file configFileOption
+	_, err := flags.NewParser(&file, flags.IgnoreUnknown).Parse()
 	if err != nil {
 		log.Fatalf("error parsing flags: %v", err)
 	}
+	opt.Config = config.Default()
+	if file.ConfigFile != "" {
+		err = config.LoadFile(file.ConfigFile, &opt.Config)
+		if err != nil {
+			log.Fatalf("error loading configuration: %v", err)
+		}
+	}
+
+	parser := flags.NewParser(&opt, flags.Default)
+	parser.SubcommandsOptional = true
+	_, err = parser.AddCommand("gen-cert", "Generate self-signed certificates",
+		"Generate a server or client certificate signed by a local CA for bench setups", &genCertCommand{})
+	if err != nil {
+		log.Fatalf("error adding command: %v", err)
+	}
+	_, err = parser.AddCommand("sim-stub", "Serve a SIM API stub",
+		"Serve a Twilio compatible SIM API from memory to try SIM activation without real SIMs", &simStubCommand{})
+	if err != nil {
+		log.Fatalf("error adding command: %v", err)
+	}
+
+	_, err = parser.Parse()
+	if err != nil {
+		log.Fatalf("error parsing flags: %v", err)
+	}
+
+	// A command was run instead of the server
+	if parser.Active != nil {
+		return
+	}
+
+	cfg := opt.Config
+	if opt.PrintConfig {
+		out, err := cfg.YAML()
+		if err != nil {
+			log.Fatalf("error printing configuration: %v", err)
+		}
+		fmt.Print(string(out))
+	}
+	err = cfg.Validate()
+	if err != nil {
+		log.Fatalf("invalid configuration:\n%v", err)
+	}
+	if opt.PrintConfig {
+		return
+	}
 
-	db, created, err := sqlitestore.New(opt.SqliteFile)
+	err = logging.Setup(os.Stderr, cfg.Log.Format, cfg.Log.Level, cfg.Log.Levels)
 	if err != nil {
-		log.Fatalf("error connect to sqlite: %v", err)
+		log.Fatalf("error setting up logging: %v", err)
+	}
+
+	db, created, err := sqlitestore.New(cfg.DB.SqliteFile)
+	if err != nil {
+		fatal("error connect to sqlite", err)
 	}
 
 	//some test data
@@ -33,31 +99,69 @@ func main() {
 		modems := utility.GenerateFakeModems(20)
 
 		for _, modem := range modems {
-			err = db.AddModem(modem)
+			_, err = db.AddModem(modem, model.Origin{Source: model.SourceDiscovery, Actor: "fake data"})
 			if err != nil {
-				log.Fatalf("error adding modem to database: %v", err)
+				fatal("error adding modem to database", err)
 			}
 		}
 
 	} else {
-		log.Println("db already exists")
+		slog.Info("db already exists", "file", cfg.DB.SqliteFile)
 	}
 
 	server := server.New(server.Config{
-		HTTPListenAddr: opt.HTTPAddr,
-		DB:             db,
+		HTTPListenAddr:   cfg.HTTP.Addr,
+		HTTPReadTimeout:  cfg.HTTP.ReadTimeout,
+		HTTPWriteTimeout: cfg.HTTP.WriteTimeout,
+		DB:               db,
+		AuthDisabled:     cfg.Auth.NoAuth,
+		AdminAPIKey:      cfg.Auth.AdminAPIKey,
+		CORSOrigins:      cfg.HTTP.CORSOrigins,
+
+		TLSCertFile:       cfg.HTTP.TLSCert,
+		TLSKeyFile:        cfg.HTTP.TLSKey,
+		ClientCAFile:      cfg.HTTP.ClientCA,
+		RequireClientCert: cfg.HTTP.RequireClientCert,
+		CertRoles:         cfg.HTTP.CertRoles,
+
+		Discovery: cfg.Discovery,
+		SNMP:      cfg.SNMP,
+		SSH:       cfg.SSH,
+		Upgrade:   cfg.Upgrade,
+		Workflow:  cfg.Workflow,
+		Label:     cfg.Label,
+		Test:      cfg.Test,
+		SIM:       cfg.SIM,
+		Escrow:    cfg.Escrow,
 	})
 
 	e := server.Start()
 	if e != nil {
-		log.Fatalf("error starting server: %v", e)
+		fatal("error starting server", e)
+	}
+
+	if cfg.Discovery.Enabled {
+		go server.RunModemService()
 	}
 
 	// Block forever
-	// Capture Ctrl-C
+	// Capture Ctrl-C, reload the TLS certificates on SIGHUP
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	<-c
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range c {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		if err := server.ReloadTLS(); err != nil {
+			slog.Error("error reloading TLS certificates", "err", err)
+		}
+	}
 
 	server.Shutdown()
 }
+
+// fatal logs an error the server cannot start after and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
+}
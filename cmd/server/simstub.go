@@ -0,0 +1,40 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ebobo/modem_prod_go/pkg/sim"
+)
+
+// simStubCommand serves a Twilio compatible SIM API from memory, point
+// --sim-url at it to try SIM activation on a bench without real SIMs
+type simStubCommand struct {
+	Addr       string   `long:"addr" default:"localhost:9099" description:"address to listen on"`
+	AccountSID string   `long:"account-sid" description:"account SID requests must authenticate with, not checked if empty"`
+	AuthToken  string   `long:"auth-token" description:"auth token requests must authenticate with"`
+	ICCIDs     []string `long:"iccid" description:"ICCID of a ready SIM, can be repeated"`
+	Any        bool     `long:"any" description:"add unknown ICCIDs as ready SIMs when they are looked up"`
+	FailFirst  int      `long:"fail-first" description:"number of status changes of each SIM that fail with 503, to try retries"`
+}
+
+func (c *simStubCommand) Execute(args []string) error {
+	stub := sim.NewStub()
+	stub.AccountSID = c.AccountSID
+	stub.AuthToken = c.AuthToken
+	stub.AutoCreate = c.Any
+	stub.FailFirst = c.FailFirst
+	for _, iccid := range c.ICCIDs {
+		stub.Add(iccid, sim.StatusReady)
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		log.Printf("%s %s %s", r.Method, r.URL.RequestURI(), r.PostForm.Encode())
+		stub.ServeHTTP(w, r)
+	})
+	log.Printf("serving the SIM API stub on %s", c.Addr)
+	srv := &http.Server{Addr: c.Addr, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	return srv.ListenAndServe()
+}
@@ -1,14 +1,17 @@
 module github.com/ebobo/modem_prod_go
 
-go 1.20
+go 1.21
 
 require (
 	github.com/google/uuid v1.3.0 // direct
 	github.com/gorilla/handlers v1.5.1 // direct
 	github.com/gorilla/mux v1.8.0 // direct
+	github.com/gorilla/websocket v1.5.0 // direct
 	github.com/jessevdk/go-flags v1.5.0 // direct
 	github.com/jmoiron/sqlx v1.3.5 // direct
+	github.com/prometheus/client_golang v1.16.0 // direct
 	github.com/rs/cors v1.9.0 // direct
+	gopkg.in/yaml.v3 v3.0.1 // direct
 	modernc.org/sqlite v1.23.0 // direct
 )
 
@@ -19,15 +22,23 @@ require (
 )
 
 require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
 	github.com/dustin/go-humanize v1.0.1 // indirect
 	github.com/felixge/httpsnoop v1.0.1 // indirect
+	github.com/golang/protobuf v1.5.3 // indirect
 	github.com/kballard/go-shellquote v0.0.0-20180428030007-95032a82bc51 // indirect
 	github.com/mattn/go-isatty v0.0.16 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.4 // indirect
+	github.com/prometheus/client_model v0.3.0 // indirect
+	github.com/prometheus/common v0.42.0 // indirect
+	github.com/prometheus/procfs v0.10.1 // indirect
 	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
 	golang.org/x/mod v0.3.0 // indirect
 	golang.org/x/sys v0.9.0 // indirect
 	golang.org/x/tools v0.0.0-20201124115921-2c860bdd6e78 // indirect
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
+	google.golang.org/protobuf v1.30.0 // indirect
 	lukechampine.com/uint128 v1.2.0 // indirect
 	modernc.org/cc/v3 v3.40.0 // indirect
 	modernc.org/ccgo/v3 v3.16.13 // indirect
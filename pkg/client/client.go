@@ -0,0 +1,178 @@
+// Package client is a typed client for the REST API of the modem production server.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+// Client calls the REST API of a modem production server
+type Client struct {
+	baseURL string
+	apiKey  string
+
+	// HTTPClient is used for all requests, set its transport for TLS client certificates
+	HTTPClient *http.Client
+}
+
+// New returns a client for the server at baseURL, e.g. http://localhost:9090.
+// apiKey may be empty when the server authenticates the client by certificate.
+func New(baseURL string, apiKey string) *Client {
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		apiKey:     apiKey,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Error is returned for responses with an error status code
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+	Details    []model.FieldError
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// IsNotFound reports whether err is a 404 Not Found response
+func IsNotFound(err error) bool {
+	return statusCode(err) == http.StatusNotFound
+}
+
+// IsConflict reports whether err is a 409 Conflict or a 412 Precondition Failed response
+func IsConflict(err error) bool {
+	code := statusCode(err)
+	return code == http.StatusConflict || code == http.StatusPreconditionFailed
+}
+
+func statusCode(err error) int {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.StatusCode
+	}
+	return 0
+}
+
+// request describes one API call
+type request struct {
+	method      string
+	path        string
+	query       url.Values
+	header      http.Header
+	body        interface{} // sent as is if it is an io.Reader, as JSON otherwise
+	contentType string
+}
+
+// do sends a request and decodes the JSON response into out if it is not nil,
+// or reads the body as is if out is a *[]byte. The response is returned for its headers.
+func (c *Client) do(ctx context.Context, req request, out interface{}) (*http.Response, error) {
+	u := c.baseURL + req.path
+	if len(req.query) > 0 {
+		u += "?" + req.query.Encode()
+	}
+
+	var body io.Reader
+	if r, ok := req.body.(io.Reader); ok {
+		body = r
+	} else if req.body != nil {
+		b, err := json.Marshal(req.body)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	r, err := http.NewRequestWithContext(ctx, req.method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range req.header {
+		r.Header[name] = values
+	}
+	r.Header.Set("Accept", "application/json")
+	if req.body != nil {
+		contentType := req.contentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		r.Header.Set("Content-Type", contentType)
+	}
+	if c.apiKey != "" {
+		r.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.HTTPClient.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return resp, decodeError(resp)
+	}
+	if raw, ok := out.(*[]byte); ok {
+		*raw, err = io.ReadAll(resp.Body)
+		return resp, err
+	}
+	if out != nil && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotModified {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp, fmt.Errorf("unable to decode response of %s %s: %w", req.method, req.path, err)
+		}
+	}
+	return resp, nil
+}
+
+func decodeError(resp *http.Response) error {
+	apiErr := &Error{StatusCode: resp.StatusCode}
+
+	var body struct {
+		Error struct {
+			Code    string             `json:"code"`
+			Message string             `json:"message"`
+			Details []model.FieldError `json:"details"`
+		} `json:"error"`
+	}
+	if json.NewDecoder(resp.Body).Decode(&body) == nil {
+		apiErr.Code = body.Error.Code
+		apiErr.Message = body.Error.Message
+		apiErr.Details = body.Error.Details
+	}
+	return apiErr
+}
+
+// Version is the version and build information of the server
+type Version struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+}
+
+// Version returns the version and build information of the server
+func (c *Client) Version(ctx context.Context) (Version, error) {
+	var v Version
+	_, err := c.do(ctx, request{method: "GET", path: "/api/v1/version"}, &v)
+	return v, err
+}
+
+// Ready returns nil if the server reports it is ready
+func (c *Client) Ready(ctx context.Context) error {
+	_, err := c.do(ctx, request{method: "GET", path: "/readyz"}, nil)
+	return err
+}
@@ -0,0 +1,106 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Event is a change published by the server. Data holds the modem, modem change,
+// order, label job or discrepancy the event is about, depending on the type.
+type Event struct {
+	ID         uint64          `json:"id"`
+	Type       string          `json:"type"`
+	MacAddress string          `json:"mac_address"`
+	Timestamp  int64           `json:"timestamp"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// EventFilter selects the events to watch, empty fields match all events
+type EventFilter struct {
+	MacAddresses []string
+	Types        []string // a type ending in .* matches all types with that prefix
+	LastID       uint64   // resume after this event
+}
+
+// ErrEventsLagged is returned by WatchEvents when the server closed the stream
+// because the client did not keep up
+var ErrEventsLagged = errors.New("event stream fell behind")
+
+// WatchEvents streams events from the server and calls handle for each of them
+// until ctx is done, the stream ends or handle returns an error.
+func (c *Client) WatchEvents(ctx context.Context, filter EventFilter, handle func(Event) error) error {
+	query := url.Values{}
+	if len(filter.MacAddresses) > 0 {
+		query.Set("mac", strings.Join(filter.MacAddresses, ","))
+	}
+	if len(filter.Types) > 0 {
+		query.Set("type", strings.Join(filter.Types, ","))
+	}
+	if filter.LastID > 0 {
+		query.Set("last_event_id", strconv.FormatUint(filter.LastID, 10))
+	}
+
+	u := c.baseURL + "/api/v1/events"
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	r, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Accept", "text/event-stream")
+	if c.apiKey != "" {
+		r.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	// The stream stays open, so only the transport of the configured client is used
+	httpClient := &http.Client{Transport: c.HTTPClient.Transport}
+	resp, err := httpClient.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return decodeError(resp)
+	}
+
+	// Each message is a block of field lines ended by an empty line
+	var eventType, data string
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			eventType = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		case line == "":
+			if eventType == "lagged" {
+				return ErrEventsLagged
+			}
+			if data != "" {
+				var e Event
+				if err := json.Unmarshal([]byte(data), &e); err != nil {
+					return fmt.Errorf("invalid event: %w", err)
+				}
+				if err := handle(e); err != nil {
+					return err
+				}
+			}
+			eventType, data = "", ""
+		}
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return scanner.Err()
+}
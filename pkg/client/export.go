@@ -0,0 +1,50 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ExportModems writes the modems matching q to w as a csv, xlsx or json file and
+// returns the number of bytes written. columns selects and orders the columns,
+// the server uses the shipping manifest columns if it is empty. The cursor of q
+// is not supported, its limit caps the number of modems. passwords adds the
+// escrowed admin passwords, which needs the admin role.
+func (c *Client) ExportModems(ctx context.Context, q ModemQuery, format string, columns []string, passwords bool, w io.Writer) (int64, error) {
+	query := q.values()
+	query.Set("format", format)
+	if len(columns) > 0 {
+		query.Set("columns", strings.Join(columns, ","))
+	}
+	if passwords {
+		query.Set("passwords", "true")
+	}
+
+	return c.download(ctx, "/api/v1/modems/export?"+query.Encode(), w)
+}
+
+// download copies the body of a GET of path to w and returns the number of bytes written
+func (c *Client) download(ctx context.Context, path string, w io.Writer) (int64, error) {
+	r, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+path, nil)
+	if err != nil {
+		return 0, err
+	}
+	if c.apiKey != "" {
+		r.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	// Large downloads take longer than the timeout of the configured client, so
+	// only its transport is used and ctx bounds the download
+	httpClient := &http.Client{Transport: c.HTTPClient.Transport}
+	resp, err := httpClient.Do(r)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return 0, decodeError(resp)
+	}
+	return io.Copy(w, resp.Body)
+}
@@ -0,0 +1,61 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"strconv"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+	"github.com/ebobo/modem_prod_go/pkg/reconcile"
+)
+
+// ImportOptions controls an import of expected units
+type ImportOptions struct {
+	Mode   string // model.ImportInsert or model.ImportUpsert, insert if empty
+	DryRun bool   // report what would be imported without storing anything
+	Batch  string // batch of the units that have none
+}
+
+func (o ImportOptions) values() url.Values {
+	v := url.Values{}
+	if o.Mode != "" {
+		v.Set("mode", o.Mode)
+	}
+	if o.DryRun {
+		v.Set("dry_run", strconv.FormatBool(o.DryRun))
+	}
+	if o.Batch != "" {
+		v.Set("batch", o.Batch)
+	}
+	return v
+}
+
+// ImportModems imports the expected units of a supplier batch. If any unit is
+// invalid nothing is imported and the error details name the invalid rows.
+func (c *Client) ImportModems(ctx context.Context, units []model.ExpectedUnit, opts ImportOptions) (model.ImportResult, error) {
+	var result model.ImportResult
+	_, err := c.do(ctx, request{method: "POST", path: "/api/v1/modems/import", query: opts.values(), body: units}, &result)
+	return result, err
+}
+
+// ImportModemsCSV imports expected units from CSV with a header row naming the
+// columns, as sent by the supplier
+func (c *Client) ImportModemsCSV(ctx context.Context, r io.Reader, opts ImportOptions) (model.ImportResult, error) {
+	var result model.ImportResult
+	_, err := c.do(ctx, request{method: "POST", path: "/api/v1/modems/import", query: opts.values(), body: r, contentType: "text/csv"}, &result)
+	return result, err
+}
+
+// Reconciliation compares the expected units with the known modems. If batch is
+// not empty only the units of that batch are reported as missing or mismatched.
+func (c *Client) Reconciliation(ctx context.Context, batch string) (reconcile.Report, error) {
+	query := url.Values{}
+	if batch != "" {
+		query.Set("batch", batch)
+	}
+
+	var report reconcile.Report
+	_, err := c.do(ctx, request{method: "GET", path: "/api/v1/reconciliation", query: query}, &report)
+	return report, err
+}
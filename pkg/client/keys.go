@@ -0,0 +1,36 @@
+package client
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+// NewAPIKey is a created api key together with its secret, which is only returned once
+type NewAPIKey struct {
+	model.APIKey
+	Key string `json:"key"`
+}
+
+// ListAPIKeys returns all api keys, including revoked ones
+func (c *Client) ListAPIKeys(ctx context.Context) ([]model.APIKey, error) {
+	var keys []model.APIKey
+	_, err := c.do(ctx, request{method: "GET", path: "/api/v1/keys"}, &keys)
+	return keys, err
+}
+
+// AddAPIKey creates an api key with the given name and role
+func (c *Client) AddAPIKey(ctx context.Context, name string, role string) (NewAPIKey, error) {
+	body := model.APIKey{Name: name, Role: role}
+
+	var key NewAPIKey
+	_, err := c.do(ctx, request{method: "POST", path: "/api/v1/keys", body: body}, &key)
+	return key, err
+}
+
+// RevokeAPIKey revokes an api key
+func (c *Client) RevokeAPIKey(ctx context.Context, id int64) error {
+	_, err := c.do(ctx, request{method: "DELETE", path: "/api/v1/keys/" + strconv.FormatInt(id, 10)}, nil)
+	return err
+}
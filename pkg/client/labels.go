@@ -0,0 +1,92 @@
+package client
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+// PrintRequest selects how a label is printed, empty fields use the server's defaults
+type PrintRequest struct {
+	Template string `json:"template,omitempty"` // ignored by reprints
+	Printer  string `json:"printer,omitempty"`
+	Copies   int    `json:"copies,omitempty"`
+}
+
+// ListLabelTemplates returns the label templates, including the built-in one
+func (c *Client) ListLabelTemplates(ctx context.Context) ([]model.LabelTemplate, error) {
+	var templates []model.LabelTemplate
+	_, err := c.do(ctx, request{method: "GET", path: "/api/v1/labels/templates"}, &templates)
+	return templates, err
+}
+
+// GetLabelTemplate returns a label template
+func (c *Client) GetLabelTemplate(ctx context.Context, name string) (model.LabelTemplate, error) {
+	var t model.LabelTemplate
+	_, err := c.do(ctx, request{method: "GET", path: "/api/v1/labels/templates/" + url.PathEscape(name)}, &t)
+	return t, err
+}
+
+// PutLabelTemplate adds or replaces a label template
+func (c *Client) PutLabelTemplate(ctx context.Context, t model.LabelTemplate) (model.LabelTemplate, error) {
+	var saved model.LabelTemplate
+	_, err := c.do(ctx, request{method: "PUT", path: "/api/v1/labels/templates/" + url.PathEscape(t.Name), body: t}, &saved)
+	return saved, err
+}
+
+// DeleteLabelTemplate deletes a label template
+func (c *Client) DeleteLabelTemplate(ctx context.Context, name string) error {
+	_, err := c.do(ctx, request{method: "DELETE", path: "/api/v1/labels/templates/" + url.PathEscape(name)}, nil)
+	return err
+}
+
+// RenderLabel returns the ZPL of the label of a modem without printing it, the
+// configured template is used if template is empty
+func (c *Client) RenderLabel(ctx context.Context, mac string, template string) ([]byte, error) {
+	query := url.Values{}
+	if template != "" {
+		query.Set("template", template)
+	}
+
+	var zpl []byte
+	_, err := c.do(ctx, request{method: "GET", path: modemPath(mac) + "/label", query: query}, &zpl)
+	return zpl, err
+}
+
+// PrintLabel queues printing the label of a modem
+func (c *Client) PrintLabel(ctx context.Context, mac string, req PrintRequest) (model.LabelJob, error) {
+	var job model.LabelJob
+	_, err := c.do(ctx, request{method: "POST", path: modemPath(mac) + "/label", body: req}, &job)
+	return job, err
+}
+
+// ReprintLabel queues printing the label of an earlier job again, as it was printed then
+func (c *Client) ReprintLabel(ctx context.Context, id int64, req PrintRequest) (model.LabelJob, error) {
+	var job model.LabelJob
+	_, err := c.do(ctx, request{method: "POST", path: "/api/v1/labels/jobs/" + strconv.FormatInt(id, 10) + "/reprint", body: req}, &job)
+	return job, err
+}
+
+// ListLabelJobs returns the most recent print jobs, filtered by modem and status if they are not empty
+func (c *Client) ListLabelJobs(ctx context.Context, mac string, status string) ([]model.LabelJob, error) {
+	query := url.Values{}
+	if mac != "" {
+		query.Set("mac", mac)
+	}
+	if status != "" {
+		query.Set("status", status)
+	}
+
+	var jobs []model.LabelJob
+	_, err := c.do(ctx, request{method: "GET", path: "/api/v1/labels/jobs", query: query}, &jobs)
+	return jobs, err
+}
+
+// GetLabelJob returns a print job
+func (c *Client) GetLabelJob(ctx context.Context, id int64) (model.LabelJob, error) {
+	var job model.LabelJob
+	_, err := c.do(ctx, request{method: "GET", path: "/api/v1/labels/jobs/" + strconv.FormatInt(id, 10)}, &job)
+	return job, err
+}
@@ -0,0 +1,213 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+// ModemQuery filters, sorts and paginates ListModems. Nil and empty fields do not filter.
+type ModemQuery struct {
+	State       *int
+	Model       string
+	Firmware    string
+	Upgraded    *bool
+	SIMProvider string
+	TestStatus  string
+	SwitchPort  *int
+	UpdatedFrom int // unix timestamp, inclusive
+	UpdatedTo   int // unix timestamp, inclusive
+
+	SortBy     string // any modem field, defaults to mac_address
+	Descending bool
+	Cursor     string // NextCursor of the previous page
+	Limit      int    // 0 returns all remaining modems
+}
+
+func (q ModemQuery) values() url.Values {
+	v := url.Values{}
+	if q.State != nil {
+		v.Set("state", strconv.Itoa(*q.State))
+	}
+	if q.Model != "" {
+		v.Set("model", q.Model)
+	}
+	if q.Firmware != "" {
+		v.Set("firmware", q.Firmware)
+	}
+	if q.Upgraded != nil {
+		v.Set("upgraded", strconv.FormatBool(*q.Upgraded))
+	}
+	if q.SIMProvider != "" {
+		v.Set("sim_provider", q.SIMProvider)
+	}
+	if q.TestStatus != "" {
+		v.Set("test_status", q.TestStatus)
+	}
+	if q.SwitchPort != nil {
+		v.Set("switch_port", strconv.Itoa(*q.SwitchPort))
+	}
+	if q.UpdatedFrom > 0 {
+		v.Set("updated_from", strconv.Itoa(q.UpdatedFrom))
+	}
+	if q.UpdatedTo > 0 {
+		v.Set("updated_to", strconv.Itoa(q.UpdatedTo))
+	}
+	if q.SortBy != "" {
+		if q.Descending {
+			v.Set("sort", "-"+q.SortBy)
+		} else {
+			v.Set("sort", q.SortBy)
+		}
+	}
+	if q.Cursor != "" {
+		v.Set("cursor", q.Cursor)
+	}
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	return v
+}
+
+// ModemPage is one page of modems
+type ModemPage struct {
+	Modems     []model.Modem
+	Total      int    // number of modems matching the filters
+	NextCursor string // empty on the last page
+}
+
+// ListModems returns one page of the modems matching the query
+func (c *Client) ListModems(ctx context.Context, q ModemQuery) (ModemPage, error) {
+	var page ModemPage
+	resp, err := c.do(ctx, request{method: "GET", path: "/api/v1/modems", query: q.values()}, &page.Modems)
+	if err != nil {
+		return page, err
+	}
+	page.Total, _ = strconv.Atoi(resp.Header.Get("X-Total-Count"))
+	page.NextCursor = resp.Header.Get("X-Next-Cursor")
+	return page, nil
+}
+
+// ListAllModems follows the pages of ListModems and returns all modems matching the query
+func (c *Client) ListAllModems(ctx context.Context, q ModemQuery) ([]model.Modem, error) {
+	if q.Limit == 0 {
+		q.Limit = 500
+	}
+
+	var modems []model.Modem
+	for {
+		page, err := c.ListModems(ctx, q)
+		if err != nil {
+			return modems, err
+		}
+		modems = append(modems, page.Modems...)
+		if page.NextCursor == "" {
+			return modems, nil
+		}
+		q.Cursor = page.NextCursor
+	}
+}
+
+// GetModem returns a modem by mac address
+func (c *Client) GetModem(ctx context.Context, mac string) (model.Modem, error) {
+	var modem model.Modem
+	_, err := c.do(ctx, request{method: "GET", path: modemPath(mac)}, &modem)
+	return modem, err
+}
+
+// AddModem adds a new modem
+func (c *Client) AddModem(ctx context.Context, modem model.Modem) (model.Modem, error) {
+	var added model.Modem
+	_, err := c.do(ctx, request{method: "POST", path: "/api/v1/modem", body: modem}, &added)
+	return added, err
+}
+
+// UpdateModem replaces a modem. If modem.Version is set the update fails with a
+// conflict when the modem was changed since that version.
+func (c *Client) UpdateModem(ctx context.Context, modem model.Modem) (model.Modem, error) {
+	var updated model.Modem
+	_, err := c.do(ctx, request{method: "PUT", path: modemPath(modem.MacAddress), body: modem}, &updated)
+	return updated, err
+}
+
+// PatchModem changes the given fields of a modem, fields set to nil are reset to their
+// zero value. If version is not 0 the patch fails with a conflict when the modem was
+// changed since that version.
+func (c *Client) PatchModem(ctx context.Context, mac string, fields map[string]interface{}, version int) (model.Modem, error) {
+	req := request{
+		method:      "PATCH",
+		path:        modemPath(mac),
+		body:        fields,
+		contentType: "application/merge-patch+json",
+	}
+	if version != 0 {
+		req.header = http.Header{"If-Match": {strconv.Quote(strconv.Itoa(version))}}
+	}
+
+	var updated model.Modem
+	_, err := c.do(ctx, req, &updated)
+	return updated, err
+}
+
+// DeleteModem deletes a modem
+func (c *Client) DeleteModem(ctx context.Context, mac string) error {
+	_, err := c.do(ctx, request{method: "DELETE", path: modemPath(mac)}, nil)
+	return err
+}
+
+// SetModemState sets the state of a modem, 0: unknown, 1: normal, 2: busy, 3: error
+func (c *Client) SetModemState(ctx context.Context, mac string, state int) error {
+	body := map[string]int{"state": state}
+	_, err := c.do(ctx, request{method: "PUT", path: modemPath(mac) + "/state", body: body}, nil)
+	return err
+}
+
+// SetModemProgress sets the upgrade progress of a modem in percent
+func (c *Client) SetModemProgress(ctx context.Context, mac string, progress int) error {
+	body := map[string]int{"progress": progress}
+	_, err := c.do(ctx, request{method: "PUT", path: modemPath(mac) + "/progress", body: body}, nil)
+	return err
+}
+
+// RereadModem makes the server read the info of a modem again
+func (c *Client) RereadModem(ctx context.Context, mac string) (model.Modem, error) {
+	var modem model.Modem
+	_, err := c.do(ctx, request{method: "POST", path: modemPath(mac) + "/reread"}, &modem)
+	return modem, err
+}
+
+// ReupgradeModem makes the server upgrade a modem again
+func (c *Client) ReupgradeModem(ctx context.Context, mac string) (model.Modem, error) {
+	var modem model.Modem
+	_, err := c.do(ctx, request{method: "POST", path: modemPath(mac) + "/reupgrade"}, &modem)
+	return modem, err
+}
+
+// PowerCycleModem makes the server turn the PoE power of the modem's switch port off and on
+func (c *Client) PowerCycleModem(ctx context.Context, mac string) error {
+	_, err := c.do(ctx, request{method: "POST", path: modemPath(mac) + "/power-cycle"}, nil)
+	return err
+}
+
+// ModemHistory returns the changes of a modem from oldest to newest. from and to are
+// unix timestamps, a zero value means no limit.
+func (c *Client) ModemHistory(ctx context.Context, mac string, from int, to int) ([]model.ModemEvent, error) {
+	query := url.Values{}
+	if from > 0 {
+		query.Set("from", strconv.Itoa(from))
+	}
+	if to > 0 {
+		query.Set("to", strconv.Itoa(to))
+	}
+
+	var events []model.ModemEvent
+	_, err := c.do(ctx, request{method: "GET", path: modemPath(mac) + "/history", query: query}, &events)
+	return events, err
+}
+
+func modemPath(mac string) string {
+	return "/api/v1/modem/" + url.PathEscape(mac)
+}
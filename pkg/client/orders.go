@@ -0,0 +1,75 @@
+package client
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+// ListOrders returns all work orders, or only those with the given status if it is not empty
+func (c *Client) ListOrders(ctx context.Context, status string) ([]model.Order, error) {
+	query := url.Values{}
+	if status != "" {
+		query.Set("status", status)
+	}
+
+	var orders []model.Order
+	_, err := c.do(ctx, request{method: "GET", path: "/api/v1/orders", query: query}, &orders)
+	return orders, err
+}
+
+// AddOrder creates a work order and returns it with its id
+func (c *Client) AddOrder(ctx context.Context, order model.Order) (model.Order, error) {
+	var added model.Order
+	_, err := c.do(ctx, request{method: "POST", path: "/api/v1/orders", body: order}, &added)
+	return added, err
+}
+
+// GetOrder returns a work order by id
+func (c *Client) GetOrder(ctx context.Context, id int64) (model.Order, error) {
+	var order model.Order
+	_, err := c.do(ctx, request{method: "GET", path: orderPath(id)}, &order)
+	return order, err
+}
+
+// OrderModems returns the modems assigned to a work order
+func (c *Client) OrderModems(ctx context.Context, id int64) ([]model.Modem, error) {
+	var modems []model.Modem
+	_, err := c.do(ctx, request{method: "GET", path: orderPath(id) + "/modems"}, &modems)
+	return modems, err
+}
+
+// AssignModems assigns modems to a work order and returns the updated order
+func (c *Client) AssignModems(ctx context.Context, id int64, macs []string) (model.Order, error) {
+	body := map[string][]string{"mac_addresses": macs}
+
+	var order model.Order
+	_, err := c.do(ctx, request{method: "POST", path: orderPath(id) + "/modems", body: body}, &order)
+	return order, err
+}
+
+// AutoAssignModems assigns the unassigned modems on the order's bench and returns the updated order
+func (c *Client) AutoAssignModems(ctx context.Context, id int64) (model.Order, error) {
+	var order model.Order
+	_, err := c.do(ctx, request{method: "POST", path: orderPath(id) + "/assign"}, &order)
+	return order, err
+}
+
+// UnassignModem removes a modem from a work order
+func (c *Client) UnassignModem(ctx context.Context, id int64, mac string) error {
+	_, err := c.do(ctx, request{method: "DELETE", path: orderPath(id) + "/modems/" + url.PathEscape(mac)}, nil)
+	return err
+}
+
+// CloseOrder closes a work order and returns it with its final counts
+func (c *Client) CloseOrder(ctx context.Context, id int64) (model.Order, error) {
+	var order model.Order
+	_, err := c.do(ctx, request{method: "POST", path: orderPath(id) + "/close"}, &order)
+	return order, err
+}
+
+func orderPath(id int64) string {
+	return "/api/v1/orders/" + strconv.FormatInt(id, 10)
+}
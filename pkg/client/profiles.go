@@ -0,0 +1,74 @@
+package client
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+// ListConfigProfiles returns the config profiles, without their admin passwords
+func (c *Client) ListConfigProfiles(ctx context.Context) ([]model.ConfigProfile, error) {
+	var profiles []model.ConfigProfile
+	_, err := c.do(ctx, request{method: "GET", path: "/api/v1/profiles"}, &profiles)
+	return profiles, err
+}
+
+// GetConfigProfile returns a config profile, without its admin password
+func (c *Client) GetConfigProfile(ctx context.Context, name string) (model.ConfigProfile, error) {
+	var p model.ConfigProfile
+	_, err := c.do(ctx, request{method: "GET", path: "/api/v1/profiles/" + url.PathEscape(name)}, &p)
+	return p, err
+}
+
+// PutConfigProfile adds or replaces a config profile, a replaced profile keeps
+// its admin password if p has none
+func (c *Client) PutConfigProfile(ctx context.Context, p model.ConfigProfile) (model.ConfigProfile, error) {
+	var saved model.ConfigProfile
+	_, err := c.do(ctx, request{method: "PUT", path: "/api/v1/profiles/" + url.PathEscape(p.Name), body: p}, &saved)
+	return saved, err
+}
+
+// DeleteConfigProfile deletes a config profile
+func (c *Client) DeleteConfigProfile(ctx context.Context, name string) error {
+	_, err := c.do(ctx, request{method: "DELETE", path: "/api/v1/profiles/" + url.PathEscape(name)}, nil)
+	return err
+}
+
+// Provision starts applying a config profile to a modem, the profile of its
+// work order if profile is empty
+func (c *Client) Provision(ctx context.Context, mac string, profile string) (model.Provisioning, error) {
+	var p model.Provisioning
+	body := struct {
+		Profile string `json:"profile,omitempty"`
+	}{profile}
+	_, err := c.do(ctx, request{method: "POST", path: modemPath(mac) + "/provision", body: body}, &p)
+	return p, err
+}
+
+// GetProvisioning returns the latest provisioning of a modem
+func (c *Client) GetProvisioning(ctx context.Context, mac string) (model.Provisioning, error) {
+	var p model.Provisioning
+	_, err := c.do(ctx, request{method: "GET", path: modemPath(mac) + "/provision"}, &p)
+	return p, err
+}
+
+// ConfigDiff reads the settings of a profile from a modem and compares them
+// with the desired values, the profile of its work order is used if profile is empty
+func (c *Client) ConfigDiff(ctx context.Context, mac string, profile string) ([]model.ConfigDiff, error) {
+	query := url.Values{}
+	if profile != "" {
+		query.Set("profile", profile)
+	}
+
+	var diffs []model.ConfigDiff
+	_, err := c.do(ctx, request{method: "GET", path: modemPath(mac) + "/config-diff", query: query}, &diffs)
+	return diffs, err
+}
+
+// GetModemPassword returns the admin password last set on a modem, which needs the admin role
+func (c *Client) GetModemPassword(ctx context.Context, mac string) (model.ModemPassword, error) {
+	var p model.ModemPassword
+	_, err := c.do(ctx, request{method: "GET", path: modemPath(mac) + "/password"}, &p)
+	return p, err
+}
@@ -0,0 +1,21 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/url"
+)
+
+// GetModemReport writes the production traveler of a modem to w as an html or
+// pdf file and returns the number of bytes written
+func (c *Client) GetModemReport(ctx context.Context, mac string, format string, w io.Writer) (int64, error) {
+	query := url.Values{"format": {format}}
+	return c.download(ctx, modemPath(mac)+"/report?"+query.Encode(), w)
+}
+
+// GetOrderReports writes a zip file with the travelers of all modems of a
+// work order to w, each an html or pdf file, and returns the number of bytes written
+func (c *Client) GetOrderReports(ctx context.Context, id int64, format string, w io.Writer) (int64, error) {
+	query := url.Values{"format": {format}}
+	return c.download(ctx, orderPath(id)+"/reports?"+query.Encode(), w)
+}
@@ -0,0 +1,44 @@
+package client
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+// GetSIM returns the status of the SIM of a modem as the carrier reports it
+func (c *Client) GetSIM(ctx context.Context, mac string) (model.SIMState, error) {
+	var state model.SIMState
+	_, err := c.do(ctx, request{method: "GET", path: modemPath(mac) + "/sim"}, &state)
+	return state, err
+}
+
+// ActivateSIM queues the activation of the SIM of a modem
+func (c *Client) ActivateSIM(ctx context.Context, mac string) (model.SIMJob, error) {
+	var job model.SIMJob
+	_, err := c.do(ctx, request{method: "POST", path: modemPath(mac) + "/sim/activate"}, &job)
+	return job, err
+}
+
+// SuspendSIM queues the suspension of the SIM of a modem
+func (c *Client) SuspendSIM(ctx context.Context, mac string) (model.SIMJob, error) {
+	var job model.SIMJob
+	_, err := c.do(ctx, request{method: "POST", path: modemPath(mac) + "/sim/suspend"}, &job)
+	return job, err
+}
+
+// ListSIMJobs returns the most recent SIM jobs, filtered by modem and status if they are not empty
+func (c *Client) ListSIMJobs(ctx context.Context, mac string, status string) ([]model.SIMJob, error) {
+	query := url.Values{}
+	if mac != "" {
+		query.Set("mac", mac)
+	}
+	if status != "" {
+		query.Set("status", status)
+	}
+
+	var jobs []model.SIMJob
+	_, err := c.do(ctx, request{method: "GET", path: "/api/v1/sim/jobs", query: query}, &jobs)
+	return jobs, err
+}
@@ -0,0 +1,28 @@
+package client
+
+import (
+	"context"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+// TestModem starts running the functional tests on a modem
+func (c *Client) TestModem(ctx context.Context, mac string) (model.TestRun, error) {
+	var run model.TestRun
+	_, err := c.do(ctx, request{method: "POST", path: modemPath(mac) + "/test"}, &run)
+	return run, err
+}
+
+// GetTestRun returns the latest test run of a modem with its results
+func (c *Client) GetTestRun(ctx context.Context, mac string) (model.TestRun, error) {
+	var run model.TestRun
+	_, err := c.do(ctx, request{method: "GET", path: modemPath(mac) + "/test"}, &run)
+	return run, err
+}
+
+// ListTestRuns returns all test runs of a modem with their results, newest first
+func (c *Client) ListTestRuns(ctx context.Context, mac string) ([]model.TestRun, error) {
+	var runs []model.TestRun
+	_, err := c.do(ctx, request{method: "GET", path: modemPath(mac) + "/tests"}, &runs)
+	return runs, err
+}
@@ -0,0 +1,379 @@
+// Package config is the configuration of the modem production server.
+//
+// Settings are read in this order, later ones take precedence:
+// built-in defaults, the YAML configuration file, environment variables
+// and command line flags.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/ebobo/modem_prod_go/pkg/escrow"
+	"github.com/ebobo/modem_prod_go/pkg/functest"
+	"github.com/ebobo/modem_prod_go/pkg/logging"
+	"github.com/ebobo/modem_prod_go/pkg/model"
+	"github.com/ebobo/modem_prod_go/pkg/sim"
+)
+
+// Config is the complete server configuration. The struct tags define the
+// YAML keys, command line flags and environment variables of each setting.
+type Config struct {
+	HTTP      HTTP      `yaml:"http" group:"HTTP Options"`
+	DB        DB        `yaml:"db" group:"Database Options"`
+	Auth      Auth      `yaml:"auth" group:"Authentication Options"`
+	Discovery Discovery `yaml:"discovery" group:"Discovery Options"`
+	SNMP      SNMP      `yaml:"snmp" group:"Switch SNMP Options"`
+	SSH       SSH       `yaml:"ssh" group:"Modem SSH Options"`
+	Upgrade   Upgrade   `yaml:"upgrade" group:"Upgrade Options"`
+	Workflow  Workflow  `yaml:"workflow" group:"Workflow Options"`
+	Label     Label     `yaml:"label" group:"Label Printing Options"`
+	Test      Test      `yaml:"test" group:"Functional Test Options"`
+	SIM       SIM       `yaml:"sim" group:"SIM Activation Options"`
+	Escrow    Escrow    `yaml:"escrow" group:"Password Escrow Options"`
+	Log       Log       `yaml:"log" group:"Logging Options"`
+}
+
+// HTTP configures the REST API
+type HTTP struct {
+	Addr         string        `yaml:"addr" short:"h" long:"http-addr" env:"HTTP_ADDR" description:"http listen address"`
+	ReadTimeout  time.Duration `yaml:"read_timeout" long:"http-read-timeout" env:"HTTP_READ_TIMEOUT" description:"maximum time to read a request"`
+	WriteTimeout time.Duration `yaml:"write_timeout" long:"http-write-timeout" env:"HTTP_WRITE_TIMEOUT" description:"maximum time to write a response"`
+	CORSOrigins  []string      `yaml:"cors_origins" long:"cors-origin" env:"CORS_ORIGINS" env-delim:"," description:"origin allowed to call the API from a browser, can be repeated"`
+
+	TLSCert           string            `yaml:"tls_cert" long:"tls-cert" env:"TLS_CERT" description:"TLS certificate file, enables HTTPS together with --tls-key"`
+	TLSKey            string            `yaml:"tls_key" long:"tls-key" env:"TLS_KEY" description:"TLS key file"`
+	ClientCA          string            `yaml:"client_ca" long:"client-ca" env:"CLIENT_CA" description:"CA file for client certificates (mutual TLS)"`
+	RequireClientCert bool              `yaml:"require_client_cert" long:"require-client-cert" env:"REQUIRE_CLIENT_CERT" description:"reject connections without a valid client certificate"`
+	CertRoles         map[string]string `yaml:"cert_roles" long:"cert-role" description:"map a client certificate common name or organizational unit to a role, as name:role, can be repeated"`
+}
+
+// DB configures the store
+type DB struct {
+	SqliteFile string `yaml:"sqlite_file" long:"sqlite-file" env:"SQLITE_FILE" description:"sqlite file"`
+}
+
+// Auth configures authentication of API callers
+type Auth struct {
+	NoAuth      bool   `yaml:"no_auth" long:"no-auth" env:"NO_AUTH" description:"disable authentication, every caller is admin (development only)"`
+	AdminAPIKey string `yaml:"admin_api_key" long:"admin-api-key" env:"ADMIN_API_KEY" description:"api key added with the admin role if it does not exist"`
+}
+
+// Discovery configures how modems are found on the production network
+type Discovery struct {
+	Enabled      bool          `yaml:"enabled" long:"discovery" env:"DISCOVERY" description:"discover, read and upgrade modems on the production network"`
+	Interface    string        `yaml:"interface" long:"iface" env:"DISCOVERY_IFACE" description:"interface to capture on"`
+	Filter       string        `yaml:"filter" long:"filter" env:"DISCOVERY_FILTER" description:"BPF filter for capture"`
+	Snaplen      int           `yaml:"snaplen" long:"snaplen" env:"DISCOVERY_SNAPLEN" description:"maximum size to read for each packet"`
+	NoPromisc    bool          `yaml:"no_promisc" long:"no-promisc" env:"DISCOVERY_NO_PROMISC" description:"do not put the interface in promiscuous mode"`
+	Timeout      time.Duration `yaml:"timeout" long:"capture-timeout" env:"DISCOVERY_TIMEOUT" description:"capture read timeout"`
+	PingInterval time.Duration `yaml:"ping_interval" long:"ping-interval" env:"DISCOVERY_PING_INTERVAL" description:"how often to ping all nodes so modems show up"`
+}
+
+// SNMP configures the switch the modems are connected to
+type SNMP struct {
+	SwitchAddress     string        `yaml:"switch_address" long:"switch-address" env:"SWITCH_ADDRESS" description:"address of the switch the modems are connected to"`
+	Port              uint16        `yaml:"port" long:"snmp-port" env:"SNMP_PORT" description:"SNMP port of the switch"`
+	Community         string        `yaml:"community" long:"snmp-community" env:"SNMP_COMMUNITY" description:"SNMP community to read the switch with"`
+	WriteCommunity    string        `yaml:"write_community" long:"snmp-write-community" env:"SNMP_WRITE_COMMUNITY" description:"SNMP community to change port power with"`
+	Timeout           time.Duration `yaml:"timeout" long:"snmp-timeout" env:"SNMP_TIMEOUT" description:"timeout of an SNMP request"`
+	PollInterval      time.Duration `yaml:"poll_interval" long:"snmp-poll-interval" env:"SNMP_POLL_INTERVAL" description:"how often to map modems to switch ports"`
+	PowerCycleOffTime time.Duration `yaml:"power_cycle_off_time" long:"power-cycle-off-time" env:"POWER_CYCLE_OFF_TIME" description:"how long port power is off when power cycling a modem"`
+	ModemMACPrefix    string        `yaml:"modem_mac_prefix" long:"modem-mac-prefix" env:"MODEM_MAC_PREFIX" description:"mac address prefix of the modems on the switch"`
+}
+
+// SSH configures the connections used to read modem info
+type SSH struct {
+	User     string        `yaml:"user" long:"ssh-user" env:"SSH_USER" description:"user to log in to modems with"`
+	Password string        `yaml:"password" long:"ssh-password" env:"SSH_PASSWORD" description:"password to log in to modems with"`
+	Port     uint16        `yaml:"port" long:"ssh-port" env:"SSH_PORT" description:"SSH port of the modems"`
+	Timeout  time.Duration `yaml:"timeout" long:"ssh-timeout" env:"SSH_TIMEOUT" description:"timeout of connecting to a modem"`
+}
+
+// Upgrade configures firmware upgrades
+type Upgrade struct {
+	Disabled bool          `yaml:"disabled" long:"no-upgrade" env:"NO_UPGRADE" description:"do not upgrade discovered modems"`
+	Timeout  time.Duration `yaml:"timeout" long:"upgrade-timeout" env:"UPGRADE_TIMEOUT" description:"how long an upgraded modem may take to come back before the upgrade failed"`
+}
+
+// Workflow configures how discovered modems move through production
+type Workflow struct {
+	SkipInfoRead bool          `yaml:"skip_info_read" long:"skip-info-read" env:"SKIP_INFO_READ" description:"do not read the info of discovered modems"`
+	Interval     time.Duration `yaml:"interval" long:"workflow-interval" env:"WORKFLOW_INTERVAL" description:"pause between processing updates of discovered modems"`
+}
+
+// Label configures printing labels on a Zebra compatible printer
+type Label struct {
+	Printer  string        `yaml:"printer" long:"label-printer" env:"LABEL_PRINTER" description:"address of the label printer, port 9100 if none is given"`
+	Printers []string      `yaml:"printers" long:"label-allowed-printer" env:"LABEL_ALLOWED_PRINTERS" env-delim:"," description:"address of another printer print requests may name, can be repeated"`
+	Template string        `yaml:"template" long:"label-template" env:"LABEL_TEMPLATE" description:"label template used when a print job names none"`
+	Timeout  time.Duration `yaml:"timeout" long:"label-timeout" env:"LABEL_TIMEOUT" description:"timeout of sending a label to the printer"`
+}
+
+// Test configures the functional tests of modems
+type Test struct {
+	Plan                string        `yaml:"plan" long:"test-plan" env:"TEST_PLAN" description:"YAML file with the test plan, it replaces the steps and their options below"`
+	Steps               []string      `yaml:"steps" long:"test-step" env:"TEST_STEPS" env-delim:"," description:"test step to run, in order, can be repeated: sim, registration, signal, ping, sms, lan"`
+	Auto                bool          `yaml:"auto" long:"test-auto" env:"TEST_AUTO" description:"test modems once they are upgraded and their info is read"`
+	Timeout             time.Duration `yaml:"timeout" long:"test-timeout" env:"TEST_TIMEOUT" description:"maximum time of a test run"`
+	RegistrationTimeout time.Duration `yaml:"registration_timeout" long:"test-registration-timeout" env:"TEST_REGISTRATION_TIMEOUT" description:"time a modem may take to register on the network"`
+	PingHost            string        `yaml:"ping_host" long:"test-ping-host" env:"TEST_PING_HOST" description:"host pinged over the mobile data connection"`
+	PingCount           int           `yaml:"ping_count" long:"test-ping-count" env:"TEST_PING_COUNT" description:"number of pings"`
+	MinSignal           int           `yaml:"min_signal" long:"test-min-signal" env:"TEST_MIN_SIGNAL" description:"lowest accepted signal strength in dBm"`
+	SMSNumber           string        `yaml:"sms_number" long:"test-sms-number" env:"TEST_SMS_NUMBER" description:"number the sms step sends a text to, it must come back to the modem"`
+	SMSTimeout          time.Duration `yaml:"sms_timeout" long:"test-sms-timeout" env:"TEST_SMS_TIMEOUT" description:"time the test SMS may take to come back"`
+	LANInterface        string        `yaml:"lan_interface" long:"test-lan-interface" env:"TEST_LAN_INTERFACE" description:"network interface of the LAN port on the modem"`
+}
+
+// TestPlan returns the test plan read from the plan file, or made of the steps
+// and their options if no file is set
+func (t Test) TestPlan() (functest.Plan, error) {
+	if t.Plan != "" {
+		p, err := functest.LoadPlan(t.Plan)
+		if err != nil {
+			return p, fmt.Errorf("test.plan: %w", err)
+		}
+		return p, nil
+	}
+	if t.SMSNumber == "" && slices.Contains(t.Steps, "sms") {
+		return functest.Plan{}, errors.New("test.sms_number is required for the sms step")
+	}
+	p, err := functest.NewPlan(t.Steps, functest.Options{
+		RegistrationTimeout: t.RegistrationTimeout,
+		PingHost:            t.PingHost,
+		PingCount:           t.PingCount,
+		MinSignal:           t.MinSignal,
+		SMSNumber:           t.SMSNumber,
+		SMSTimeout:          t.SMSTimeout,
+		LANInterface:        t.LANInterface,
+	})
+	if err != nil {
+		return p, fmt.Errorf("test.steps: %w", err)
+	}
+	return p, nil
+}
+
+// SIM configures the carrier API the SIMs of modems are activated with
+type SIM struct {
+	Provider    string        `yaml:"provider" long:"sim-provider" env:"SIM_PROVIDER" description:"carrier API to manage SIMs with: twilio, SIMs are not managed if empty"`
+	Auto        bool          `yaml:"auto" long:"sim-auto" env:"SIM_AUTO" description:"activate the SIM of a modem once its ICCID is read"`
+	URL         string        `yaml:"url" long:"sim-url" env:"SIM_URL" description:"base URL of the carrier API"`
+	AccountSID  string        `yaml:"account_sid" long:"sim-account-sid" env:"SIM_ACCOUNT_SID" description:"account SID at the carrier"`
+	AuthToken   string        `yaml:"auth_token" long:"sim-auth-token" env:"SIM_AUTH_TOKEN" description:"auth token of the account"`
+	Timeout     time.Duration `yaml:"timeout" long:"sim-timeout" env:"SIM_TIMEOUT" description:"timeout of a request to the carrier API"`
+	MaxAttempts int           `yaml:"max_attempts" long:"sim-max-attempts" env:"SIM_MAX_ATTEMPTS" description:"attempts of a SIM job before it fails"`
+	RetryDelay  time.Duration `yaml:"retry_delay" long:"sim-retry-delay" env:"SIM_RETRY_DELAY" description:"pause before the first retry of a SIM job, doubled after each attempt"`
+}
+
+// Escrow configures the key modem admin passwords are encrypted with, unique
+// passwords can only be generated if one is set
+type Escrow struct {
+	Key     string `yaml:"key" long:"escrow-key" env:"ESCROW_KEY" description:"AES-256 key to encrypt modem passwords with, as 64 hex digits or base64"`
+	KeyFile string `yaml:"key_file" long:"escrow-key-file" env:"ESCROW_KEY_FILE" description:"file with the escrow key"`
+}
+
+// Log configures logging
+type Log struct {
+	Format string            `yaml:"format" long:"log-format" env:"LOG_FORMAT" choice:"text" choice:"json" description:"log format"`
+	Level  string            `yaml:"level" long:"log-level" env:"LOG_LEVEL" description:"log level: debug, info, warn or error"`
+	Levels map[string]string `yaml:"levels" long:"log-subsystem-level" description:"log level of a subsystem (discovery, snmp, ssh, upgrade, http, store, reconcile, label, provision, test, sim), as subsystem:level, can be repeated"`
+}
+
+// Default returns the built-in configuration
+func Default() Config {
+	return Config{
+		HTTP: HTTP{
+			Addr:         ":9090",
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 45 * time.Second,
+		},
+		DB: DB{
+			SqliteFile: "modems.db",
+		},
+		Discovery: Discovery{
+			Interface: "eno1",
+			// "icmp6 and ether[6:4] & 0xffffff00 = 0x001e4200" only looks for teltonika devices
+			Filter:       "icmp6 and ether",
+			Snaplen:      512,
+			Timeout:      40 * time.Second,
+			PingInterval: 10 * time.Second,
+		},
+		SNMP: SNMP{
+			SwitchAddress:     "192.168.2.1",
+			Port:              161,
+			Community:         "public",
+			WriteCommunity:    "private",
+			Timeout:           2 * time.Second,
+			PollInterval:      10 * time.Second,
+			PowerCycleOffTime: 5 * time.Second,
+			ModemMACPrefix:    "00:1f:43:",
+		},
+		SSH: SSH{
+			User:     "root",
+			Password: "admin",
+			Port:     22,
+			Timeout:  10 * time.Second,
+		},
+		Upgrade: Upgrade{
+			Timeout: 3 * time.Minute,
+		},
+		Workflow: Workflow{
+			Interval: time.Second,
+		},
+		Label: Label{
+			Template: "default",
+			Timeout:  10 * time.Second,
+		},
+		Test: Test{
+			Steps:               slices.Clone(functest.DefaultSteps),
+			Timeout:             5 * time.Minute,
+			RegistrationTimeout: time.Minute,
+			PingHost:            "8.8.8.8",
+			PingCount:           4,
+			MinSignal:           -95,
+			SMSTimeout:          time.Minute,
+			LANInterface:        "eth0",
+		},
+		SIM: SIM{
+			URL:         sim.DefaultTwilioURL,
+			Timeout:     30 * time.Second,
+			MaxAttempts: 5,
+			RetryDelay:  30 * time.Second,
+		},
+		Log: Log{
+			Format: "text",
+			Level:  "info",
+		},
+	}
+}
+
+// LoadFile reads the YAML file at path over c. Settings missing from the file
+// keep their value, unknown settings are an error.
+func LoadFile(path string, c *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	err = dec.Decode(c)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid configuration file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate checks that the configuration is usable, it reports all problems at once
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.HTTP.Addr != "", "http.addr is required")
+	check(c.HTTP.ReadTimeout >= 0, "http.read_timeout must not be negative")
+	check(c.HTTP.WriteTimeout >= 0, "http.write_timeout must not be negative")
+	check((c.HTTP.TLSCert == "") == (c.HTTP.TLSKey == ""), "http.tls_cert and http.tls_key must be set together")
+	check(c.HTTP.ClientCA == "" || c.HTTP.TLSCert != "", "http.client_ca requires http.tls_cert and http.tls_key")
+	check(!c.HTTP.RequireClientCert || c.HTTP.ClientCA != "", "http.require_client_cert requires http.client_ca")
+	for name, role := range c.HTTP.CertRoles {
+		check(model.ValidRole(role), "http.cert_roles: unknown role %q for %q", role, name)
+	}
+
+	check(c.DB.SqliteFile != "", "db.sqlite_file is required")
+
+	if c.Discovery.Enabled {
+		check(c.Discovery.Interface != "", "discovery.interface is required")
+		check(c.Discovery.Snaplen > 0, "discovery.snaplen must be positive")
+		check(c.Discovery.Timeout > 0, "discovery.timeout must be positive")
+		check(c.Discovery.PingInterval > 0, "discovery.ping_interval must be positive")
+
+		check(c.SNMP.SwitchAddress != "", "snmp.switch_address is required")
+		check(c.SNMP.Port != 0, "snmp.port is required")
+		check(c.SNMP.Community != "", "snmp.community is required")
+		check(c.SNMP.Timeout > 0, "snmp.timeout must be positive")
+		check(c.SNMP.PollInterval > 0, "snmp.poll_interval must be positive")
+
+		check(c.SSH.User != "", "ssh.user is required")
+		check(c.SSH.Port != 0, "ssh.port is required")
+		check(c.SSH.Timeout > 0, "ssh.timeout must be positive")
+
+		check(c.Upgrade.Disabled || c.Upgrade.Timeout > 0, "upgrade.timeout must be positive")
+
+		check(c.Workflow.Interval > 0, "workflow.interval must be positive")
+	}
+	check(c.SNMP.PowerCycleOffTime > 0, "snmp.power_cycle_off_time must be positive")
+
+	check(c.Label.Template != "", "label.template is required")
+	check(c.Label.Timeout > 0, "label.timeout must be positive")
+
+	if _, err := c.Test.TestPlan(); err != nil {
+		errs = append(errs, err)
+	}
+	check(c.Test.Timeout > 0, "test.timeout must be positive")
+	check(c.Test.RegistrationTimeout > 0, "test.registration_timeout must be positive")
+	check(c.Test.PingHost != "", "test.ping_host is required")
+	check(c.Test.PingCount > 0, "test.ping_count must be positive")
+	check(c.Test.SMSTimeout > 0, "test.sms_timeout must be positive")
+	check(c.Test.LANInterface != "", "test.lan_interface is required")
+
+	check(c.SIM.Provider == "" || c.SIM.Provider == "twilio", "sim.provider must be empty or twilio")
+	check(!c.SIM.Auto || c.SIM.Provider != "", "sim.auto requires sim.provider")
+	if c.SIM.Provider != "" {
+		check(c.SIM.URL != "", "sim.url is required")
+		check(c.SIM.AccountSID != "", "sim.account_sid is required")
+		check(c.SIM.AuthToken != "", "sim.auth_token is required")
+	}
+	check(c.SIM.Timeout > 0, "sim.timeout must be positive")
+	check(c.SIM.MaxAttempts > 0, "sim.max_attempts must be positive")
+	check(c.SIM.RetryDelay > 0, "sim.retry_delay must be positive")
+
+	check(c.Escrow.Key == "" || c.Escrow.KeyFile == "", "escrow.key and escrow.key_file can not both be set")
+	if c.Escrow.Key != "" {
+		if _, err := escrow.ParseKey(c.Escrow.Key); err != nil {
+			errs = append(errs, fmt.Errorf("escrow.key: %w", err))
+		}
+	}
+
+	check(c.Log.Format == "text" || c.Log.Format == "json", "log.format must be text or json")
+	if _, err := logging.ParseLevel(c.Log.Level); err != nil {
+		errs = append(errs, fmt.Errorf("log.level: %w", err))
+	}
+	for name, level := range c.Log.Levels {
+		if _, err := logging.ParseLevel(level); err != nil {
+			errs = append(errs, fmt.Errorf("log.levels: %s: %w", name, err))
+		}
+		check(slices.Contains(logging.Subsystems(), name), "log.levels: unknown subsystem %q", name)
+	}
+
+	return errors.Join(errs...)
+}
+
+// redacted replaces secrets when printing the configuration
+const redacted = "<redacted>"
+
+// YAML returns the configuration as YAML with secrets redacted
+func (c Config) YAML() ([]byte, error) {
+	for _, secret := range []*string{&c.Auth.AdminAPIKey, &c.SNMP.WriteCommunity, &c.SSH.Password, &c.SIM.AuthToken, &c.Escrow.Key} {
+		if *secret != "" {
+			*secret = redacted
+		}
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), enc.Close()
+}
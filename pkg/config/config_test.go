@@ -0,0 +1,150 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+)
+
+// load reads the configuration like the server does: the defaults, then the
+// YAML file, then environment variables and flags
+func load(t *testing.T, yaml string, env map[string]string, args ...string) Config {
+	t.Helper()
+
+	c := Default()
+	if yaml != "" {
+		file := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(file, []byte(yaml), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := LoadFile(file, &c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+	if _, err := flags.NewParser(&c, flags.None).ParseArgs(args); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+const testYAML = `
+http:
+  addr: ":8080"
+  read_timeout: 30s
+  cors_origins: [https://yaml.example.com]
+snmp:
+  switch_address: 10.0.0.1
+  community: bench
+ssh:
+  user: yaml
+log:
+  levels:
+    ssh: debug
+`
+
+func TestPrecedence(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		yaml string
+		env  map[string]string
+		args []string
+		want func(c *Config)
+	}{
+		{"defaults", "", nil, nil, func(c *Config) {}},
+		{"file over defaults", testYAML, nil, nil, func(c *Config) {
+			c.HTTP.Addr, c.HTTP.ReadTimeout = ":8080", 30*time.Second
+			c.HTTP.CORSOrigins = []string{"https://yaml.example.com"}
+			c.SNMP.SwitchAddress, c.SNMP.Community = "10.0.0.1", "bench"
+			c.SSH.User = "yaml"
+			c.Log.Levels = map[string]string{"ssh": "debug"}
+		}},
+		{"environment over file", testYAML, map[string]string{
+			"HTTP_ADDR":      ":8081",
+			"SNMP_COMMUNITY": "env",
+			"CORS_ORIGINS":   "https://a.example.com,https://b.example.com",
+			"SSH_PORT":       "2222",
+		}, nil, func(c *Config) {
+			c.HTTP.Addr, c.HTTP.ReadTimeout = ":8081", 30*time.Second
+			c.HTTP.CORSOrigins = []string{"https://a.example.com", "https://b.example.com"}
+			c.SNMP.SwitchAddress, c.SNMP.Community = "10.0.0.1", "env"
+			c.SSH.User, c.SSH.Port = "yaml", 2222
+			c.Log.Levels = map[string]string{"ssh": "debug"}
+		}},
+		{"flags over environment", testYAML, map[string]string{
+			"HTTP_ADDR":      ":8081",
+			"SNMP_COMMUNITY": "env",
+		}, []string{"--http-addr", ":8082", "--ssh-user", "flag", "--snmp-poll-interval", "1m"}, func(c *Config) {
+			c.HTTP.Addr, c.HTTP.ReadTimeout = ":8082", 30*time.Second
+			c.HTTP.CORSOrigins = []string{"https://yaml.example.com"}
+			c.SNMP.SwitchAddress, c.SNMP.Community, c.SNMP.PollInterval = "10.0.0.1", "env", time.Minute
+			c.SSH.User = "flag"
+			c.Log.Levels = map[string]string{"ssh": "debug"}
+		}},
+		{"flags without file", "", nil, []string{"--discovery", "--iface", "eth2", "--log-subsystem-level", "snmp:warn"}, func(c *Config) {
+			c.Discovery.Enabled, c.Discovery.Interface = true, "eth2"
+			c.Log.Levels = map[string]string{"snmp": "warn"}
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			want := Default()
+			tt.want(&want)
+
+			// compared as YAML, the flags parser sets empty slices and maps where the defaults have nil
+			got, err := load(t, tt.yaml, tt.env, tt.args...).YAML()
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantYAML, err := want.YAML()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(wantYAML) {
+				t.Errorf("got\n%s\nwant\n%s", got, wantYAML)
+			}
+		})
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		yaml string
+		err  string
+	}{
+		{"empty", "", ""},
+		{"comments only", "# nothing set\n", ""},
+		{"unknown key", "http:\n  address: \":8080\"\n", "field address not found"},
+		{"wrong type", "snmp:\n  port: many\n", "cannot unmarshal"},
+		{"invalid duration", "http:\n  read_timeout: soon\n", "invalid configuration file"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(file, []byte(tt.yaml), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			c := Default()
+			err := LoadFile(file, &c)
+			if tt.err == "" {
+				if err != nil || !reflect.DeepEqual(c, Default()) {
+					t.Errorf("err %v, config changed %v", err, !reflect.DeepEqual(c, Default()))
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("err %v, want %q", err, tt.err)
+			}
+		})
+	}
+
+	if err := LoadFile(filepath.Join(t.TempDir(), "missing.yaml"), &Config{}); !os.IsNotExist(err) {
+		t.Errorf("missing file: %v", err)
+	}
+}
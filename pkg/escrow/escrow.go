@@ -0,0 +1,120 @@
+// Package escrow generates modem admin passwords and encrypts them for storage
+// with AES-256-GCM.
+package escrow
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+)
+
+// KeySize is the size of an escrow key, AES-256
+const KeySize = 32
+
+// PasswordLength is the length of generated passwords
+const PasswordLength = 16
+
+// passwordAlphabet leaves out characters that are easily mixed up when a
+// password is read from a handover sheet, like 0 and O or 1, l and I
+const passwordAlphabet = "abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// ErrDecrypt is returned when a sealed password can not be decrypted, because
+// the key is wrong or the data was changed
+var ErrDecrypt = errors.New("failed to decrypt password, wrong key or corrupted data")
+
+// ParseKey parses a key given as 64 hex digits or as base64 of 32 bytes
+func ParseKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if key, err := hex.DecodeString(s); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(s); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("the escrow key must be %d bytes, as hex or base64", KeySize)
+}
+
+// LoadKey returns the key given directly or read from a file, nil if neither is set
+func LoadKey(key string, file string) ([]byte, error) {
+	if key != "" && file != "" {
+		return nil, errors.New("give the escrow key or a key file, not both")
+	}
+	if file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		key = string(b)
+	}
+	if key == "" {
+		return nil, nil
+	}
+	return ParseKey(key)
+}
+
+// Sealer encrypts and decrypts passwords with one key
+type Sealer struct {
+	aead cipher.AEAD
+}
+
+// New returns a sealer using key, which must be KeySize bytes
+func New(key []byte) (*Sealer, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("the escrow key must be %d bytes", KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Sealer{aead: aead}, nil
+}
+
+// Seal encrypts a password. The MAC address of the modem is authenticated with
+// it, so a sealed password can not be moved to another modem.
+func (s *Sealer) Seal(password string, mac string) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := s.aead.Seal(nonce, nonce, []byte(password), []byte(mac))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a password sealed for the modem with the MAC address
+func (s *Sealer) Open(sealed string, mac string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(b) < s.aead.NonceSize() {
+		return "", ErrDecrypt
+	}
+	nonce, ciphertext := b[:s.aead.NonceSize()], b[s.aead.NonceSize():]
+	password, err := s.aead.Open(nil, nonce, ciphertext, []byte(mac))
+	if err != nil {
+		return "", ErrDecrypt
+	}
+	return string(password), nil
+}
+
+// GeneratePassword returns a random password of PasswordLength characters
+func GeneratePassword() (string, error) {
+	max := big.NewInt(int64(len(passwordAlphabet)))
+	b := make([]byte, PasswordLength)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = passwordAlphabet[n.Int64()]
+	}
+	return string(b), nil
+}
@@ -0,0 +1,118 @@
+package escrow
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testSealer(t *testing.T, b byte) *Sealer {
+	t.Helper()
+
+	s, err := New(bytes.Repeat([]byte{b}, KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSealOpen(t *testing.T) {
+	const mac = "00:1f:43:00:00:01"
+	s := testSealer(t, 1)
+
+	sealed, err := s.Seal("secret", mac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sealed, "secret") {
+		t.Fatalf("sealed password %q is readable", sealed)
+	}
+	again, err := s.Seal("secret", mac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == sealed {
+		t.Error("sealing twice gives the same result, the nonce is not random")
+	}
+
+	password, err := s.Open(sealed, mac)
+	if err != nil || password != "secret" {
+		t.Fatalf("opened %q, %v", password, err)
+	}
+
+	b, _ := base64.StdEncoding.DecodeString(sealed)
+	b[len(b)-1] ^= 1
+	for _, tt := range []struct {
+		name   string
+		sealer *Sealer
+		sealed string
+		mac    string
+	}{
+		{"other modem", s, sealed, "00:1f:43:00:00:02"},
+		{"other key", testSealer(t, 2), sealed, mac},
+		{"changed", s, base64.StdEncoding.EncodeToString(b), mac},
+		{"not base64", s, "not base64!", mac},
+		{"too short", s, base64.StdEncoding.EncodeToString([]byte{1, 2, 3}), mac},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if password, err := tt.sealer.Open(tt.sealed, tt.mac); !errors.Is(err, ErrDecrypt) {
+				t.Errorf("opened %q, %v", password, err)
+			}
+		})
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	key := bytes.Repeat([]byte{0xab}, KeySize)
+	file := filepath.Join(t.TempDir(), "escrow.key")
+	if err := os.WriteFile(file, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		key  string
+		file string
+		want []byte
+		err  bool
+	}{
+		{"none", "", "", nil, false},
+		{"hex", hex.EncodeToString(key), "", key, false},
+		{"base64", base64.StdEncoding.EncodeToString(key), "", key, false},
+		{"file", "", file, key, false},
+		{"both", hex.EncodeToString(key), file, nil, true},
+		{"short", hex.EncodeToString(key[:16]), "", nil, true},
+		{"missing file", "", file + ".missing", nil, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadKey(tt.key, tt.file)
+			if (err != nil) != tt.err || !bytes.Equal(got, tt.want) {
+				t.Errorf("got %x, %v", got, err)
+			}
+		})
+	}
+}
+
+func TestGeneratePassword(t *testing.T) {
+	a, err := GeneratePassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := GeneratePassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a) != PasswordLength || a == b {
+		t.Errorf("generated %q and %q", a, b)
+	}
+	for _, c := range a {
+		if !strings.ContainsRune(passwordAlphabet, c) {
+			t.Errorf("%q has %q, which is not in the alphabet", a, c)
+		}
+	}
+}
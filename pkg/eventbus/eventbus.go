@@ -0,0 +1,193 @@
+package eventbus
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	ModemAdded       = "modem.added"
+	ModemUpdated     = "modem.updated"
+	ModemDeleted     = "modem.deleted"
+	OrderChanged     = "order.changed"
+	ModemDiscovered  = "discovery.modem"
+	SwitchPortMapped = "discovery.port"
+	ModemInfoRead    = "discovery.info"
+	ReadFailed       = "discovery.failed"
+	UpgradeFinished  = "upgrade.finished"
+	UpgradeFailed    = "upgrade.failed"
+	LabelJobChanged  = "label.job"
+	Discrepancy      = "reconcile.discrepancy"
+	Provisioning     = "provision.status"
+	TestRun          = "test.status"
+	SIMJobChanged    = "sim.job"
+)
+
+// Event is a message published on the bus
+type Event struct {
+	ID         uint64      `json:"id"`
+	Type       string      `json:"type"`
+	MacAddress string      `json:"mac_address,omitempty"`
+	Timestamp  int64       `json:"timestamp"`
+	Data       interface{} `json:"data,omitempty"`
+}
+
+// Filter selects the events a subscriber receives, empty fields match everything.
+// A type ending in ".*" matches all types with that prefix, e.g. "modem.*".
+type Filter struct {
+	MacAddresses []string
+	Types        []string
+}
+
+// Bus delivers published events to subscribers and keeps the most recent events
+// so subscribers can resume after reconnecting.
+type Bus struct {
+	mu          sync.Mutex
+	nextID      uint64
+	history     []Event
+	historySize int
+	subscribers map[*Subscription]struct{}
+}
+
+// Subscription receives the events matching its filter on C. If the subscriber
+// does not keep up and its buffer fills, C is closed and Lagged returns true.
+type Subscription struct {
+	C <-chan Event
+
+	c      chan Event
+	filter Filter
+	bus    *Bus
+	lagged bool
+	closed bool
+}
+
+// New creates a bus remembering the last historySize events
+func New(historySize int) *Bus {
+	return &Bus{
+		nextID:      1,
+		historySize: historySize,
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish assigns the event an ID and timestamp and delivers it to all matching
+// subscribers without blocking.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	e.ID = b.nextID
+	b.nextID++
+	e.Timestamp = time.Now().Unix()
+
+	b.history = append(b.history, e)
+	if len(b.history) > b.historySize {
+		b.history = b.history[len(b.history)-b.historySize:]
+	}
+
+	for sub := range b.subscribers {
+		if !sub.filter.matches(e) {
+			continue
+		}
+		select {
+		case sub.c <- e:
+		default:
+			// Slow subscriber, drop it rather than blocking the publishers
+			sub.lagged = true
+			b.unsubscribe(sub)
+		}
+	}
+}
+
+// Subscribe returns a subscription with room for buffer events. Events published
+// after lastID that are still in the history are delivered first, a lastID of 0
+// only delivers new events.
+func (b *Bus) Subscribe(filter Filter, lastID uint64, buffer int) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := make(chan Event, buffer)
+	sub := &Subscription{
+		C:      c,
+		c:      c,
+		filter: filter,
+		bus:    b,
+	}
+	b.subscribers[sub] = struct{}{}
+
+	if lastID > 0 {
+		for _, e := range b.history {
+			if e.ID <= lastID || !filter.matches(e) {
+				continue
+			}
+			select {
+			case c <- e:
+			default:
+				sub.lagged = true
+				b.unsubscribe(sub)
+				return sub
+			}
+		}
+	}
+	return sub
+}
+
+// Close ends the subscription and closes C
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	s.bus.unsubscribe(s)
+}
+
+// Lagged reports whether the subscription was closed because its buffer was full
+func (s *Subscription) Lagged() bool {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	return s.lagged
+}
+
+// unsubscribe must be called with the bus lock held
+func (b *Bus) unsubscribe(sub *Subscription) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	delete(b.subscribers, sub)
+	close(sub.c)
+}
+
+func (f Filter) matches(e Event) bool {
+	if !contains(f.MacAddresses, e.MacAddress) {
+		return false
+	}
+	if len(f.Types) == 0 {
+		return true
+	}
+	for _, t := range f.Types {
+		if t == e.Type || (strings.HasSuffix(t, ".*") && strings.HasPrefix(e.Type, strings.TrimSuffix(t, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// contains reports whether value is in list, an empty list contains everything
+func contains(list []string, value string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package eventbus
+
+import (
+	"fmt"
+	"testing"
+)
+
+// received drains the events buffered for a subscription
+func received(sub *Subscription) []string {
+	var got []string
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				return got
+			}
+			got = append(got, fmt.Sprintf("%d %s %s", e.ID, e.Type, e.MacAddress))
+		default:
+			return got
+		}
+	}
+}
+
+func publishTestEvents(b *Bus) {
+	b.Publish(Event{Type: ModemAdded, MacAddress: "00:1f:43:00:00:01"})
+	b.Publish(Event{Type: ModemUpdated, MacAddress: "00:1f:43:00:00:02"})
+	b.Publish(Event{Type: UpgradeFinished, MacAddress: "00:1f:43:00:00:01"})
+	b.Publish(Event{Type: OrderChanged})
+}
+
+func TestFilter(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		filter Filter
+		want   string
+	}{
+		{"everything", Filter{}, "[1 modem.added 00:1f:43:00:00:01 2 modem.updated 00:1f:43:00:00:02 3 upgrade.finished 00:1f:43:00:00:01 4 order.changed ]"},
+		{"type", Filter{Types: []string{UpgradeFinished}}, "[3 upgrade.finished 00:1f:43:00:00:01]"},
+		{"types", Filter{Types: []string{ModemAdded, OrderChanged}}, "[1 modem.added 00:1f:43:00:00:01 4 order.changed ]"},
+		{"type prefix", Filter{Types: []string{"modem.*"}}, "[1 modem.added 00:1f:43:00:00:01 2 modem.updated 00:1f:43:00:00:02]"},
+		{"prefix needs the dot", Filter{Types: []string{"mod.*"}}, "[]"},
+		{"mac", Filter{MacAddresses: []string{"00:1f:43:00:00:01"}}, "[1 modem.added 00:1f:43:00:00:01 3 upgrade.finished 00:1f:43:00:00:01]"},
+		{"mac and type", Filter{MacAddresses: []string{"00:1f:43:00:00:01"}, Types: []string{"upgrade.*"}}, "[3 upgrade.finished 00:1f:43:00:00:01]"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b := New(10)
+			sub := b.Subscribe(tt.filter, 0, 10)
+			defer sub.Close()
+
+			publishTestEvents(b)
+			if got := fmt.Sprint(received(sub)); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubscribeReplay(t *testing.T) {
+	// the history of 3 keeps events 2 to 4
+	for _, tt := range []struct {
+		name   string
+		filter Filter
+		lastID uint64
+		want   string
+	}{
+		{"new events only", Filter{}, 0, "[5 modem.deleted 00:1f:43:00:00:01]"},
+		{"after the last id", Filter{}, 2, "[3 upgrade.finished 00:1f:43:00:00:01 4 order.changed  5 modem.deleted 00:1f:43:00:00:01]"},
+		{"history is filtered", Filter{MacAddresses: []string{"00:1f:43:00:00:01"}}, 2, "[3 upgrade.finished 00:1f:43:00:00:01 5 modem.deleted 00:1f:43:00:00:01]"},
+		{"older than the history", Filter{}, 1, "[2 modem.updated 00:1f:43:00:00:02 3 upgrade.finished 00:1f:43:00:00:01 4 order.changed  5 modem.deleted 00:1f:43:00:00:01]"},
+		{"up to date", Filter{}, 4, "[5 modem.deleted 00:1f:43:00:00:01]"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b := New(3)
+			publishTestEvents(b)
+
+			sub := b.Subscribe(tt.filter, tt.lastID, 10)
+			defer sub.Close()
+			b.Publish(Event{Type: ModemDeleted, MacAddress: "00:1f:43:00:00:01"})
+
+			if got := fmt.Sprint(received(sub)); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLaggedSubscriber(t *testing.T) {
+	b := New(10)
+	slow := b.Subscribe(Filter{}, 0, 2)
+	other := b.Subscribe(Filter{Types: []string{OrderChanged}}, 0, 2)
+	defer other.Close()
+
+	publishTestEvents(b)
+
+	// the buffered events are still delivered before C is closed
+	if got := fmt.Sprint(received(slow)); got != "[1 modem.added 00:1f:43:00:00:01 2 modem.updated 00:1f:43:00:00:02]" {
+		t.Errorf("got %s", got)
+	}
+	if _, ok := <-slow.C; ok {
+		t.Error("C is not closed")
+	}
+	if !slow.Lagged() {
+		t.Error("not lagged")
+	}
+	slow.Close()
+
+	// other subscribers keep receiving events
+	b.Publish(Event{Type: OrderChanged})
+	if got := fmt.Sprint(received(other)); got != "[4 order.changed  5 order.changed ]" {
+		t.Errorf("other got %s", got)
+	}
+	if other.Lagged() {
+		t.Error("other lagged")
+	}
+}
+
+func TestReplayLagged(t *testing.T) {
+	b := New(10)
+	publishTestEvents(b)
+
+	sub := b.Subscribe(Filter{}, 1, 2)
+	if got := fmt.Sprint(received(sub)); got != "[2 modem.updated 00:1f:43:00:00:02 3 upgrade.finished 00:1f:43:00:00:01]" {
+		t.Errorf("got %s", got)
+	}
+	if !sub.Lagged() {
+		t.Error("not lagged")
+	}
+}
+
+func TestClose(t *testing.T) {
+	b := New(10)
+	sub := b.Subscribe(Filter{}, 0, 2)
+	sub.Close()
+	sub.Close()
+
+	b.Publish(Event{Type: OrderChanged})
+	if _, ok := <-sub.C; ok {
+		t.Error("received after Close")
+	}
+	if sub.Lagged() {
+		t.Error("lagged after Close")
+	}
+
+	var nilBus *Bus
+	nilBus.Publish(Event{Type: OrderChanged})
+}
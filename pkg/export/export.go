@@ -0,0 +1,216 @@
+// Package export writes modem records as CSV, JSON or Excel files, for example
+// as shipping manifests. Records are written one at a time so exports of any
+// size can be streamed.
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+// Formats are the supported export formats
+var Formats = []string{"csv", "xlsx", "json"}
+
+// Column is one exported field of a modem
+type Column struct {
+	Name  string
+	value func(m model.Modem) interface{}
+}
+
+// NewColumn returns a column with values the caller provides, e.g. from another store
+func NewColumn(name string, value func(m model.Modem) interface{}) Column {
+	return Column{Name: name, value: value}
+}
+
+// columns are all exportable columns in their default order
+var columns = []Column{
+	{"mac_address", func(m model.Modem) interface{} { return m.MacAddress }},
+	{"imei", func(m model.Modem) interface{} { return m.IMEI }},
+	{"iccid", func(m model.Modem) interface{} { return m.ICCID }},
+	{"imsi", func(m model.Modem) interface{} { return m.IMSI }},
+	{"serial", func(m model.Modem) interface{} { return m.Serial }},
+	{"model", func(m model.Modem) interface{} { return m.Model }},
+	{"firmware", func(m model.Modem) interface{} { return m.Firmware }},
+	{"kernel", func(m model.Modem) interface{} { return m.Kernel }},
+	{"sim_provider", func(m model.Modem) interface{} { return m.SIMProvider }},
+	{"sim_status", func(m model.Modem) interface{} { return m.SIMStatus }},
+	{"state", func(m model.Modem) interface{} { return model.StateName(m.State) }},
+	{"upgraded", func(m model.Modem) interface{} { return m.Upgraded }},
+	{"progress", func(m model.Modem) interface{} { return m.Progress }},
+	{"fail_count", func(m model.Modem) interface{} { return m.FailCount }},
+	{"test_status", func(m model.Modem) interface{} { return m.TestStatus }},
+	{"switch_port", func(m model.Modem) interface{} { return m.SwitchPort }},
+	{"ipv6", func(m model.Modem) interface{} { return m.IPV6 }},
+	{"last_updated", func(m model.Modem) interface{} { return formatTime(m.LastUpdated) }},
+	{"version", func(m model.Modem) interface{} { return m.Version }},
+}
+
+// DefaultColumns are the columns of a shipping manifest
+var DefaultColumns = []string{"mac_address", "imei", "iccid", "imsi", "serial", "model", "firmware"}
+
+// ColumnNames returns the names of all exportable columns
+func ColumnNames() []string {
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// Columns returns the named columns in the given order, DefaultColumns if names is empty
+func Columns(names []string) ([]Column, error) {
+	if len(names) == 0 {
+		names = DefaultColumns
+	}
+
+	selected := make([]Column, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if seen[name] {
+			return nil, fmt.Errorf("column %q is selected more than once", name)
+		}
+		seen[name] = true
+
+		found := false
+		for _, c := range columns {
+			if c.Name == name {
+				selected = append(selected, c)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown column %q, use %s", name, strings.Join(ColumnNames(), ", "))
+		}
+	}
+	return selected, nil
+}
+
+// ContentType returns the media type of a format
+func ContentType(format string) string {
+	switch format {
+	case "csv":
+		return "text/csv; charset=utf-8"
+	case "xlsx":
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	default:
+		return "application/json"
+	}
+}
+
+// Writer writes modems to an export. Close must be called to finish the file.
+type Writer interface {
+	Write(m model.Modem) error
+	Close() error
+}
+
+// NewWriter returns a writer of the format writing the columns to w. The
+// header, if the format has one, is written immediately.
+func NewWriter(w io.Writer, format string, cols []Column) (Writer, error) {
+	switch format {
+	case "csv":
+		return newCSVWriter(w, cols)
+	case "xlsx":
+		return newXLSXWriter(w, cols)
+	case "json":
+		return newJSONWriter(w, cols)
+	default:
+		return nil, fmt.Errorf("unknown export format %q, use %s", format, strings.Join(Formats, ", "))
+	}
+}
+
+// formatValue formats a column value as text for CSV and Excel
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// formatTime formats a unix timestamp as RFC 3339 in UTC
+func formatTime(timestamp int) string {
+	if timestamp == 0 {
+		return ""
+	}
+	return time.Unix(int64(timestamp), 0).UTC().Format(time.RFC3339)
+}
+
+type csvWriter struct {
+	w    *csv.Writer
+	cols []Column
+	row  []string
+}
+
+func newCSVWriter(w io.Writer, cols []Column) (*csvWriter, error) {
+	cw := &csvWriter{w: csv.NewWriter(w), cols: cols, row: make([]string, len(cols))}
+	for i, c := range cols {
+		cw.row[i] = c.Name
+	}
+	return cw, cw.w.Write(cw.row)
+}
+
+func (cw *csvWriter) Write(m model.Modem) error {
+	for i, c := range cw.cols {
+		cw.row[i] = formatValue(c.value(m))
+	}
+	return cw.w.Write(cw.row)
+}
+
+func (cw *csvWriter) Close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// jsonWriter writes an array of objects with the columns in order
+type jsonWriter struct {
+	w     io.Writer
+	cols  []Column
+	count int
+}
+
+func newJSONWriter(w io.Writer, cols []Column) (*jsonWriter, error) {
+	_, err := io.WriteString(w, "[")
+	return &jsonWriter{w: w, cols: cols}, err
+}
+
+func (jw *jsonWriter) Write(m model.Modem) error {
+	var b strings.Builder
+	if jw.count > 0 {
+		b.WriteString(",")
+	}
+	b.WriteString("\n{")
+	for i, c := range jw.cols {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		value, err := json.Marshal(c.value(m))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, "%q:%s", c.Name, value)
+	}
+	b.WriteString("}")
+	jw.count++
+
+	_, err := io.WriteString(jw.w, b.String())
+	return err
+}
+
+func (jw *jsonWriter) Close() error {
+	_, err := io.WriteString(jw.w, "\n]\n")
+	return err
+}
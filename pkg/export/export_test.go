@@ -0,0 +1,240 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+var testModems = []model.Modem{
+	{MacAddress: "00:1f:43:00:00:01", IMEI: "861234567890123", ICCID: "89470000000000000001", Serial: "1100123456",
+		Model: "TRB140", State: model.StateNormal, Upgraded: true, Progress: 100, LastUpdated: 1700000000, Version: 3},
+	// values that need quoting or escaping
+	{MacAddress: "00:1f:43:00:00:02", Serial: `11"00, <7> & 8`, Model: "TRB140\nrev B", State: model.StateError, FailCount: 2},
+}
+
+var testColumns = []string{"mac_address", "imei", "iccid", "serial", "model", "state", "upgraded", "fail_count", "last_updated", "version"}
+
+// wantRows are the test modems as text, with the header
+var wantRows = [][]string{
+	testColumns,
+	{"00:1f:43:00:00:01", "861234567890123", "89470000000000000001", "1100123456", "TRB140", "normal", "true", "0", "2023-11-14T22:13:20Z", "3"},
+	{"00:1f:43:00:00:02", "", "", `11"00, <7> & 8`, "TRB140\nrev B", "error", "false", "2", "", "0"},
+}
+
+// export writes the test modems in the format
+func export(t *testing.T, format string, names []string) []byte {
+	t.Helper()
+
+	cols, err := Columns(names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, format, cols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range testModems {
+		if err := w.Write(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCSV(t *testing.T) {
+	rows, err := csv.NewReader(bytes.NewReader(export(t, "csv", testColumns))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rows, wantRows) {
+		t.Errorf("rows %q, want %q", rows, wantRows)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	out := export(t, "json", testColumns)
+
+	var got []map[string]interface{}
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatalf("%s: %v", out, err)
+	}
+	want := []map[string]interface{}{
+		{"mac_address": "00:1f:43:00:00:01", "imei": "861234567890123", "iccid": "89470000000000000001", "serial": "1100123456",
+			"model": "TRB140", "state": "normal", "upgraded": true, "fail_count": 0.0, "last_updated": "2023-11-14T22:13:20Z", "version": 3.0},
+		{"mac_address": "00:1f:43:00:00:02", "imei": "", "iccid": "", "serial": `11"00, <7> & 8`,
+			"model": "TRB140\nrev B", "state": "error", "upgraded": false, "fail_count": 2.0, "last_updated": "", "version": 0.0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// the keys are in column order
+	if first := strings.SplitN(string(out), "\n", 3)[1]; !strings.HasPrefix(first, `{"mac_address":`) || !strings.Contains(first, `"fail_count":0,"last_updated"`) {
+		t.Errorf("first record %s", first)
+	}
+
+	var empty []interface{}
+	if err := json.Unmarshal(exportNone(t, "json"), &empty); err != nil || len(empty) != 0 {
+		t.Errorf("empty export %v: %v", empty, err)
+	}
+}
+
+// exportNone writes an export without modems
+func exportNone(t *testing.T, format string) []byte {
+	t.Helper()
+
+	cols, _ := Columns(nil)
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, format, cols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// sheetRows reads the cells of the sheet of a workbook, and whether each row is bold
+func sheetRows(t *testing.T, workbook []byte) ([][]string, []bool) {
+	t.Helper()
+
+	zr, err := zip.NewReader(bytes.NewReader(workbook), int64(len(workbook)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	var sheet []byte
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// every part is well formed XML
+		dec := xml.NewDecoder(bytes.NewReader(data))
+		for {
+			if _, err := dec.Token(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%s: %v", f.Name, err)
+			}
+		}
+		if f.Name == "xl/worksheets/sheet1.xml" {
+			sheet = data
+		}
+	}
+	wantNames := []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml", "xl/worksheets/sheet1.xml"}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("parts %q", names)
+	}
+
+	var ws struct {
+		Rows []struct {
+			Cells []struct {
+				Type  string `xml:"t,attr"`
+				Style string `xml:"s,attr"`
+				Text  string `xml:"is>t"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := xml.Unmarshal(sheet, &ws); err != nil {
+		t.Fatal(err)
+	}
+	var rows [][]string
+	var bold []bool
+	for _, r := range ws.Rows {
+		var row []string
+		for _, c := range r.Cells {
+			if c.Type != "inlineStr" {
+				t.Errorf("cell %q of type %q", c.Text, c.Type)
+			}
+			row = append(row, c.Text)
+		}
+		rows = append(rows, row)
+		bold = append(bold, len(r.Cells) > 0 && r.Cells[0].Style == "1")
+	}
+	return rows, bold
+}
+
+func TestXLSX(t *testing.T) {
+	rows, bold := sheetRows(t, export(t, "xlsx", testColumns))
+	if !reflect.DeepEqual(rows, wantRows) {
+		t.Errorf("rows %q, want %q", rows, wantRows)
+	}
+	if !reflect.DeepEqual(bold, []bool{true, false, false}) {
+		t.Errorf("bold rows %v, want the header", bold)
+	}
+
+	rows, _ = sheetRows(t, exportNone(t, "xlsx"))
+	if !reflect.DeepEqual(rows, [][]string{DefaultColumns}) {
+		t.Errorf("empty export %q", rows)
+	}
+}
+
+func TestColumns(t *testing.T) {
+	for _, tt := range []struct {
+		names []string
+		want  []string
+		err   string
+	}{
+		{nil, DefaultColumns, ""},
+		{[]string{"serial", " mac_address "}, []string{"serial", "mac_address"}, ""},
+		{[]string{"serial", "password"}, nil, `unknown column "password"`},
+		{[]string{"imei", "imei"}, nil, `column "imei" is selected more than once`},
+	} {
+		cols, err := Columns(tt.names)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%q: err %v, want %q", tt.names, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.names, err)
+			continue
+		}
+		var got []string
+		for _, c := range cols {
+			got = append(got, c.Name)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: columns %q", tt.names, got)
+		}
+	}
+
+	// a column provided by the caller
+	extra := NewColumn("order", func(m model.Modem) interface{} { return "WO-" + m.MacAddress[len(m.MacAddress)-2:] })
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, "csv", []Column{extra})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(testModems[0])
+	w.Close()
+	if buf.String() != "order\nWO-01\n" {
+		t.Errorf("got %q", buf.String())
+	}
+
+	if _, err := NewWriter(&buf, "pdf", nil); err == nil || !strings.Contains(err.Error(), "use csv, xlsx, json") {
+		t.Errorf("unknown format: %v", err)
+	}
+}
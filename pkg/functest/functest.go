@@ -0,0 +1,244 @@
+// Package functest runs the functional production tests of modems over SSH.
+//
+// A test is a chain of steps described by a Plan. Each step is a TestStep
+// looked up by name in a registry, so a customer specific check is added by
+// registering a new step, without changing the workflow running the plan.
+package functest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+	"github.com/ebobo/modem_prod_go/pkg/provision"
+)
+
+// TestStep is one kind of functional test. Run returns what it measured, and
+// an error if the modem failed the step. The measurements are checked against
+// the limits of the plan afterwards.
+type TestStep interface {
+	Name() string
+	Run(ctx context.Context, r provision.Runner, params Params) (model.Measurements, error)
+}
+
+// ParamChecker is implemented by steps that check their params when a plan is
+// loaded, so a bad plan is found before the first modem is tested
+type ParamChecker interface {
+	CheckParams(params Params) error
+}
+
+// stepFunc is a TestStep calling a function
+type stepFunc struct {
+	name  string
+	run   func(ctx context.Context, r provision.Runner, params Params) (model.Measurements, error)
+	check func(params Params) error
+}
+
+func (s stepFunc) Name() string { return s.name }
+
+func (s stepFunc) Run(ctx context.Context, r provision.Runner, params Params) (model.Measurements, error) {
+	return s.run(ctx, r, params)
+}
+
+func (s stepFunc) CheckParams(params Params) error {
+	if s.check == nil {
+		return nil
+	}
+	return s.check(params)
+}
+
+// NewStep returns a step calling run, check may be nil if the step has no params to check
+func NewStep(name string, run func(ctx context.Context, r provision.Runner, params Params) (model.Measurements, error), check func(params Params) error) TestStep {
+	return stepFunc{name: name, run: run, check: check}
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]TestStep{}
+)
+
+// Register makes a step available to test plans. It panics if a step with
+// the same name is registered already, as steps are registered in init.
+func Register(step TestStep) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, ok := registry[step.Name()]; ok {
+		panic("functest: step " + step.Name() + " is registered twice")
+	}
+	registry[step.Name()] = step
+}
+
+// Lookup returns the registered step with the name
+func Lookup(name string) (TestStep, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	step, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown test step %q, use one of %s", name, strings.Join(stepNames(), ", "))
+	}
+	return step, nil
+}
+
+// StepNames returns the names of the registered steps in alphabetical order
+func StepNames() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	return stepNames()
+}
+
+func stepNames() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Params are the settings of a step in a plan, e.g. the host to ping
+type Params map[string]string
+
+// String returns the param name, or def if it is not set
+func (p Params) String(name string, def string) string {
+	if v := p[name]; v != "" {
+		return v
+	}
+	return def
+}
+
+// Int returns the param name as an integer, or def if it is not set
+func (p Params) Int(name string, def int) (int, error) {
+	v := p[name]
+	if v == "" {
+		return def, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("param %s must be an integer, got %q", name, v)
+	}
+	return i, nil
+}
+
+// Duration returns the param name as a duration like 30s, or def if it is not set
+func (p Params) Duration(name string, def time.Duration) (time.Duration, error) {
+	v := p[name]
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("param %s must be a positive duration like 30s, got %q", name, v)
+	}
+	return d, nil
+}
+
+// Run runs the steps of the plan in order until one fails and passes the
+// result of each to record as soon as it is known. A failed step is run again
+// up to its retry count. It returns whether all steps passed.
+func (p Plan) Run(ctx context.Context, r provision.Runner, record func(model.TestResult)) bool {
+	for _, ps := range p.Steps {
+		result := ps.run(ctx, r)
+		record(result)
+		if !result.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+// run runs one step of a plan with its retries, the result is that of the last attempt
+func (ps PlanStep) run(ctx context.Context, r provision.Runner) model.TestResult {
+	result := model.TestResult{Step: ps.Name, StartedAt: int(time.Now().Unix())}
+
+	step, err := Lookup(ps.Step)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		measurements, err := step.Run(ctx, r, ps.Params)
+		if err == nil {
+			err = ps.checkLimits(measurements)
+		}
+
+		result.Attempts = attempt
+		result.Passed = err == nil
+		result.Measurements = measurements
+		result.DurationMs = time.Since(start).Milliseconds()
+		result.Error = ""
+		if err != nil {
+			result.Error = err.Error()
+		}
+		if err == nil || attempt > ps.Retries || ctx.Err() != nil {
+			return result
+		}
+		if wait(ctx, ps.RetryDelay) != nil {
+			return result
+		}
+	}
+}
+
+// checkLimits sets the limits of the plan step on the measurements and
+// returns an error naming those out of their limits
+func (ps PlanStep) checkLimits(measurements model.Measurements) error {
+	names := make([]string, 0, len(ps.Limits))
+	for name := range ps.Limits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems []string
+	for _, name := range names {
+		limit := ps.Limits[name]
+		i := slices.IndexFunc(measurements, func(m model.Measurement) bool { return m.Name == name })
+		if i < 0 {
+			problems = append(problems, name+" was not measured")
+			continue
+		}
+
+		m := &measurements[i]
+		m.Min, m.Max = limit.Min, limit.Max
+		switch {
+		case m.Min != nil && m.Value < *m.Min:
+			problems = append(problems, fmt.Sprintf("%s %s is below the minimum %s", name, formatValue(m.Value, m.Unit), formatValue(*m.Min, m.Unit)))
+		case m.Max != nil && m.Value > *m.Max:
+			problems = append(problems, fmt.Sprintf("%s %s is above the maximum %s", name, formatValue(m.Value, m.Unit), formatValue(*m.Max, m.Unit)))
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// formatValue formats a measured value with its unit, e.g. -67 dBm
+func formatValue(v float64, unit string) string {
+	if unit == "" {
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64) + " " + unit
+}
+
+// wait pauses for d, it returns an error if ctx ends first
+func wait(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
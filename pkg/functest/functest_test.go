@@ -0,0 +1,135 @@
+package functest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+	"github.com/ebobo/modem_prod_go/pkg/provision"
+)
+
+// flakyRuns counts the runs of the flaky step, which fails its first runs
+var flakyRuns int
+
+func init() {
+	Register(NewStep("test_flaky", func(ctx context.Context, r provision.Runner, params Params) (model.Measurements, error) {
+		flakyRuns++
+		fail, err := params.Int("fail", 0)
+		if err != nil {
+			return nil, err
+		}
+		if flakyRuns <= fail {
+			return nil, errors.New("not yet")
+		}
+		return model.Measurements{{Name: "rssi", Value: -60, Unit: "dBm"}}, nil
+	}, nil))
+}
+
+func limit(min, max *float64) Limit {
+	return Limit{Min: min, Max: max}
+}
+
+func value(v float64) *float64 {
+	return &v
+}
+
+func TestCheckLimits(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		limits map[string]Limit
+		err    string
+	}{
+		{"no limits", nil, ""},
+		{"within", map[string]Limit{"rssi": limit(value(-90), value(-50)), "loss": limit(nil, value(10))}, ""},
+		{"on the limits", map[string]Limit{"rssi": limit(value(-67), value(-67))}, ""},
+		{"below", map[string]Limit{"rssi": limit(value(-60), nil)}, "rssi -67 dBm is below the minimum -60 dBm"},
+		{"above", map[string]Limit{"loss": limit(nil, value(2.5))}, "loss 5 is above the maximum 2.5"},
+		{"not measured", map[string]Limit{"rsrp": limit(value(-100), nil)}, "rsrp was not measured"},
+		{"several", map[string]Limit{"rssi": limit(nil, value(-70)), "loss": limit(nil, value(1)), "rsrp": {}},
+			"loss 5 is above the maximum 1, rsrp was not measured, rssi -67 dBm is above the maximum -70 dBm"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			measurements := model.Measurements{{Name: "rssi", Value: -67, Unit: "dBm"}, {Name: "loss", Value: 5}}
+			err := PlanStep{Limits: tt.limits}.checkLimits(measurements)
+			if tt.err == "" && err != nil || tt.err != "" && (err == nil || err.Error() != tt.err) {
+				t.Fatalf("error %v, want %q", err, tt.err)
+			}
+			for _, m := range measurements {
+				if l, ok := tt.limits[m.Name]; ok && (m.Min != l.Min || m.Max != l.Max) {
+					t.Errorf("limits of %s not set on the measurement", m.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestRunRetries(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		fail     string
+		retries  int
+		limits   map[string]Limit
+		passed   bool
+		attempts int
+		runs     int
+	}{
+		{"passes", "0", 2, nil, true, 1, 1},
+		{"passes on a retry", "2", 2, nil, true, 3, 3},
+		{"fails all attempts", "3", 2, nil, false, 3, 3},
+		{"no retries", "1", 0, nil, false, 1, 1},
+		{"out of limits is retried", "0", 1, map[string]Limit{"rssi": limit(nil, value(-70))}, false, 2, 2},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			flakyRuns = 0
+			plan := Plan{Steps: []PlanStep{
+				{Name: "flaky", Step: "test_flaky", Params: Params{"fail": tt.fail}, Limits: tt.limits, Retries: tt.retries, RetryDelay: time.Millisecond},
+				{Name: "after", Step: "test_flaky"},
+			}}
+
+			var results []model.TestResult
+			passed := plan.Run(context.Background(), nil, func(r model.TestResult) { results = append(results, r) })
+
+			if passed != tt.passed {
+				t.Errorf("passed %v, want %v", passed, tt.passed)
+			}
+			if results[0].Attempts != tt.attempts || results[0].Passed != tt.passed {
+				t.Errorf("result %+v, want %d attempts", results[0], tt.attempts)
+			}
+			// the next step runs only if the flaky one passed
+			if want := map[bool]int{true: 2, false: 1}[tt.passed]; len(results) != want {
+				t.Errorf("%d results, want %d", len(results), want)
+			}
+			if want := tt.runs + len(results) - 1; flakyRuns != want {
+				t.Errorf("step ran %d times, want %d", flakyRuns, want)
+			}
+		})
+	}
+}
+
+func TestRunRetriesCanceled(t *testing.T) {
+	flakyRuns = 0
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	var results []model.TestResult
+	plan := Plan{Steps: []PlanStep{{Name: "flaky", Step: "test_flaky", Params: Params{"fail": "5"}, Retries: 5, RetryDelay: time.Hour}}}
+	if plan.Run(ctx, nil, func(r model.TestResult) { results = append(results, r) }) {
+		t.Fatal("passed")
+	}
+	if len(results) != 1 || results[0].Attempts != 1 {
+		t.Errorf("%d attempts before the context ended, want 1", results[0].Attempts)
+	}
+}
+
+func TestRunUnknownStep(t *testing.T) {
+	var results []model.TestResult
+	plan := Plan{Steps: []PlanStep{{Name: "missing", Step: "no_such_step"}}}
+	if plan.Run(context.Background(), nil, func(r model.TestResult) { results = append(results, r) }) {
+		t.Fatal("passed")
+	}
+	if len(results) != 1 || results[0].Error == "" {
+		t.Errorf("results %+v", results)
+	}
+}
@@ -0,0 +1,151 @@
+package functest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Plan is a chain of test steps, run in order until one fails
+type Plan struct {
+	Steps []PlanStep `yaml:"steps"`
+}
+
+// PlanStep is one step of a plan, a registered TestStep with its params and limits
+type PlanStep struct {
+	Name       string           `yaml:"name"` // unique in the plan, the step if not set
+	Step       string           `yaml:"step"` // the registered TestStep
+	Params     Params           `yaml:"params"`
+	Limits     map[string]Limit `yaml:"limits"`      // by measurement name
+	Retries    int              `yaml:"retries"`     // times a failed step is run again
+	RetryDelay time.Duration    `yaml:"retry_delay"` // pause before running a failed step again
+}
+
+// Limit is the range a measurement must be in, either end may be left open
+type Limit struct {
+	Min *float64 `yaml:"min"`
+	Max *float64 `yaml:"max"`
+}
+
+// LoadPlan reads a plan from a YAML file
+func LoadPlan(path string) (Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Plan{}, err
+	}
+	p, err := ParsePlan(data)
+	if err != nil {
+		return p, fmt.Errorf("invalid test plan %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// ParsePlan parses and validates a plan written as YAML, unknown fields are an error
+func ParsePlan(data []byte) (Plan, error) {
+	var p Plan
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&p); err != nil && !errors.Is(err, io.EOF) {
+		return p, err
+	}
+	for i := range p.Steps {
+		if p.Steps[i].Name == "" {
+			p.Steps[i].Name = p.Steps[i].Step
+		}
+	}
+	return p, p.Validate()
+}
+
+// Validate checks that the steps of the plan are registered, their names are
+// unique and their params, limits and retries are valid
+func (p Plan) Validate() error {
+	if len(p.Steps) == 0 {
+		return errors.New("the plan has no steps")
+	}
+
+	var errs []error
+	seen := make(map[string]bool)
+	for i, ps := range p.Steps {
+		field := "steps[" + strconv.Itoa(i) + "]"
+		if ps.Step == "" {
+			errs = append(errs, fmt.Errorf("%s: step is required", field))
+			continue
+		}
+		field += " " + ps.Name
+
+		if seen[ps.Name] {
+			errs = append(errs, fmt.Errorf("%s: the name is used twice, set name to tell the steps apart", field))
+		}
+		seen[ps.Name] = true
+
+		step, err := Lookup(ps.Step)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", field, err))
+		} else if checker, ok := step.(ParamChecker); ok {
+			if err := checker.CheckParams(ps.Params); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", field, err))
+			}
+		}
+
+		for name, limit := range ps.Limits {
+			if limit.Min == nil && limit.Max == nil {
+				errs = append(errs, fmt.Errorf("%s: limit %s needs min or max", field, name))
+			} else if limit.Min != nil && limit.Max != nil && *limit.Min > *limit.Max {
+				errs = append(errs, fmt.Errorf("%s: limit %s has a min above its max", field, name))
+			}
+		}
+		if ps.Retries < 0 {
+			errs = append(errs, fmt.Errorf("%s: retries must not be negative", field))
+		}
+		if ps.RetryDelay < 0 {
+			errs = append(errs, fmt.Errorf("%s: retry_delay must not be negative", field))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Options are the targets and limits of the built-in steps given as settings
+// instead of a plan file
+type Options struct {
+	RegistrationTimeout time.Duration // time the modem may take to register on the network
+	PingHost            string        // host pinged over the mobile data connection
+	PingCount           int
+	MinSignal           int    // lowest accepted RSSI in dBm
+	SMSNumber           string // number the test SMS is sent to, it must come back to the modem
+	SMSTimeout          time.Duration
+	LANInterface        string // network interface of the LAN port, e.g. eth0
+}
+
+// DefaultSteps are the steps of the default plan. The SMS loopback needs a
+// number to send to, so it is left out.
+var DefaultSteps = []string{"sim", "registration", "signal", "ping", "lan"}
+
+// NewPlan returns a plan running the named built-in steps once each, with
+// their params and limits taken from o
+func NewPlan(steps []string, o Options) (Plan, error) {
+	p := Plan{}
+	for _, name := range steps {
+		ps := PlanStep{Name: name, Step: name}
+		switch name {
+		case "registration":
+			ps.Params = Params{"timeout": o.RegistrationTimeout.String()}
+		case "signal":
+			min := float64(o.MinSignal)
+			ps.Limits = map[string]Limit{"rssi": {Min: &min}}
+		case "ping":
+			ps.Params = Params{"host": o.PingHost, "count": strconv.Itoa(o.PingCount)}
+		case "sms":
+			ps.Params = Params{"number": o.SMSNumber, "timeout": o.SMSTimeout.String()}
+		case "lan":
+			ps.Params = Params{"interface": o.LANInterface}
+		}
+		p.Steps = append(p.Steps, ps)
+	}
+	return p, p.Validate()
+}
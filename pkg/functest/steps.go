@@ -0,0 +1,254 @@
+package functest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+	"github.com/ebobo/modem_prod_go/pkg/provision"
+)
+
+// pollInterval is the pause between checks of steps waiting for the modem
+const pollInterval = 2 * time.Second
+
+// The built-in steps
+func init() {
+	Register(NewStep("sim", testSIM, nil))
+	Register(NewStep("registration", testRegistration, checkRegistration))
+	Register(NewStep("signal", testSignal, nil))
+	Register(NewStep("ping", testPing, checkPing))
+	Register(NewStep("sms", testSMS, checkSMS))
+	Register(NewStep("lan", testLAN, nil))
+	Register(NewStep("command", testCommand, checkCommand))
+}
+
+// seconds returns d in seconds, rounded to milliseconds
+func seconds(d time.Duration) float64 {
+	return d.Round(time.Millisecond).Seconds()
+}
+
+// testSIM checks that a SIM card is inserted
+func testSIM(ctx context.Context, r provision.Runner, params Params) (model.Measurements, error) {
+	out, err := r.Run(ctx, "gsmctl -z")
+	if err != nil {
+		return nil, err
+	}
+	if state := strings.TrimSpace(out); state != "inserted" {
+		return nil, fmt.Errorf("no SIM card detected, SIM state is %q", state)
+	}
+	return nil, nil
+}
+
+// checkRegistration checks the params of the registration step: timeout
+func checkRegistration(params Params) error {
+	_, err := params.Duration("timeout", time.Minute)
+	return err
+}
+
+// testRegistration waits for the modem to register on the mobile network
+func testRegistration(ctx context.Context, r provision.Runner, params Params) (model.Measurements, error) {
+	timeout, err := params.Duration("timeout", time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	deadline := start.Add(timeout)
+	for {
+		out, err := r.Run(ctx, "gsmctl -g")
+		if err != nil {
+			return nil, err
+		}
+		state := strings.TrimSpace(out)
+		if strings.HasPrefix(state, "registered") {
+			return model.Measurements{{Name: "registration_time", Value: seconds(time.Since(start)), Unit: "s"}}, nil
+		}
+		if state == "denied" {
+			return nil, errors.New("registration on the network was denied")
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("not registered on the network after %s, state is %q", timeout, state)
+		}
+		if err := wait(ctx, pollInterval); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// testSignal measures the signal strength, the plan sets the lowest accepted rssi
+func testSignal(ctx context.Context, r provision.Runner, params Params) (model.Measurements, error) {
+	out, err := r.Run(ctx, "gsmctl -q")
+	if err != nil {
+		return nil, err
+	}
+	rssi, err := strconv.Atoi(strings.TrimSpace(out))
+	if err != nil {
+		return nil, fmt.Errorf("unexpected signal strength %q", strings.TrimSpace(out))
+	}
+	return model.Measurements{{Name: "rssi", Value: float64(rssi), Unit: "dBm"}}, nil
+}
+
+var (
+	pingPacketsRegex = regexp.MustCompile(`(\d+) packets transmitted, (\d+) (packets )?received`)
+	pingRTTRegex     = regexp.MustCompile(`min/avg/max[^=]*= [\d.]+/([\d.]+)/`)
+)
+
+// checkPing checks the params of the ping step: host and count
+func checkPing(params Params) error {
+	count, err := params.Int("count", 4)
+	if err != nil {
+		return err
+	}
+	if count < 1 {
+		return errors.New("param count must be positive")
+	}
+	return nil
+}
+
+// testPing pings a host to check the mobile data connection. It fails if no
+// reply comes back, the plan may limit packet_loss and rtt_avg.
+func testPing(ctx context.Context, r provision.Runner, params Params) (model.Measurements, error) {
+	host := params.String("host", "8.8.8.8")
+	count, err := params.Int("count", 4)
+	if err != nil {
+		return nil, err
+	}
+
+	// ping exits with 1 if no reply came back, its output still has the statistics
+	out, err := r.Run(ctx, fmt.Sprintf("ping -c %d -W 2 %s", count, provision.Quote(host)))
+	var exitErr *provision.ExitError
+	if errors.As(err, &exitErr) && exitErr.Status == 1 {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m := pingPacketsRegex.FindStringSubmatch(out)
+	if m == nil {
+		return nil, fmt.Errorf("unexpected ping output %q", strings.TrimSpace(out))
+	}
+	sent, _ := strconv.Atoi(m[1])
+	received, _ := strconv.Atoi(m[2])
+	measurements := model.Measurements{{Name: "packet_loss", Value: float64(sent-received) * 100 / float64(sent), Unit: "%"}}
+	if m := pingRTTRegex.FindStringSubmatch(out); m != nil {
+		rtt, _ := strconv.ParseFloat(m[1], 64)
+		measurements = append(measurements, model.Measurement{Name: "rtt_avg", Value: rtt, Unit: "ms"})
+	}
+	if received == 0 {
+		return measurements, fmt.Errorf("no reply from %s", host)
+	}
+	return measurements, nil
+}
+
+// checkSMS checks the params of the sms step: number and timeout
+func checkSMS(params Params) error {
+	if params.String("number", "") == "" {
+		return errors.New("param number is required")
+	}
+	_, err := params.Duration("timeout", time.Minute)
+	return err
+}
+
+// testSMS sends an SMS with a unique text to the number and waits for it to
+// come back to the modem
+func testSMS(ctx context.Context, r provision.Runner, params Params) (model.Measurements, error) {
+	if err := checkSMS(params); err != nil {
+		return nil, err
+	}
+	number := params.String("number", "")
+	timeout, _ := params.Duration("timeout", time.Minute)
+
+	token := make([]byte, 4)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	text := "modem production test " + hex.EncodeToString(token)
+
+	start := time.Now()
+	if _, err := r.Run(ctx, "gsmctl -S -s "+provision.Quote(number+" "+text)); err != nil {
+		return nil, fmt.Errorf("failed to send SMS: %w", err)
+	}
+	deadline := start.Add(timeout)
+	for {
+		if err := wait(ctx, pollInterval); err != nil {
+			return nil, err
+		}
+		out, err := r.Run(ctx, "gsmctl -S -l all")
+		if err != nil {
+			return nil, err
+		}
+		if strings.Contains(out, text) {
+			return model.Measurements{{Name: "sms_round_trip", Value: seconds(time.Since(start)), Unit: "s"}}, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("the SMS sent to %s did not come back within %s", number, timeout)
+		}
+	}
+}
+
+// testLAN checks that the LAN port, param interface, has a link
+func testLAN(ctx context.Context, r provision.Runner, params Params) (model.Measurements, error) {
+	iface := params.String("interface", "eth0")
+	dir := "/sys/class/net/" + iface
+	out, err := r.Run(ctx, "cat "+provision.Quote(dir+"/carrier"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the link state of %s: %w", iface, err)
+	}
+	if strings.TrimSpace(out) != "1" {
+		return nil, fmt.Errorf("%s has no link", iface)
+	}
+
+	// The speed is only measured if the driver reports it
+	out, err = r.Run(ctx, "cat "+provision.Quote(dir+"/speed"))
+	if speed, convErr := strconv.Atoi(strings.TrimSpace(out)); err == nil && convErr == nil && speed > 0 {
+		return model.Measurements{{Name: "link_speed", Value: float64(speed), Unit: "Mbit/s"}}, nil
+	}
+	return nil, nil
+}
+
+// checkCommand checks the params of the command step: command, expect, measure and unit
+func checkCommand(params Params) error {
+	if params.String("command", "") == "" {
+		return errors.New("param command is required")
+	}
+	if params.String("unit", "") != "" && params.String("measure", "") == "" {
+		return errors.New("param unit requires measure")
+	}
+	return nil
+}
+
+// testCommand runs a shell command on the modem, for checks described in a
+// plan without a step of their own. It fails if the command fails or its output
+// lacks the text of param expect. With param measure the output is measured
+// as a number of the given unit, so the plan can limit it.
+func testCommand(ctx context.Context, r provision.Runner, params Params) (model.Measurements, error) {
+	if err := checkCommand(params); err != nil {
+		return nil, err
+	}
+	out, err := r.Run(ctx, params.String("command", ""))
+	if err != nil {
+		return nil, err
+	}
+	out = strings.TrimSpace(out)
+
+	if expect := params.String("expect", ""); expect != "" && !strings.Contains(out, expect) {
+		return nil, fmt.Errorf("the output %q does not contain %q", out, expect)
+	}
+	name := params.String("measure", "")
+	if name == "" {
+		return nil, nil
+	}
+	value, err := strconv.ParseFloat(out, 64)
+	if err != nil {
+		return nil, fmt.Errorf("the output %q is not a number", out)
+	}
+	return model.Measurements{{Name: name, Value: value, Unit: params.String("unit", "")}}, nil
+}
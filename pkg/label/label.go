@@ -0,0 +1,138 @@
+// Package label renders ZPL labels for modems and sends them to Zebra
+// compatible printers over raw TCP.
+package label
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+// DefaultPort is the raw printing port of Zebra printers
+const DefaultPort = "9100"
+
+// DefaultTemplateName is the name of the built-in template
+const DefaultTemplateName = "default"
+
+// DefaultTemplate is a 4x2 inch label at 203 dpi with Code128 barcodes of the
+// IMEI, serial and MAC address and a QR code of the IMEI
+const DefaultTemplate = `^XA
+^CI28
+^PW812
+^LL406
+^FO30,20^A0N,30,30^FD{{.Model}}^FS
+^FO520,20^A0N,22,22^FD{{.Firmware}}^FS
+^FO30,60^BY2^BCN,60,N,N,N^FD{{.IMEI}}^FS
+^FO30,125^A0N,24,24^FDIMEI {{.IMEI}}^FS
+^FO30,160^BY2^BCN,60,N,N,N^FD{{.Serial}}^FS
+^FO30,225^A0N,24,24^FDS/N {{.Serial}}^FS
+^FO30,260^BY2^BCN,60,N,N,N^FD{{.MAC}}^FS
+^FO30,325^A0N,24,24^FDMAC {{.MacAddress}}^FS
+^FO600,160^BQN,2,6^FDQA,{{.IMEI}}^FS
+^XZ
+`
+
+// Fields are the values a template can use, e.g. {{.IMEI}}
+type Fields struct {
+	MacAddress string // with colons, e.g. 00:1e:42:12:34:56
+	MAC        string // upper case without separators, e.g. 001E42123456
+	IMEI       string
+	ICCID      string
+	IMSI       string
+	Serial     string
+	Model      string
+	Firmware   string
+}
+
+// sanitize removes the ZPL command prefixes, so field values can not end a
+// field or start a command
+func sanitize(s string) string {
+	return strings.NewReplacer("^", "", "~", "").Replace(s)
+}
+
+// FieldsOf returns the template fields of a modem
+func FieldsOf(m model.Modem) Fields {
+	return Fields{
+		MacAddress: sanitize(m.MacAddress),
+		MAC:        sanitize(strings.ToUpper(strings.NewReplacer(":", "", "-", "").Replace(m.MacAddress))),
+		IMEI:       sanitize(m.IMEI),
+		ICCID:      sanitize(m.ICCID),
+		IMSI:       sanitize(m.IMSI),
+		Serial:     sanitize(m.Serial),
+		Model:      sanitize(m.Model),
+		Firmware:   sanitize(m.Firmware),
+	}
+}
+
+// Template is a parsed ZPL template
+type Template struct {
+	t *template.Template
+}
+
+// Parse parses a ZPL template. It must be one label, from ^XA to ^XZ, and may
+// only use the fields of Fields.
+func Parse(name string, text string) (*Template, error) {
+	trimmed := strings.TrimSpace(text)
+	if !strings.HasPrefix(trimmed, "^XA") || !strings.HasSuffix(trimmed, "^XZ") {
+		return nil, errors.New("a label must start with ^XA and end with ^XZ")
+	}
+
+	t, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unknown fields are only found when executing
+	tmpl := &Template{t: t}
+	if _, err := tmpl.Render(model.Modem{}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// Render returns the ZPL of the label of a modem
+func (t *Template) Render(m model.Modem) ([]byte, error) {
+	var b bytes.Buffer
+	if err := t.t.Execute(&b, FieldsOf(m)); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// PrinterAddress adds the default port to a printer address without one
+func PrinterAddress(addr string) string {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return net.JoinHostPort(strings.Trim(addr, "[]"), DefaultPort)
+	}
+	return addr
+}
+
+// Send prints copies of a label by writing its ZPL to the printer at addr.
+// The printer gives no feedback, a label counts as printed once it is sent.
+func Send(ctx context.Context, addr string, zpl []byte, copies int, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", PrinterAddress(addr))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	conn.SetWriteDeadline(deadline)
+	for i := 0; i < copies; i++ {
+		if _, err := conn.Write(zpl); err != nil {
+			return fmt.Errorf("failed to send label to %s: %w", addr, err)
+		}
+	}
+	return conn.Close()
+}
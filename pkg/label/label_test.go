@@ -0,0 +1,136 @@
+package label
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+// listenPrinter accepts one connection like a printer and returns what was sent
+func listenPrinter(t *testing.T) (string, <-chan string) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		data, _ := io.ReadAll(conn)
+		received <- string(data)
+	}()
+	return l.Addr().String(), received
+}
+
+func TestSend(t *testing.T) {
+	addr, received := listenPrinter(t)
+	zpl := []byte("^XA^FDlabel^FS^XZ")
+
+	if err := Send(context.Background(), addr, zpl, 3, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-received:
+		if want := strings.Repeat(string(zpl), 3); got != want {
+			t.Errorf("printer got %q, want %q", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("printer got nothing")
+	}
+}
+
+func TestSendFails(t *testing.T) {
+	// A port nothing listens on
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	if err := Send(context.Background(), addr, []byte("^XA^XZ"), 1, time.Second); err == nil {
+		t.Error("sent to a closed port")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	addr, _ = listenPrinter(t)
+	if err := Send(ctx, addr, []byte("^XA^XZ"), 1, time.Second); err == nil {
+		t.Error("sent with a cancelled context")
+	}
+}
+
+func TestPrinterAddress(t *testing.T) {
+	for addr, want := range map[string]string{
+		"192.168.2.50":      "192.168.2.50:9100",
+		"192.168.2.50:6101": "192.168.2.50:6101",
+		"zebra1":            "zebra1:9100",
+		"fd00::50":          "[fd00::50]:9100",
+		"[fd00::50]":        "[fd00::50]:9100",
+		"[fd00::50]:6101":   "[fd00::50]:6101",
+	} {
+		if got := PrinterAddress(addr); got != want {
+			t.Errorf("PrinterAddress(%q) = %q, want %q", addr, got, want)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	modem := model.Modem{
+		MacAddress: "00:1e:42:12:34:56",
+		IMEI:       "356789012345678",
+		Serial:     "11^XZ~JR",
+		Model:      "TRB140",
+	}
+
+	tmpl, err := Parse("test", "^XA^FD{{.MAC}}^FS^FD{{.MacAddress}}^FS^FD{{.IMEI}}^FS^FD{{.Serial}}^FS^XZ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := tmpl.Render(modem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Field values can not end the field or start a command
+	want := "^XA^FD001E42123456^FS^FD00:1e:42:12:34:56^FS^FD356789012345678^FS^FD11XZJR^FS^XZ"
+	if string(got) != want {
+		t.Errorf("rendered %q, want %q", got, want)
+	}
+
+	tmpl, err = Parse(DefaultTemplateName, DefaultTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = tmpl.Render(modem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(got), "^FDQA,356789012345678^FS") || !strings.Contains(string(got), "^FDMAC 00:1e:42:12:34:56^FS") {
+		t.Errorf("default template rendered %q", got)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for name, text := range map[string]string{
+		"no start":      "^FD{{.IMEI}}^FS^XZ",
+		"no end":        "^XA^FD{{.IMEI}}^FS",
+		"unknown field": "^XA^FD{{.Password}}^FS^XZ",
+		"syntax error":  "^XA^FD{{.IMEI}^FS^XZ",
+	} {
+		if _, err := Parse(name, text); err == nil {
+			t.Errorf("%s: parsed", name)
+		}
+	}
+}
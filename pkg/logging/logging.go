@@ -0,0 +1,185 @@
+// Package logging sets up structured logging with a level per subsystem.
+//
+// Loggers returned by For can be created before Setup runs, they always log
+// through the handler and levels of the latest Setup.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// Subsystems that have their own log level
+const (
+	Discovery = "discovery"
+	SNMP      = "snmp"
+	SSH       = "ssh"
+	Upgrade   = "upgrade"
+	HTTP      = "http"
+	Store     = "store"
+	Reconcile = "reconcile"
+	Label     = "label"
+	Provision = "provision"
+	Test      = "test"
+	SIM       = "sim"
+)
+
+var (
+	// levels of the subsystems, the keys never change after init
+	levels = map[string]*slog.LevelVar{}
+
+	// base is the handler records are written with
+	base atomic.Pointer[slog.Handler]
+)
+
+func init() {
+	for _, name := range []string{Discovery, SNMP, SSH, Upgrade, HTTP, Store, Reconcile, Label, Provision, Test, SIM} {
+		levels[name] = &slog.LevelVar{}
+	}
+	h := slog.Default().Handler()
+	base.Store(&h)
+}
+
+// Subsystems returns the names of the subsystems in order
+func Subsystems() []string {
+	var names []string
+	for name := range levels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseLevel parses debug, info, warn or error
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return level, fmt.Errorf("invalid log level %q, use debug, info, warn or error", s)
+	}
+	return level, nil
+}
+
+// Setup makes all logging go to w in the given format, text or json. Level is
+// the level of messages not from a subsystem and the default of subsystems,
+// subsystemLevels overrides it per subsystem.
+func Setup(w io.Writer, format string, level string, subsystemLevels map[string]string) error {
+	defaultLevel, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	subsystem := make(map[string]slog.Level, len(subsystemLevels))
+	for name, s := range subsystemLevels {
+		if _, ok := levels[name]; !ok {
+			return fmt.Errorf("unknown log subsystem %q, use one of %s", name, strings.Join(Subsystems(), ", "))
+		}
+		if subsystem[name], err = ParseLevel(s); err != nil {
+			return err
+		}
+	}
+
+	// Levels are checked by the subsystem loggers, the handler lets everything
+	// through that is not filtered before
+	options := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var h slog.Handler
+	switch format {
+	case "json":
+		h = slog.NewJSONHandler(w, options)
+	case "text", "":
+		h = slog.NewTextHandler(w, options)
+	default:
+		return fmt.Errorf("invalid log format %q, use text or json", format)
+	}
+
+	for name, v := range levels {
+		if l, ok := subsystem[name]; ok {
+			v.Set(l)
+		} else {
+			v.Set(defaultLevel)
+		}
+	}
+	base.Store(&h)
+
+	// Everything else, including the standard log package, goes through the default logger
+	slog.SetDefault(slog.New(&subsystemHandler{level: leveler(defaultLevel)}))
+	return nil
+}
+
+// For returns the logger of a subsystem. It panics on unknown subsystems.
+func For(subsystem string) *slog.Logger {
+	level, ok := levels[subsystem]
+	if !ok {
+		panic("logging: unknown subsystem " + subsystem)
+	}
+	return slog.New(&subsystemHandler{
+		subsystem: subsystem,
+		level:     level,
+	})
+}
+
+type leveler slog.Level
+
+func (l leveler) Level() slog.Level { return slog.Level(l) }
+
+type contextKey struct{}
+
+// WithAttrs returns a context carrying attributes that are added to every
+// record logged with it, like the id of the HTTP request being served
+func WithAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	existing, _ := ctx.Value(contextKey{}).([]slog.Attr)
+	all := make([]slog.Attr, 0, len(existing)+len(attrs))
+	all = append(all, existing...)
+	all = append(all, attrs...)
+	return context.WithValue(ctx, contextKey{}, all)
+}
+
+// subsystemHandler filters records on the level of its subsystem and passes
+// them on to the current base handler
+type subsystemHandler struct {
+	subsystem string
+	level     slog.Leveler
+
+	// with are the WithAttrs and WithGroup calls made on the logger, they are
+	// applied to the base handler in order when a record is handled
+	with []func(slog.Handler) slog.Handler
+}
+
+func (h *subsystemHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *subsystemHandler) Handle(ctx context.Context, r slog.Record) error {
+	next := *base.Load()
+	if h.subsystem != "" {
+		next = next.WithAttrs([]slog.Attr{slog.String("subsystem", h.subsystem)})
+	}
+	if attrs, ok := ctx.Value(contextKey{}).([]slog.Attr); ok {
+		next = next.WithAttrs(attrs)
+	}
+	for _, with := range h.with {
+		next = with(next)
+	}
+	return next.Handle(ctx, r)
+}
+
+func (h *subsystemHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.withOp(func(next slog.Handler) slog.Handler { return next.WithAttrs(attrs) })
+}
+
+func (h *subsystemHandler) WithGroup(name string) slog.Handler {
+	return h.withOp(func(next slog.Handler) slog.Handler { return next.WithGroup(name) })
+}
+
+func (h *subsystemHandler) withOp(op func(slog.Handler) slog.Handler) slog.Handler {
+	with := make([]func(slog.Handler) slog.Handler, 0, len(h.with)+1)
+	with = append(with, h.with...)
+	return &subsystemHandler{
+		subsystem: h.subsystem,
+		level:     h.level,
+		with:      append(with, op),
+	}
+}
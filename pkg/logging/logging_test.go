@@ -0,0 +1,151 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// setup runs Setup with JSON output to the returned buffer, the previous
+// logging is restored when the test ends
+func setup(t *testing.T, level string, subsystemLevels map[string]string) *bytes.Buffer {
+	t.Helper()
+
+	defaultLogger, handler := slog.Default(), base.Load()
+	t.Cleanup(func() {
+		slog.SetDefault(defaultLogger)
+		base.Store(handler)
+		for _, v := range levels {
+			v.Set(slog.LevelInfo)
+		}
+	})
+
+	var buf bytes.Buffer
+	if err := Setup(&buf, "json", level, subsystemLevels); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+// records returns the messages and subsystems of the JSON records in buf, and resets it
+func records(t *testing.T, buf *bytes.Buffer) []string {
+	t.Helper()
+
+	var got []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var r struct {
+			Msg       string `json:"msg"`
+			Subsystem string `json:"subsystem"`
+		}
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+		got = append(got, r.Subsystem+":"+r.Msg)
+	}
+	buf.Reset()
+	return got
+}
+
+func TestSubsystemLevels(t *testing.T) {
+	// loggers made before Setup follow it
+	sshLog, snmpLog := For(SSH), For(SNMP)
+	buf := setup(t, "warn", map[string]string{SSH: "debug", Store: "error"})
+
+	sshLog.Debug("ssh debug")
+	snmpLog.Info("snmp info")
+	snmpLog.Warn("snmp warn")
+	For(Store).Warn("store warn")
+	For(Store).Error("store error")
+	slog.Info("default info")
+	slog.Warn("default warn")
+	log.Print("standard log")
+
+	if got := records(t, buf); strings.Join(got, " ") != "ssh:ssh debug snmp:snmp warn store:store error :default warn" {
+		t.Errorf("logged %q", got)
+	}
+
+	// a second Setup changes the levels of the existing loggers
+	if err := Setup(buf, "json", "debug", nil); err != nil {
+		t.Fatal(err)
+	}
+	sshLog.Debug("ssh debug")
+	snmpLog.Debug("snmp debug")
+	slog.Debug("default debug")
+	if got := records(t, buf); strings.Join(got, " ") != "ssh:ssh debug snmp:snmp debug :default debug" {
+		t.Errorf("logged %q after the second Setup", got)
+	}
+}
+
+func TestSetupErrors(t *testing.T) {
+	buf := setup(t, "info", map[string]string{SSH: "debug"})
+
+	for _, tt := range []struct {
+		format string
+		level  string
+		levels map[string]string
+		err    string
+	}{
+		{"json", "verbose", nil, `invalid log level "verbose"`},
+		{"json", "info", map[string]string{SSH: "loud"}, `invalid log level "loud"`},
+		{"json", "info", map[string]string{"modem": "debug"}, `unknown log subsystem "modem"`},
+		{"xml", "info", nil, `invalid log format "xml"`},
+	} {
+		err := Setup(&bytes.Buffer{}, tt.format, tt.level, tt.levels)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s %s %v: err %v, want %q", tt.format, tt.level, tt.levels, err, tt.err)
+		}
+	}
+
+	// the logging set up before is kept
+	For(SSH).Debug("ssh debug")
+	For(SNMP).Debug("snmp debug")
+	if got := records(t, buf); len(got) != 1 || got[0] != "ssh:ssh debug" {
+		t.Errorf("logged %q", got)
+	}
+}
+
+func TestAttrs(t *testing.T) {
+	buf := setup(t, "info", nil)
+
+	ctx := WithAttrs(context.Background(), slog.String("request_id", "r1"))
+	ctx = WithAttrs(ctx, slog.String("actor", "bench"))
+	For(HTTP).With("mac", "00:1f:43:00:00:01").WithGroup("job").InfoContext(ctx, "done", "id", 7)
+
+	var r map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	job, _ := r["job"].(map[string]interface{})
+	if r["subsystem"] != HTTP || r["request_id"] != "r1" || r["actor"] != "bench" || r["mac"] != "00:1f:43:00:00:01" || job["id"] != 7.0 {
+		t.Errorf("record %v", r)
+	}
+}
+
+func TestTextFormat(t *testing.T) {
+	setup(t, "info", nil)
+
+	var buf bytes.Buffer
+	if err := Setup(&buf, "text", "info", nil); err != nil {
+		t.Fatal(err)
+	}
+	For(Upgrade).Info("upgraded", "mac", "00:1f:43:00:00:01")
+	if got := buf.String(); !strings.Contains(got, "level=INFO msg=upgraded subsystem=upgrade mac=00:1f:43:00:00:01") {
+		t.Errorf("logged %q", got)
+	}
+}
+
+func TestForUnknownSubsystem(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic")
+		}
+	}()
+	For("modem")
+}
@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "modem_prod"
+
+// Production line metrics, registered with the default prometheus registry
+var (
+	UpgradesStarted = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upgrades_started_total",
+		Help:      "Number of modem upgrades started.",
+	})
+
+	UpgradesSucceeded = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upgrades_succeeded_total",
+		Help:      "Number of modem upgrades that succeeded.",
+	})
+
+	UpgradesFailed = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upgrades_failed_total",
+		Help:      "Number of modem upgrades that failed.",
+	})
+
+	UpgradeDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upgrade_duration_seconds",
+		Help:      "Duration of modem upgrades.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+	})
+
+	TestRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "test_runs_total",
+		Help:      "Number of finished functional test runs by result.",
+	}, []string{"result"})
+
+	SIMRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sim_requests_total",
+		Help:      "Number of SIM activation and suspension attempts at the carrier by result.",
+	}, []string{"action", "result"})
+
+	SSHDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ssh_command_duration_seconds",
+		Help:      "Duration of SSH calls to modems.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"command"})
+
+	SSHErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ssh_errors_total",
+		Help:      "Number of failed SSH calls to modems.",
+	}, []string{"command"})
+
+	SNMPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "snmp_request_duration_seconds",
+		Help:      "Duration of SNMP calls to the switch by operation (connect, walk or set).",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"op", "oid"})
+
+	SNMPErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "snmp_errors_total",
+		Help:      "Number of failed SNMP calls to the switch by operation (connect, walk or set).",
+	}, []string{"op", "oid"})
+
+	DiscoveryPackets = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "discovery_packets_total",
+		Help:      "Number of IPv6 packets seen by modem discovery.",
+	})
+
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Duration of HTTP requests by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	StoreDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "store_operation_duration_seconds",
+		Help:      "Duration of store operations.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"operation"})
+)
+
+// ObserveStore records the duration of a store operation started at start,
+// use it as defer metrics.ObserveStore("operation", time.Now())
+func ObserveStore(operation string, start time.Time) {
+	StoreDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// ObserveSSH records the duration of an SSH call and counts it as failed if err is not nil
+func ObserveSSH(command string, start time.Time, err error) {
+	SSHDuration.WithLabelValues(command).Observe(time.Since(start).Seconds())
+	if err != nil {
+		SSHErrors.WithLabelValues(command).Inc()
+	}
+}
+
+// ObserveSNMP records the duration of an SNMP call and counts it as failed if err is not nil.
+// op is "connect", "walk" or "set", oid is empty for connect.
+func ObserveSNMP(op string, oid string, start time.Time, err error) {
+	SNMPDuration.WithLabelValues(op, oid).Observe(time.Since(start).Seconds())
+	if err != nil {
+		SNMPErrors.WithLabelValues(op, oid).Inc()
+	}
+}
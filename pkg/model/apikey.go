@@ -0,0 +1,40 @@
+package model
+
+// API roles, each role has the permissions of the roles before it
+const (
+	RoleViewer   = "viewer"   // read modems, orders and events
+	RoleOperator = "operator" // set modem state and progress, assign modems to orders
+	RoleEngineer = "engineer" // add, change and delete modems, manage orders
+	RoleAdmin    = "admin"    // manage API keys
+)
+
+var roleRanks = map[string]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleEngineer: 3,
+	RoleAdmin:    4,
+}
+
+// ValidRole reports whether role is one of the API roles
+func ValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// RoleAllows reports whether role has the permissions of required
+func RoleAllows(role, required string) bool {
+	return ValidRole(role) && roleRanks[role] >= roleRanks[required]
+}
+
+// Define the api key struct. Only the hash of the key is stored, the key itself
+// is shown once when it is created.
+type APIKey struct {
+	ID        int64  `json:"id" db:"id"`
+	Name      string `json:"name" db:"name"`
+	Role      string `json:"role" db:"role"`
+	Prefix    string `json:"prefix" db:"prefix"` // first characters of the key to tell keys apart
+	Hash      string `json:"-" db:"hash"`
+	CreatedAt int    `json:"created_at" db:"created_at"`
+	LastUsed  int    `json:"last_used" db:"last_used"`
+	RevokedAt int    `json:"revoked_at" db:"revoked_at"` // 0 while the key is active
+}
@@ -0,0 +1,42 @@
+package model
+
+// Sources of a modem change
+const (
+	SourceDiscovery = "discovery"
+	SourceAPI       = "api"
+	SourceUpgrade   = "upgrade"
+	SourceTest      = "test"
+	SourceSIM       = "sim"
+)
+
+// FieldModem is the field of the history events recording that a modem was
+// created or deleted, the new value is EventCreated or EventDeleted
+const (
+	FieldModem   = "modem"
+	EventCreated = "created"
+	EventDeleted = "deleted"
+)
+
+// Origin describes where a modem change came from and who made it
+type Origin struct {
+	Source string
+	Actor  string
+}
+
+// Define the modem event struct to represent one entry in the modem history
+type ModemEvent struct {
+	ID         int64  `json:"id" db:"id"`
+	MacAddress string `json:"mac_address" db:"mac_address"`
+	Field      string `json:"field" db:"field"`
+	OldValue   string `json:"old_value" db:"old_value"`
+	NewValue   string `json:"new_value" db:"new_value"`
+	Source     string `json:"source" db:"source"`
+	Actor      string `json:"actor" db:"actor"`
+	Timestamp  int    `json:"timestamp" db:"timestamp"`
+}
+
+// ModemChange is published when a modem is updated
+type ModemChange struct {
+	Modem   Modem        `json:"modem"`
+	Changes []ModemEvent `json:"changes"`
+}
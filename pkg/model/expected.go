@@ -0,0 +1,131 @@
+package model
+
+import (
+	"net"
+	"strings"
+)
+
+// Define the expected unit struct to represent a modem announced by the supplier
+// before the batch arrives, discovered modems are reconciled against these
+type ExpectedUnit struct {
+	MacAddress string `json:"mac_address" db:"mac_address"`
+	Serial     string `json:"serial" db:"serial"`
+	IMEI       string `json:"imei" db:"imei"`
+	Model      string `json:"model" db:"model"`
+	Firmware   string `json:"firmware" db:"firmware"`
+	Batch      string `json:"batch" db:"batch"` // supplier batch or delivery the unit belongs to
+	ImportedAt int    `json:"imported_at" db:"imported_at"`
+}
+
+// Import modes
+const (
+	ImportInsert = "insert" // units that are expected already are skipped
+	ImportUpsert = "upsert" // units that are expected already are replaced
+)
+
+// ValidImportMode reports whether mode is an import mode
+func ValidImportMode(mode string) bool {
+	return mode == ImportInsert || mode == ImportUpsert
+}
+
+// Actions taken for a row of an import
+const (
+	ImportInserted  = "inserted"
+	ImportUpdated   = "updated"
+	ImportUnchanged = "unchanged"
+	ImportSkipped   = "skipped"
+	ImportInvalid   = "invalid"
+)
+
+// ImportRow is the result of one row of an import of expected units
+type ImportRow struct {
+	Row        int          `json:"row"` // position of the unit in the import, from 1
+	MacAddress string       `json:"mac_address"`
+	Action     string       `json:"action"`
+	Errors     []FieldError `json:"errors,omitempty"`
+
+	// Discovered is set if a modem with the MAC address is known already,
+	// Mismatches lists the fields where it differs from the expected unit
+	Discovered bool     `json:"discovered"`
+	Mismatches []string `json:"mismatches,omitempty"`
+}
+
+// Normalize trims the fields and formats the MAC address like discovery does,
+// it is left as is if it is not a MAC address
+func (u *ExpectedUnit) Normalize() {
+	u.MacAddress = strings.TrimSpace(u.MacAddress)
+	if mac, err := net.ParseMAC(u.MacAddress); err == nil {
+		u.MacAddress = mac.String()
+	}
+	u.Serial = strings.TrimSpace(u.Serial)
+	u.IMEI = strings.TrimSpace(u.IMEI)
+	u.Model = strings.TrimSpace(u.Model)
+	u.Firmware = strings.TrimSpace(u.Firmware)
+	u.Batch = strings.TrimSpace(u.Batch)
+}
+
+// Kinds of discrepancies between the expected units and the discovered modems
+const (
+	DiscrepancyMissing    = "missing"    // expected but not discovered
+	DiscrepancyUnexpected = "unexpected" // discovered but not expected
+	DiscrepancyMismatch   = "mismatch"   // a field of the discovered modem differs from the expected unit
+)
+
+// Discrepancy is a difference between an expected unit and the discovered modem
+type Discrepancy struct {
+	MacAddress string `json:"mac_address"`
+	Kind       string `json:"kind"`
+	Field      string `json:"field,omitempty"`
+	Expected   string `json:"expected,omitempty"`
+	Actual     string `json:"actual,omitempty"`
+	Batch      string `json:"batch,omitempty"`
+}
+
+// Compare returns a mismatch for every field in which a discovered modem differs
+// from the expected unit. Fields that are empty on either side are not compared.
+func (u ExpectedUnit) Compare(m Modem) []Discrepancy {
+	var mismatches []Discrepancy
+	compare := func(field, expected, actual string) {
+		if expected != "" && actual != "" && !strings.EqualFold(expected, actual) {
+			mismatches = append(mismatches, Discrepancy{
+				MacAddress: u.MacAddress,
+				Kind:       DiscrepancyMismatch,
+				Field:      field,
+				Expected:   expected,
+				Actual:     actual,
+				Batch:      u.Batch,
+			})
+		}
+	}
+	compare("serial", u.Serial, m.Serial)
+	compare("imei", u.IMEI, m.IMEI)
+	compare("model", u.Model, m.Model)
+	compare("firmware", u.Firmware, m.Firmware)
+	return mismatches
+}
+
+// Mismatches returns the names of the fields Compare reports
+func (u ExpectedUnit) Mismatches(m Modem) []string {
+	var fields []string
+	for _, d := range u.Compare(m) {
+		fields = append(fields, d.Field)
+	}
+	return fields
+}
+
+// ImportResult is the result of an import of expected units. Nothing is
+// stored if any row is invalid or the import is a dry run.
+type ImportResult struct {
+	Mode      string      `json:"mode"`
+	DryRun    bool        `json:"dry_run"`
+	Committed bool        `json:"committed"`
+	Inserted  int         `json:"inserted"`
+	Updated   int         `json:"updated"`
+	Unchanged int         `json:"unchanged"`
+	Skipped   int         `json:"skipped"`
+	Invalid   int         `json:"invalid"`
+	Rows      []ImportRow `json:"rows"`
+
+	// Unexpected lists the discovered modems that are not expected after the import
+	Unexpected []string `json:"unexpected"`
+}
@@ -0,0 +1,33 @@
+package model
+
+// Label print job states
+const (
+	LabelQueued  = "queued"
+	LabelPrinted = "printed"
+	LabelFailed  = "failed"
+)
+
+// Define the label template struct to represent a ZPL template for modem labels
+type LabelTemplate struct {
+	Name        string `json:"name" db:"name"`
+	Description string `json:"description" db:"description"`
+	ZPL         string `json:"zpl" db:"zpl"`
+	BuiltIn     bool   `json:"built_in" db:"-"` // the built-in template, not stored
+	UpdatedAt   int    `json:"updated_at" db:"updated_at"`
+}
+
+// Define the label job struct to represent printing the label of a modem
+type LabelJob struct {
+	ID         int64  `json:"id" db:"id"`
+	MacAddress string `json:"mac_address" db:"mac_address"`
+	Template   string `json:"template" db:"template"`
+	Printer    string `json:"printer" db:"printer"`
+	Copies     int    `json:"copies" db:"copies"`
+	Status     string `json:"status" db:"status"`
+	Error      string `json:"error" db:"error"`
+	ReprintOf  int64  `json:"reprint_of" db:"reprint_of"` // id of the job this one reprints, 0 if it is not a reprint
+	Actor      string `json:"actor" db:"actor"`
+	CreatedAt  int    `json:"created_at" db:"created_at"`
+	PrintedAt  int    `json:"printed_at" db:"printed_at"`
+	ZPL        string `json:"-" db:"zpl"` // rendered label, reprints send it again
+}
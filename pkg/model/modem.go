@@ -1,5 +1,11 @@
 package model
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
 // Define the modem struct to represent the modem data
 type Modem struct {
 	MacAddress  string `json:"mac_address" db:"mac_address"`
@@ -14,9 +20,43 @@ type Modem struct {
 	LastUpdated int    `json:"last_updated" db:"last_updated"`
 	FailCount   int    `json:"fail_count" db:"fail_count"`
 	SIMProvider string `json:"sim_provider" db:"sim_provider"`
-	SIMStatus   bool   `json:"sim_status" db:"sim_status"`
+	SIMStatus   bool   `json:"sim_status" db:"sim_status"` // the carrier reported the SIM active, any other status is false
 	IMEI        string `json:"imei" db:"imei"`
 	ICCID       string `json:"iccid" db:"iccid"`
 	IMSI        string `json:"imsi" db:"imsi"`
 	Progress    int    `json:"progress" db:"progress"`
+	TestStatus  string `json:"test_status" db:"test_status"` // outcome of the latest functional test, empty if never tested
+	Version     int    `json:"version" db:"version"`         // incremented on every change
+}
+
+// Modem states
+const (
+	StateUnknown = 0
+	StateNormal  = 1
+	StateBusy    = 2
+	StateError   = 3
+)
+
+var stateNames = []string{"unknown", "normal", "busy", "error"}
+
+// StateName returns the name of a modem state
+func StateName(state int) string {
+	if state < 0 || state >= len(stateNames) {
+		return strconv.Itoa(state)
+	}
+	return stateNames[state]
+}
+
+// ParseState returns the modem state with the given name or number
+func ParseState(s string) (int, error) {
+	for state, name := range stateNames {
+		if strings.EqualFold(s, name) {
+			return state, nil
+		}
+	}
+	state, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("unknown state %q, use one of %s", s, strings.Join(stateNames, ", "))
+	}
+	return state, ValidateState(state)
 }
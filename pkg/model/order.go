@@ -0,0 +1,27 @@
+package model
+
+// Work order states
+const (
+	OrderOpen   = "open"
+	OrderClosed = "closed"
+)
+
+// Define the order struct to represent a customer work order
+type Order struct {
+	ID            int64  `json:"id" db:"id"`
+	Customer      string `json:"customer" db:"customer"`
+	Quantity      int    `json:"quantity" db:"quantity"`
+	Model         string `json:"model" db:"model"`
+	Firmware      string `json:"firmware" db:"firmware"`             // target firmware
+	ConfigProfile string `json:"config_profile" db:"config_profile"` // name of the config profile to apply
+	BenchFrom     int    `json:"bench_from" db:"bench_from"`         // first switch port of the bench used for the order
+	BenchTo       int    `json:"bench_to" db:"bench_to"`             // last switch port of the bench used for the order
+	Status        string `json:"status" db:"status"`
+	CreatedAt     int    `json:"created_at" db:"created_at"`
+	ClosedAt      int    `json:"closed_at" db:"closed_at"`
+
+	// Counts of the modems assigned to the order, these are not stored
+	Assigned  int `json:"assigned" db:"assigned"`
+	Completed int `json:"completed" db:"completed"` // upgraded to the target firmware, not in error and not failed or running a test
+	Failed    int `json:"failed" db:"failed"`       // in error or failed their test
+}
@@ -0,0 +1,108 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+)
+
+// Provisioning states of a modem
+const (
+	ProvisionRunning = "running"
+	ProvisionApplied = "applied"
+	ProvisionFailed  = "failed"
+)
+
+// UCISetting is one UCI option or section, e.g. network.lan.ipaddr=192.168.1.1,
+// or network.wan2=interface to create a section
+type UCISetting struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// UCISettings are stored as a JSON array, in the order they are applied
+type UCISettings []UCISetting
+
+func (s UCISettings) Value() (driver.Value, error) {
+	if s == nil {
+		s = UCISettings{}
+	}
+	b, err := json.Marshal(s)
+	return string(b), err
+}
+
+func (s *UCISettings) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case string:
+		return json.Unmarshal([]byte(v), s)
+	case []byte:
+		return json.Unmarshal(v, s)
+	case nil:
+		*s = nil
+		return nil
+	}
+	return errors.New("unsupported type of uci settings")
+}
+
+// Define the config profile struct to represent the configuration applied to modems of a work order
+type ConfigProfile struct {
+	Name        string      `json:"name" db:"name"`
+	Description string      `json:"description" db:"description"`
+	Settings    UCISettings `json:"settings" db:"settings"`
+	UpdatedAt   int         `json:"updated_at" db:"updated_at"`
+
+	// AdminPassword is set for the SSH user after the settings are applied,
+	// empty keeps the password. The API never returns it, only AdminPasswordSet.
+	AdminPassword    string `json:"admin_password,omitempty" db:"admin_password"`
+	AdminPasswordSet bool   `json:"admin_password_set" db:"-"`
+
+	// PasswordSealed tells that AdminPassword is encrypted with the escrow key
+	PasswordSealed bool `json:"-" db:"admin_password_sealed"`
+
+	// UniquePassword sets a generated password on every modem instead, it is
+	// kept encrypted in the password escrow
+	UniquePassword bool `json:"unique_password" db:"unique_password"`
+}
+
+// ConfigDiff compares the desired value of a UCI key with the value on a modem
+type ConfigDiff struct {
+	Key     string `json:"key"`
+	Desired string `json:"desired"`
+	Actual  string `json:"actual"`
+	Missing bool   `json:"missing"` // the key is not set on the modem
+	Match   bool   `json:"match"`
+}
+
+// Define the provisioning struct to represent the latest profile applied to a modem
+type Provisioning struct {
+	MacAddress string `json:"mac_address" db:"mac_address"`
+	Profile    string `json:"profile" db:"profile"`
+	Status     string `json:"status" db:"status"`
+	Error      string `json:"error" db:"error"`
+	Actor      string `json:"actor" db:"actor"`
+	StartedAt  int    `json:"started_at" db:"started_at"`
+	FinishedAt int    `json:"finished_at" db:"finished_at"`
+
+	// PasswordProfile is the profile whose admin password the modem has, empty
+	// if it still has the configured SSH password
+	PasswordProfile string `json:"password_profile" db:"password_profile"`
+}
+
+// Escrowed password states, a password is pending until it is set on the modem
+const (
+	PasswordPending = "pending"
+	PasswordActive  = "active"
+)
+
+// Define the modem password struct to represent an admin password set on a modem, kept encrypted
+type ModemPassword struct {
+	ID         int64  `json:"id" db:"id"`
+	MacAddress string `json:"mac_address" db:"mac_address"`
+	User       string `json:"user" db:"user"`
+	Password   string `json:"password,omitempty" db:"-"` // only returned to admins
+	Sealed     string `json:"-" db:"sealed"`             // the encrypted password
+	Profile    string `json:"profile" db:"profile"`
+	Status     string `json:"status" db:"status"`
+	CreatedAt  int    `json:"created_at" db:"created_at"`
+	SetAt      int    `json:"set_at" db:"set_at"`
+}
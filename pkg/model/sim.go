@@ -0,0 +1,40 @@
+package model
+
+// SIM job actions
+const (
+	SIMActivate = "activate"
+	SIMSuspend  = "suspend"
+)
+
+// SIM job states
+const (
+	SIMJobQueued = "queued"
+	SIMJobDone   = "done"
+	SIMJobFailed = "failed"
+)
+
+// Define the SIM job struct to represent activating or suspending the SIM of a
+// modem at the carrier, retried until it succeeds or runs out of attempts
+type SIMJob struct {
+	ID            int64  `json:"id" db:"id"`
+	MacAddress    string `json:"mac_address" db:"mac_address"`
+	ICCID         string `json:"iccid" db:"iccid"`
+	Provider      string `json:"provider" db:"provider"`
+	Action        string `json:"action" db:"action"`
+	Status        string `json:"status" db:"status"`
+	SIMStatus     string `json:"sim_status" db:"sim_status"` // status of the SIM at the carrier after the last attempt, e.g. active
+	Attempts      int    `json:"attempts" db:"attempts"`
+	Error         string `json:"error" db:"error"` // error of the last attempt
+	Actor         string `json:"actor" db:"actor"`
+	CreatedAt     int    `json:"created_at" db:"created_at"`
+	UpdatedAt     int    `json:"updated_at" db:"updated_at"`
+	NextAttemptAt int    `json:"next_attempt_at" db:"next_attempt_at"` // when a queued job is tried next
+}
+
+// SIMState is the status of the SIM of a modem as reported by the carrier
+type SIMState struct {
+	MacAddress string `json:"mac_address"`
+	ICCID      string `json:"iccid"`
+	Provider   string `json:"provider"`
+	Status     string `json:"status"`
+}
@@ -0,0 +1,74 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+)
+
+// Functional test states of a modem and of a test run
+const (
+	TestRunning = "running"
+	TestPassed  = "passed"
+	TestFailed  = "failed"
+)
+
+// Measurement is a value measured by a test step, e.g. the signal strength,
+// with the limits of the test plan it was checked against
+type Measurement struct {
+	Name  string   `json:"name"`
+	Value float64  `json:"value"`
+	Unit  string   `json:"unit,omitempty"`
+	Min   *float64 `json:"min,omitempty"`
+	Max   *float64 `json:"max,omitempty"`
+}
+
+// Measurements are stored as a JSON array
+type Measurements []Measurement
+
+func (m Measurements) Value() (driver.Value, error) {
+	if m == nil {
+		m = Measurements{}
+	}
+	b, err := json.Marshal(m)
+	return string(b), err
+}
+
+func (m *Measurements) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case string:
+		return json.Unmarshal([]byte(v), m)
+	case []byte:
+		return json.Unmarshal(v, m)
+	case nil:
+		*m = nil
+		return nil
+	}
+	return errors.New("unsupported type of measurements")
+}
+
+// Define the test run struct to represent one run of the functional tests on a modem
+type TestRun struct {
+	ID         int64        `json:"id" db:"id"`
+	MacAddress string       `json:"mac_address" db:"mac_address"`
+	Status     string       `json:"status" db:"status"`
+	Error      string       `json:"error" db:"error"` // why the run could not be completed, e.g. the modem was not reachable
+	Actor      string       `json:"actor" db:"actor"`
+	StartedAt  int          `json:"started_at" db:"started_at"`
+	FinishedAt int          `json:"finished_at" db:"finished_at"`
+	Results    []TestResult `json:"results" db:"-"`
+}
+
+// Define the test result struct to represent the outcome of one step of a test run
+type TestResult struct {
+	ID           int64        `json:"id" db:"id"`
+	RunID        int64        `json:"run_id" db:"run_id"`
+	MacAddress   string       `json:"mac_address" db:"mac_address"`
+	Step         string       `json:"step" db:"step"`
+	Passed       bool         `json:"passed" db:"passed"`
+	Error        string       `json:"error" db:"error"`
+	Measurements Measurements `json:"measurements" db:"measurements"`
+	StartedAt    int          `json:"started_at" db:"started_at"`
+	DurationMs   int64        `json:"duration_ms" db:"duration_ms"` // of the last attempt
+	Attempts     int          `json:"attempts" db:"attempts"`
+}
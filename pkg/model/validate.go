@@ -0,0 +1,187 @@
+package model
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// maxLabelCopies limits the copies of one label print job
+const maxLabelCopies = 20
+
+// nameRegex matches the names of label templates and config profiles
+var nameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// uciKeyRegex matches config.section or config.section.option, the section may
+// be a named one or e.g. @wifi-iface[0]
+var uciKeyRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+\.(@[A-Za-z0-9_-]+\[-?[0-9]+\]|[A-Za-z0-9_]+)(\.[A-Za-z0-9_]+)?$`)
+
+// FieldError describes why the value of a field is invalid
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned when a record has one or more invalid fields
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Field + ": " + f.Message
+	}
+	return "validation failed: " + strings.Join(msgs, ", ")
+}
+
+// Add records an invalid field
+func (e *ValidationError) Add(field string, format string, args ...interface{}) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Err returns e if any field was invalid, otherwise nil
+func (e *ValidationError) Err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// ValidateState checks a modem state, 0: unknown, 1: normal, 2: busy, 3: error
+func ValidateState(state int) error {
+	v := &ValidationError{}
+	if state < 0 || state > 3 {
+		v.Add("state", "must be between 0 and 3")
+	}
+	return v.Err()
+}
+
+// ValidateProgress checks an upgrade progress in percent
+func ValidateProgress(progress int) error {
+	v := &ValidationError{}
+	if progress < 0 || progress > 100 {
+		v.Add("progress", "must be between 0 and 100")
+	}
+	return v.Err()
+}
+
+// Validate checks the fields of a modem
+func (m Modem) Validate() error {
+	v := &ValidationError{}
+	if _, err := net.ParseMAC(m.MacAddress); err != nil {
+		v.Add("mac_address", "must be a MAC address")
+	}
+	if m.SwitchPort < -1 {
+		v.Add("switch_port", "must be -1 or a port number")
+	}
+	if ValidateState(m.State) != nil {
+		v.Add("state", "must be between 0 and 3")
+	}
+	if ValidateProgress(m.Progress) != nil {
+		v.Add("progress", "must be between 0 and 100")
+	}
+	if m.FailCount < 0 {
+		v.Add("fail_count", "must not be negative")
+	}
+	switch m.TestStatus {
+	case "", TestRunning, TestPassed, TestFailed:
+	default:
+		v.Add("test_status", "must be empty, %s, %s or %s", TestRunning, TestPassed, TestFailed)
+	}
+	return v.Err()
+}
+
+// Validate checks the fields of a new work order
+func (o Order) Validate() error {
+	v := &ValidationError{}
+	if o.Customer == "" {
+		v.Add("customer", "is required")
+	}
+	if o.Quantity <= 0 {
+		v.Add("quantity", "must be positive")
+	}
+	if o.BenchFrom < 0 || o.BenchTo < 0 {
+		v.Add("bench_from", "switch ports must not be negative")
+	}
+	if o.BenchFrom > o.BenchTo {
+		v.Add("bench_from", "must not be after bench_to")
+	}
+	return v.Err()
+}
+
+// Validate checks the fields of a new api key
+func (k APIKey) Validate() error {
+	v := &ValidationError{}
+	if k.Name == "" {
+		v.Add("name", "is required")
+	}
+	if !ValidRole(k.Role) {
+		v.Add("role", "must be one of %s, %s, %s or %s", RoleViewer, RoleOperator, RoleEngineer, RoleAdmin)
+	}
+	return v.Err()
+}
+
+// Validate checks the fields of an expected unit
+func (u ExpectedUnit) Validate() error {
+	v := &ValidationError{}
+	if _, err := net.ParseMAC(u.MacAddress); err != nil {
+		v.Add("mac_address", "must be a MAC address")
+	}
+	if u.IMEI != "" && (len(u.IMEI) != 15 || strings.Trim(u.IMEI, "0123456789") != "") {
+		v.Add("imei", "must be 15 digits")
+	}
+	return v.Err()
+}
+
+// Validate checks the fields of a label template, the ZPL itself is checked when it is parsed
+func (t LabelTemplate) Validate() error {
+	v := &ValidationError{}
+	if !nameRegex.MatchString(t.Name) {
+		v.Add("name", "must be up to 64 lower case letters, digits, - and _")
+	}
+	if t.ZPL == "" {
+		v.Add("zpl", "is required")
+	}
+	return v.Err()
+}
+
+// ValidateCopies checks the number of copies of a print job
+func ValidateCopies(copies int) error {
+	v := &ValidationError{}
+	if copies < 1 || copies > maxLabelCopies {
+		v.Add("copies", "must be between 1 and %d", maxLabelCopies)
+	}
+	return v.Err()
+}
+
+func (p ConfigProfile) Validate() error {
+	v := &ValidationError{}
+	if !nameRegex.MatchString(p.Name) {
+		v.Add("name", "must be up to 64 lower case letters, digits, - and _")
+	}
+	if len(p.Settings) == 0 {
+		v.Add("settings", "at least one setting is required")
+	}
+	seen := make(map[string]bool)
+	for i, setting := range p.Settings {
+		field := fmt.Sprintf("settings[%d]", i)
+		if !uciKeyRegex.MatchString(setting.Key) {
+			v.Add(field+".key", "must be config.section or config.section.option, got %q", setting.Key)
+		} else if seen[setting.Key] {
+			v.Add(field+".key", "%s is set twice", setting.Key)
+		}
+		seen[setting.Key] = true
+		if strings.ContainsAny(setting.Value, "\r\n\x00") {
+			v.Add(field+".value", "must be a single line")
+		}
+	}
+	if strings.ContainsAny(p.AdminPassword, "\r\n\x00") {
+		v.Add("admin_password", "must be a single line")
+	}
+	if p.UniquePassword && p.AdminPassword != "" {
+		v.Add("admin_password", "can not be set together with unique_password")
+	}
+	return v.Err()
+}
@@ -0,0 +1,147 @@
+// Package provision applies UCI configuration to modems over SSH and reads it
+// back to verify it.
+package provision
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+// Runner runs a shell command on a modem and returns its output
+type Runner interface {
+	Run(ctx context.Context, command string) (string, error)
+}
+
+// ExitError is returned by a Runner when a command exits with a non-zero status
+type ExitError struct {
+	Command string // the program, without its arguments as they may be secret
+	Status  int
+	Output  string // stderr, or stdout if stderr was empty
+}
+
+func (e *ExitError) Error() string {
+	if e.Output == "" {
+		return fmt.Sprintf("%s: exit status %d", e.Command, e.Status)
+	}
+	return fmt.Sprintf("%s: exit status %d: %s", e.Command, e.Status, e.Output)
+}
+
+// Quote quotes s for the shell of the modem
+func Quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// configOf returns the config file a UCI key belongs to, e.g. network for network.lan.ipaddr
+func configOf(key string) string {
+	config, _, _ := strings.Cut(key, ".")
+	return config
+}
+
+// Apply sets the UCI settings, commits them and reloads the services using
+// them. If setting fails the uncommitted changes are reverted.
+func Apply(ctx context.Context, r Runner, settings []model.UCISetting) error {
+	var configs []string
+	for _, setting := range settings {
+		if config := configOf(setting.Key); !slices.Contains(configs, config) {
+			configs = append(configs, config)
+		}
+	}
+
+	for _, setting := range settings {
+		if _, err := r.Run(ctx, "uci set "+setting.Key+"="+Quote(setting.Value)); err != nil {
+			for _, config := range configs {
+				r.Run(ctx, "uci revert "+config)
+			}
+			return fmt.Errorf("failed to set %s: %w", setting.Key, err)
+		}
+	}
+	for _, config := range configs {
+		if _, err := r.Run(ctx, "uci commit "+config); err != nil {
+			return fmt.Errorf("failed to commit %s: %w", config, err)
+		}
+	}
+	if _, err := r.Run(ctx, "reload_config"); err != nil {
+		return fmt.Errorf("failed to reload the configuration: %w", err)
+	}
+	return nil
+}
+
+// Read returns the values of the UCI keys on a modem, keys that are not set are left out
+func Read(ctx context.Context, r Runner, keys []string) (map[string]string, error) {
+	values := make(map[string]string, len(keys))
+	for _, key := range keys {
+		value, err := r.Run(ctx, "uci -q get "+key)
+		var exitErr *ExitError
+		if errors.As(err, &exitErr) && exitErr.Status == 1 {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", key, err)
+		}
+		values[key] = strings.TrimRight(value, "\r\n")
+	}
+	return values, nil
+}
+
+// Diff compares the desired settings with the values read from a modem
+func Diff(settings []model.UCISetting, actual map[string]string) []model.ConfigDiff {
+	diffs := make([]model.ConfigDiff, len(settings))
+	for i, setting := range settings {
+		value, ok := actual[setting.Key]
+		diffs[i] = model.ConfigDiff{
+			Key:     setting.Key,
+			Desired: setting.Value,
+			Actual:  value,
+			Missing: !ok,
+			Match:   ok && value == setting.Value,
+		}
+	}
+	return diffs
+}
+
+// Compare reads the settings from a modem and compares them with the desired values
+func Compare(ctx context.Context, r Runner, settings []model.UCISetting) ([]model.ConfigDiff, error) {
+	keys := make([]string, len(settings))
+	for i, setting := range settings {
+		keys[i] = setting.Key
+	}
+	actual, err := Read(ctx, r, keys)
+	if err != nil {
+		return nil, err
+	}
+	return Diff(settings, actual), nil
+}
+
+// Verify reads the settings back from a modem and returns an error naming the
+// keys that do not have the desired value
+func Verify(ctx context.Context, r Runner, settings []model.UCISetting) ([]model.ConfigDiff, error) {
+	diffs, err := Compare(ctx, r, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	var differ []string
+	for _, d := range diffs {
+		if !d.Match {
+			differ = append(differ, d.Key)
+		}
+	}
+	if len(differ) > 0 {
+		return diffs, fmt.Errorf("%d settings differ after applying: %s", len(differ), strings.Join(differ, ", "))
+	}
+	return diffs, nil
+}
+
+// SetPassword changes the password of a user on a modem
+func SetPassword(ctx context.Context, r Runner, user string, password string) error {
+	input := Quote(password + "\n" + password + "\n")
+	if _, err := r.Run(ctx, "printf '%s' "+input+" | passwd "+Quote(user)); err != nil {
+		return fmt.Errorf("failed to set the password of %s: %w", user, err)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package provision
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+// fakeRunner runs commands against a map of UCI values and records them
+type fakeRunner struct {
+	values   map[string]string
+	fail     string // commands starting with it fail
+	commands []string
+}
+
+func (f *fakeRunner) Run(ctx context.Context, command string) (string, error) {
+	f.commands = append(f.commands, command)
+	if f.fail != "" && strings.HasPrefix(command, f.fail) {
+		return "", &ExitError{Command: "uci", Status: 2, Output: "failed"}
+	}
+	if key, ok := strings.CutPrefix(command, "uci -q get "); ok {
+		value, ok := f.values[key]
+		if !ok {
+			return "", &ExitError{Command: "uci", Status: 1}
+		}
+		return value + "\n", nil
+	}
+	return "", nil
+}
+
+var testSettings = []model.UCISetting{
+	{Key: "network.lan.ipaddr", Value: "192.168.9.1"},
+	{Key: "system.system.hostname", Value: "it's a modem"},
+	{Key: "network.lan.netmask", Value: "255.255.255.0"},
+}
+
+func TestApply(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		fail     string
+		err      string
+		commands []string
+	}{
+		{"applied", "", "", []string{
+			"uci set network.lan.ipaddr='192.168.9.1'",
+			`uci set system.system.hostname='it'\''s a modem'`,
+			"uci set network.lan.netmask='255.255.255.0'",
+			"uci commit network",
+			"uci commit system",
+			"reload_config",
+		}},
+		{"set fails", "uci set system", "failed to set system.system.hostname", []string{
+			"uci set network.lan.ipaddr='192.168.9.1'",
+			`uci set system.system.hostname='it'\''s a modem'`,
+			"uci revert network",
+			"uci revert system",
+		}},
+		{"commit fails", "uci commit system", "failed to commit system", []string{
+			"uci set network.lan.ipaddr='192.168.9.1'",
+			`uci set system.system.hostname='it'\''s a modem'`,
+			"uci set network.lan.netmask='255.255.255.0'",
+			"uci commit network",
+			"uci commit system",
+		}},
+		{"reload fails", "reload_config", "failed to reload", nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &fakeRunner{fail: tt.fail}
+			err := Apply(context.Background(), r, testSettings)
+			if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("error %v, want %q", err, tt.err)
+			}
+			if tt.commands != nil && !reflect.DeepEqual(r.commands, tt.commands) {
+				t.Errorf("ran %q, want %q", r.commands, tt.commands)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	r := &fakeRunner{values: map[string]string{
+		"network.lan.ipaddr":     "192.168.9.1",
+		"system.system.hostname": "other",
+	}}
+	diffs, err := Verify(context.Background(), r, testSettings)
+	if err == nil || err.Error() != "2 settings differ after applying: system.system.hostname, network.lan.netmask" {
+		t.Errorf("error %v", err)
+	}
+	want := []model.ConfigDiff{
+		{Key: "network.lan.ipaddr", Desired: "192.168.9.1", Actual: "192.168.9.1", Match: true},
+		{Key: "system.system.hostname", Desired: "it's a modem", Actual: "other"},
+		{Key: "network.lan.netmask", Desired: "255.255.255.0", Missing: true},
+	}
+	if !reflect.DeepEqual(diffs, want) {
+		t.Errorf("diffs %+v, want %+v", diffs, want)
+	}
+
+	r.values["system.system.hostname"] = "it's a modem"
+	r.values["network.lan.netmask"] = "255.255.255.0"
+	if _, err := Verify(context.Background(), r, testSettings); err != nil {
+		t.Errorf("verifying the applied settings: %v", err)
+	}
+
+	r.fail = "uci -q get"
+	if _, err := Verify(context.Background(), r, testSettings); err == nil || !strings.Contains(err.Error(), "failed to read network.lan.ipaddr") {
+		t.Errorf("error %v when reading fails", err)
+	}
+}
+
+func TestSetPassword(t *testing.T) {
+	r := &fakeRunner{}
+	if err := SetPassword(context.Background(), r, "root", "it's"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`printf '%s' 'it'\''s
+it'\''s
+' | passwd 'root'`}
+	if !reflect.DeepEqual(r.commands, want) {
+		t.Errorf("ran %q, want %q", r.commands, want)
+	}
+}
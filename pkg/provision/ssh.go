@@ -0,0 +1,116 @@
+package provision
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/ebobo/modem_prod_go/pkg/metrics"
+)
+
+// ErrAuth is returned by Dial when none of the passwords is accepted
+var ErrAuth = errors.New("none of the passwords was accepted")
+
+// SSH runs commands on a modem over SSH
+type SSH struct {
+	client *ssh.Client
+}
+
+// Dial connects to a modem, trying the passwords in order until one is
+// accepted. The index of the accepted password is returned.
+func Dial(ctx context.Context, addr string, user string, passwords []string, timeout time.Duration) (*SSH, int, error) {
+	for i, password := range passwords {
+		client, err := dial(ctx, addr, user, password, timeout)
+		if err == nil {
+			return &SSH{client: client}, i, nil
+		}
+		if !strings.Contains(err.Error(), "unable to authenticate") {
+			return nil, 0, err
+		}
+	}
+	return nil, 0, ErrAuth
+}
+
+func dial(ctx context.Context, addr string, user string, password string, timeout time.Duration) (*ssh.Client, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		metrics.ObserveSSH("dial", start, err)
+		return nil, err
+	}
+
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.Password(password)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         timeout,
+	})
+	metrics.ObserveSSH("dial", start, err)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// Run runs a command in a new session, closing the session stops it if ctx is done
+func (s *SSH) Run(ctx context.Context, command string) (string, error) {
+	session, err := s.client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			session.Close()
+		case <-done:
+		}
+	}()
+
+	// The program name is the metric label, the arguments may be secret
+	name, _, _ := strings.Cut(command, " ")
+	start := time.Now()
+	err = session.Run(command)
+	metrics.ObserveSSH(name, start, err)
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		output := strings.TrimSpace(stderr.String())
+		if output == "" {
+			output = strings.TrimSpace(stdout.String())
+		}
+		return stdout.String(), &ExitError{Command: name, Status: exitErr.ExitStatus(), Output: output}
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.ReplaceAll(stdout.String(), "\r", ""), nil
+}
+
+// Close closes the connection
+func (s *SSH) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,69 @@
+// Package reconcile compares the units a supplier announced with the modems
+// discovery found, to spot missing, unexpected and mismatched units.
+package reconcile
+
+import (
+	"time"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+// Report is the result of comparing the expected units with the discovered modems
+type Report struct {
+	GeneratedAt int64  `json:"generated_at"`
+	Batch       string `json:"batch,omitempty"` // only units of this batch are reported as missing or mismatched
+
+	Expected   int `json:"expected"`   // number of expected units
+	Discovered int `json:"discovered"` // number of expected units that were discovered
+	Matched    int `json:"matched"`    // number of discovered expected units without mismatches
+
+	Missing    []model.ExpectedUnit `json:"missing"`
+	Unexpected []model.Modem        `json:"unexpected"`
+	Mismatches []model.Discrepancy  `json:"mismatches"`
+}
+
+// Compare reconciles the expected units with the discovered modems. If batch is
+// not empty only the units of that batch are reported as missing or mismatched,
+// the others still count as expected when looking for unexpected modems.
+func Compare(expected []model.ExpectedUnit, modems []model.Modem, batch string) Report {
+	report := Report{
+		GeneratedAt: time.Now().Unix(),
+		Batch:       batch,
+		Missing:     []model.ExpectedUnit{},
+		Unexpected:  []model.Modem{},
+		Mismatches:  []model.Discrepancy{},
+	}
+
+	discovered := make(map[string]model.Modem, len(modems))
+	for _, m := range modems {
+		discovered[m.MacAddress] = m
+	}
+
+	isExpected := make(map[string]bool, len(expected))
+	for _, unit := range expected {
+		isExpected[unit.MacAddress] = true
+		if batch != "" && unit.Batch != batch {
+			continue
+		}
+
+		report.Expected++
+		modem, ok := discovered[unit.MacAddress]
+		if !ok {
+			report.Missing = append(report.Missing, unit)
+			continue
+		}
+		report.Discovered++
+		mismatches := unit.Compare(modem)
+		if len(mismatches) == 0 {
+			report.Matched++
+		}
+		report.Mismatches = append(report.Mismatches, mismatches...)
+	}
+
+	for _, m := range modems {
+		if !isExpected[m.MacAddress] {
+			report.Unexpected = append(report.Unexpected, m)
+		}
+	}
+	return report
+}
@@ -0,0 +1,77 @@
+package reconcile
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+func TestCompare(t *testing.T) {
+	expected := []model.ExpectedUnit{
+		{MacAddress: "00:1f:43:00:00:01", Serial: "1111", Model: "TRB140", Batch: "b1"},
+		{MacAddress: "00:1f:43:00:00:02", Serial: "2222", Model: "TRB140", Batch: "b1"},
+		{MacAddress: "00:1f:43:00:00:03", Serial: "3333", Batch: "b1"},
+		{MacAddress: "00:1f:43:00:00:04", Serial: "4444", Batch: "b2"},
+	}
+	modems := []model.Modem{
+		{MacAddress: "00:1f:43:00:00:01", Serial: "1111", Model: "trb140"}, // case is ignored
+		{MacAddress: "00:1f:43:00:00:02", Serial: "9999", Model: "TRB141"},
+		{MacAddress: "00:1f:43:00:00:04", Serial: "0000"},
+		{MacAddress: "00:1f:43:00:00:05"},
+	}
+
+	for _, tt := range []struct {
+		name       string
+		batch      string
+		expected   int
+		discovered int
+		matched    int
+		missing    []string
+		mismatches []string
+	}{
+		{"all batches", "", 4, 3, 1,
+			[]string{"00:1f:43:00:00:03"},
+			[]string{"00:1f:43:00:00:02 serial", "00:1f:43:00:00:02 model", "00:1f:43:00:00:04 serial"}},
+		{"one batch", "b1", 3, 2, 1,
+			[]string{"00:1f:43:00:00:03"},
+			[]string{"00:1f:43:00:00:02 serial", "00:1f:43:00:00:02 model"}},
+		{"other batch", "b2", 1, 1, 0,
+			[]string{},
+			[]string{"00:1f:43:00:00:04 serial"}},
+		{"unknown batch", "b3", 0, 0, 0, []string{}, []string{}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			report := Compare(expected, modems, tt.batch)
+
+			if report.Batch != tt.batch || report.Expected != tt.expected || report.Discovered != tt.discovered || report.Matched != tt.matched {
+				t.Errorf("counts %d expected, %d discovered, %d matched, want %d, %d, %d",
+					report.Expected, report.Discovered, report.Matched, tt.expected, tt.discovered, tt.matched)
+			}
+
+			missing := []string{}
+			for _, u := range report.Missing {
+				missing = append(missing, u.MacAddress)
+			}
+			if !reflect.DeepEqual(missing, tt.missing) {
+				t.Errorf("missing %v, want %v", missing, tt.missing)
+			}
+
+			mismatches := []string{}
+			for _, d := range report.Mismatches {
+				if d.Kind != model.DiscrepancyMismatch {
+					t.Errorf("discrepancy of kind %s", d.Kind)
+				}
+				mismatches = append(mismatches, d.MacAddress+" "+d.Field)
+			}
+			if !reflect.DeepEqual(mismatches, tt.mismatches) {
+				t.Errorf("mismatches %v, want %v", mismatches, tt.mismatches)
+			}
+
+			// units of other batches are still expected, so only the fifth modem is unexpected
+			if len(report.Unexpected) != 1 || report.Unexpected[0].MacAddress != "00:1f:43:00:00:05" {
+				t.Errorf("unexpected %+v", report.Unexpected)
+			}
+		})
+	}
+}
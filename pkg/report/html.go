@@ -0,0 +1,70 @@
+package report
+
+import (
+	"html/template"
+	"io"
+)
+
+var htmlTemplate = template.Must(template.New("traveler").Funcs(template.FuncMap{
+	"status": func(s string) string {
+		switch s {
+		case "passed", "done", "active":
+			return "ok"
+		case "failed", "error":
+			return "bad"
+		}
+		return ""
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}} {{.Subtitle}}</title>
+<style>
+body { font-family: Helvetica, Arial, sans-serif; font-size: 10pt; margin: 2em; color: #222; }
+h1 { font-size: 18pt; margin-bottom: 0; }
+p.subtitle { margin-top: 0.2em; color: #555; }
+h2 { font-size: 12pt; border-bottom: 1px solid #888; margin-top: 1.5em; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 0.8em; }
+table.fields th { width: 12em; }
+th, td { text-align: left; vertical-align: top; padding: 2px 6px; }
+table.grid th { border-bottom: 1px solid #888; }
+table.grid td { border-bottom: 1px solid #ddd; }
+.ok { color: #080; font-weight: bold; }
+.bad { color: #c00; font-weight: bold; }
+footer { margin-top: 2em; font-size: 8pt; color: #666; }
+@media print { body { margin: 0; } h2 { page-break-after: avoid; } tr { page-break-inside: avoid; } }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="subtitle">{{.Subtitle}}</p>
+{{range .Sections}}
+<h2>{{.Title}}</h2>
+{{- if .Fields}}
+<table class="fields">
+{{- range .Fields}}
+<tr><th>{{.Label}}</th><td class="{{status .Value}}">{{.Value}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- with .Table}}
+<table class="grid">
+<tr>{{range .Columns}}<th>{{.}}</th>{{end}}</tr>
+{{- range .Rows}}
+<tr>{{range .}}<td class="{{status .}}">{{.}}</td>{{end}}</tr>
+{{- end}}
+</table>
+{{- end}}
+{{- if .Note}}
+<p>{{.Note}}</p>
+{{- end}}
+{{end}}
+<footer>{{.Footer}}</footer>
+</body>
+</html>
+`))
+
+func writeHTML(w io.Writer, doc document) error {
+	return htmlTemplate.Execute(w, doc)
+}
@@ -0,0 +1,289 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The PDF is written by hand like the XLSX export, it only needs text and
+// lines in the standard Helvetica fonts which every PDF reader has built in.
+
+// A4 page in points, with the margins of the text
+const (
+	pageWidth    = 595.0
+	pageHeight   = 842.0
+	margin       = 50.0
+	contentWidth = pageWidth - 2*margin
+	footerHeight = 30.0
+	labelWidth   = 120.0
+	cellPadding  = 4.0
+)
+
+// helveticaWidths are the widths of the characters from space to ~ in
+// Helvetica, in thousandths of the font size
+var helveticaWidths = [...]float64{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+// boldFactor widens Helvetica to about Helvetica-Bold, a little generously so
+// bold text never runs over
+const boldFactor = 1.1
+
+// textWidth returns the width of s in points
+func textWidth(s string, size float64, bold bool) float64 {
+	var w float64
+	for i := 0; i < len(s); i++ {
+		w += helveticaWidths[s[i]-' ']
+	}
+	if bold {
+		w *= boldFactor
+	}
+	return w * size / 1000
+}
+
+// pdfText makes s printable in the standard fonts: characters they lack
+// become ? and whitespace becomes spaces
+func pdfText(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\t' || r == '\n' || r == '\r':
+			return ' '
+		case r < ' ' || r > '~':
+			return '?'
+		}
+		return r
+	}, s)
+}
+
+// pdfString quotes s as a PDF literal string
+func pdfString(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `(`, `\(`, `)`, `\)`)
+	return "(" + r.Replace(s) + ")"
+}
+
+// wrap breaks text into lines no wider than width, splitting words that are too long on their own
+func wrap(text string, size float64, bold bool, width float64) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(pdfText(text)) {
+		for textWidth(word, size, bold) > width && len(word) > 1 {
+			if line != "" {
+				lines, line = append(lines, line), ""
+			}
+			n := len(word) - 1
+			for n > 1 && textWidth(word[:n], size, bold) > width {
+				n--
+			}
+			lines, word = append(lines, word[:n]), word[n:]
+		}
+		switch {
+		case line == "":
+			line = word
+		case textWidth(line+" "+word, size, bold) <= width:
+			line += " " + word
+		default:
+			lines, line = append(lines, line), word
+		}
+	}
+	return append(lines, line)
+}
+
+// pdfDoc lays out text top down over as many pages as it needs
+type pdfDoc struct {
+	pages []*bytes.Buffer // content streams
+	page  *bytes.Buffer
+	y     float64 // top of the free space on the page
+}
+
+func (d *pdfDoc) newPage() {
+	d.page = new(bytes.Buffer)
+	d.pages = append(d.pages, d.page)
+	d.y = pageHeight - margin
+}
+
+// need starts a new page unless height fits on this one, and reports whether it did
+func (d *pdfDoc) need(height float64) bool {
+	if d.page != nil && d.y-height >= margin+footerHeight {
+		return false
+	}
+	d.newPage()
+	return true
+}
+
+// text writes s with its baseline at x, y
+func (d *pdfDoc) text(x float64, y float64, size float64, bold bool, s string) {
+	if s == "" {
+		return
+	}
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(d.page, "BT /%s %.1f Tf %.2f %.2f Td %s Tj ET\n", font, size, x, y, pdfString(pdfText(s)))
+}
+
+// rule draws a horizontal line at y, gray is 0 for black to 1 for white
+func (d *pdfDoc) rule(y float64, width float64, gray float64) {
+	fmt.Fprintf(d.page, "%.2f G %.2f w %.2f %.2f m %.2f %.2f l S\n", gray, width, margin, y, pageWidth-margin, y)
+}
+
+func (d *pdfDoc) heading(title string) {
+	d.need(60) // keep a heading with the start of its section
+	d.y -= 10
+	d.text(margin, d.y-12, 12, true, title)
+	d.y -= 17
+	d.rule(d.y, 0.8, 0.3)
+	d.y -= 5
+}
+
+func (d *pdfDoc) fields(fields []field) {
+	const size, leading = 9.0, 12.0
+	for _, f := range fields {
+		lines := wrap(f.Value, size, false, contentWidth-labelWidth)
+		d.need(float64(len(lines)) * leading)
+		d.text(margin, d.y-size, size, true, f.Label)
+		for i, line := range lines {
+			d.text(margin+labelWidth, d.y-size-float64(i)*leading, size, false, line)
+		}
+		d.y -= float64(len(lines)) * leading
+	}
+}
+
+// table writes the rows under the column headers, which are repeated on every page the table continues on
+func (d *pdfDoc) table(t *table) {
+	const size, leading = 8.0, 10.0
+	var total float64
+	for _, w := range t.Widths {
+		total += w
+	}
+	widths := make([]float64, len(t.Widths))
+	for i, w := range t.Widths {
+		widths[i] = w / total * contentWidth
+	}
+
+	layout := func(cells []string, bold bool) ([][]string, float64) {
+		wrapped := make([][]string, len(cells))
+		height := 0.0
+		for i, cell := range cells {
+			wrapped[i] = wrap(cell, size, bold, widths[i]-cellPadding)
+			height = max(height, float64(len(wrapped[i]))*leading+3)
+		}
+		return wrapped, height
+	}
+	draw := func(wrapped [][]string, height float64, bold bool) {
+		x := margin
+		for i, lines := range wrapped {
+			for j, line := range lines {
+				d.text(x, d.y-size-float64(j)*leading, size, bold, line)
+			}
+			x += widths[i]
+		}
+		d.y -= height
+		if bold {
+			d.rule(d.y+1, 0.6, 0.3)
+		} else {
+			d.rule(d.y+1, 0.3, 0.8)
+		}
+	}
+
+	header, headerHeight := layout(t.Columns, true)
+	d.need(headerHeight + 2*leading) // keep the header with the first row
+	draw(header, headerHeight, true)
+	for _, cells := range t.Rows {
+		row, height := layout(cells, false)
+		if d.need(height) {
+			draw(header, headerHeight, true)
+		}
+		draw(row, height, false)
+	}
+}
+
+func (d *pdfDoc) note(s string) {
+	d.need(12)
+	d.text(margin, d.y-9, 9, false, s)
+	d.y -= 12
+}
+
+// footers writes the footer and page number at the bottom of every page
+func (d *pdfDoc) footers(footer string) {
+	for i, page := range d.pages {
+		d.page = page
+		number := fmt.Sprintf("Page %d of %d", i+1, len(d.pages))
+		page.WriteString("0.4 g\n")
+		d.text(margin, margin-10, 8, false, footer)
+		d.text(pageWidth-margin-textWidth(number, 8, false), margin-10, 8, false, number)
+		page.WriteString("0 g\n")
+	}
+}
+
+func writePDF(w io.Writer, doc document) error {
+	d := &pdfDoc{}
+	d.newPage()
+	d.text(margin, d.y-18, 18, true, doc.Title)
+	d.y -= 26
+	d.text(margin, d.y-10, 10, false, doc.Subtitle)
+	d.y -= 14
+
+	for _, s := range doc.Sections {
+		d.heading(s.Title)
+		d.fields(s.Fields)
+		if s.Table != nil {
+			if len(s.Fields) > 0 {
+				d.y -= 8
+			}
+			d.table(s.Table)
+		}
+		if s.Note != "" {
+			d.note(s.Note)
+		}
+	}
+	d.footers(doc.Footer)
+
+	return d.write(w, doc)
+}
+
+// write writes the pages as a PDF file. The objects are the catalog, the page
+// tree, the two fonts and the document info, then each page with its content.
+func (d *pdfDoc) write(w io.Writer, doc document) error {
+	var b bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, b.Len())
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+	const firstPage = 6
+
+	b.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPage+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	object(fmt.Sprintf("<< /Title %s /Subject %s /Producer (modem_prod) /CreationDate (D:%s) >>",
+		pdfString(pdfText(doc.Title)), pdfString(pdfText(doc.Subtitle)), doc.Created.UTC().Format("20060102150405Z")))
+	for i, page := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, firstPage+2*i+1))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", page.Len(), page.Bytes()))
+	}
+
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R /Info 5 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	_, err := b.WriteTo(w)
+	return err
+}
@@ -0,0 +1,61 @@
+package report
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPDFText(t *testing.T) {
+	for in, want := range map[string]string{
+		"TRB140 -67 dBm": "TRB140 -67 dBm",
+		"a\tb\r\nc":      "a b  c",
+		"Ærø\x01~":       "?r??~",
+		"":               "",
+	} {
+		if got := pdfText(in); got != want {
+			t.Errorf("pdfText(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestPDFString(t *testing.T) {
+	if got, want := pdfString(`a(b)\c`), `(a\(b\)\\c)`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestTextWidth(t *testing.T) {
+	if got := textWidth("ai", 10, false); got != (556+222)*10.0/1000 {
+		t.Errorf("width %v", got)
+	}
+	if regular, bold := textWidth("Modem", 12, false), textWidth("Modem", 12, true); bold <= regular {
+		t.Errorf("bold width %v is not wider than %v", bold, regular)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	abcd := textWidth("ab cd", 10, false)
+	for _, tt := range []struct {
+		name  string
+		text  string
+		bold  bool
+		width float64
+		want  []string
+	}{
+		{"empty", "", false, 100, []string{""}},
+		{"fits", "ab cd", false, abcd, []string{"ab cd"}},
+		{"wraps", "ab cd ef", false, abcd, []string{"ab cd", "ef"}},
+		{"bold is wider", "ab cd", true, abcd, []string{"ab", "cd"}},
+		{"whitespace", "  ab\tcd\n\nef ", false, abcd, []string{"ab cd", "ef"}},
+		{"long word", "aaaaaaaaaa", false, 20, []string{"aaa", "aaa", "aaa", "a"}},
+		{"long word after text", "x aaaaaaaaaa y", false, 20, []string{"x", "aaa", "aaa", "aaa", "a y"}},
+		{"narrower than a character", "ab", false, 1, []string{"a", "b"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := wrap(tt.text, 10, tt.bold, tt.width)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,290 @@
+// Package report renders the production traveler of a modem, the record for
+// the customer of what was done to one unit: its identifiers, firmware,
+// config profile, functional test results and the history of the work.
+//
+// The traveler is laid out once as sections of fields and tables, which the
+// HTML and PDF writers render the same way.
+package report
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+// Formats are the supported report formats
+var Formats = []string{"html", "pdf"}
+
+// ContentType returns the MIME type of a report format
+func ContentType(format string) string {
+	if format == "pdf" {
+		return "application/pdf"
+	}
+	return "text/html; charset=utf-8"
+}
+
+// Filename returns the name of the traveler file of a modem, e.g. traveler-001f43aabbcc.pdf
+func Filename(mac string, format string) string {
+	return "traveler-" + strings.ReplaceAll(mac, ":", "") + "." + format
+}
+
+// Traveler is what the report of one modem is made of. The pointers are nil if
+// the modem was not assigned, provisioned or tested.
+type Traveler struct {
+	Modem        model.Modem
+	Order        *model.Order
+	Provisioning *model.Provisioning
+	TestRun      *model.TestRun
+	History      []model.ModemEvent // oldest first
+	GeneratedAt  time.Time
+}
+
+// Write renders the traveler to w in the format, html or pdf
+func (t Traveler) Write(w io.Writer, format string) error {
+	doc := document{
+		Title:    "Production traveler",
+		Subtitle: t.subtitle(),
+		Footer:   "Traveler of " + t.Modem.MacAddress + ", generated " + t.GeneratedAt.Format("2006-01-02 15:04:05 MST"),
+		Created:  t.GeneratedAt,
+		Sections: t.sections(),
+	}
+	switch format {
+	case "html":
+		return writeHTML(w, doc)
+	case "pdf":
+		return writePDF(w, doc)
+	}
+	return fmt.Errorf("unsupported report format %q", format)
+}
+
+// FirmwareBefore returns the firmware the modem had when it was first read,
+// the current firmware if it never changed
+func (t Traveler) FirmwareBefore() string {
+	for _, e := range t.History {
+		if e.Field != "firmware" {
+			continue
+		}
+		if e.OldValue != "" {
+			return e.OldValue
+		}
+		if e.NewValue != "" {
+			return e.NewValue
+		}
+	}
+	return t.Modem.Firmware
+}
+
+// document is a report laid out for the writers
+type document struct {
+	Title    string
+	Subtitle string
+	Footer   string
+	Created  time.Time
+	Sections []section
+}
+
+// section is one part of the report, with fields, a table or both. Note is
+// shown if the section has nothing else, e.g. the modem was not tested.
+type section struct {
+	Title  string
+	Fields []field
+	Table  *table
+	Note   string
+}
+
+type field struct {
+	Label string
+	Value string
+}
+
+// table has relative column widths, they are scaled to the page
+type table struct {
+	Columns []string
+	Widths  []float64
+	Rows    [][]string
+}
+
+func (t Traveler) subtitle() string {
+	parts := []string{t.Modem.Model, "MAC " + t.Modem.MacAddress}
+	if t.Modem.Serial != "" {
+		parts = append(parts, "serial "+t.Modem.Serial)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (t Traveler) sections() []section {
+	m := t.Modem
+	sections := []section{{
+		Title: "Unit",
+		Fields: []field{
+			{"Model", m.Model},
+			{"MAC address", m.MacAddress},
+			{"Serial", m.Serial},
+			{"IMEI", m.IMEI},
+			{"ICCID", m.ICCID},
+			{"IMSI", m.IMSI},
+		},
+	}}
+
+	production := section{Title: "Production"}
+	if t.Order != nil {
+		production.Fields = append(production.Fields,
+			field{"Work order", fmt.Sprintf("%d, %s", t.Order.ID, t.Order.Customer)},
+			field{"Bench", fmt.Sprintf("ports %d to %d", t.Order.BenchFrom, t.Order.BenchTo)})
+	} else {
+		production.Fields = append(production.Fields, field{"Work order", "not assigned"})
+	}
+	production.Fields = append(production.Fields,
+		field{"Bench slot", slot(m.SwitchPort)},
+		field{"Firmware before", t.FirmwareBefore()},
+		field{"Firmware after", m.Firmware})
+	if t.Order != nil && t.Order.Firmware != "" {
+		production.Fields = append(production.Fields, field{"Target firmware", t.Order.Firmware})
+	}
+	production.Fields = append(production.Fields,
+		field{"Kernel", m.Kernel},
+		field{"Upgraded", yesNo(m.Upgraded)},
+		field{"State", model.StateName(m.State)})
+	sections = append(sections, production)
+
+	config := section{Title: "Configuration"}
+	if p := t.Provisioning; p != nil {
+		config.Fields = []field{
+			{"Profile", p.Profile},
+			{"Status", p.Status},
+			{"Operator", p.Actor},
+			{"Started", formatTime(p.StartedAt)},
+			{"Finished", formatTime(p.FinishedAt)},
+		}
+		if p.Error != "" {
+			config.Fields = append(config.Fields, field{"Error", p.Error})
+		}
+	} else {
+		config.Note = "No config profile was applied."
+	}
+	sections = append(sections, config)
+
+	simStatus := "inactive"
+	if m.SIMStatus {
+		simStatus = "active"
+	}
+	sections = append(sections, section{
+		Title:  "SIM",
+		Fields: []field{{"Carrier", valueOr(m.SIMProvider, "not managed")}, {"Status", simStatus}},
+	})
+
+	test := section{Title: "Functional test"}
+	if run := t.TestRun; run != nil {
+		test.Fields = []field{
+			{"Result", run.Status},
+			{"Run", strconv.FormatInt(run.ID, 10)},
+			{"Operator", run.Actor},
+			{"Started", formatTime(run.StartedAt)},
+			{"Finished", formatTime(run.FinishedAt)},
+		}
+		if run.Error != "" {
+			test.Fields = append(test.Fields, field{"Error", run.Error})
+		}
+		test.Table = &table{
+			Columns: []string{"Step", "Result", "Attempts", "Duration", "Measurements", "Error"},
+			Widths:  []float64{2, 1.3, 1.2, 1.3, 4, 4},
+		}
+		for _, r := range run.Results {
+			result := model.TestFailed
+			if r.Passed {
+				result = model.TestPassed
+			}
+			test.Table.Rows = append(test.Table.Rows, []string{
+				r.Step, result, strconv.Itoa(r.Attempts),
+				(time.Duration(r.DurationMs) * time.Millisecond).String(),
+				formatMeasurements(r.Measurements), r.Error,
+			})
+		}
+	} else {
+		test.Note = "The unit was not tested."
+	}
+	sections = append(sections, test)
+
+	history := section{Title: "History"}
+	if len(t.History) > 0 {
+		history.Table = &table{
+			Columns: []string{"Time", "Change", "Source", "By"},
+			Widths:  []float64{2.6, 6, 1.4, 2.4},
+		}
+		for _, e := range t.History {
+			history.Table.Rows = append(history.Table.Rows, []string{
+				formatTime(e.Timestamp), describeChange(e), e.Source, e.Actor,
+			})
+		}
+	} else {
+		history.Note = "No changes were recorded."
+	}
+	return append(sections, history)
+}
+
+// describeChange describes a history entry in words, e.g. firmware: A -> B
+func describeChange(e model.ModemEvent) string {
+	if e.OldValue == "" {
+		return e.Field + " set to " + valueOr(e.NewValue, "empty")
+	}
+	return e.Field + ": " + e.OldValue + " -> " + valueOr(e.NewValue, "empty")
+}
+
+// formatMeasurements describes measurements with their limits, e.g. rssi -67 dBm (min -95)
+func formatMeasurements(measurements model.Measurements) string {
+	parts := make([]string, len(measurements))
+	for i, m := range measurements {
+		parts[i] = m.Name + " " + formatValue(m.Value, m.Unit)
+		var limits []string
+		if m.Min != nil {
+			limits = append(limits, "min "+formatValue(*m.Min, m.Unit))
+		}
+		if m.Max != nil {
+			limits = append(limits, "max "+formatValue(*m.Max, m.Unit))
+		}
+		if len(limits) > 0 {
+			parts[i] += " (" + strings.Join(limits, ", ") + ")"
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+func formatValue(v float64, unit string) string {
+	s := strconv.FormatFloat(v, 'f', -1, 64)
+	if unit != "" {
+		s += " " + unit
+	}
+	return s
+}
+
+func formatTime(timestamp int) string {
+	if timestamp == 0 {
+		return "-"
+	}
+	return time.Unix(int64(timestamp), 0).Format("2006-01-02 15:04:05 MST")
+}
+
+func slot(port int) string {
+	if port < 0 {
+		return "unknown"
+	}
+	return "switch port " + strconv.Itoa(port)
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+func valueOr(s string, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+// modemRequestBuffer is how many API requests may wait for the discovery loop
+const modemRequestBuffer = 16
+
+// errModemNotDiscovered is returned for requests about a modem discovery has not seen
+var errModemNotDiscovered = errors.New("modem has not been discovered")
+
+// modemRequest asks the discovery loop to read or upgrade a modem again. The
+// loop stores the change and sends the result on reply.
+type modemRequest struct {
+	mac    string
+	apply  func(m *model.Modem)
+	origin model.Origin
+	reply  chan modemReply
+}
+
+// modemReply is the stored modem, or the error of a modemRequest
+type modemReply struct {
+	modem model.Modem
+	err   error
+}
+
+// applyModemRequest stores the change of a request and applies it to the modem
+// discovery works on, so discovery does not overwrite it
+func (s *Server) applyModemRequest(modemList map[string]model.Modem, req modemRequest) modemReply {
+	modem, ok := modemList[req.mac]
+	if !ok {
+		return modemReply{err: errModemNotDiscovered}
+	}
+	saved, err := s.db.ModifyModem(req.mac, req.origin, req.apply)
+	if err != nil {
+		return modemReply{err: err}
+	}
+	req.apply(&modem)
+	modemList[req.mac] = modem
+	return modemReply{modem: saved}
+}
+
+// resetInfo makes discovery read the info of a modem again
+func resetInfo(m *model.Modem) {
+	m.IMEI = ""
+	m.ICCID = ""
+	m.IMSI = ""
+	m.Serial = ""
+	m.State = 1
+}
+
+// resetUpgrade makes discovery upgrade a modem again
+func resetUpgrade(m *model.Modem) {
+	m.Upgraded = false
+	m.Progress = 0
+	m.State = 1
+}
+
+// RereadModem makes discovery read the info of a modem again
+func (s *Server) RereadModem(w http.ResponseWriter, r *http.Request) {
+	s.restartModemStep(w, r, "read", resetInfo)
+}
+
+// ReupgradeModem makes discovery upgrade a modem again
+func (s *Server) ReupgradeModem(w http.ResponseWriter, r *http.Request) {
+	s.restartModemStep(w, r, "upgrade", resetUpgrade)
+}
+
+// restartModemStep resets the fields of a modem that make discovery run a step
+// again. If discovery is running it stores the change, otherwise it is stored here.
+func (s *Server) restartModemStep(w http.ResponseWriter, r *http.Request, step string, reset func(m *model.Modem)) {
+	macAddress := mux.Vars(r)["mac"]
+
+	modem, err := s.db.GetModem(macAddress)
+	if err != nil {
+		writeStoreError(w, "failed to "+step+" modem "+macAddress, err)
+		return
+	}
+	if modem.State == 2 {
+		writeError(w, http.StatusConflict, "modem "+macAddress+" is busy")
+		return
+	}
+
+	if s.discovery.isRunning() {
+		reply := make(chan modemReply, 1)
+		select {
+		case s.modemRequests <- modemRequest{mac: macAddress, apply: reset, origin: apiOrigin(r), reply: reply}:
+		default:
+			writeError(w, http.StatusServiceUnavailable, "discovery is too busy, try again later")
+			return
+		}
+		select {
+		case res := <-reply:
+			modem, err = res.modem, res.err
+		case <-r.Context().Done():
+			return
+		}
+	} else {
+		modem, err = s.db.ModifyModem(macAddress, apiOrigin(r), reset)
+	}
+	if errors.Is(err, errModemNotDiscovered) {
+		writeError(w, http.StatusConflict, "modem "+macAddress+" has not been discovered since discovery started")
+		return
+	}
+	if err != nil {
+		writeStoreError(w, "failed to "+step+" modem "+macAddress, err)
+		return
+	}
+	httpLog.InfoContext(r.Context(), "requested modem step", "mac", macAddress, "step", step)
+
+	w.Header().Set("ETag", modemETag(modem))
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(modem)
+}
+
+// PowerCycleModem turns the PoE power of the switch port of a modem off and on again.
+// The power cycle continues in the background after the response.
+func (s *Server) PowerCycleModem(w http.ResponseWriter, r *http.Request) {
+	macAddress := mux.Vars(r)["mac"]
+
+	modem, err := s.db.GetModem(macAddress)
+	if err != nil {
+		writeStoreError(w, "failed to power cycle modem "+macAddress, err)
+		return
+	}
+	if modem.SwitchPort < 0 {
+		writeError(w, http.StatusConflict, "the switch port of modem "+macAddress+" is not known")
+		return
+	}
+
+	id, _ := requestIdentity(r)
+	ctx := r.Context()
+	go func() {
+		log := snmpLog.With("mac", macAddress, "port", modem.SwitchPort, "job", newJobID())
+		log.InfoContext(ctx, "power cycling modem", "actor", id.Name)
+		if err := powerCyclePort(s.snmp, modem.SwitchPort); err != nil {
+			log.ErrorContext(ctx, "power cycling modem failed", "err", err)
+			return
+		}
+		log.InfoContext(ctx, "power cycled modem")
+	}()
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(modem)
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+func TestRestartModemStep(t *testing.T) {
+	const mac = "00:1f:43:00:00:01"
+
+	for _, tt := range []struct {
+		name       string
+		running    bool
+		discovered bool // discovery has the modem in its list
+		full       bool // the request queue of discovery is full
+		status     int
+	}{
+		{"discovery not running", false, false, false, http.StatusAccepted},
+		{"discovered", true, true, false, http.StatusAccepted},
+		{"not discovered", true, false, false, http.StatusConflict},
+		{"queue full", true, true, true, http.StatusServiceUnavailable},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			modem, err := s.db.ModifyModem(addTestModem(t, s, mac).MacAddress, model.Origin{Source: model.SourceUpgrade}, func(m *model.Modem) {
+				m.Upgraded, m.Progress, m.State = true, 100, model.StateError
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the discovery loop, with the modem as discovery last saw it
+			modemList := map[string]model.Modem{}
+			if tt.discovered {
+				modemList[mac] = modem
+			}
+			if tt.full {
+				for i := 0; i < modemRequestBuffer; i++ {
+					s.modemRequests <- modemRequest{}
+				}
+			} else if tt.running {
+				done := make(chan struct{})
+				defer close(done)
+				go func() {
+					select {
+					case req := <-s.modemRequests:
+						req.reply <- s.applyModemRequest(modemList, req)
+					case <-done:
+					}
+				}()
+			}
+			s.discovery.setRunning(tt.running)
+
+			r := httptest.NewRequest("POST", "/api/v1/modem/"+mac+"/reupgrade", nil)
+			w := httptest.NewRecorder()
+			s.ReupgradeModem(w, mux.SetURLVars(r, map[string]string{"mac": mac}))
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+
+			stored, err := s.db.GetModem(mac)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.status != http.StatusAccepted {
+				if stored != modem {
+					t.Errorf("modem changed to %+v", stored)
+				}
+				return
+			}
+			if stored.Upgraded || stored.Progress != 0 || stored.State != model.StateNormal {
+				t.Errorf("stored %+v", stored)
+			}
+			if etag := w.Header().Get("ETag"); etag != modemETag(stored) {
+				t.Errorf("ETag %s, want %s", etag, modemETag(stored))
+			}
+			if m := modemList[mac]; tt.discovered && (m.Upgraded || m.State != model.StateNormal) {
+				t.Errorf("discovery has %+v", m)
+			}
+		})
+	}
+}
@@ -0,0 +1,309 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ebobo/modem_prod_go/pkg/logging"
+	"github.com/ebobo/modem_prod_go/pkg/model"
+	sqlitestore "github.com/ebobo/modem_prod_go/pkg/store/sqlite"
+)
+
+// bootstrapKeyOutput is where a generated admin api key is printed
+var bootstrapKeyOutput io.Writer = os.Stderr
+
+const (
+	// apiKeyPrefix starts every generated api key so leaked keys are easy to search for
+	apiKeyPrefix = "mp_"
+
+	// apiKeyTouchInterval limits how often the last use of a key is written to the store
+	apiKeyTouchInterval = time.Minute
+)
+
+// identity is the authenticated caller of a request
+type identity struct {
+	KeyID int64
+	Name  string
+	Role  string
+}
+
+type identityKey struct{}
+
+// requestIdentity returns the identity authenticated for a request, if any
+func requestIdentity(r *http.Request) (identity, bool) {
+	id, ok := r.Context().Value(identityKey{}).(identity)
+	return id, ok
+}
+
+// apiKeyTouches remembers when the last use of each key was stored
+type apiKeyTouches struct {
+	mu   sync.Mutex
+	last map[int64]time.Time
+}
+
+// due reports whether the use of a key should be stored, and records it if so
+func (t *apiKeyTouches) due(id int64, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if now.Sub(t.last[id]) < apiKeyTouchInterval {
+		return false
+	}
+	t.last[id] = now
+	return true
+}
+
+// generateAPIKey returns a new random api key
+func generateAPIKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashAPIKey returns the hash an api key is stored by. Keys are random and long,
+// so a plain hash is enough to protect them.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// displayPrefix is the start of a key shown to tell keys apart
+func displayPrefix(key string) string {
+	if len(key) > len(apiKeyPrefix)+6 {
+		return key[:len(apiKeyPrefix)+6]
+	}
+	return key
+}
+
+// requestAPIKey returns the api key of a request from the Authorization bearer
+// token or the X-API-Key header. Browsers can not set headers on event streams,
+// so those may pass the key as ?access_token= instead.
+func requestAPIKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if scheme, token, ok := strings.Cut(auth, " "); ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if strings.HasPrefix(r.URL.Path, "/api/v1/events") {
+		return r.URL.Query().Get("access_token")
+	}
+	return ""
+}
+
+// require wraps a handler so it is only called for callers with at least the given role
+func (s *Server) require(role string, handler http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.authDisabled {
+			id := identity{Name: "anonymous", Role: model.RoleAdmin}
+			handler(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, id)))
+			return
+		}
+
+		id, ok := s.authenticate(w, r)
+		if !ok {
+			return
+		}
+
+		if !model.RoleAllows(id.Role, role) {
+			httpLog.WarnContext(r.Context(), "denied request", "method", r.Method, "path", r.URL.Path,
+				"actor", id.Name, "role", id.Role, "required_role", role)
+			writeError(w, http.StatusForbidden, "the "+id.Role+" role is not allowed to do this")
+			return
+		}
+
+		now := time.Now()
+		if id.KeyID != 0 && s.keyTouches.due(id.KeyID, now) {
+			if err := s.db.TouchAPIKey(id.KeyID, int(now.Unix())); err != nil {
+				httpLog.ErrorContext(r.Context(), "failed to record use of api key", "key_id", id.KeyID, "err", err)
+			}
+		}
+
+		ctx := logging.WithAttrs(r.Context(), slog.String("actor", id.Name))
+		if r.Method != "GET" {
+			httpLog.InfoContext(ctx, "authorized request", "method", r.Method, "path", r.URL.Path, "role", id.Role)
+		}
+
+		handler(w, r.WithContext(context.WithValue(ctx, identityKey{}, id)))
+	})
+}
+
+// authenticate identifies the caller by api key, or by client certificate if the
+// request has no key. On failure it writes a 401 error and returns false.
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) (identity, bool) {
+	key := requestAPIKey(r)
+	if key == "" {
+		if id, ok := s.certificateIdentity(r); ok {
+			return id, true
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="modem_prod"`)
+		writeError(w, http.StatusUnauthorized, "an api key or client certificate is required")
+		return identity{}, false
+	}
+
+	apiKey, err := s.db.GetAPIKeyByHash(hashAPIKey(key))
+	if errors.Is(err, sqlitestore.ErrNotFound) {
+		httpLog.WarnContext(r.Context(), "rejected unknown api key", "key_prefix", displayPrefix(key),
+			"method", r.Method, "path", r.URL.Path)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="modem_prod", error="invalid_token"`)
+		writeError(w, http.StatusUnauthorized, "invalid api key")
+		return identity{}, false
+	}
+	if err != nil {
+		writeStoreError(w, "failed to check api key", err)
+		return identity{}, false
+	}
+
+	return identity{KeyID: apiKey.ID, Name: apiKey.Name, Role: apiKey.Role}, true
+}
+
+// bootstrapAdminKey makes sure there is a way to administer the api keys. The
+// configured admin key is added if it is not stored yet, otherwise an admin key is
+// generated and logged once if there are no admin keys. A configured admin key
+// that was revoked is not added again, the server refuses to start with it.
+func (s *Server) bootstrapAdminKey() error {
+	if s.authDisabled {
+		httpLog.Warn("authentication is disabled, every caller has the admin role")
+		return nil
+	}
+
+	if s.adminKey != "" {
+		key, err := s.db.FindAPIKeyByHash(hashAPIKey(s.adminKey))
+		if err == nil {
+			if key.RevokedAt != 0 {
+				return fmt.Errorf("configured admin key is revoked, %s was revoked at %s",
+					key.Prefix, time.Unix(int64(key.RevokedAt), 0).Format(time.RFC3339))
+			}
+			return nil
+		}
+		if !errors.Is(err, sqlitestore.ErrNotFound) {
+			return err
+		}
+		_, err = s.db.AddAPIKey(model.APIKey{
+			Name:   "bootstrap admin",
+			Role:   model.RoleAdmin,
+			Prefix: displayPrefix(s.adminKey),
+			Hash:   hashAPIKey(s.adminKey),
+		})
+		if err != nil {
+			return err
+		}
+		httpLog.Info("added the configured admin api key", "key_prefix", displayPrefix(s.adminKey))
+		return nil
+	}
+
+	admins, err := s.db.CountActiveAPIKeys(model.RoleAdmin)
+	if err != nil || admins > 0 {
+		return err
+	}
+
+	key, err := generateAPIKey()
+	if err != nil {
+		return err
+	}
+	_, err = s.db.AddAPIKey(model.APIKey{
+		Name:   "bootstrap admin",
+		Role:   model.RoleAdmin,
+		Prefix: displayPrefix(key),
+		Hash:   hashAPIKey(key),
+	})
+	if err != nil {
+		return err
+	}
+	// The secret is printed once and kept out of the logs, which are stored and shipped
+	httpLog.Warn("no admin api key exists, generated one and printed it to stderr", "key_prefix", displayPrefix(key))
+	fmt.Fprintf(bootstrapKeyOutput, "Generated admin api key, it will not be shown again:\n\n    %s\n\n", key)
+	return nil
+}
+
+// GetListAPIKeys returns all api keys without their secrets
+func (s *Server) GetListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := s.db.ListAPIKeys()
+	if err != nil {
+		writeStoreError(w, "failed to list api keys", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// newAPIKeyResponse is the only time the secret of a key is returned
+type newAPIKeyResponse struct {
+	model.APIKey
+	Key string `json:"key"`
+}
+
+// AddAPIKey creates an api key with the name and role in the request body
+func (s *Server) AddAPIKey(w http.ResponseWriter, r *http.Request) {
+	var apiKey model.APIKey
+	if !readJSON(w, r, &apiKey) {
+		return
+	}
+	if err := apiKey.Validate(); err != nil {
+		writeStoreError(w, "invalid api key", err)
+		return
+	}
+
+	key, err := generateAPIKey()
+	if err != nil {
+		writeStoreError(w, "failed to generate api key", err)
+		return
+	}
+	apiKey.Prefix = displayPrefix(key)
+	apiKey.Hash = hashAPIKey(key)
+
+	apiKey.ID, err = s.db.AddAPIKey(apiKey)
+	if err != nil {
+		writeStoreError(w, "failed to add api key", err)
+		return
+	}
+
+	// Read it back for the timestamps set by the store
+	apiKey, err = s.db.GetAPIKeyByHash(apiKey.Hash)
+	if err != nil {
+		writeStoreError(w, "failed to get api key", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newAPIKeyResponse{APIKey: apiKey, Key: key})
+}
+
+// RevokeAPIKey revokes the api key with the id in the path
+func (s *Server) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid api key id")
+		return
+	}
+
+	if err := s.db.RevokeAPIKey(id); err != nil {
+		writeStoreError(w, "failed to revoke api key", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+func TestBootstrapGeneratedAdminKey(t *testing.T) {
+	var out bytes.Buffer
+	bootstrapKeyOutput = &out
+	t.Cleanup(func() { bootstrapKeyOutput = os.Stderr })
+
+	s := newTestServer(t)
+	for i := 0; i < 2; i++ {
+		if err := s.bootstrapAdminKey(); err != nil {
+			t.Fatalf("bootstrap %d: %v", i, err)
+		}
+	}
+
+	// printed once, and it is the stored key
+	fields := strings.Fields(out.String())
+	key := fields[len(fields)-1]
+	if strings.Count(out.String(), apiKeyPrefix) != 1 || !strings.HasPrefix(key, apiKeyPrefix) {
+		t.Fatalf("printed %q", out.String())
+	}
+	stored, err := s.db.GetAPIKeyByHash(hashAPIKey(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Role != model.RoleAdmin {
+		t.Errorf("stored %+v", stored)
+	}
+}
+
+func TestBootstrapAdminKey(t *testing.T) {
+	const key = "mp_configured-admin-key"
+	s := newTestServer(t, func(c *Config) { c.AdminAPIKey = key })
+
+	// Starting again with the key stored already is fine
+	for i := 0; i < 2; i++ {
+		if err := s.bootstrapAdminKey(); err != nil {
+			t.Fatalf("bootstrap %d: %v", i, err)
+		}
+	}
+	stored, err := s.db.GetAPIKeyByHash(hashAPIKey(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.db.RevokeAPIKey(stored.ID); err != nil {
+		t.Fatal(err)
+	}
+	err = s.bootstrapAdminKey()
+	if err == nil || !strings.Contains(err.Error(), "configured admin key is revoked") {
+		t.Errorf("bootstrap with the key revoked: %v", err)
+	}
+	if _, err := s.db.GetAPIKeyByHash(hashAPIKey(key)); err == nil {
+		t.Error("revoked key is active again")
+	}
+}
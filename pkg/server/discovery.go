@@ -1,11 +1,12 @@
 package server
 
 import (
-	"bytes"
-	"flag"
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"math/rand"
+	"net"
 	"os/exec"
 	"strconv"
 	"strings"
@@ -15,16 +16,29 @@ import (
 	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
 	"github.com/gosnmp/gosnmp"
-	"golang.org/x/crypto/ssh"
 
+	"github.com/ebobo/modem_prod_go/pkg/config"
+	"github.com/ebobo/modem_prod_go/pkg/eventbus"
+	"github.com/ebobo/modem_prod_go/pkg/metrics"
 	"github.com/ebobo/modem_prod_go/pkg/model"
+	"github.com/ebobo/modem_prod_go/pkg/provision"
+	sqlitestore "github.com/ebobo/modem_prod_go/pkg/store/sqlite"
 )
 
-var filter = flag.String("filter", "icmp6 and ether", "BPF filter for capture") // Default "icmp6 and ether[6:4] & 0xffffff00 = 0x001e4200" look for teltonika devices
-var iface = flag.String("iface", "eno1", "Select interface where to capture")
-var snaplen = flag.Int("snaplen", 512, "Maximun size to read for each packet")
-var promisc = flag.Bool("promisc", true, "Enable promiscuous mode")
-var timeoutT = flag.Int("timeout", 40, "Connection Timeout in seconds")
+// upgradeCheckInterval is how often an upgraded modem is checked for being back
+const upgradeCheckInterval = 5 * time.Second
+
+// modemFailure reports a modem whose info could not be read or that could not be upgraded
+type modemFailure struct {
+	mac    string
+	source string // history source of the failed step
+	event  string
+	err    error
+}
+
+// oidPoEAdminEnable is pethPsePortAdminEnable of the POWER-ETHERNET-MIB for PSE group 1,
+// followed by the port number. Setting it to 2 turns the port power off, 1 turns it on.
+const oidPoEAdminEnable = ".1.3.6.1.2.1.105.1.1.1.3.1"
 
 // Constructor function to populate default values
 func NewModemInfo(mac string) model.Modem {
@@ -45,61 +59,80 @@ func NewModemInfo(mac string) model.Modem {
 	return modemInfo
 }
 
-func RunModemService() {
-	log.Println("discovery start")
-	defer log.Println("discovery end")
+// RunModemService discovers modems on the network, reads their info, upgrades
+// them and, if enabled, tests them. Changes are saved to the store and published on the event bus.
+func (s *Server) RunModemService() {
+	discoveryLog.Info("discovery start", "iface", s.discoveryCfg.Interface)
+	defer discoveryLog.Info("discovery end")
+
+	s.discovery.setRunning(true)
+	defer s.discovery.setRunning(false)
 
 	// Define channels for communication between goroutines
 	updateModemInfoChan := make(chan model.Modem)
+	failures := make(chan modemFailure)
 
 	// Start goroutine for discovering modems
-	go modemDiscovery(updateModemInfoChan)
+	go modemDiscovery(updateModemInfoChan, s.discoveryCfg, s.discovery)
 
 	time.Sleep(3 * time.Second)
 
 	// Start goroutine for pinging ff02::1%{iface} to trick modems into letting us discover them
-	go pingRoutine(*iface)
+	go pingRoutine(s.discoveryCfg.Interface, s.discoveryCfg.PingInterval)
 
 	// Start goroutine for routinely checking which port a modem is connected to
-	go mapModemMAC_Port(updateModemInfoChan)
+	go mapModemMAC_Port(updateModemInfoChan, s.snmp, s.discovery)
 
-	// This should probably be a proper database later down the line
+	// Working copy of the discovered modems, changes are saved to the store
 	modemList := make(map[string]model.Modem)
 
-	var printModemList bool = false
-
 	for {
 
-		// Wait for updated modem info
-		modemInfoReceived := <-updateModemInfoChan
+		// Wait for updated modem info, or a request from the API to read or upgrade a modem again
+		var modemInfoReceived model.Modem
+		select {
+		case req := <-s.modemRequests:
+			req.reply <- s.applyModemRequest(modemList, req)
+			continue
+		case f := <-failures:
+			s.failModem(modemList, f)
+			continue
+		case modemInfoReceived = <-updateModemInfoChan:
+		}
+
+		// Events to publish for the changes made by the received info
+		var events []string
+		source := model.SourceDiscovery
 
 		// Add/update the info in the list of discovered modems
 		if modem, ok := modemList[modemInfoReceived.MacAddress]; ok {
 			if modemInfoReceived.IPV6 != "::" && modemInfoReceived.IPV6 != modem.IPV6 {
-				log.Printf("Updating IP address for modem with MAC %s to %s\n", modemInfoReceived.MacAddress, modemInfoReceived.IPV6)
+				discoveryLog.Info("updating modem IP address", "mac", modemInfoReceived.MacAddress, "ipv6", modemInfoReceived.IPV6)
 				modem.IPV6 = modemInfoReceived.IPV6
 				modem.State = 1
 				modemList[modemInfoReceived.MacAddress] = modem
-				printModemList = true
+				events = append(events, eventbus.ModemDiscovered)
 			}
 
 			if modemInfoReceived.SwitchPort > -1 && modemInfoReceived.SwitchPort != modem.SwitchPort {
+				discoveryLog.Info("modem switch port mapped", "mac", modemInfoReceived.MacAddress, "port", modemInfoReceived.SwitchPort)
 				modem.SwitchPort = modemInfoReceived.SwitchPort
 				modemList[modemInfoReceived.MacAddress] = modem
-				printModemList = true
+				events = append(events, eventbus.SwitchPortMapped)
 			}
 
 			if modemInfoReceived.State == 1 && modemInfoReceived.Upgraded { // This will need to be  changed
-				fmt.Println("Modem was upgraded")
+				upgradeLog.Info("modem was upgraded", "mac", modemInfoReceived.MacAddress)
 				modem.State = modemInfoReceived.State
 				modem.Upgraded = modemInfoReceived.Upgraded
 				modemList[modemInfoReceived.MacAddress] = modem
-				printModemList = true
+				events = append(events, eventbus.UpgradeFinished)
+				source = model.SourceUpgrade
 			}
 
 			// Update IMEI?
 			if modem.IMEI == "" && modemInfoReceived.IMEI != "" {
-				log.Println("Modem's IMEI was fetched")
+				sshLog.Info("modem info was read", "mac", modemInfoReceived.MacAddress, "imei", modemInfoReceived.IMEI)
 				modem.State = modemInfoReceived.State
 				modem.IMEI = modemInfoReceived.IMEI
 				modem.ICCID = modemInfoReceived.ICCID
@@ -108,10 +141,10 @@ func RunModemService() {
 				modem.Serial = modemInfoReceived.Serial
 				modem.Model = modemInfoReceived.Model
 				modemList[modemInfoReceived.MacAddress] = modem
-				printModemList = true
+				events = append(events, eventbus.ModemInfoRead)
 			}
 		} else {
-			log.Printf("Adding new modem with MAC %s and IP %s\n", modemInfoReceived.MacAddress, modemInfoReceived.IPV6)
+			discoveryLog.Info("adding new modem", "mac", modemInfoReceived.MacAddress, "ipv6", modemInfoReceived.IPV6)
 			modem := NewModemInfo(modemInfoReceived.MacAddress)
 			modem.IPV6 = modemInfoReceived.IPV6
 			modem.SwitchPort = modemInfoReceived.SwitchPort
@@ -119,7 +152,7 @@ func RunModemService() {
 				modem.State = 2 // 2: busy
 			}
 			modemList[modemInfoReceived.MacAddress] = modem
-			printModemList = true
+			events = append(events, eventbus.ModemDiscovered)
 		}
 
 		// Update last_updated
@@ -127,73 +160,137 @@ func RunModemService() {
 		modem.LastUpdated = int(time.Now().Unix())
 		modemList[modemInfoReceived.MacAddress] = modem
 
-		if printModemList {
-			for k := range modemList {
-				// fmt.Printf("key[%s] value[%v]\n", k, v)
-				fmt.Printf("%+v\n", modemList[k])
+		if len(events) > 0 {
+			discoveryLog.Debug("modem changed", "mac", modem.MacAddress, "events", events, "ipv6", modem.IPV6,
+				"port", modem.SwitchPort, "state", model.StateName(modem.State), "upgraded", modem.Upgraded)
+			s.saveDiscoveredModem(modem, source)
+			for _, eventType := range events {
+				s.bus.Publish(eventbus.Event{Type: eventType, MacAddress: modem.MacAddress, Data: modem})
 			}
-			printModemList = false
 		}
 
 		// Start different goroutines
 		for i, m := range modemList {
 			if m.State == 1 {
-				if m.IMEI == "" {
-					log.Printf("m.imei == \"%s\", therefore we need to fetch the imei\n", m.IMEI)
+				if m.IMEI == "" && !s.workflow.SkipInfoRead {
+					sshLog.Debug("modem has no IMEI yet, reading its info", "mac", m.MacAddress)
 					m.State = 2 // 2: busy
 					modemList[i] = m
-					go readModemInfo(updateModemInfoChan, modemList[i])
+					s.saveDiscoveredModem(m, model.SourceDiscovery)
+					go readModemInfo(updateModemInfoChan, failures, modemList[i], s.ssh, s.modemPasswords(m.MacAddress), s.discoveryCfg.Interface, s.discovery)
 				}
-				if !m.Upgraded {
+				if !m.Upgraded && !s.upgrade.Disabled {
 					m.State = 2
 					modemList[i] = m
-					go upgradeModem(updateModemInfoChan, modemList[i])
+					s.saveDiscoveredModem(m, model.SourceUpgrade)
+					go upgradeModem(updateModemInfoChan, failures, modemList[i], modemAddress(m, s.discoveryCfg.Interface, s.ssh.Port), s.upgrade.Timeout)
+				}
+				if s.test.Auto && m.State == 1 && m.Upgraded && (m.IMEI != "" || s.workflow.SkipInfoRead) && m.TestStatus == "" {
+					// The working copy only records that the test was started, the outcome is saved to the store
+					m.TestStatus = model.TestRunning
+					modemList[i] = m
+					go s.autoTestModem(m)
 				}
 			}
 		}
 
-		time.Sleep(time.Second)
+		time.Sleep(s.workflow.Interval)
+	}
+}
+
+// saveDiscoveredModem adds a newly discovered modem to the store, or updates the
+// fields discovery is responsible for on a known one
+func (s *Server) saveDiscoveredModem(modem model.Modem, source string) {
+	_, err := s.db.GetModem(modem.MacAddress)
+	if errors.Is(err, sqlitestore.ErrNotFound) {
+		_, err = s.db.AddModem(modem, model.Origin{Source: source, Actor: s.discoveryCfg.Interface})
+		if err != nil {
+			discoveryLog.Error("failed to add discovered modem", "mac", modem.MacAddress, "err", err)
+		}
+		return
+	}
+	if err != nil {
+		discoveryLog.Error("failed to get discovered modem", "mac", modem.MacAddress, "err", err)
+		return
+	}
+
+	_, err = s.db.ModifyModem(modem.MacAddress, model.Origin{Source: source, Actor: s.discoveryCfg.Interface}, func(m *model.Modem) {
+		m.IPV6 = modem.IPV6
+		m.SwitchPort = modem.SwitchPort
+		m.State = modem.State
+		m.Upgraded = modem.Upgraded
+		m.IMEI = modem.IMEI
+		m.ICCID = modem.ICCID
+		m.IMSI = modem.IMSI
+		m.Firmware = modem.Firmware
+		m.Serial = modem.Serial
+		m.Model = modem.Model
+		m.LastUpdated = modem.LastUpdated
+	})
+	if err != nil {
+		discoveryLog.Error("failed to update discovered modem", "mac", modem.MacAddress, "err", err)
 	}
 }
 
-func pingRoutine(iface string) {
+// failModem marks a modem whose info could not be read or that could not be
+// upgraded as failed. Discovery leaves it alone until the step is restarted from the API.
+func (s *Server) failModem(modemList map[string]model.Modem, f modemFailure) {
+	modem, ok := modemList[f.mac]
+	if !ok {
+		return
+	}
+	modem.State = model.StateError
+	modemList[f.mac] = modem
+
+	saved, err := s.db.ModifyModem(f.mac, model.Origin{Source: f.source, Actor: s.discoveryCfg.Interface}, func(m *model.Modem) {
+		m.State = model.StateError
+		m.FailCount++
+	})
+	if err != nil {
+		discoveryLog.Error("failed to save failed modem", "mac", f.mac, "err", err)
+		return
+	}
+	s.bus.Publish(eventbus.Event{Type: f.event, MacAddress: f.mac, Data: saved})
+}
+
+func pingRoutine(iface string, interval time.Duration) {
 	var str1 string = "ff02::01%" + iface
 	for {
-		time.Sleep(10 * time.Second)
-		// log.Println("Ping!")
+		time.Sleep(interval)
+		discoveryLog.Debug("pinging all nodes", "address", str1)
 		_, cmderr := exec.Command("ping", "-6", "-c 1", str1).Output()
 		if cmderr != nil {
-			log.Printf("pingRoutine error %s", cmderr)
+			discoveryLog.Warn("ping failed", "address", str1, "err", cmderr)
 		}
 	}
 }
 
-func modemDiscovery(c chan<- model.Modem) {
-	flag.Parse()
-
-	var timeout time.Duration = time.Duration(*timeoutT) * time.Second
-
+func modemDiscovery(c chan<- model.Modem, cfg config.Discovery, status *discoveryStatus) {
 	// Opening Device
-	handle, err := pcap.OpenLive(*iface, int32(*snaplen), *promisc, timeout)
-	log.Println("using iface ", *iface)
+	handle, err := pcap.OpenLive(cfg.Interface, int32(cfg.Snaplen), !cfg.NoPromisc, cfg.Timeout)
 	if err != nil {
-		log.Fatal(err)
+		fatal(discoveryLog, "failed to open capture", "iface", cfg.Interface, "err", err)
 	}
+	discoveryLog.Info("capturing", "iface", cfg.Interface)
 
 	defer handle.Close()
 
+	status.setPcapOpen(true)
+	defer status.setPcapOpen(false)
+
 	// Applying BPF Filter if it exists
-	if *filter != "" {
-		log.Println("applying filter ", *filter)
-		err := handle.SetBPFFilter(*filter)
+	if cfg.Filter != "" {
+		discoveryLog.Info("applying filter", "filter", cfg.Filter)
+		err := handle.SetBPFFilter(cfg.Filter)
 		if err != nil {
-			log.Fatalf("error applying BPF Filter %s - %v", *filter, err)
+			fatal(discoveryLog, "error applying BPF filter", "filter", cfg.Filter, "err", err)
 		}
 	}
 
 	packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
 
 	for packet := range packetSource.Packets() {
+		metrics.DiscoveryPackets.Inc()
 
 		if ethernetLayer := packet.Layer(layers.LayerTypeEthernet); ethernetLayer != nil {
 
@@ -215,171 +312,265 @@ func modemDiscovery(c chan<- model.Modem) {
 	}
 }
 
-func upgradeModem(c chan<- model.Modem, m model.Modem) {
+// upgradeModem upgrades a modem, which has failed if it does not accept
+// connections at addr again within timeout
+func upgradeModem(c chan<- model.Modem, failures chan<- modemFailure, m model.Modem, addr string, timeout time.Duration) {
+
+	log := upgradeLog.With("mac", m.MacAddress, "job", newJobID())
+	log.Info("upgrading modem")
+	metrics.UpgradesStarted.Inc()
+	start := time.Now()
 
-	log.Printf("Upgrading %s", m.MacAddress)
 	time.Sleep(time.Duration(rand.Intn(4)+3) * time.Second)
+	if err := waitForModem(addr, timeout); err != nil {
+		metrics.UpgradesFailed.Inc()
+		log.Error("upgrading modem failed", "err", err, "duration", time.Since(start))
+		failures <- modemFailure{mac: m.MacAddress, source: model.SourceUpgrade, event: eventbus.UpgradeFailed, err: err}
+		return
+	}
 	m.State = 1
 	m.Upgraded = true
-	log.Printf("Finished upgrading %s", m.MacAddress)
+	metrics.UpgradeDuration.Observe(time.Since(start).Seconds())
+	metrics.UpgradesSucceeded.Inc()
+	log.Info("finished upgrading modem", "duration", time.Since(start))
 	c <- m
 }
 
-func readModemInfo(c chan<- model.Modem, modem model.Modem) {
-	config := &ssh.ClientConfig{
-		User: "root",
-		Auth: []ssh.AuthMethod{
-			ssh.Password("admin"),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+// waitForModem waits until a modem accepts connections at addr after booting the new firmware
+func waitForModem(addr string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		conn, err := net.DialTimeout("tcp", addr, upgradeCheckInterval)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("the modem did not come back within %s: %w", timeout, err)
+		}
+		time.Sleep(upgradeCheckInterval)
 	}
+}
 
-	modemIP_String := "[" + modem.IPV6 + "%" + *iface + "]:22"
+// readModemInfo reads the identifiers of a modem over SSH, logging in with the
+// first of the passwords the modem accepts. A modem that can not be read,
+// including one that accepts none of the passwords, is reported as a failure.
+func readModemInfo(c chan<- model.Modem, failures chan<- modemFailure, modem model.Modem, cfg config.SSH, passwords []string, iface string, status *discoveryStatus) {
+	status.sshStarted(modem.MacAddress)
+
+	log := sshLog.With("mac", modem.MacAddress, "job", newJobID())
+	fail := func(err error) {
+		log.Error("failed to read modem info", "err", err)
+		status.sshFinished(modem.MacAddress, err)
+		failures <- modemFailure{mac: modem.MacAddress, source: model.SourceDiscovery, event: eventbus.ReadFailed, err: err}
+	}
 
-	client, err := ssh.Dial("tcp", modemIP_String, config)
+	ctx := context.Background()
+	addr := modemAddress(modem, iface, cfg.Port)
+	client, _, err := provision.Dial(ctx, addr, cfg.User, passwords, cfg.Timeout)
 	if err != nil {
-		log.Fatal("Failed to dial: ", err)
+		fail(fmt.Errorf("failed to dial %s: %w", addr, err))
+		return
+	}
+	defer client.Close()
+
+	log.Debug("dialed modem", "address", addr)
+
+	for _, field := range []struct {
+		value   *string
+		command string
+	}{
+		{&modem.IMEI, "gsmctl -i"},
+		{&modem.ICCID, "gsmctl -J"},
+		{&modem.IMSI, "gsmctl -x"},
+		{&modem.Firmware, "gsmctl -y"},
+		{&modem.Serial, "gsmctl -a"},
+		{&modem.Model, "gsmctl -m"},
+	} {
+		*field.value, err = readModemInfoRunCommand(ctx, log, client, field.command)
+		if err != nil {
+			fail(err)
+			return
+		}
 	}
-
-	log.Printf("Dialed modem %s", modemIP_String)
-
-	modem.IMEI = readModemInfoRunCommand(client, "gsmctl -i")
-	modem.ICCID = readModemInfoRunCommand(client, "gsmctl -J")
-	modem.IMSI = readModemInfoRunCommand(client, "gsmctl -x")
-	modem.Firmware = readModemInfoRunCommand(client, "gsmctl -y")
-	modem.Serial = readModemInfoRunCommand(client, "gsmctl -a")
-	modem.Model = readModemInfoRunCommand(client, "gsmctl -m")
 	modem.State = 1
+	status.sshFinished(modem.MacAddress, nil)
 
 	time.Sleep(time.Duration(rand.Intn(4)+3) * time.Second)
 
 	c <- modem
 }
 
-func readModemInfoRunCommand(client *ssh.Client, command string) string {
+// modemAddress returns the SSH address of a modem, its link-local address on iface
+func modemAddress(modem model.Modem, iface string, port uint16) string {
+	return "[" + modem.IPV6 + "%" + iface + "]:" + strconv.Itoa(int(port))
+}
 
-	// Each ClientConn can support multiple interactive sessions,
-	// represented by a Session.
-	session, err := client.NewSession()
+// readModemInfoRunCommand runs a gsmctl command, N/A is returned if the modem
+// reports it has no value
+func readModemInfoRunCommand(ctx context.Context, log *slog.Logger, r provision.Runner, command string) (string, error) {
+	output, err := r.Run(ctx, command)
 	if err != nil {
-		log.Fatal("Failed to create session: ", err)
-	}
-	defer session.Close()
-
-	var b bytes.Buffer
-	session.Stdout = &b
-	res := "Command error"
-	if err := session.Run(command); err != nil {
-		log.Fatal("Failed to run: " + err.Error())
+		return "", fmt.Errorf("failed to run %s: %w", command, err)
 	}
-	if strings.HasPrefix(b.String(), "Failed") || strings.HasPrefix(b.String(), "ERROR") {
-		res = "N/A"
-	} else {
-		res = strings.ReplaceAll(b.String(), "\r", "")
-		res = strings.ReplaceAll(res, "\n", "")
+	res := "N/A"
+	if !strings.HasPrefix(output, "Failed") && !strings.HasPrefix(output, "ERROR") {
+		res = strings.ReplaceAll(output, "\n", "")
 	}
-	// log.Printf("Command %s returned %s, function returning %s", command, b.String(), res)
-	return res
+	log.Debug("ran command", "command", command, "output", output, "result", res)
+	return res, nil
 }
 
-func mapModemMAC_Port(c chan<- model.Modem) {
-	// Struct for temp storage og SNMPwalk results
+// mapModemMAC_Port routinely maps the modems on the switch to the ports they
+// are connected to. A failed walk is retried at the next poll.
+func mapModemMAC_Port(c chan<- model.Modem, cfg config.SNMP, status *discoveryStatus) {
 	for {
-		type WalkInfo struct {
-			oid  string
-			mac  string
-			port string
+		ports, err := walkSwitchPorts(cfg)
+		status.snmpWalked(err)
+		if err != nil {
+			snmpLog.Error("failed to map modems to switch ports", "switch", cfg.SwitchAddress, "err", err)
+		} else {
+			for mac, port := range ports {
+				snmpLog.Debug("modem connected to switch port", "mac", mac, "port", port)
+				sendModemInfo := NewModemInfo(mac)
+				sendModemInfo.SwitchPort = port
+				c <- sendModemInfo
+			}
 		}
+		time.Sleep(cfg.PollInterval)
+	}
+}
 
-		walkList := make(map[string]WalkInfo)
-
-		// SNMP device parameters
-		ip := "192.168.2.1"
-		community := "public"
+// walkSwitchPorts returns the switch ports of the modems connected to the switch by mac address
+func walkSwitchPorts(cfg config.SNMP) (map[string]int, error) {
+	// Struct for temp storage og SNMPwalk results
+	type WalkInfo struct {
+		oid  string
+		mac  string
+		port string
+	}
 
-		// Create an SNMP Go client
-		snmpClient := &gosnmp.GoSNMP{
-			Target:    ip,
-			Port:      161,
-			Community: community,
-			Version:   gosnmp.Version2c, // Specify the SNMP version here
-			Timeout:   time.Duration(2) * time.Second,
-		}
+	walkList := make(map[string]WalkInfo)
 
-		// Establish an SNMP connection
-		err := snmpClient.Connect()
-		if err != nil {
-			log.Fatalf("SNMP Connect failed: %v", err)
-		}
-		defer snmpClient.Conn.Close()
+	// Create an SNMP Go client
+	snmpClient := newSwitchClient(cfg, cfg.Community)
 
-		// First we get the MAC addresses of connected devices
+	// Establish an SNMP connection
+	start := time.Now()
+	err := snmpClient.Connect()
+	metrics.ObserveSNMP("connect", "", start, err)
+	if err != nil {
+		return nil, fmt.Errorf("SNMP connect failed: %w", err)
+	}
+	defer snmpClient.Conn.Close()
 
-		// Build the SNMP walk OID for MAC info
-		oid_mac := ".1.2.6.1.2.1.18.4.3.3.3"
+	// First we get the MAC addresses of connected devices
 
-		// Perform the SNMP walk
-		results, err := snmpClient.WalkAll(oid_mac)
-		if err != nil {
-			log.Fatalf("SNMP Walk failed: %v", err)
-		}
+	// Build the SNMP walk OID for MAC info
+	oid_mac := ".1.2.6.1.2.1.18.4.3.3.3"
 
-		// Process the SNMP walk results
-		for _, pdu := range results {
-			macStringRaw := fmt.Sprintf("%v", pdu.Value)                                            // Convert weird type (interface{}) to string
-			macStringTrim := strings.Split(strings.Trim(strings.Trim(macStringRaw, "]"), "["), " ") // Remove brackets and split into a slice of strings
-			macByte := []byte{0, 0, 0, 0, 0, 0}                                                     // declare and initialize
-			for n, stringByte := range macStringTrim {
-				macByteInt, err := strconv.Atoi(stringByte)
-				if err != nil {
-					fmt.Println("Error during conversion")
-					return
-				}
-				macByte[n] = byte(macByteInt)
-			}
-			// Format the MAC address byte array into a string
-			macAddress := fmt.Sprintf("%02x:%02x:%02x:%02x:%02x:%02x", macByte[0], macByte[1], macByte[2], macByte[3], macByte[4], macByte[5])
+	// Perform the SNMP walk
+	start = time.Now()
+	results, err := snmpClient.WalkAll(oid_mac)
+	metrics.ObserveSNMP("walk", oid_mac, start, err)
+	if err != nil {
+		return nil, fmt.Errorf("SNMP walk of %s failed: %w", oid_mac, err)
+	}
 
-			macWalk := WalkInfo{}
-			macWalk.mac = macAddress
-			macWalk.oid = strings.TrimPrefix(pdu.Name, oid_mac)
-			walkList[strings.TrimPrefix(pdu.Name, oid_mac)] = macWalk
+	// Process the SNMP walk results
+	for _, pdu := range results {
+		macStringRaw := fmt.Sprintf("%v", pdu.Value)                                            // Convert weird type (interface{}) to string
+		macStringTrim := strings.Split(strings.Trim(strings.Trim(macStringRaw, "]"), "["), " ") // Remove brackets and split into a slice of strings
+		macByte := []byte{0, 0, 0, 0, 0, 0}                                                     // declare and initialize
+		valid := len(macStringTrim) == len(macByte)
+		for n := 0; valid && n < len(macByte); n++ {
+			macByteInt, err := strconv.Atoi(macStringTrim[n])
+			valid = err == nil
+			macByte[n] = byte(macByteInt)
+		}
+		if !valid {
+			snmpLog.Error("invalid mac address in SNMP walk", "value", macStringRaw)
+			continue
 		}
+		// Format the MAC address byte array into a string
+		macAddress := fmt.Sprintf("%02x:%02x:%02x:%02x:%02x:%02x", macByte[0], macByte[1], macByte[2], macByte[3], macByte[4], macByte[5])
 
-		// Build the SNMP walk OID for port info
-		oid_port := ".1.5.8.1.5.9.10.4.3.1.2"
+		macWalk := WalkInfo{}
+		macWalk.mac = macAddress
+		macWalk.oid = strings.TrimPrefix(pdu.Name, oid_mac)
+		walkList[strings.TrimPrefix(pdu.Name, oid_mac)] = macWalk
+	}
 
-		// Perform the SNMP walk
-		results, err = snmpClient.WalkAll(oid_port)
-		if err != nil {
-			log.Fatalf("SNMP Walk failed: %v", err)
-		}
+	// Build the SNMP walk OID for port info
+	oid_port := ".1.5.8.1.5.9.10.4.3.1.2"
 
-		// Process the SNMP walk results
-		for _, pdu := range results {
-			portWalk := walkList[strings.TrimPrefix(pdu.Name, oid_port)]
-			portWalk.port = fmt.Sprintf("%v", pdu.Value)
-			walkList[strings.TrimPrefix(pdu.Name, oid_port)] = portWalk
+	// Perform the SNMP walk
+	start = time.Now()
+	results, err = snmpClient.WalkAll(oid_port)
+	metrics.ObserveSNMP("walk", oid_port, start, err)
+	if err != nil {
+		return nil, fmt.Errorf("SNMP walk of %s failed: %w", oid_port, err)
+	}
 
-		}
+	// Process the SNMP walk results
+	for _, pdu := range results {
+		portWalk := walkList[strings.TrimPrefix(pdu.Name, oid_port)]
+		portWalk.port = fmt.Sprintf("%v", pdu.Value)
+		walkList[strings.TrimPrefix(pdu.Name, oid_port)] = portWalk
 
-		// for _, walk := range walkList {
-		// 	fmt.Printf("MAC %s is connected to port %v\n", walk.mac, walk.port)
-		// }
-
-		for _, walk := range walkList {
-			if strings.HasPrefix(walk.mac, "00:1f:43:") {
-				// fmt.Printf("Teltonika device with MAC %s is connected to port %v\n", walk.mac, walk.port)
-				sendModemInfo := NewModemInfo(walk.mac)
-				sendModemInfo.SwitchPort, err = strconv.Atoi(walk.port)
-				if err != nil {
-					fmt.Println("Error getting switch port")
-					return
-				}
-				c <- sendModemInfo
+	}
+
+	ports := make(map[string]int)
+	for _, walk := range walkList {
+		if strings.HasPrefix(walk.mac, cfg.ModemMACPrefix) {
+			port, err := strconv.Atoi(walk.port)
+			if err != nil {
+				snmpLog.Error("invalid switch port in SNMP walk", "mac", walk.mac, "value", walk.port)
+				continue
 			}
+			ports[walk.mac] = port
 		}
-		time.Sleep(10 * time.Second)
 	}
+	return ports, nil
+}
 
+// newSwitchClient returns an SNMP client for the switch the modems are connected to
+func newSwitchClient(cfg config.SNMP, community string) *gosnmp.GoSNMP {
+	return &gosnmp.GoSNMP{
+		Target:    cfg.SwitchAddress,
+		Port:      cfg.Port,
+		Community: community,
+		Version:   gosnmp.Version2c, // Specify the SNMP version here
+		Timeout:   cfg.Timeout,
+	}
+}
+
+// powerCyclePort turns the PoE power of a switch port off and on again
+func powerCyclePort(cfg config.SNMP, port int) error {
+	snmpClient := newSwitchClient(cfg, cfg.WriteCommunity)
+
+	start := time.Now()
+	err := snmpClient.Connect()
+	metrics.ObserveSNMP("connect", "", start, err)
+	if err != nil {
+		return fmt.Errorf("SNMP connect failed: %w", err)
+	}
+	defer snmpClient.Conn.Close()
+
+	oid := fmt.Sprintf("%s.%d", oidPoEAdminEnable, port)
+	set := func(value int) error {
+		start := time.Now()
+		_, err := snmpClient.Set([]gosnmp.SnmpPDU{{Name: oid, Type: gosnmp.Integer, Value: value}})
+		metrics.ObserveSNMP("set", oidPoEAdminEnable, start, err)
+		return err
+	}
+
+	if err := set(2); err != nil {
+		return fmt.Errorf("turning off port %d failed: %w", port, err)
+	}
+	time.Sleep(cfg.PowerCycleOffTime)
+	if err := set(1); err != nil {
+		return fmt.Errorf("turning on port %d failed: %w", port, err)
+	}
+	return nil
 }
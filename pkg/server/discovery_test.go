@@ -0,0 +1,154 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ebobo/modem_prod_go/pkg/config"
+	"github.com/ebobo/modem_prod_go/pkg/eventbus"
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+func TestFailModem(t *testing.T) {
+	s := newTestServer(t)
+	modem := addTestModem(t, s, "00:1f:43:00:00:01")
+	sub := s.bus.Subscribe(eventbus.Filter{Types: []string{eventbus.UpgradeFailed}}, 0, 1)
+	defer sub.Close()
+
+	modemList := map[string]model.Modem{modem.MacAddress: modem}
+	for i := 0; i < 2; i++ {
+		s.failModem(modemList, modemFailure{mac: modem.MacAddress, source: model.SourceUpgrade, event: eventbus.UpgradeFailed, err: errors.New("gone")})
+	}
+
+	if modemList[modem.MacAddress].State != model.StateError {
+		t.Errorf("working copy state = %d, want %d", modemList[modem.MacAddress].State, model.StateError)
+	}
+	saved, err := s.db.GetModem(modem.MacAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.State != model.StateError || saved.FailCount != 2 {
+		t.Errorf("saved state = %d, fail count = %d, want %d and 2", saved.State, saved.FailCount, model.StateError)
+	}
+	select {
+	case e := <-sub.C:
+		if e.MacAddress != modem.MacAddress {
+			t.Errorf("event of %s, want %s", e.MacAddress, modem.MacAddress)
+		}
+	case <-time.After(time.Second):
+		t.Error("no upgrade.failed event was published")
+	}
+
+	events, err := s.db.ListModemEvents(modem.MacAddress, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range events {
+		if e.Field == "fail_count" && e.Source != model.SourceUpgrade {
+			t.Errorf("fail_count change has source %q, want %q", e.Source, model.SourceUpgrade)
+		}
+	}
+}
+
+func TestFailModemUnknown(t *testing.T) {
+	s := newTestServer(t)
+	modemList := map[string]model.Modem{}
+
+	s.failModem(modemList, modemFailure{mac: "00:1f:43:00:00:02", source: model.SourceDiscovery, event: eventbus.ReadFailed})
+	if len(modemList) != 0 {
+		t.Errorf("a modem discovery does not know was added: %v", modemList)
+	}
+}
+
+func TestWaitForModem(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+
+	if err := waitForModem(addr, 0); err != nil {
+		t.Errorf("modem listening: %v", err)
+	}
+
+	l.Close()
+	if err := waitForModem(addr, 0); err == nil {
+		t.Error("modem not listening: no error")
+	}
+}
+
+// gsmctlOutput is what a modem answers to the gsmctl commands reading its info
+var gsmctlOutput = map[string]string{
+	"gsmctl -i": "356789012345678\r\n",
+	"gsmctl -J": "89470000000000000001\n",
+	"gsmctl -x": "242010000000001\n",
+	"gsmctl -y": "TRB1_R_00.07.05\n",
+	"gsmctl -a": "ERROR\n",
+	"gsmctl -m": "TRB140\n",
+}
+
+func TestReadModemInfo(t *testing.T) {
+	port := startTestSSH(t, "escrowed", func(command string) (string, int) {
+		output, ok := gsmctlOutput[command]
+		if !ok {
+			return "", 127
+		}
+		return output, 0
+	})
+	cfg := config.Default().SSH
+	cfg.Port = port
+	modem := NewModemInfo("00:1f:43:00:00:01")
+	modem.IPV6 = "::1"
+
+	c := make(chan model.Modem, 1)
+	failures := make(chan modemFailure, 1)
+	readModemInfo(c, failures, modem, cfg, []string{"old", "escrowed", "admin"}, "lo", newDiscoveryStatus(config.Default().SNMP))
+
+	select {
+	case f := <-failures:
+		t.Fatalf("reading failed: %v", f.err)
+	case read := <-c:
+		want := modem
+		want.IMEI, want.ICCID, want.IMSI = "356789012345678", "89470000000000000001", "242010000000001"
+		want.Firmware, want.Serial, want.Model = "TRB1_R_00.07.05", "N/A", "TRB140"
+		want.State = model.StateNormal
+		if read != want {
+			t.Errorf("read %+v, want %+v", read, want)
+		}
+	}
+}
+
+func TestReadModemInfoFails(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		passwords []string
+		output    string
+		status    int
+	}{
+		{"no password accepted", []string{"old", "older"}, "", 0},
+		{"command fails", []string{"admin"}, "gsmctl: not found", 127},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			port := startTestSSH(t, "admin", func(string) (string, int) { return tt.output, tt.status })
+			cfg := config.Default().SSH
+			cfg.Port = port
+			modem := NewModemInfo("00:1f:43:00:00:01")
+			modem.IPV6 = "::1"
+
+			c := make(chan model.Modem, 1)
+			failures := make(chan modemFailure, 1)
+			readModemInfo(c, failures, modem, cfg, tt.passwords, "lo", newDiscoveryStatus(config.Default().SNMP))
+
+			select {
+			case f := <-failures:
+				if f.mac != modem.MacAddress || f.source != model.SourceDiscovery || f.event != eventbus.ReadFailed || f.err == nil {
+					t.Errorf("failure %+v", f)
+				}
+			case read := <-c:
+				t.Errorf("read %+v, want a failure", read)
+			}
+		})
+	}
+}
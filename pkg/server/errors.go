@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+	sqlitestore "github.com/ebobo/modem_prod_go/pkg/store/sqlite"
+)
+
+// errorResponse is the JSON body of every error returned by the REST API
+type errorResponse struct {
+	Error errorBody `json:"error"`
+}
+
+type errorBody struct {
+	Code    string             `json:"code"`
+	Message string             `json:"message"`
+	Details []model.FieldError `json:"details,omitempty"`
+}
+
+// writeError writes a JSON error. The code is derived from the HTTP status,
+// e.g. "not_found" for 404.
+func writeError(w http.ResponseWriter, status int, message string, details ...model.FieldError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{
+		Error: errorBody{
+			Code:    strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_")),
+			Message: message,
+			Details: details,
+		},
+	})
+}
+
+// writeStoreError maps errors returned by the store, and validation errors, to
+// HTTP errors. message describes the failed operation.
+func writeStoreError(w http.ResponseWriter, message string, err error) {
+	var validationErr *model.ValidationError
+
+	switch {
+	case errors.As(err, &validationErr):
+		writeError(w, http.StatusUnprocessableEntity, message+": validation failed", validationErr.Fields...)
+	case errors.Is(err, sqlitestore.ErrNotFound), errors.Is(err, sqlitestore.ErrNoRowsAffected):
+		writeError(w, http.StatusNotFound, message+": "+err.Error())
+	case errors.Is(err, sqlitestore.ErrUniqueConstraintViolation),
+		errors.Is(err, sqlitestore.ErrOrderClosed),
+		errors.Is(err, sqlitestore.ErrOrderFull),
+		errors.Is(err, sqlitestore.ErrProfileInUse),
+		errors.Is(err, sqlitestore.ErrProvisioning),
+		errors.Is(err, sqlitestore.ErrTesting),
+		errors.Is(err, sqlitestore.ErrSIMJobQueued):
+		writeError(w, http.StatusConflict, message+": "+err.Error())
+	case errors.Is(err, sqlitestore.ErrVersionConflict):
+		writeError(w, http.StatusPreconditionFailed, message+": modem was changed, fetch it again and retry")
+	case errors.Is(err, sqlitestore.ErrInvalidQuery):
+		writeError(w, http.StatusBadRequest, message+": "+err.Error())
+	default:
+		httpLog.Error(message, "err", err)
+		writeError(w, http.StatusInternalServerError, message)
+	}
+}
+
+// readJSON decodes the request body into v, on failure it writes a 400 error and returns false
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		httpLog.WarnContext(r.Context(), "failed to read request body", "err", err)
+		writeError(w, http.StatusBadRequest, "failed to read request body")
+		return false
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		httpLog.DebugContext(r.Context(), "failed to unmarshal request body", "err", err)
+		writeError(w, http.StatusBadRequest, "failed to unmarshal request body: "+err.Error())
+		return false
+	}
+	return true
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+	sqlitestore "github.com/ebobo/modem_prod_go/pkg/store/sqlite"
+)
+
+func TestWriteStoreError(t *testing.T) {
+	validation := &model.ValidationError{}
+	validation.Add("state", "must be 0 to 3")
+	validation.Add("imei", "must be 15 digits")
+
+	for _, tt := range []struct {
+		name    string
+		err     error
+		status  int
+		code    string
+		message string
+		details []model.FieldError
+	}{
+		{"validation", validation, http.StatusUnprocessableEntity, "unprocessable_entity", "failed to save: validation failed", validation.Fields},
+		{"wrapped validation", fmt.Errorf("row 3: %w", validation), http.StatusUnprocessableEntity, "unprocessable_entity", "failed to save: validation failed", validation.Fields},
+		{"not found", sqlitestore.ErrNotFound, http.StatusNotFound, "not_found", "failed to save: not found", nil},
+		{"no rows affected", sqlitestore.ErrNoRowsAffected, http.StatusNotFound, "not_found", "failed to save: no rows affected by operation", nil},
+		{"wrapped not found", fmt.Errorf("modem 00:1f:43:00:00:09: %w", sqlitestore.ErrNotFound), http.StatusNotFound, "not_found", "failed to save: modem 00:1f:43:00:00:09: not found", nil},
+		{"unique", sqlitestore.ErrUniqueConstraintViolation, http.StatusConflict, "conflict", "", nil},
+		{"order closed", sqlitestore.ErrOrderClosed, http.StatusConflict, "conflict", "failed to save: order is closed", nil},
+		{"order full", sqlitestore.ErrOrderFull, http.StatusConflict, "conflict", "failed to save: order quantity reached", nil},
+		{"profile in use", sqlitestore.ErrProfileInUse, http.StatusConflict, "conflict", "", nil},
+		{"provisioning", sqlitestore.ErrProvisioning, http.StatusConflict, "conflict", "", nil},
+		{"testing", sqlitestore.ErrTesting, http.StatusConflict, "conflict", "", nil},
+		{"sim job queued", sqlitestore.ErrSIMJobQueued, http.StatusConflict, "conflict", "", nil},
+		{"version conflict", sqlitestore.ErrVersionConflict, http.StatusPreconditionFailed, "precondition_failed", "failed to save: modem was changed, fetch it again and retry", nil},
+		{"invalid query", fmt.Errorf("unknown sort column %q: %w", "x", sqlitestore.ErrInvalidQuery), http.StatusBadRequest, "bad_request", `failed to save: unknown sort column "x": invalid query`, nil},
+		{"other", errors.New("disk I/O error"), http.StatusInternalServerError, "internal_server_error", "failed to save", nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			writeStoreError(w, "failed to save", tt.err)
+
+			if w.Code != tt.status {
+				t.Errorf("status %d, want %d", w.Code, tt.status)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("content type %q", ct)
+			}
+			var body errorResponse
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Error.Code != tt.code {
+				t.Errorf("code %q, want %q", body.Error.Code, tt.code)
+			}
+			want := tt.message
+			if want == "" {
+				want = "failed to save: " + tt.err.Error()
+			}
+			if body.Error.Message != want {
+				t.Errorf("message %q, want %q", body.Error.Message, want)
+			}
+			if !reflect.DeepEqual(body.Error.Details, tt.details) {
+				t.Errorf("details %+v, want %+v", body.Error.Details, tt.details)
+			}
+			// internal errors are logged, not returned
+			if tt.status == http.StatusInternalServerError && strings.Contains(body.Error.Message, tt.err.Error()) {
+				t.Errorf("message %q leaks the error", body.Error.Message)
+			}
+		})
+	}
+}
+
+func TestReadJSON(t *testing.T) {
+	for _, tt := range []struct {
+		body string
+		ok   bool
+	}{
+		{`{"state":1}`, true},
+		{`{"state":"busy"}`, false},
+		{`{`, false},
+	} {
+		r := httptest.NewRequest("PUT", "/", strings.NewReader(tt.body))
+		w := httptest.NewRecorder()
+		var v struct {
+			State int `json:"state"`
+		}
+		if ok := readJSON(w, r, &v); ok != tt.ok {
+			t.Errorf("%s: ok %v", tt.body, ok)
+		}
+		if !tt.ok && (w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"code":"bad_request"`)) {
+			t.Errorf("%s: status %d, body %s", tt.body, w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/ebobo/modem_prod_go/pkg/eventbus"
+)
+
+const (
+	// eventBufferSize is how many events a client may fall behind before it is disconnected
+	eventBufferSize = 256
+
+	// eventHistorySize is how many events are kept for clients resuming with Last-Event-ID
+	eventHistorySize = 1000
+
+	// eventKeepAlive is the interval of keep-alive messages on idle streams
+	eventKeepAlive = 15 * time.Second
+
+	// eventWriteTimeout bounds how long a single write to a client may take
+	eventWriteTimeout = 10 * time.Second
+)
+
+// checkOrigin applies the CORS origins of the rest of the API to websockets. Requests
+// without an Origin header do not come from a browser and are allowed.
+func (s *Server) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || len(s.corsOrigins) == 0 {
+		return true
+	}
+	for _, allowed := range s.corsOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetEvents streams events as Server-Sent Events. Events can be filtered with
+// ?mac= and ?type= (comma separated or repeated), and a client resumes after the
+// last event it received with the Last-Event-ID header or ?last_event_id=.
+func (s *Server) GetEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	filter, lastID, err := parseEventFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	sub := s.bus.Subscribe(filter, lastID, eventBufferSize)
+	defer sub.Close()
+
+	// The server write timeout does not apply to streams, only individual writes are bounded
+	rc := http.NewResponseController(w)
+	write := func(format string, args ...interface{}) error {
+		rc.SetWriteDeadline(time.Now().Add(eventWriteTimeout))
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	if err := write(": connected\n\n"); err != nil {
+		httpLog.WarnContext(r.Context(), "event stream failed", "err", err)
+		return
+	}
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-s.ctx.Done():
+			return
+
+		case <-keepAlive.C:
+			if err := write(": keep-alive\n\n"); err != nil {
+				return
+			}
+
+		case e, ok := <-sub.C:
+			if !ok {
+				if sub.Lagged() {
+					httpLog.WarnContext(r.Context(), "event stream fell behind and was closed")
+					write("event: lagged\ndata: {}\n\n")
+				}
+				return
+			}
+
+			data, err := json.Marshal(e)
+			if err != nil {
+				httpLog.ErrorContext(r.Context(), "failed to marshal event", "event_id", e.ID, "err", err)
+				continue
+			}
+			if err := write("id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// GetEventsWebSocket streams the same events as GetEvents over a WebSocket, one JSON
+// event per text message.
+func (s *Server) GetEventsWebSocket(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	filter, lastID, err := parseEventFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     s.checkOrigin,
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		httpLog.WarnContext(r.Context(), "websocket upgrade failed", "err", err)
+		return
+	}
+	defer conn.Close()
+
+	sub := s.bus.Subscribe(filter, lastID, eventBufferSize)
+	defer sub.Close()
+
+	// Read in the background to process pings and notice when the client goes away
+	gone := make(chan struct{})
+	conn.SetReadDeadline(time.Now().Add(2 * eventKeepAlive))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * eventKeepAlive))
+	})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-gone:
+			return
+
+		case <-s.ctx.Done():
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"),
+				time.Now().Add(eventWriteTimeout))
+			return
+
+		case <-keepAlive.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(eventWriteTimeout)); err != nil {
+				return
+			}
+
+		case e, ok := <-sub.C:
+			if !ok {
+				if sub.Lagged() {
+					httpLog.WarnContext(r.Context(), "event websocket fell behind and was closed")
+					conn.WriteControl(websocket.CloseMessage,
+						websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "client fell behind"),
+						time.Now().Add(eventWriteTimeout))
+				}
+				return
+			}
+
+			conn.SetWriteDeadline(time.Now().Add(eventWriteTimeout))
+			if err := conn.WriteJSON(e); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func parseEventFilter(r *http.Request) (eventbus.Filter, uint64, error) {
+	values := r.URL.Query()
+	filter := eventbus.Filter{
+		MacAddresses: splitList(values["mac"]),
+		Types:        splitList(values["type"]),
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = values.Get("last_event_id")
+	}
+	if lastEventID == "" {
+		return filter, 0, nil
+	}
+
+	lastID, err := strconv.ParseUint(lastEventID, 10, 64)
+	if err != nil {
+		return filter, 0, fmt.Errorf("invalid last event id %q", lastEventID)
+	}
+	return filter, lastID, nil
+}
+
+// splitList flattens repeated and comma separated query values
+func splitList(values []string) []string {
+	var list []string
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
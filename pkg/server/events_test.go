@@ -0,0 +1,219 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/ebobo/modem_prod_go/pkg/eventbus"
+)
+
+// newEventServer serves handler of a test server whose context ends with the test
+func newEventServer(t *testing.T, handler func(*Server) http.HandlerFunc) (*Server, *httptest.Server) {
+	t.Helper()
+
+	s := newTestServer(t)
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	ts := httptest.NewServer(handler(s))
+	t.Cleanup(func() {
+		s.cancel()
+		ts.Close()
+	})
+	return s, ts
+}
+
+// readSSE reads the next message of an event stream, without the blank line ending it
+func readSSE(t *testing.T, r *bufio.Reader) []string {
+	t.Helper()
+
+	var lines []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("after %q: %v", lines, err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return lines
+		}
+		lines = append(lines, line)
+	}
+}
+
+// openSSE requests the event stream and waits until it is subscribed
+func openSSE(t *testing.T, ts *httptest.Server, query string, lastEventID string) *bufio.Reader {
+	t.Helper()
+
+	req, err := http.NewRequest("GET", ts.URL+"/api/v1/events"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("content type %q", ct)
+	}
+	r := bufio.NewReader(resp.Body)
+	if got := readSSE(t, r); len(got) != 1 || got[0] != ": connected" {
+		t.Fatalf("got %q, want the connected comment", got)
+	}
+	return r
+}
+
+func TestGetEvents(t *testing.T) {
+	s, ts := newEventServer(t, func(s *Server) http.HandlerFunc { return s.GetEvents })
+
+	r := openSSE(t, ts, "?mac=00:1f:43:00:00:01&type=modem.*,upgrade.finished", "")
+	s.bus.Publish(eventbus.Event{Type: eventbus.ModemAdded, MacAddress: "00:1f:43:00:00:02"})
+	s.bus.Publish(eventbus.Event{Type: eventbus.OrderChanged})
+	s.bus.Publish(eventbus.Event{Type: eventbus.UpgradeFailed, MacAddress: "00:1f:43:00:00:01"})
+	s.bus.Publish(eventbus.Event{Type: eventbus.ModemUpdated, MacAddress: "00:1f:43:00:00:01", Data: map[string]int{"state": 1}})
+
+	got := readSSE(t, r)
+	if len(got) != 3 || got[0] != "id: 4" || got[1] != "event: modem.updated" || !strings.HasPrefix(got[2], "data: ") {
+		t.Fatalf("got %q", got)
+	}
+	var e eventbus.Event
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(got[2], "data: ")), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.ID != 4 || e.Type != eventbus.ModemUpdated || e.MacAddress != "00:1f:43:00:00:01" || e.Timestamp == 0 {
+		t.Errorf("event %+v", e)
+	}
+	if data, _ := json.Marshal(e.Data); string(data) != `{"state":1}` {
+		t.Errorf("data %s", data)
+	}
+}
+
+func TestGetEventsResume(t *testing.T) {
+	s, ts := newEventServer(t, func(s *Server) http.HandlerFunc { return s.GetEvents })
+	for _, typ := range []string{eventbus.ModemAdded, eventbus.OrderChanged, eventbus.ModemUpdated, eventbus.OrderChanged} {
+		s.bus.Publish(eventbus.Event{Type: typ})
+	}
+
+	ids := func(r *bufio.Reader, n int) []string {
+		var got []string
+		for len(got) < n {
+			got = append(got, readSSE(t, r)[0])
+		}
+		return got
+	}
+
+	// the header takes precedence over the query parameter
+	r := openSSE(t, ts, "?last_event_id=3&type=order.changed", "1")
+	if got := strings.Join(ids(r, 2), ","); got != "id: 2,id: 4" {
+		t.Errorf("resumed with the header: %s", got)
+	}
+	r = openSSE(t, ts, "?last_event_id=2", "")
+	if got := strings.Join(ids(r, 2), ","); got != "id: 3,id: 4" {
+		t.Errorf("resumed with the query: %s", got)
+	}
+
+	// new events follow the history
+	s.bus.Publish(eventbus.Event{Type: eventbus.ModemDeleted})
+	if got := ids(r, 1)[0]; got != "id: 5" {
+		t.Errorf("then %s", got)
+	}
+}
+
+func TestGetEventsInvalid(t *testing.T) {
+	s := newTestServer(t)
+
+	r := httptest.NewRequest("GET", "/api/v1/events", nil)
+	r.Header.Set("Last-Event-ID", "abc")
+	w := httptest.NewRecorder()
+	s.GetEvents(w, r)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `invalid last event id \"abc\"`) {
+		t.Errorf("status %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestGetEventsWebSocket(t *testing.T) {
+	s, ts := newEventServer(t, func(s *Server) http.HandlerFunc { return s.GetEventsWebSocket })
+	s.bus.Publish(eventbus.Event{Type: eventbus.ModemAdded, MacAddress: "00:1f:43:00:00:01"})
+	s.bus.Publish(eventbus.Event{Type: eventbus.OrderChanged})
+	s.bus.Publish(eventbus.Event{Type: eventbus.ModemUpdated, MacAddress: "00:1f:43:00:00:01"})
+
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/api/v1/events/ws?type=modem.*"
+	conn, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Last-Event-ID": {"1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var e eventbus.Event
+	if err := conn.ReadJSON(&e); err != nil {
+		t.Fatal(err)
+	}
+	if e.ID != 3 || e.Type != eventbus.ModemUpdated || e.MacAddress != "00:1f:43:00:00:01" {
+		t.Errorf("event %+v", e)
+	}
+
+	// the server closes the socket when it shuts down
+	s.cancel()
+	for {
+		if _, _, err = conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("closed with %v", err)
+	}
+}
+
+func TestGetEventsWebSocketOrigin(t *testing.T) {
+	s, ts := newEventServer(t, func(s *Server) http.HandlerFunc { return s.GetEventsWebSocket })
+	s.corsOrigins = []string{"https://bench.example.com"}
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/api/v1/events/ws"
+
+	for _, tt := range []struct {
+		origin string
+		ok     bool
+	}{
+		{"", true},
+		{"https://bench.example.com", true},
+		{"https://BENCH.example.com", true},
+		{"https://evil.example.com", false},
+	} {
+		header := http.Header{}
+		if tt.origin != "" {
+			header.Set("Origin", tt.origin)
+		}
+		conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+		if tt.ok {
+			if err != nil {
+				t.Errorf("%q: %v", tt.origin, err)
+				continue
+			}
+			conn.Close()
+			continue
+		}
+		if err == nil {
+			conn.Close()
+			t.Errorf("%q: connected", tt.origin)
+		} else if resp == nil || resp.StatusCode != http.StatusForbidden {
+			t.Errorf("%q: %v", tt.origin, err)
+		}
+	}
+}
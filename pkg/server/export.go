@@ -0,0 +1,152 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ebobo/modem_prod_go/pkg/export"
+	"github.com/ebobo/modem_prod_go/pkg/model"
+	sqlitestore "github.com/ebobo/modem_prod_go/pkg/store/sqlite"
+)
+
+const (
+	// exportPageSize is how many modems are read from the store at a time while exporting
+	exportPageSize = 500
+
+	// exportPageTimeout is the time allowed to write one page of an export, the
+	// write deadline of the server is extended by it for every page
+	exportPageTimeout = 30 * time.Second
+)
+
+// ExportModems streams the modems matching the list filters as a CSV, Excel or
+// JSON file. ?columns= selects and orders the columns, ?limit= caps the number of modems.
+// ?passwords=true adds the admin user and password of each modem for a
+// customer handover, only admins may ask for it.
+func (s *Server) ExportModems(w http.ResponseWriter, r *http.Request) {
+	query, err := parseModemQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if query.Cursor != "" {
+		writeError(w, http.StatusBadRequest, "cursor is not supported by exports")
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if !slices.Contains(export.Formats, format) {
+		writeError(w, http.StatusBadRequest, "format must be one of "+strings.Join(export.Formats, ", "))
+		return
+	}
+	var names []string
+	if columns := r.URL.Query().Get("columns"); columns != "" {
+		names = strings.Split(columns, ",")
+	}
+	cols, err := export.Columns(names)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	passwords, _ := strconv.ParseBool(r.URL.Query().Get("passwords"))
+	if passwords {
+		if id, _ := requestIdentity(r); !model.RoleAllows(id.Role, model.RoleAdmin) {
+			httpLog.WarnContext(r.Context(), "denied export with passwords", "role", id.Role)
+			writeError(w, http.StatusForbidden, "only the admin role may export passwords")
+			return
+		}
+		if s.escrow == nil {
+			writeError(w, http.StatusServiceUnavailable, "failed to export passwords: "+errNoEscrowKey.Error())
+			return
+		}
+		cols = append(cols, s.passwordColumns(r.Context())...)
+	}
+
+	// Read the first page before writing anything so errors still get a proper status
+	remaining := query.Limit
+	query.Limit = exportPageSize
+	if remaining > 0 && remaining < exportPageSize {
+		query.Limit = remaining
+	}
+	page, err := s.db.ListModems(query)
+	if err != nil {
+		writeStoreError(w, "failed to export modems", err)
+		return
+	}
+
+	filename := fmt.Sprintf("modems-%s.%s", time.Now().Format("20060102-150405"), format)
+	w.Header().Set("Content-Type", export.ContentType(format))
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+
+	ew, err := export.NewWriter(w, format, cols)
+	if err != nil {
+		httpLog.WarnContext(r.Context(), "export aborted", "format", format, "err", err)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	count := 0
+	for {
+		rc.SetWriteDeadline(time.Now().Add(exportPageTimeout))
+
+		for _, modem := range page.Modems {
+			if err := ew.Write(modem); err != nil {
+				httpLog.WarnContext(r.Context(), "export aborted", "format", format, "written", count, "err", err)
+				return
+			}
+			count++
+		}
+		if remaining > 0 && count >= remaining {
+			break
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		rc.Flush()
+
+		query.Cursor = page.NextCursor
+		if remaining > 0 && remaining-count < exportPageSize {
+			query.Limit = remaining - count
+		}
+		page, err = s.db.ListModems(query)
+		if err != nil {
+			// The status is sent already, a truncated file is all that can be done
+			httpLog.ErrorContext(r.Context(), "export failed", "format", format, "written", count, "err", err)
+			return
+		}
+	}
+
+	if err := ew.Close(); err != nil {
+		httpLog.WarnContext(r.Context(), "export aborted", "format", format, "written", count, "err", err)
+		return
+	}
+	httpLog.InfoContext(r.Context(), "exported modems", "format", format, "count", count, "passwords", passwords)
+}
+
+// passwordColumns are the admin user and password of the modems, empty for
+// modems without an escrowed password
+func (s *Server) passwordColumns(ctx context.Context) []export.Column {
+	// Both columns need the password, the user column is written first and reads it
+	var last model.ModemPassword
+	user := export.NewColumn("admin_user", func(m model.Modem) interface{} {
+		p, err := s.revealPassword(m.MacAddress)
+		if err != nil && !errors.Is(err, sqlitestore.ErrNotFound) {
+			httpLog.ErrorContext(ctx, "failed to get password of exported modem", "mac", m.MacAddress, "err", err)
+		}
+		last = p
+		return p.User
+	})
+	password := export.NewColumn("admin_password", func(m model.Modem) interface{} {
+		return last.Password
+	})
+	return []export.Column{user, password}
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/ebobo/modem_prod_go/pkg/config"
+	"github.com/ebobo/modem_prod_go/pkg/version"
+)
+
+const (
+	// healthCheckTimeout bounds how long the database ping of a readiness check may take
+	healthCheckTimeout = 2 * time.Second
+
+	// maxSNMPWalksMissed is how many switch walks in a row may fail before the
+	// snmp check fails
+	maxSNMPWalksMissed = 3
+
+	// maxSSHWorkerAge is how long an SSH worker may run before it is considered stuck
+	maxSSHWorkerAge = 2 * time.Minute
+
+	// maxSSHFailures is how many modems in a row may fail to be read. One modem with
+	// a password nobody knows is not a reason to fail, every modem failing is.
+	maxSSHFailures = 3
+)
+
+const (
+	checkOK          = "ok"
+	checkFailed      = "failed"
+	checkDisabled    = "disabled"
+	checkUnavailable = "unavailable"
+)
+
+// discoveryStatus tracks the state of the discovery subsystems for the readiness check
+type discoveryStatus struct {
+	mu             sync.Mutex
+	running        bool
+	pcapOpen       bool
+	lastSNMPWalk   time.Time
+	maxSNMPWalkAge time.Duration        // how old the last successful walk may be
+	snmpErr        error                // of the last walk, nil if it succeeded
+	sshWorkers     map[string]time.Time // start time of the running SSH workers by mac address
+	sshFailures    int                  // modems that failed to be read since the last one that was read
+	sshErr         error                // of the last modem that failed to be read
+}
+
+// newDiscoveryStatus returns the status of discovery walking the switch as configured
+// by snmp. A walk runs every poll interval and a failing one takes up to the timeout.
+func newDiscoveryStatus(snmp config.SNMP) *discoveryStatus {
+	return &discoveryStatus{
+		maxSNMPWalkAge: maxSNMPWalksMissed * (snmp.PollInterval + snmp.Timeout),
+		sshWorkers:     make(map[string]time.Time),
+	}
+}
+
+func (d *discoveryStatus) setRunning(running bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.running = running
+}
+
+func (d *discoveryStatus) isRunning() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.running
+}
+
+func (d *discoveryStatus) setPcapOpen(open bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pcapOpen = open
+}
+
+// snmpWalked records the outcome of a switch walk
+func (d *discoveryStatus) snmpWalked(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.snmpErr = err
+	if err == nil {
+		d.lastSNMPWalk = time.Now()
+	}
+}
+
+func (d *discoveryStatus) sshStarted(mac string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sshWorkers[mac] = time.Now()
+}
+
+// sshFinished records the outcome of reading a modem, err is nil if it was read
+func (d *discoveryStatus) sshFinished(mac string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.sshWorkers, mac)
+	if err != nil {
+		d.sshFailures++
+		d.sshErr = err
+	} else {
+		d.sshFailures = 0
+	}
+}
+
+// checkResult is the outcome of one readiness check
+type checkResult struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// readinessReport is the body of /readyz
+type readinessReport struct {
+	Status    string                 `json:"status"`
+	Timestamp int64                  `json:"timestamp"`
+	Checks    map[string]checkResult `json:"checks"`
+}
+
+// checks reports on the discovery subsystems. They are disabled when discovery is not running.
+func (d *discoveryStatus) checks(now time.Time) map[string]checkResult {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.running {
+		disabled := checkResult{Status: checkDisabled, Message: "discovery is not running"}
+		return map[string]checkResult{"pcap": disabled, "snmp": disabled, "ssh": disabled}
+	}
+
+	checks := map[string]checkResult{}
+
+	if d.pcapOpen {
+		checks["pcap"] = checkResult{Status: checkOK}
+	} else {
+		checks["pcap"] = checkResult{Status: checkFailed, Message: "capture handle is not open"}
+	}
+
+	switch {
+	case d.lastSNMPWalk.IsZero():
+		message := "no successful switch walk yet"
+		if d.snmpErr != nil {
+			message += ": " + d.snmpErr.Error()
+		}
+		checks["snmp"] = checkResult{Status: checkFailed, Message: message}
+	case now.Sub(d.lastSNMPWalk) > d.maxSNMPWalkAge:
+		message := fmt.Sprintf("last successful switch walk was %s ago", now.Sub(d.lastSNMPWalk).Round(time.Second))
+		if d.snmpErr != nil {
+			message += ": " + d.snmpErr.Error()
+		}
+		checks["snmp"] = checkResult{Status: checkFailed, Message: message}
+	default:
+		checks["snmp"] = checkResult{Status: checkOK,
+			Message: fmt.Sprintf("last successful switch walk %s ago", now.Sub(d.lastSNMPWalk).Round(time.Second))}
+	}
+
+	stuck := 0
+	for _, started := range d.sshWorkers {
+		if now.Sub(started) > maxSSHWorkerAge {
+			stuck++
+		}
+	}
+	switch {
+	case stuck > 0:
+		checks["ssh"] = checkResult{Status: checkFailed,
+			Message: fmt.Sprintf("%d of %d workers running longer than %s", stuck, len(d.sshWorkers), maxSSHWorkerAge)}
+	case d.sshFailures >= maxSSHFailures:
+		checks["ssh"] = checkResult{Status: checkFailed,
+			Message: fmt.Sprintf("last %d modems failed to be read: %v", d.sshFailures, d.sshErr)}
+	default:
+		checks["ssh"] = checkResult{Status: checkOK,
+			Message: fmt.Sprintf("%d workers running", len(d.sshWorkers))}
+	}
+
+	return checks
+}
+
+// GetHealth reports that the process is alive
+func (s *Server) GetHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(checkResult{Status: checkOK})
+}
+
+// GetReadiness checks the database and the discovery subsystems. It responds with
+// 503 Service Unavailable if any of them failed.
+func (s *Server) GetReadiness(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	report := readinessReport{
+		Status:    checkOK,
+		Timestamp: now.Unix(),
+		Checks:    s.discovery.checks(now),
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+	if err := s.db.Ping(ctx); err != nil {
+		report.Checks["database"] = checkResult{Status: checkFailed, Message: err.Error()}
+	} else {
+		report.Checks["database"] = checkResult{Status: checkOK}
+	}
+
+	status := http.StatusOK
+	for _, check := range report.Checks {
+		if check.Status == checkFailed {
+			report.Status = checkUnavailable
+			status = http.StatusServiceUnavailable
+			break
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
+
+// GetVersion returns the version and build information of the server
+func (s *Server) GetVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"version":    version.Version(),
+		"commit":     version.Commit,
+		"build_time": version.BuildTime,
+		"go_version": runtime.Version(),
+	})
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ebobo/modem_prod_go/pkg/config"
+)
+
+func TestDiscoveryChecks(t *testing.T) {
+	walkErr := errors.New("request timeout")
+	readErr := errors.New("no password accepted")
+	now := time.Now()
+
+	for _, tt := range []struct {
+		name    string
+		status  func(d *discoveryStatus)
+		check   string
+		want    string
+		message string
+	}{
+		{"not running", func(d *discoveryStatus) { d.running = false }, "ssh", checkDisabled, ""},
+		{"pcap closed", func(d *discoveryStatus) { d.pcapOpen = false }, "pcap", checkFailed, "not open"},
+		{"snmp walked", func(d *discoveryStatus) {}, "snmp", checkOK, ""},
+		{"snmp never walked", func(d *discoveryStatus) { d.lastSNMPWalk = time.Time{}; d.snmpWalked(walkErr) }, "snmp", checkFailed, "request timeout"},
+		{"snmp walk failing", func(d *discoveryStatus) {
+			d.lastSNMPWalk = now.Add(-40 * time.Second)
+			d.snmpWalked(walkErr)
+		}, "snmp", checkFailed, "request timeout"},
+		{"snmp walks failing less than the limit", func(d *discoveryStatus) {
+			d.lastSNMPWalk = now.Add(-30 * time.Second)
+			d.snmpWalked(walkErr)
+		}, "snmp", checkOK, ""},
+		{"snmp walk failed once", func(d *discoveryStatus) { d.snmpWalked(nil); d.snmpWalked(walkErr) }, "snmp", checkOK, ""},
+		{"ssh worker stuck", func(d *discoveryStatus) { d.sshWorkers["a"] = now.Add(-2 * maxSSHWorkerAge) }, "ssh", checkFailed, "longer than"},
+		{"ssh one modem failing", func(d *discoveryStatus) {
+			for i := 1; i < maxSSHFailures; i++ {
+				d.sshStarted("a")
+				d.sshFinished("a", readErr)
+			}
+		}, "ssh", checkOK, ""},
+		{"ssh every modem failing", func(d *discoveryStatus) {
+			for i := 0; i < maxSSHFailures; i++ {
+				d.sshStarted("a")
+				d.sshFinished("a", readErr)
+			}
+		}, "ssh", checkFailed, "no password accepted"},
+		{"ssh modem read after failures", func(d *discoveryStatus) {
+			for i := 0; i < maxSSHFailures; i++ {
+				d.sshFinished("a", readErr)
+			}
+			d.sshFinished("b", nil)
+		}, "ssh", checkOK, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// walks every 10 seconds, failing after 2, may be 36 seconds old
+			d := newDiscoveryStatus(config.SNMP{PollInterval: 10 * time.Second, Timeout: 2 * time.Second})
+			d.running, d.pcapOpen, d.lastSNMPWalk = true, true, now
+			tt.status(d)
+
+			got := d.checks(now)[tt.check]
+			if got.Status != tt.want || !strings.Contains(got.Message, tt.message) {
+				t.Errorf("%s check %+v, want %s with %q", tt.check, got, tt.want, tt.message)
+			}
+		})
+	}
+}
+
+func TestSNMPWalkAge(t *testing.T) {
+	now := time.Now()
+
+	for _, tt := range []struct {
+		interval time.Duration
+		age      time.Duration
+		want     string
+	}{
+		{10 * time.Second, 20 * time.Second, checkOK},
+		{10 * time.Second, 2 * time.Minute, checkFailed},
+		{time.Minute, 2 * time.Minute, checkOK},
+		{time.Minute, 4 * time.Minute, checkFailed},
+	} {
+		d := newDiscoveryStatus(config.SNMP{PollInterval: tt.interval, Timeout: 2 * time.Second})
+		d.running, d.pcapOpen, d.lastSNMPWalk = true, true, now.Add(-tt.age)
+
+		if got := d.checks(now)["snmp"]; got.Status != tt.want {
+			t.Errorf("poll interval %s, walked %s ago: %+v, want %s", tt.interval, tt.age, got, tt.want)
+		}
+	}
+}
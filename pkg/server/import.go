@@ -0,0 +1,164 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+// maxImportSize limits the body of an import, a supplier list of 100000 units is about 6 MB
+const maxImportSize = 32 << 20
+
+// importColumns maps the CSV header names of an import to the fields of an expected unit
+var importColumns = map[string]func(u *model.ExpectedUnit) *string{
+	"mac_address": func(u *model.ExpectedUnit) *string { return &u.MacAddress },
+	"mac":         func(u *model.ExpectedUnit) *string { return &u.MacAddress },
+	"serial":      func(u *model.ExpectedUnit) *string { return &u.Serial },
+	"imei":        func(u *model.ExpectedUnit) *string { return &u.IMEI },
+	"model":       func(u *model.ExpectedUnit) *string { return &u.Model },
+	"firmware":    func(u *model.ExpectedUnit) *string { return &u.Firmware },
+	"batch":       func(u *model.ExpectedUnit) *string { return &u.Batch },
+}
+
+// ImportModems imports the list of expected units sent by a supplier, as CSV with
+// a header row or as a JSON array. ?mode=insert skips units that are expected
+// already, ?mode=upsert replaces them. With ?dry_run=true nothing is stored.
+// ?batch= sets the batch of rows that have none.
+func (s *Server) ImportModems(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	values := r.URL.Query()
+	mode := values.Get("mode")
+	if mode == "" {
+		mode = model.ImportInsert
+	}
+	if !model.ValidImportMode(mode) {
+		writeError(w, http.StatusBadRequest, "mode must be insert or upsert")
+		return
+	}
+	dryRun := false
+	if values.Get("dry_run") != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(values.Get("dry_run")); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid dry_run")
+			return
+		}
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxImportSize)
+	var units []model.ExpectedUnit
+	var err error
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch contentType {
+	case "text/csv":
+		units, err = readImportCSV(body)
+	case "application/json", "":
+		err = json.NewDecoder(body).Decode(&units)
+	default:
+		writeError(w, http.StatusUnsupportedMediaType, "import must be text/csv or application/json")
+		return
+	}
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("import is larger than %d bytes", maxImportSize))
+			return
+		}
+		writeError(w, http.StatusBadRequest, "failed to read import: "+err.Error())
+		return
+	}
+	if len(units) == 0 {
+		writeError(w, http.StatusBadRequest, "import has no units")
+		return
+	}
+
+	batch := strings.TrimSpace(values.Get("batch"))
+	for i := range units {
+		units[i].Normalize()
+		if units[i].Batch == "" {
+			units[i].Batch = batch
+		}
+	}
+
+	result, err := s.db.ImportExpectedUnits(units, mode, dryRun)
+	if err != nil {
+		writeStoreError(w, "failed to import units", err)
+		return
+	}
+
+	if result.Invalid > 0 && !dryRun {
+		var details []model.FieldError
+		for _, row := range result.Rows {
+			for _, f := range row.Errors {
+				details = append(details, model.FieldError{Field: fmt.Sprintf("rows[%d].%s", row.Row, f.Field), Message: f.Message})
+			}
+		}
+		writeError(w, http.StatusUnprocessableEntity, fmt.Sprintf("failed to import units: %d of %d rows are invalid, nothing was imported", result.Invalid, len(units)), details...)
+		return
+	}
+
+	httpLog.InfoContext(r.Context(), "imported expected units", "mode", mode, "dry_run", dryRun,
+		"inserted", result.Inserted, "updated", result.Updated, "unchanged", result.Unchanged,
+		"skipped", result.Skipped, "invalid", result.Invalid, "unexpected", len(result.Unexpected))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// readImportCSV reads expected units from CSV. The first row names the columns,
+// see importColumns, and must include mac_address.
+func readImportCSV(r io.Reader) ([]model.ExpectedUnit, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make([]func(u *model.ExpectedUnit) *string, len(header))
+	hasMAC := false
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		field, ok := importColumns[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown column %q, use mac_address, serial, imei, model, firmware and batch", name)
+		}
+		fields[i] = field
+		hasMAC = hasMAC || name == "mac_address" || name == "mac"
+	}
+	if !hasMAC {
+		return nil, errors.New("the mac_address column is required")
+	}
+
+	var units []model.ExpectedUnit
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return units, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var unit model.ExpectedUnit
+		for i, value := range record {
+			*fields[i](&unit) = value
+		}
+		units = append(units, unit)
+	}
+}
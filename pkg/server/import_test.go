@@ -0,0 +1,161 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+func TestReadImportCSV(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		csv  string
+		want []model.ExpectedUnit
+		err  string
+	}{
+		{"empty", "", nil, ""},
+		{"header only", "mac_address,serial\n", nil, ""},
+		{"columns in any order", "\ufeffSerial, MAC,imei,batch\n1111, 00:1f:43:00:00:01,356789012345678,b1\n",
+			[]model.ExpectedUnit{{MacAddress: "00:1f:43:00:00:01", Serial: "1111", IMEI: "356789012345678", Batch: "b1"}}, ""},
+		{"all columns", "mac_address,serial,imei,model,firmware,batch\n00:1f:43:00:00:01,1111,,TRB140,TRB1_R_00.07.05,b1\n00:1f:43:00:00:02,2222,,,,\n",
+			[]model.ExpectedUnit{
+				{MacAddress: "00:1f:43:00:00:01", Serial: "1111", Model: "TRB140", Firmware: "TRB1_R_00.07.05", Batch: "b1"},
+				{MacAddress: "00:1f:43:00:00:02", Serial: "2222"},
+			}, ""},
+		{"unknown column", "mac_address,color\n", nil, `unknown column "color"`},
+		{"no mac column", "serial\n1111\n", nil, "the mac_address column is required"},
+		{"short row", "mac_address,serial\n00:1f:43:00:00:01\n", nil, "wrong number of fields"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			units, err := readImportCSV(strings.NewReader(tt.csv))
+			if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("error %v, want %q", err, tt.err)
+			}
+			if !reflect.DeepEqual(units, tt.want) {
+				t.Errorf("units %+v, want %+v", units, tt.want)
+			}
+		})
+	}
+}
+
+// importUnits posts a CSV import and decodes the result
+func importUnits(t *testing.T, s *Server, query string, csv string) (int, model.ImportResult) {
+	t.Helper()
+
+	r := httptest.NewRequest("POST", "/api/v1/modems/import?"+query, strings.NewReader(csv))
+	r.Header.Set("Content-Type", "text/csv")
+	w := httptest.NewRecorder()
+	s.ImportModems(w, r)
+
+	var result model.ImportResult
+	if w.Code == http.StatusOK {
+		if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w.Code, result
+}
+
+// importActions returns the action of each row of an import
+func importActions(result model.ImportResult) []string {
+	actions := make([]string, len(result.Rows))
+	for i, row := range result.Rows {
+		actions[i] = row.Action
+	}
+	return actions
+}
+
+func TestImportModems(t *testing.T) {
+	const first = "mac_address,serial\n00:1f:43:00:00:01,1111\n00:1f:43:00:00:02,2222\n"
+	const second = "mac_address,serial\n00-1F-43-00-00-01,1111\n00:1f:43:00:00:02,9999\n00:1f:43:00:00:03,3333\n"
+
+	for _, tt := range []struct {
+		name      string
+		query     string
+		csv       string
+		status    int
+		committed bool
+		actions   []string
+		serials   map[string]string // stored afterwards by MAC address, empty if not expected
+	}{
+		{"insert", "mode=insert", second, http.StatusOK, true,
+			[]string{model.ImportSkipped, model.ImportSkipped, model.ImportInserted},
+			map[string]string{"00:1f:43:00:00:02": "2222", "00:1f:43:00:00:03": "3333"}},
+		{"upsert", "mode=upsert", second, http.StatusOK, true,
+			[]string{model.ImportUnchanged, model.ImportUpdated, model.ImportInserted},
+			map[string]string{"00:1f:43:00:00:02": "9999", "00:1f:43:00:00:03": "3333"}},
+		{"dry run", "mode=upsert&dry_run=true", second, http.StatusOK, false,
+			[]string{model.ImportUnchanged, model.ImportUpdated, model.ImportInserted},
+			map[string]string{"00:1f:43:00:00:02": "2222", "00:1f:43:00:00:03": ""}},
+		{"duplicate rows", "mode=upsert", "mac_address,serial\n00:1f:43:00:00:03,3333\n00:1f:43:00:00:03,4444\n", http.StatusUnprocessableEntity, false,
+			nil, map[string]string{"00:1f:43:00:00:03": ""}},
+		{"duplicate rows dry run", "dry_run=1", "mac_address,serial\n00:1f:43:00:00:03,3333\n00:1F:43:00:00:03,4444\n", http.StatusOK, false,
+			[]string{model.ImportInserted, model.ImportInvalid}, map[string]string{"00:1f:43:00:00:03": ""}},
+		{"invalid row", "mode=upsert", "mac_address,serial\n00:1f:43:00:00:03,3333\nnot a mac,4444\n", http.StatusUnprocessableEntity, false,
+			nil, map[string]string{"00:1f:43:00:00:03": ""}},
+		{"unknown mode", "mode=replace", second, http.StatusBadRequest, false, nil, nil},
+		{"no units", "", "mac_address\n", http.StatusBadRequest, false, nil, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			if status, _ := importUnits(t, s, "", first); status != http.StatusOK {
+				t.Fatalf("status %d importing the first list", status)
+			}
+
+			status, result := importUnits(t, s, tt.query, tt.csv)
+			if status != tt.status {
+				t.Fatalf("status %d, want %d", status, tt.status)
+			}
+			if result.Committed != tt.committed {
+				t.Errorf("committed %v, want %v", result.Committed, tt.committed)
+			}
+			if tt.actions != nil && !reflect.DeepEqual(importActions(result), tt.actions) {
+				t.Errorf("actions %v, want %v", importActions(result), tt.actions)
+			}
+			for mac, serial := range tt.serials {
+				unit, err := s.db.GetExpectedUnit(mac)
+				if unit.Serial != serial {
+					t.Errorf("%s has serial %q (%v), want %q", mac, unit.Serial, err, serial)
+				}
+			}
+		})
+	}
+}
+
+func TestImportModemsBatch(t *testing.T) {
+	s := newTestServer(t)
+	if status, _ := importUnits(t, s, "batch=b7", "mac_address,batch\n00:1f:43:00:00:03,\n00:1f:43:00:00:04,b1\n"); status != http.StatusOK {
+		t.Fatalf("status %d", status)
+	}
+	for mac, batch := range map[string]string{"00:1f:43:00:00:03": "b7", "00:1f:43:00:00:04": "b1"} {
+		if unit, err := s.db.GetExpectedUnit(mac); err != nil || unit.Batch != batch {
+			t.Errorf("%s has batch %q (%v), want %q", mac, unit.Batch, err, batch)
+		}
+	}
+}
+
+func TestImportModemsDiscovered(t *testing.T) {
+	s := newTestServer(t)
+	modem := addTestModem(t, s, "00:1f:43:00:00:01")
+	modem.Serial = "1111"
+	if _, err := s.db.UpdateModem(modem, model.Origin{Source: model.SourceDiscovery}); err != nil {
+		t.Fatal(err)
+	}
+	addTestModem(t, s, "00:1f:43:00:00:09")
+
+	_, result := importUnits(t, s, "dry_run=true", "mac_address,serial\n00:1f:43:00:00:01,2222\n00:1f:43:00:00:02,\n")
+	if row := result.Rows[0]; !row.Discovered || !reflect.DeepEqual(row.Mismatches, []string{"serial"}) {
+		t.Errorf("row %+v, want discovered with a serial mismatch", row)
+	}
+	if result.Rows[1].Discovered {
+		t.Errorf("row %+v is discovered", result.Rows[1])
+	}
+	if !reflect.DeepEqual(result.Unexpected, []string{"00:1f:43:00:00:09"}) {
+		t.Errorf("unexpected %v", result.Unexpected)
+	}
+}
@@ -0,0 +1,355 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ebobo/modem_prod_go/pkg/eventbus"
+	"github.com/ebobo/modem_prod_go/pkg/label"
+	"github.com/ebobo/modem_prod_go/pkg/model"
+	sqlitestore "github.com/ebobo/modem_prod_go/pkg/store/sqlite"
+)
+
+// labelQueueSize is how many print jobs can wait for the printer
+const labelQueueSize = 100
+
+// printRequest is the body of a print or reprint request, empty fields use the configured defaults
+type printRequest struct {
+	Template string `json:"template"`
+	Printer  string `json:"printer"`
+	Copies   int    `json:"copies"`
+}
+
+// labelTemplate returns a stored template, or the built-in one if it is asked
+// for and was not replaced
+func (s *Server) labelTemplate(name string) (model.LabelTemplate, error) {
+	t, err := s.db.GetLabelTemplate(name)
+	if errors.Is(err, sqlitestore.ErrNotFound) && name == label.DefaultTemplateName {
+		return builtInLabelTemplate(), nil
+	}
+	return t, err
+}
+
+func builtInLabelTemplate() model.LabelTemplate {
+	return model.LabelTemplate{
+		Name:        label.DefaultTemplateName,
+		Description: "4x2 inch label with IMEI, serial and MAC barcodes and an IMEI QR code",
+		ZPL:         label.DefaultTemplate,
+		BuiltIn:     true,
+	}
+}
+
+// renderLabel renders the label of a modem with the named template
+func (s *Server) renderLabel(mac string, name string) ([]byte, error) {
+	modem, err := s.db.GetModem(mac)
+	if err != nil {
+		return nil, err
+	}
+	t, err := s.labelTemplate(name)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := label.Parse(t.Name, t.ZPL)
+	if err != nil {
+		return nil, err
+	}
+	return tmpl.Render(modem)
+}
+
+func (s *Server) GetListLabelTemplates(w http.ResponseWriter, r *http.Request) {
+	templates, err := s.db.ListLabelTemplates()
+	if err != nil {
+		writeStoreError(w, "failed to get label templates", err)
+		return
+	}
+
+	replaced := false
+	for _, t := range templates {
+		replaced = replaced || t.Name == label.DefaultTemplateName
+	}
+	if !replaced {
+		templates = append([]model.LabelTemplate{builtInLabelTemplate()}, templates...)
+	}
+	json.NewEncoder(w).Encode(templates)
+}
+
+func (s *Server) GetLabelTemplate(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	t, err := s.labelTemplate(name)
+	if err != nil {
+		writeStoreError(w, "failed to get label template "+name, err)
+		return
+	}
+	json.NewEncoder(w).Encode(t)
+}
+
+// PutLabelTemplate adds or replaces a template, the ZPL must parse and may only use the label fields
+func (s *Server) PutLabelTemplate(w http.ResponseWriter, r *http.Request) {
+	var t model.LabelTemplate
+	if !readJSON(w, r, &t) {
+		return
+	}
+	t.Name = mux.Vars(r)["name"]
+
+	if err := t.Validate(); err != nil {
+		writeStoreError(w, "failed to save label template", err)
+		return
+	}
+	if _, err := label.Parse(t.Name, t.ZPL); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, "failed to save label template: validation failed",
+			model.FieldError{Field: "zpl", Message: err.Error()})
+		return
+	}
+
+	t, err := s.db.PutLabelTemplate(t)
+	if err != nil {
+		writeStoreError(w, "failed to save label template", err)
+		return
+	}
+	httpLog.InfoContext(r.Context(), "saved label template", "template", t.Name)
+	json.NewEncoder(w).Encode(t)
+}
+
+// DeleteLabelTemplate deletes a template, deleting a replaced built-in template restores it
+func (s *Server) DeleteLabelTemplate(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	if err := s.db.DeleteLabelTemplate(name); err != nil {
+		writeStoreError(w, "failed to delete label template "+name, err)
+		return
+	}
+	httpLog.InfoContext(r.Context(), "deleted label template", "template", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetModemLabel returns the ZPL of the label of a modem without printing it, ?template= selects the template
+func (s *Server) GetModemLabel(w http.ResponseWriter, r *http.Request) {
+	mac := mux.Vars(r)["mac"]
+	name := r.URL.Query().Get("template")
+	if name == "" {
+		name = s.label.Template
+	}
+
+	zpl, err := s.renderLabel(mac, name)
+	if err != nil {
+		writeLabelError(w, "failed to render label of modem "+mac, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(zpl)
+}
+
+// PrintModemLabel queues a print job for the label of a modem
+func (s *Server) PrintModemLabel(w http.ResponseWriter, r *http.Request) {
+	mac := mux.Vars(r)["mac"]
+
+	req := printRequest{}
+	if r.ContentLength != 0 && !readJSON(w, r, &req) {
+		return
+	}
+	if req.Template == "" {
+		req.Template = s.label.Template
+	}
+
+	zpl, err := s.renderLabel(mac, req.Template)
+	if err != nil {
+		writeLabelError(w, "failed to render label of modem "+mac, err)
+		return
+	}
+	s.queueLabelJob(w, r, model.LabelJob{MacAddress: mac, Template: req.Template, ZPL: string(zpl)}, req)
+}
+
+// ReprintLabelJob queues a new job printing the label of an earlier job again
+// as it was, even if the modem or the template changed since
+func (s *Server) ReprintLabelJob(w http.ResponseWriter, r *http.Request) {
+	id, ok := labelJobID(w, r)
+	if !ok {
+		return
+	}
+
+	req := printRequest{}
+	if r.ContentLength != 0 && !readJSON(w, r, &req) {
+		return
+	}
+
+	job, err := s.db.GetLabelJob(id)
+	if err != nil {
+		writeStoreError(w, "failed to get label job", err)
+		return
+	}
+	if req.Printer == "" {
+		req.Printer = job.Printer
+	}
+	s.queueLabelJob(w, r, model.LabelJob{MacAddress: job.MacAddress, Template: job.Template, ZPL: job.ZPL, ReprintOf: job.ID}, req)
+}
+
+// printerAllowed reports whether labels may be sent to the printer, the configured
+// printer and the allowed printers are. Print requests can not name any other
+// address, the server would connect to it.
+func (s *Server) printerAllowed(printer string) bool {
+	addr := label.PrinterAddress(printer)
+	if s.label.Printer != "" && addr == label.PrinterAddress(s.label.Printer) {
+		return true
+	}
+	for _, allowed := range s.label.Printers {
+		if addr == label.PrinterAddress(allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// queueLabelJob stores a print job with the printer and copies of req and passes it to the printer
+func (s *Server) queueLabelJob(w http.ResponseWriter, r *http.Request, job model.LabelJob, req printRequest) {
+	job.Printer = req.Printer
+	if job.Printer == "" {
+		job.Printer = s.label.Printer
+	}
+	if job.Printer == "" {
+		writeError(w, http.StatusBadRequest, "no label printer is configured, set one in the request")
+		return
+	}
+	if !s.printerAllowed(job.Printer) {
+		writeError(w, http.StatusUnprocessableEntity, "failed to print label: validation failed",
+			model.FieldError{Field: "printer", Message: "is not a configured printer"})
+		return
+	}
+	job.Copies = req.Copies
+	if job.Copies == 0 {
+		job.Copies = 1
+	}
+	if err := model.ValidateCopies(job.Copies); err != nil {
+		writeStoreError(w, "failed to print label", err)
+		return
+	}
+	job.Actor = apiOrigin(r).Actor
+
+	job, err := s.db.AddLabelJob(job)
+	if err != nil {
+		writeStoreError(w, "failed to print label", err)
+		return
+	}
+
+	s.bus.Publish(eventbus.Event{Type: eventbus.LabelJobChanged, MacAddress: job.MacAddress, Data: job})
+	select {
+	case s.labelJobs <- job.ID:
+	default:
+		s.finishLabelJob(job.ID, "the print queue is full")
+		writeError(w, http.StatusServiceUnavailable, "the print queue is full, try again later")
+		return
+	}
+	httpLog.InfoContext(r.Context(), "queued label", "mac", job.MacAddress, "job", job.ID, "printer", job.Printer, "reprint_of", job.ReprintOf)
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// GetListLabelJobs returns the most recent print jobs, filtered by ?mac= and ?status=
+func (s *Server) GetListLabelJobs(w http.ResponseWriter, r *http.Request) {
+	jobs, err := s.db.ListLabelJobs(r.URL.Query().Get("mac"), r.URL.Query().Get("status"))
+	if err != nil {
+		writeStoreError(w, "failed to get label jobs", err)
+		return
+	}
+	json.NewEncoder(w).Encode(jobs)
+}
+
+func (s *Server) GetLabelJob(w http.ResponseWriter, r *http.Request) {
+	id, ok := labelJobID(w, r)
+	if !ok {
+		return
+	}
+
+	job, err := s.db.GetLabelJob(id)
+	if err != nil {
+		writeStoreError(w, "failed to get label job", err)
+		return
+	}
+	json.NewEncoder(w).Encode(job)
+}
+
+func labelJobID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid label job id")
+		return 0, false
+	}
+	return id, true
+}
+
+// writeLabelError maps errors of rendering a label, a stored template that no
+// longer parses is a server side problem
+func writeLabelError(w http.ResponseWriter, message string, err error) {
+	if errors.Is(err, sqlitestore.ErrNotFound) {
+		writeStoreError(w, message, err)
+		return
+	}
+	labelLog.Error(message, "err", err)
+	writeError(w, http.StatusInternalServerError, message+": "+err.Error())
+}
+
+// requeueLabelJobs passes the jobs left queued by the last run to the printer. It
+// runs before the API starts, so they are not queued again with new jobs.
+func (s *Server) requeueLabelJobs() {
+	queued, err := s.db.ListLabelJobs("", model.LabelQueued)
+	if err != nil {
+		labelLog.Error("failed to get queued label jobs", "err", err)
+		return
+	}
+	// Oldest first, jobs that do not fit the queue fail rather than block startup
+	for i := len(queued) - 1; i >= 0; i-- {
+		select {
+		case s.labelJobs <- queued[i].ID:
+		default:
+			s.finishLabelJob(queued[i].ID, "the print queue is full")
+		}
+	}
+	if len(queued) > 0 {
+		labelLog.Info("requeued label jobs", "count", len(queued))
+	}
+}
+
+// runLabelPrinter sends queued labels to their printer one at a time until ctx is done
+func (s *Server) runLabelPrinter(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case id := <-s.labelJobs:
+			job, err := s.db.GetLabelJob(id)
+			if err != nil {
+				labelLog.Error("failed to get label job", "job", id, "err", err)
+				continue
+			}
+
+			errMsg := ""
+			if !s.printerAllowed(job.Printer) {
+				// Queued before the printer was removed from the configuration
+				labelLog.Warn("label printer is not allowed", "mac", job.MacAddress, "job", job.ID, "printer", job.Printer)
+				errMsg = "printer " + job.Printer + " is not a configured printer"
+			} else if err := label.Send(ctx, job.Printer, []byte(job.ZPL), job.Copies, s.label.Timeout); err != nil {
+				labelLog.Warn("failed to print label", "mac", job.MacAddress, "job", job.ID, "printer", job.Printer, "err", err)
+				errMsg = err.Error()
+			} else {
+				labelLog.Info("printed label", "mac", job.MacAddress, "job", job.ID, "printer", job.Printer, "copies", job.Copies)
+			}
+			s.finishLabelJob(job.ID, errMsg)
+		}
+	}
+}
+
+// finishLabelJob records the outcome of a print job and publishes it
+func (s *Server) finishLabelJob(id int64, errMsg string) {
+	job, err := s.db.FinishLabelJob(id, errMsg)
+	if err != nil {
+		labelLog.Error("failed to update label job", "job", id, "err", err)
+		return
+	}
+	s.bus.Publish(eventbus.Event{Type: eventbus.LabelJobChanged, MacAddress: job.MacAddress, Data: job})
+}
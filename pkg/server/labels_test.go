@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+func TestPrintModemLabelPrinters(t *testing.T) {
+	const mac = "00:1f:43:00:00:01"
+
+	for _, tt := range []struct {
+		name    string
+		printer string
+		status  int
+		want    string
+	}{
+		{"configured printer", "", http.StatusAccepted, "192.168.2.50"},
+		{"configured printer with port", "192.168.2.50:9100", http.StatusAccepted, "192.168.2.50:9100"},
+		{"allowed printer", "192.168.2.51", http.StatusAccepted, "192.168.2.51"},
+		{"allowed printer with port", "zebra2:6101", http.StatusAccepted, "zebra2:6101"},
+		{"other port", "192.168.2.51:22", http.StatusUnprocessableEntity, ""},
+		{"other address", "169.254.169.254:80", http.StatusUnprocessableEntity, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, func(c *Config) {
+				c.Label.Printer = "192.168.2.50"
+				c.Label.Printers = []string{"192.168.2.51", "zebra2:6101"}
+			})
+			addTestModem(t, s, mac)
+
+			r := httptest.NewRequest("POST", "/api/v1/modem/"+mac+"/label", strings.NewReader(`{"printer":"`+tt.printer+`"}`))
+			w := httptest.NewRecorder()
+			s.PrintModemLabel(w, mux.SetURLVars(r, map[string]string{"mac": mac}))
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+
+			jobs, err := s.db.ListLabelJobs(mac, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == "" {
+				if len(jobs) != 0 {
+					t.Errorf("jobs %+v, want none", jobs)
+				}
+				return
+			}
+			if len(jobs) != 1 || jobs[0].Printer != tt.want || len(s.labelJobs) != 1 {
+				t.Errorf("jobs %+v, want one queued for %s", jobs, tt.want)
+			}
+		})
+	}
+}
+
+// Jobs left queued are requeued before the API starts, and printed once
+func TestRequeueLabelJobs(t *testing.T) {
+	const mac = "00:1f:43:00:00:01"
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	received := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			data, _ := io.ReadAll(conn)
+			conn.Close()
+			received <- string(data)
+		}
+	}()
+
+	s := newTestServer(t, func(c *Config) { c.Label.Printer = l.Addr().String() })
+	addTestModem(t, s, mac)
+	var ids []int64
+	for _, printer := range []string{l.Addr().String(), "192.168.2.99"} {
+		job, err := s.db.AddLabelJob(model.LabelJob{MacAddress: mac, Template: "default", ZPL: "^XA^XZ", Printer: printer, Copies: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, job.ID)
+	}
+
+	s.requeueLabelJobs()
+	if len(s.labelJobs) != 2 {
+		t.Fatalf("%d jobs queued, want 2", len(s.labelJobs))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.runLabelPrinter(ctx)
+
+	select {
+	case got := <-received:
+		if got != "^XA^XZ" {
+			t.Errorf("printer got %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("label was not printed")
+	}
+
+	want := map[int64]string{ids[0]: model.LabelPrinted, ids[1]: model.LabelFailed}
+	deadline := time.Now().Add(5 * time.Second)
+	for id, status := range want {
+		for {
+			job, err := s.db.GetLabelJob(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if job.Status == status {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("job %d is %s, want %s", id, job.Status, status)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	select {
+	case got := <-received:
+		t.Errorf("printer got %q again", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
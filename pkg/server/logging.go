@@ -0,0 +1,76 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ebobo/modem_prod_go/pkg/logging"
+)
+
+// Loggers of the subsystems of the server
+var (
+	discoveryLog = logging.For(logging.Discovery)
+	snmpLog      = logging.For(logging.SNMP)
+	sshLog       = logging.For(logging.SSH)
+	upgradeLog   = logging.For(logging.Upgrade)
+	httpLog      = logging.For(logging.HTTP)
+	reconcileLog = logging.For(logging.Reconcile)
+	labelLog     = logging.For(logging.Label)
+	provisionLog = logging.For(logging.Provision)
+	testLog      = logging.For(logging.Test)
+	simLog       = logging.For(logging.SIM)
+)
+
+// requestIDHeader carries the id of a request, an id sent by a proxy is kept
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength limits the request ids accepted from clients
+const maxRequestIDLength = 64
+
+// newJobID returns a short id to follow one background job of a modem in the logs
+func newJobID() string {
+	return uuid.NewString()[:8]
+}
+
+// fatal logs an error the server cannot continue after and exits
+func fatal(l *slog.Logger, msg string, args ...any) {
+	l.Error(msg, args...)
+	os.Exit(1)
+}
+
+// accessLog is a middleware giving each request an id and logging it once it is done.
+// The id is returned in the X-Request-ID header and added to everything logged
+// with the request context.
+func accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(requestIDHeader)
+		if id == "" || len(id) > maxRequestIDLength {
+			id = uuid.NewString()
+		}
+		w.Header().Set(requestIDHeader, id)
+		ctx := logging.WithAttrs(r.Context(), slog.String("request_id", id))
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		level := slog.LevelInfo
+		if recorder.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		httpLog.LogAttrs(ctx, level, "request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", recorder.status),
+			slog.Int("bytes", recorder.bytes),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("remote", r.RemoteAddr),
+			slog.String("user_agent", r.UserAgent()),
+		)
+	})
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ebobo/modem_prod_go/pkg/metrics"
+	"github.com/ebobo/modem_prod_go/pkg/model"
+	sqlitestore "github.com/ebobo/modem_prod_go/pkg/store/sqlite"
+)
+
+var modemsDesc = prometheus.NewDesc(
+	"modem_prod_modems",
+	"Number of modems by state and model.",
+	[]string{"state", "model"}, nil,
+)
+
+// modemCollector reports the modem counts from the store when scraped
+type modemCollector struct {
+	db *sqlitestore.SqliteStore
+}
+
+func (c modemCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- modemsDesc
+}
+
+func (c modemCollector) Collect(ch chan<- prometheus.Metric) {
+	counts, err := c.db.CountModems()
+	if err != nil {
+		httpLog.Error("failed to count modems for metrics", "err", err)
+		return
+	}
+	for _, count := range counts {
+		ch <- prometheus.MustNewConstMetric(modemsDesc, prometheus.GaugeValue, float64(count.Count),
+			model.StateName(count.State), count.Model)
+	}
+}
+
+// registerModemCollector registers the modem counts of db, replacing those of a previous server
+func registerModemCollector(db *sqlitestore.SqliteStore) {
+	collector := modemCollector{db: db}
+	err := prometheus.Register(collector)
+
+	var registered prometheus.AlreadyRegisteredError
+	if errors.As(err, &registered) {
+		prometheus.Unregister(registered.ExistingCollector)
+		err = prometheus.Register(collector)
+	}
+	if err != nil {
+		httpLog.Error("failed to register modem metrics", "err", err)
+	}
+}
+
+// instrumentHTTP is a middleware counting requests and measuring their duration per route
+func instrumentHTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(recorder, r)
+
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		metrics.HTTPRequests.WithLabelValues(route, r.Method, strconv.Itoa(recorder.status)).Inc()
+		metrics.HTTPDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// statusRecorder remembers the status code written to a response. It passes
+// flushing and hijacking through so streaming endpoints keep working.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking not supported")
+	}
+	r.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying response writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
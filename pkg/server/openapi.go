@@ -0,0 +1,18 @@
+package server
+
+import (
+	_ "embed" // for side effect
+	"net/http"
+)
+
+// openAPISpec documents the REST API, openapi_test.go checks that it matches the
+// routes and what the handlers send and receive
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// GetOpenAPI returns the OpenAPI document of the REST API
+func (s *Server) GetOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
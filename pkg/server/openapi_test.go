@@ -0,0 +1,504 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// openAPIDoc is the part of the OpenAPI document the contract tests use
+type openAPIDoc struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas   map[string]*schema   `json:"schemas"`
+		Responses map[string]*response `json:"responses"`
+	} `json:"components"`
+}
+
+type operation struct {
+	RequestBody *struct {
+		Content map[string]struct {
+			Schema *schema `json:"schema"`
+		} `json:"content"`
+	} `json:"requestBody"`
+	Responses map[string]*response `json:"responses"`
+}
+
+type response struct {
+	Ref     string `json:"$ref"`
+	Content map[string]struct {
+		Schema *schema `json:"schema"`
+	} `json:"content"`
+}
+
+// schema is the subset of JSON schema the document uses
+type schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	AllOf                []*schema          `json:"allOf"`
+	Properties           map[string]*schema `json:"properties"`
+	AdditionalProperties json.RawMessage    `json:"additionalProperties"`
+	Required             []string           `json:"required"`
+	Items                *schema            `json:"items"`
+	Enum                 []interface{}      `json:"enum"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	Pattern              string             `json:"pattern"`
+	ReadOnly             bool               `json:"readOnly"`
+	WriteOnly            bool               `json:"writeOnly"`
+}
+
+func loadOpenAPI(t *testing.T) *openAPIDoc {
+	t.Helper()
+
+	var doc openAPIDoc
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		t.Fatalf("invalid OpenAPI document: %v", err)
+	}
+	return &doc
+}
+
+func (doc *openAPIDoc) operation(t *testing.T, path string, method string) *operation {
+	t.Helper()
+
+	raw, ok := doc.Paths[path][strings.ToLower(method)]
+	if !ok {
+		t.Fatalf("%s %s is not documented", method, path)
+	}
+	var op operation
+	if err := json.Unmarshal(raw, &op); err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	return &op
+}
+
+func (doc *openAPIDoc) resolve(s *schema) *schema {
+	for s != nil && s.Ref != "" {
+		s = doc.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+	}
+	return s
+}
+
+// validate checks value, decoded with UseNumber, against the schema and returns
+// what does not match. Properties not in the schema are reported too, unless it
+// allows additional properties or lists none, so fields added to a handler have
+// to be documented.
+// request is true for request bodies, which leave out read-only properties,
+// responses leave out write-only ones.
+func (doc *openAPIDoc) validate(s *schema, value interface{}, at string, request bool) []string {
+	s = doc.resolve(s)
+	if s == nil {
+		return []string{at + ": unknown schema"}
+	}
+
+	var problems []string
+	fail := func(format string, args ...interface{}) {
+		problems = append(problems, at+": "+fmt.Sprintf(format, args...))
+	}
+
+	if len(s.AllOf) > 0 {
+		// The parts are merged, so a property of one part is not additional in another
+		merged := &schema{Type: "object", Properties: map[string]*schema{}}
+		for _, part := range s.AllOf {
+			part = doc.resolve(part)
+			for name, p := range part.Properties {
+				merged.Properties[name] = p
+			}
+			merged.Required = append(merged.Required, part.Required...)
+		}
+		return doc.validate(merged, value, at, request)
+	}
+
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if fmt.Sprint(e) == fmt.Sprint(value) {
+				found = true
+			}
+		}
+		if !found {
+			fail("%v is not one of %v", value, s.Enum)
+		}
+	}
+
+	switch s.Type {
+	case "":
+		// any value
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			fail("%s is not an object", describe(value))
+			break
+		}
+		for _, name := range s.Required {
+			p := doc.resolve(s.Properties[name])
+			if p != nil && (request && p.ReadOnly || !request && p.WriteOnly) {
+				continue
+			}
+			if _, ok := object[name]; !ok {
+				fail("required property %s is missing", name)
+			}
+		}
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			p, ok := s.Properties[name]
+			switch {
+			case ok:
+				if p := doc.resolve(p); !request && p.WriteOnly {
+					fail("write-only property %s is returned", name)
+				}
+				problems = append(problems, doc.validate(p, object[name], at+"."+name, request)...)
+			case len(s.Properties) == 0 && len(s.AdditionalProperties) == 0:
+				// a free-form object
+			case len(s.AdditionalProperties) == 0 || string(s.AdditionalProperties) == "false":
+				fail("property %s is not documented", name)
+			case string(s.AdditionalProperties) != "true":
+				var additional schema
+				if err := json.Unmarshal(s.AdditionalProperties, &additional); err != nil {
+					fail("invalid additionalProperties: %v", err)
+					break
+				}
+				problems = append(problems, doc.validate(&additional, object[name], at+"."+name, request)...)
+			}
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			fail("%s is not an array", describe(value))
+			break
+		}
+		for i, item := range array {
+			problems = append(problems, doc.validate(s.Items, item, at+"["+strconv.Itoa(i)+"]", request)...)
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			fail("%s is not a string", describe(value))
+		} else if s.Pattern != "" && !regexp.MustCompile(s.Pattern).MatchString(str) {
+			fail("%q does not match %s", str, s.Pattern)
+		}
+	case "integer", "number":
+		n, ok := value.(json.Number)
+		if !ok {
+			fail("%s is not a number", describe(value))
+			break
+		}
+		f, err := n.Float64()
+		if _, intErr := n.Int64(); err != nil || s.Type == "integer" && intErr != nil {
+			fail("%s is not an %s", n, s.Type)
+			break
+		}
+		if s.Minimum != nil && f < *s.Minimum || s.Maximum != nil && f > *s.Maximum {
+			fail("%s is out of range", n)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			fail("%s is not a boolean", describe(value))
+		}
+	default:
+		fail("unknown type %s", s.Type)
+	}
+	return problems
+}
+
+func describe(value interface{}) string {
+	if value == nil {
+		return "null"
+	}
+	b, _ := json.Marshal(value)
+	return string(b)
+}
+
+func decodeJSON(data []byte) (interface{}, error) {
+	var v interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err := decoder.Decode(&v)
+	return v, err
+}
+
+// TestOpenAPIRoutes checks that every route is documented and every documented operation is routed
+func TestOpenAPIRoutes(t *testing.T) {
+	doc := loadOpenAPI(t)
+	s := newTestServer(t)
+
+	documented := map[string]bool{}
+	for path, item := range doc.Paths {
+		for method := range item {
+			if method != "parameters" {
+				documented[strings.ToUpper(method)+" "+path] = true
+			}
+		}
+	}
+
+	routed := map[string]bool{}
+	err := s.routes().Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil || path == "/" {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		for _, method := range methods {
+			routed[method+" "+path] = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for route := range routed {
+		if !documented[route] {
+			t.Errorf("%s is not documented", route)
+		}
+	}
+	for operation := range documented {
+		if !routed[operation] {
+			t.Errorf("%s is documented but not routed", operation)
+		}
+	}
+}
+
+// TestOpenAPIBodies sends requests through the router and checks the request and
+// response bodies against the schemas of the operations they are routed to
+func TestOpenAPIBodies(t *testing.T) {
+	doc := loadOpenAPI(t)
+	s := newTestServer(t, func(c *Config) { c.AuthDisabled = true })
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	t.Cleanup(s.cancel)
+	var err error
+	if s.testPlan, err = s.test.TestPlan(); err != nil {
+		t.Fatal(err)
+	}
+	router := s.routes()
+
+	const mac = "00:1f:43:00:00:01"
+	addTestModem(t, s, "00:1f:43:00:00:02")
+
+	for _, tt := range []struct {
+		method      string
+		path        string
+		contentType string
+		body        string
+		status      int
+	}{
+		{"GET", "/healthz", "", "", http.StatusOK},
+		{"GET", "/readyz", "", "", http.StatusOK},
+		{"GET", "/api/v1/version", "", "", http.StatusOK},
+
+		{"POST", "/api/v1/modem", "", `{"mac_address":"` + mac + `","model":"TRB140","switch_port":3,"state":1}`, http.StatusCreated},
+		{"POST", "/api/v1/modem", "", `{"mac_address":"` + mac + `"}`, http.StatusConflict},
+		{"POST", "/api/v1/modem", "", `{"mac_address":"not a mac"}`, http.StatusUnprocessableEntity},
+		{"POST", "/api/v1/modem", "", `{"mac_address":`, http.StatusBadRequest},
+		{"GET", "/api/v1/modem/" + mac, "", "", http.StatusOK},
+		{"GET", "/api/v1/modem/00:1f:43:00:00:99", "", "", http.StatusNotFound},
+		{"GET", "/api/v1/modems", "", "", http.StatusOK},
+		{"GET", "/api/v1/modems?limit=1", "", "", http.StatusOK},
+		{"GET", "/api/v1/modems?state=busy", "", "", http.StatusBadRequest},
+		{"PUT", "/api/v1/modem/" + mac, "", `{"mac_address":"` + mac + `","model":"TRB140","firmware":"TRB1_R_00.07.05","state":1}`, http.StatusOK},
+		{"PATCH", "/api/v1/modem/" + mac, "application/merge-patch+json", `{"serial":"1100123456"}`, http.StatusOK},
+		{"PATCH", "/api/v1/modem/" + mac, "application/merge-patch+json", `{"serial":"1"}`, http.StatusOK},
+		{"PATCH", "/api/v1/modem/" + mac, "text/plain", `{}`, http.StatusUnsupportedMediaType},
+		{"PUT", "/api/v1/modem/" + mac, "", `{"mac_address":"` + mac + `","version":1}`, http.StatusPreconditionFailed},
+		{"PUT", "/api/v1/modem/" + mac + "/state", "", `{"state":1}`, http.StatusOK},
+		{"PUT", "/api/v1/modem/" + mac + "/progress", "", `{"progress":50}`, http.StatusOK},
+		{"PUT", "/api/v1/modem/" + mac + "/progress", "", `{"progress":500}`, http.StatusUnprocessableEntity},
+		{"GET", "/api/v1/modem/" + mac + "/history", "", "", http.StatusOK},
+
+		{"PUT", "/api/v1/profiles/bench", "", `{"description":"bench","settings":[{"key":"network.lan.ipaddr","value":"192.168.2.1"}]}`, http.StatusOK},
+		{"PUT", "/api/v1/profiles/bench", "", `{"settings":[{"key":"","value":""}]}`, http.StatusUnprocessableEntity},
+		{"GET", "/api/v1/profiles", "", "", http.StatusOK},
+		{"GET", "/api/v1/profiles/bench", "", "", http.StatusOK},
+		{"GET", "/api/v1/modem/" + mac + "/provision", "", "", http.StatusNotFound},
+
+		{"POST", "/api/v1/orders", "", `{"customer":"Acme","quantity":2,"model":"TRB140","config_profile":"bench","bench_from":1,"bench_to":8}`, http.StatusCreated},
+		{"POST", "/api/v1/orders", "", `{"customer":"","quantity":0}`, http.StatusUnprocessableEntity},
+		{"GET", "/api/v1/orders", "", "", http.StatusOK},
+		{"GET", "/api/v1/orders/1", "", "", http.StatusOK},
+		{"GET", "/api/v1/orders/x", "", "", http.StatusBadRequest},
+		{"POST", "/api/v1/orders/1/modems", "", `{"mac_addresses":["` + mac + `"]}`, http.StatusOK},
+		{"GET", "/api/v1/orders/1/modems", "", "", http.StatusOK},
+		{"POST", "/api/v1/orders/1/assign", "", "", http.StatusOK},
+		{"DELETE", "/api/v1/profiles/bench", "", "", http.StatusConflict},
+
+		{"POST", "/api/v1/modems/import", "", `[{"mac_address":"` + mac + `","serial":"1100123456","model":"TRB140","batch":"b1"},{"mac_address":"00:1f:43:00:00:03"}]`, http.StatusOK},
+		{"POST", "/api/v1/modems/import", "text/csv", "mac_address,serial\n" + mac + ",1100123456\n", http.StatusOK},
+		{"POST", "/api/v1/modems/import", "", `[{"mac_address":"bad"}]`, http.StatusUnprocessableEntity},
+		{"GET", "/api/v1/reconciliation", "", "", http.StatusOK},
+
+		{"GET", "/api/v1/labels/templates", "", "", http.StatusOK},
+		{"PUT", "/api/v1/labels/templates/small", "", `{"description":"small","zpl":"^XA^FD{{.MAC}}^FS^XZ"}`, http.StatusOK},
+		{"PUT", "/api/v1/labels/templates/small", "", `{"zpl":"no label"}`, http.StatusUnprocessableEntity},
+		{"GET", "/api/v1/labels/templates/small", "", "", http.StatusOK},
+		{"GET", "/api/v1/labels/jobs", "", "", http.StatusOK},
+		{"GET", "/api/v1/labels/jobs/1", "", "", http.StatusNotFound},
+
+		{"GET", "/api/v1/modem/" + mac + "/test", "", "", http.StatusNotFound},
+		{"GET", "/api/v1/modem/" + mac + "/tests", "", "", http.StatusOK},
+		{"GET", "/api/v1/sim/jobs", "", "", http.StatusOK},
+
+		{"POST", "/api/v1/keys", "", `{"name":"line 1","role":"operator"}`, http.StatusCreated},
+		{"POST", "/api/v1/keys", "", `{"name":"line 1","role":"root"}`, http.StatusUnprocessableEntity},
+		{"GET", "/api/v1/keys", "", "", http.StatusOK},
+		{"DELETE", "/api/v1/keys/1", "", "", http.StatusNoContent},
+
+		{"GET", "/api/v1/openapi.json", "", "", http.StatusOK},
+		{"GET", "/api/v1/modems/export?format=csv", "", "", http.StatusOK},
+		{"GET", "/api/v1/modems/export?format=doc", "", "", http.StatusBadRequest},
+		{"GET", "/api/v1/modem/" + mac + "/report", "", "", http.StatusOK},
+		{"GET", "/api/v1/modem/" + mac + "/report?format=doc", "", "", http.StatusBadRequest},
+		{"GET", "/api/v1/orders/1/reports", "", "", http.StatusOK},
+		{"GET", "/api/v1/modem/" + mac + "/label", "", "", http.StatusOK},
+		{"POST", "/api/v1/labels/jobs/9/reprint", "", `{}`, http.StatusNotFound},
+		{"DELETE", "/api/v1/labels/templates/small", "", "", http.StatusNoContent},
+		{"GET", "/api/v1/modem/" + mac + "/password", "", "", http.StatusNotFound},
+		{"GET", "/api/v1/modem/" + mac + "/sim", "", "", http.StatusServiceUnavailable},
+		{"POST", "/api/v1/modem/" + mac + "/sim/activate", "", "", http.StatusServiceUnavailable},
+		{"POST", "/api/v1/modem/" + mac + "/reread", "", "", http.StatusAccepted},
+		{"DELETE", "/api/v1/orders/1/modems/" + mac, "", "", http.StatusNoContent},
+		{"POST", "/api/v1/orders/1/close", "", "", http.StatusOK},
+		{"POST", "/api/v1/orders/1/close", "", "", http.StatusConflict},
+
+		{"DELETE", "/api/v1/modem/00:1f:43:00:00:02", "", "", http.StatusNoContent},
+		{"DELETE", "/api/v1/modem/00:1f:43:00:00:02", "", "", http.StatusNotFound},
+	} {
+		name := tt.method + " " + tt.path
+		var body []byte
+		if tt.body != "" {
+			body = []byte(tt.body)
+		}
+		r := httptest.NewRequest(tt.method, tt.path, bytes.NewReader(body))
+		contentType := tt.contentType
+		if contentType == "" && body != nil {
+			contentType = "application/json"
+		}
+		if contentType != "" {
+			r.Header.Set("Content-Type", contentType)
+		}
+
+		var match mux.RouteMatch
+		if !router.Match(r, &match) || match.Route == nil {
+			t.Errorf("%s is not routed", name)
+			continue
+		}
+		template, _ := match.Route.GetPathTemplate()
+		op := doc.operation(t, template, tt.method)
+
+		// Only requests that are meant to be valid have to match the schema
+		if body != nil && tt.status < 300 {
+			if op.RequestBody == nil {
+				t.Errorf("%s: request body is not documented", name)
+			} else if content, ok := op.RequestBody.Content[contentType]; !ok {
+				t.Errorf("%s: request body of type %s is not documented", name, contentType)
+			} else if contentType == "application/json" {
+				value, err := decodeJSON(body)
+				if err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				for _, problem := range doc.validate(content.Schema, value, "request", true) {
+					t.Errorf("%s: %s", name, problem)
+				}
+			}
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", name, w.Code, tt.status, w.Body)
+			continue
+		}
+
+		resp, ok := op.Responses[strconv.Itoa(w.Code)]
+		if !ok {
+			t.Errorf("%s: status %d is not documented", name, w.Code)
+			continue
+		}
+		if resp.Ref != "" {
+			resp = doc.Components.Responses[strings.TrimPrefix(resp.Ref, "#/components/responses/")]
+		}
+		if len(resp.Content) == 0 {
+			if w.Body.Len() > 0 {
+				t.Errorf("%s: undocumented response body %s", name, w.Body)
+			}
+			continue
+		}
+		responseType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+		content, ok := resp.Content[responseType]
+		if !ok {
+			t.Errorf("%s: response of type %q is not documented", name, responseType)
+			continue
+		}
+		if responseType != "application/json" || content.Schema == nil {
+			continue
+		}
+		value, err := decodeJSON(w.Body.Bytes())
+		if err != nil {
+			t.Errorf("%s: invalid response %s: %v", name, w.Body, err)
+			continue
+		}
+		for _, problem := range doc.validate(content.Schema, value, "response", false) {
+			t.Errorf("%s: %s", name, problem)
+		}
+	}
+}
+
+func TestOpenAPIValidate(t *testing.T) {
+	doc := loadOpenAPI(t)
+	modem := &schema{Ref: "#/components/schemas/Modem"}
+
+	for _, tt := range []struct {
+		name    string
+		schema  *schema
+		body    string
+		request bool
+		problem string
+	}{
+		{"valid", modem, `{"mac_address":"00:1f:43:00:00:01","state":1,"upgraded":false}`, false, ""},
+		{"undocumented property", modem, `{"mac_address":"00:1f:43:00:00:01","colour":"red"}`, false, "property colour is not documented"},
+		{"out of range", modem, `{"mac_address":"00:1f:43:00:00:01","state":9}`, false, "9 is out of range"},
+		{"wrong type", modem, `{"mac_address":"00:1f:43:00:00:01","state":"busy"}`, false, "is not a number"},
+		{"not an integer", modem, `{"mac_address":"00:1f:43:00:00:01","state":1.5}`, false, "1.5 is not an integer"},
+		{"required missing", modem, `{"model":"TRB140"}`, true, "required property mac_address is missing"},
+		{"null array", &schema{Ref: "#/components/schemas/TestRun"}, `{"results":null}`, false, "null is not an array"},
+		{"not in enum", &schema{Ref: "#/components/schemas/APIKey"}, `{"name":"a","role":"root"}`, true, "root is not one of"},
+		{"read-only not required in requests", &schema{Ref: "#/components/schemas/APIKey"}, `{"name":"a","role":"admin"}`, true, ""},
+		{"write-only returned", &schema{Ref: "#/components/schemas/ConfigProfile"}, `{"settings":[],"admin_password":"x"}`, false, "write-only property admin_password"},
+		{"additional properties", &schema{Ref: "#/components/schemas/Readiness"}, `{"checks":{"ssh":{"status":"broken"}}}`, false, "response.checks.ssh.status: broken"},
+		{"all of", &schema{Ref: "#/components/schemas/NewAPIKey"}, `{"name":"a","role":"admin","key":"k","colour":"red"}`, false, "property colour is not documented"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := decodeJSON([]byte(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			problems := doc.validate(tt.schema, value, "response", tt.request)
+			if tt.problem == "" {
+				if len(problems) > 0 {
+					t.Errorf("problems %q", problems)
+				}
+				return
+			}
+			if len(problems) != 1 || !strings.Contains(problems[0], tt.problem) {
+				t.Errorf("problems %q, want %q", problems, tt.problem)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+	sqlitestore "github.com/ebobo/modem_prod_go/pkg/store/sqlite"
+)
+
+func (s *Server) GetListOrders(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	orders, err := s.db.ListOrders(r.URL.Query().Get("status"))
+	if err != nil {
+		writeStoreError(w, "failed to get orders", err)
+		return
+	}
+	json.NewEncoder(w).Encode(orders)
+}
+
+func (s *Server) AddOrder(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var newOrder model.Order
+	if !readJSON(w, r, &newOrder) {
+		return
+	}
+
+	if err := newOrder.Validate(); err != nil {
+		writeStoreError(w, "failed to add order", err)
+		return
+	}
+	if newOrder.ConfigProfile != "" {
+		_, err := s.db.GetConfigProfile(newOrder.ConfigProfile)
+		if errors.Is(err, sqlitestore.ErrNotFound) {
+			writeError(w, http.StatusUnprocessableEntity, "failed to add order: validation failed",
+				model.FieldError{Field: "config_profile", Message: "no config profile is named " + newOrder.ConfigProfile})
+			return
+		}
+		if err != nil {
+			writeStoreError(w, "failed to add order", err)
+			return
+		}
+	}
+
+	id, err := s.db.AddOrder(newOrder)
+	if err != nil {
+		writeStoreError(w, "failed to add order", err)
+		return
+	}
+
+	order, err := s.db.GetOrder(id)
+	if err != nil {
+		writeStoreError(w, "failed to get order", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(order)
+}
+
+func (s *Server) GetOrder(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	id, ok := orderID(w, r)
+	if !ok {
+		return
+	}
+
+	order, err := s.db.GetOrder(id)
+	if err != nil {
+		writeStoreError(w, "failed to get order", err)
+		return
+	}
+	json.NewEncoder(w).Encode(order)
+}
+
+func (s *Server) GetOrderModems(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	id, ok := orderID(w, r)
+	if !ok {
+		return
+	}
+
+	_, err := s.db.GetOrder(id)
+	if err != nil {
+		writeStoreError(w, "failed to get order", err)
+		return
+	}
+
+	modems, err := s.db.ListOrderModems(id)
+	if err != nil {
+		writeStoreError(w, "failed to get order modems", err)
+		return
+	}
+	json.NewEncoder(w).Encode(modems)
+}
+
+func (s *Server) AssignOrderModems(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	id, ok := orderID(w, r)
+	if !ok {
+		return
+	}
+
+	var assign struct {
+		MacAddresses []string `json:"mac_addresses"`
+	}
+	if !readJSON(w, r, &assign) {
+		return
+	}
+
+	if err := s.db.AssignModems(id, assign.MacAddresses); err != nil {
+		writeStoreError(w, "failed to assign modems", err)
+		return
+	}
+
+	order, err := s.db.GetOrder(id)
+	if err != nil {
+		writeStoreError(w, "failed to get order", err)
+		return
+	}
+	json.NewEncoder(w).Encode(order)
+}
+
+// AutoAssignOrderModems assigns the unassigned modems found on the order's bench
+func (s *Server) AutoAssignOrderModems(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	id, ok := orderID(w, r)
+	if !ok {
+		return
+	}
+
+	assigned, err := s.db.AutoAssignModems(id)
+	if err != nil {
+		writeStoreError(w, "failed to assign modems", err)
+		return
+	}
+	httpLog.InfoContext(r.Context(), "assigned modems to order", "order_id", id, "assigned", assigned)
+
+	order, err := s.db.GetOrder(id)
+	if err != nil {
+		writeStoreError(w, "failed to get order", err)
+		return
+	}
+	json.NewEncoder(w).Encode(order)
+}
+
+func (s *Server) UnassignOrderModem(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	id, ok := orderID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.db.UnassignModem(id, mux.Vars(r)["mac"]); err != nil {
+		writeStoreError(w, "failed to unassign modem", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CloseOrder closes the order and returns it as the order summary
+func (s *Server) CloseOrder(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	id, ok := orderID(w, r)
+	if !ok {
+		return
+	}
+
+	order, err := s.db.CloseOrder(id)
+	if err != nil {
+		writeStoreError(w, "failed to close order", err)
+		return
+	}
+	httpLog.InfoContext(r.Context(), "closed order", "order_id", order.ID, "customer", order.Customer,
+		"quantity", order.Quantity, "completed", order.Completed, "failed", order.Failed)
+
+	json.NewEncoder(w).Encode(order)
+}
+
+// orderID parses the order id from the path and writes an error if it is invalid
+func orderID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid order id")
+		return 0, false
+	}
+	return id, true
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+// callOrder calls an order handler with the path variables and decodes the order it returns
+func callOrder(t *testing.T, handler http.HandlerFunc, method string, vars map[string]string, body string, status int) model.Order {
+	t.Helper()
+
+	r := httptest.NewRequest(method, "/api/v1/orders", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	handler(w, mux.SetURLVars(r, vars))
+	if w.Code != status {
+		t.Fatalf("%s: status %d, want %d: %s", method, w.Code, status, w.Body)
+	}
+
+	var order model.Order
+	if status == http.StatusOK || status == http.StatusCreated {
+		if err := json.NewDecoder(w.Body).Decode(&order); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return order
+}
+
+func TestAddOrder(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"added", `{"customer":"acme","quantity":2,"model":"TRB140","firmware":"TRB1_R_00.07.06"}`, http.StatusCreated},
+		{"no customer", `{"quantity":2}`, http.StatusUnprocessableEntity},
+		{"no quantity", `{"customer":"acme"}`, http.StatusUnprocessableEntity},
+		{"bench reversed", `{"customer":"acme","quantity":2,"bench_from":5,"bench_to":1}`, http.StatusUnprocessableEntity},
+		{"unknown profile", `{"customer":"acme","quantity":2,"config_profile":"missing"}`, http.StatusUnprocessableEntity},
+		{"not json", `{`, http.StatusBadRequest},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			order := callOrder(t, s.AddOrder, "POST", nil, tt.body, tt.status)
+			if tt.status != http.StatusCreated {
+				return
+			}
+			if order.ID == 0 || order.Status != model.OrderOpen || order.CreatedAt == 0 || order.Firmware != "TRB1_R_00.07.06" {
+				t.Errorf("added %+v", order)
+			}
+		})
+	}
+}
+
+func TestOrderLifecycle(t *testing.T) {
+	s := newTestServer(t)
+	for _, mac := range []string{"00:1f:43:00:00:01", "00:1f:43:00:00:02", "00:1f:43:00:00:03"} {
+		addTestModem(t, s, mac)
+	}
+	if _, err := s.db.ModifyModem("00:1f:43:00:00:01", model.Origin{Source: model.SourceUpgrade}, func(m *model.Modem) {
+		m.Upgraded, m.Firmware, m.TestStatus = true, "B", model.TestPassed
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	order := callOrder(t, s.AddOrder, "POST", nil, `{"customer":"acme","quantity":2,"firmware":"B"}`, http.StatusCreated)
+	id := map[string]string{"id": "1"}
+
+	order = callOrder(t, s.AssignOrderModems, "POST", id, `{"mac_addresses":["00:1f:43:00:00:01","00:1f:43:00:00:02"]}`, http.StatusOK)
+	if order.Assigned != 2 || order.Completed != 1 {
+		t.Errorf("after assigning: %+v", order)
+	}
+	callOrder(t, s.AssignOrderModems, "POST", id, `{"mac_addresses":["00:1f:43:00:00:03"]}`, http.StatusConflict)
+	callOrder(t, s.AssignOrderModems, "POST", id, `{"mac_addresses":["00:1f:43:00:00:09"]}`, http.StatusNotFound)
+	callOrder(t, s.AssignOrderModems, "POST", map[string]string{"id": "x"}, `{"mac_addresses":[]}`, http.StatusBadRequest)
+	callOrder(t, s.GetOrder, "GET", map[string]string{"id": "7"}, "", http.StatusNotFound)
+
+	callOrder(t, s.UnassignOrderModem, "DELETE", map[string]string{"id": "1", "mac": "00:1f:43:00:00:02"}, "", http.StatusNoContent)
+	callOrder(t, s.UnassignOrderModem, "DELETE", map[string]string{"id": "1", "mac": "00:1f:43:00:00:02"}, "", http.StatusNotFound)
+
+	// deleting an assigned modem frees its place in the order
+	r := httptest.NewRequest("DELETE", "/api/v1/modem/00:1f:43:00:00:01", nil)
+	w := httptest.NewRecorder()
+	s.Deletemodem(w, mux.SetURLVars(r, map[string]string{"mac": "00:1f:43:00:00:01"}))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("delete status %d", w.Code)
+	}
+	if order = callOrder(t, s.GetOrder, "GET", id, "", http.StatusOK); order.Assigned != 0 || order.Completed != 0 {
+		t.Errorf("after deleting the modem: %+v", order)
+	}
+
+	callOrder(t, s.AssignOrderModems, "POST", id, `{"mac_addresses":["00:1f:43:00:00:02","00:1f:43:00:00:03"]}`, http.StatusOK)
+	summary := callOrder(t, s.CloseOrder, "POST", id, "", http.StatusOK)
+	if summary.Status != model.OrderClosed || summary.ClosedAt == 0 || summary.Assigned != 2 || summary.Completed != 0 {
+		t.Errorf("summary %+v", summary)
+	}
+	callOrder(t, s.CloseOrder, "POST", id, "", http.StatusConflict)
+	callOrder(t, s.UnassignOrderModem, "DELETE", map[string]string{"id": "1", "mac": "00:1f:43:00:00:02"}, "", http.StatusConflict)
+
+	r = httptest.NewRequest("GET", "/api/v1/orders/1/modems", nil)
+	w = httptest.NewRecorder()
+	s.GetOrderModems(w, mux.SetURLVars(r, id))
+	var modems []model.Modem
+	if err := json.NewDecoder(w.Body).Decode(&modems); err != nil || len(modems) != 2 {
+		t.Errorf("order modems %+v, %v", modems, err)
+	}
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ebobo/modem_prod_go/pkg/escrow"
+	"github.com/ebobo/modem_prod_go/pkg/model"
+	"github.com/ebobo/modem_prod_go/pkg/provision"
+)
+
+// errNoEscrowKey is returned when a password has to be encrypted or decrypted
+// without an escrow key
+var errNoEscrowKey = errors.New("no escrow key is configured")
+
+// modemPasswords returns the passwords to log in to a modem with, in the order
+// to try them: the escrowed ones newest first, the admin password of the
+// profile last setting one and the configured password
+func (s *Server) modemPasswords(mac string) []string {
+	var passwords []string
+	if s.escrow != nil {
+		escrowed, err := s.db.CurrentModemPasswords(mac)
+		if err != nil {
+			provisionLog.Error("failed to get escrowed passwords", "mac", mac, "err", err)
+		}
+		for _, p := range escrowed {
+			password, err := s.escrow.Open(p.Sealed, mac)
+			if err != nil {
+				provisionLog.Error("failed to decrypt escrowed password", "mac", mac, "password_id", p.ID, "err", err)
+				continue
+			}
+			passwords = append(passwords, password)
+		}
+	}
+
+	if p, err := s.db.GetProvisioning(mac); err == nil && p.PasswordProfile != "" {
+		profile, err := s.configProfile(p.PasswordProfile)
+		if err != nil {
+			provisionLog.Error("failed to get the profile password", "mac", mac, "profile", p.PasswordProfile, "err", err)
+		} else if profile.AdminPassword != "" {
+			passwords = append(passwords, profile.AdminPassword)
+		}
+	}
+
+	passwords = append(passwords, s.ssh.Password)
+
+	unique := passwords[:0]
+	for _, password := range passwords {
+		if !slices.Contains(unique, password) {
+			unique = append(unique, password)
+		}
+	}
+	return unique
+}
+
+// profileAAD is what the sealed admin password of a profile is bound to, as
+// escrowed modem passwords are bound to the MAC address
+func profileAAD(name string) string {
+	return "config_profiles/" + name
+}
+
+// configProfile returns a config profile with its admin password decrypted
+func (s *Server) configProfile(name string) (model.ConfigProfile, error) {
+	p, err := s.db.GetConfigProfile(name)
+	if err != nil || !p.PasswordSealed {
+		return p, err
+	}
+	if s.escrow == nil {
+		return p, errNoEscrowKey
+	}
+	if p.AdminPassword, err = s.escrow.Open(p.AdminPassword, profileAAD(name)); err != nil {
+		return p, err
+	}
+	p.PasswordSealed = false
+	return p, nil
+}
+
+// sealProfilePassword encrypts the admin password of a profile before it is
+// stored, if an escrow key is configured
+func (s *Server) sealProfilePassword(p *model.ConfigProfile) error {
+	if s.escrow == nil || p.AdminPassword == "" || p.PasswordSealed {
+		return nil
+	}
+	sealed, err := s.escrow.Seal(p.AdminPassword, profileAAD(p.Name))
+	if err != nil {
+		return err
+	}
+	p.AdminPassword = sealed
+	p.PasswordSealed = true
+	return nil
+}
+
+// sealProfilePasswords encrypts the profile passwords stored before an escrow
+// key was configured
+func (s *Server) sealProfilePasswords() error {
+	profiles, err := s.db.ListConfigProfiles()
+	if err != nil {
+		return err
+	}
+	for _, p := range profiles {
+		if p.AdminPassword == "" || p.PasswordSealed {
+			continue
+		}
+		if err := s.sealProfilePassword(&p); err != nil {
+			return err
+		}
+		if err := s.db.SetConfigProfilePassword(p.Name, p.AdminPassword, true); err != nil {
+			return err
+		}
+		provisionLog.Info("sealed the admin password of config profile", "profile", p.Name)
+	}
+	return nil
+}
+
+// rotatePassword sets the admin password of a profile on a modem, or a
+// generated one if the profile asks for unique passwords. With an escrow key the
+// password is escrowed first, so it is kept even if the outcome of setting it
+// is lost.
+func (s *Server) rotatePassword(ctx context.Context, r provision.Runner, mac string, profile model.ConfigProfile) error {
+	password := profile.AdminPassword
+	if profile.UniquePassword {
+		if s.escrow == nil {
+			return errors.New("the profile sets unique passwords, but " + errNoEscrowKey.Error())
+		}
+		var err error
+		if password, err = escrow.GeneratePassword(); err != nil {
+			return err
+		}
+	}
+	if password == "" {
+		return nil
+	}
+
+	var escrowed model.ModemPassword
+	if s.escrow != nil {
+		sealed, err := s.escrow.Seal(password, mac)
+		if err != nil {
+			return err
+		}
+		escrowed, err = s.db.AddModemPassword(model.ModemPassword{MacAddress: mac, User: s.ssh.User, Sealed: sealed, Profile: profile.Name})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := provision.SetPassword(ctx, r, s.ssh.User, password); err != nil {
+		return err
+	}
+	if escrowed.ID != 0 {
+		return s.db.ActivateModemPassword(escrowed.ID)
+	}
+	return nil
+}
+
+// revealPassword returns the password last set on a modem, decrypted
+func (s *Server) revealPassword(mac string) (model.ModemPassword, error) {
+	p, err := s.db.GetModemPassword(mac)
+	if err != nil {
+		return p, err
+	}
+	if s.escrow == nil {
+		return p, errNoEscrowKey
+	}
+	p.Password, err = s.escrow.Open(p.Sealed, mac)
+	return p, err
+}
+
+// GetModemPassword returns the admin password last set on a modem. Every call is logged.
+func (s *Server) GetModemPassword(w http.ResponseWriter, r *http.Request) {
+	mac := mux.Vars(r)["mac"]
+
+	p, err := s.revealPassword(mac)
+	switch {
+	case errors.Is(err, errNoEscrowKey):
+		writeError(w, http.StatusServiceUnavailable, "failed to get password of modem "+mac+": "+err.Error())
+		return
+	case errors.Is(err, escrow.ErrDecrypt):
+		provisionLog.ErrorContext(r.Context(), "failed to decrypt escrowed password", "mac", mac, "password_id", p.ID, "err", err)
+		writeError(w, http.StatusInternalServerError, "failed to get password of modem "+mac+": "+err.Error())
+		return
+	case err != nil:
+		writeStoreError(w, "failed to get password of modem "+mac, err)
+		return
+	}
+
+	provisionLog.InfoContext(r.Context(), "revealed modem password", "mac", mac, "password_id", p.ID)
+	json.NewEncoder(w).Encode(p)
+}
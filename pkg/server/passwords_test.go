@@ -0,0 +1,309 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ebobo/modem_prod_go/pkg/escrow"
+	"github.com/ebobo/modem_prod_go/pkg/model"
+	"github.com/ebobo/modem_prod_go/pkg/provision"
+	sqlitestore "github.com/ebobo/modem_prod_go/pkg/store/sqlite"
+)
+
+// withEscrow gives a test server an escrow key, as Start does when one is configured
+func withEscrow(t *testing.T, s *Server) {
+	t.Helper()
+
+	sealer, err := escrow.New(bytes.Repeat([]byte{7}, escrow.KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.escrow = sealer
+}
+
+// putTestProfile saves a config profile through the API and returns the status
+func putTestProfile(t *testing.T, s *Server, name string, body string) int {
+	t.Helper()
+
+	r := httptest.NewRequest("PUT", "/api/v1/profiles/"+name, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	s.PutConfigProfile(w, mux.SetURLVars(r, map[string]string{"name": name}))
+	return w.Code
+}
+
+// provisionTestModem records that a modem got the admin password of a profile
+func provisionTestModem(t *testing.T, s *Server, mac string, profile string) {
+	t.Helper()
+
+	addTestModem(t, s, mac)
+	if _, err := s.db.StartProvisioning(mac, profile, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.FinishProvisioning(mac, "", true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+const testProfile = `{"settings":[{"key":"system.system.timezone","value":"UTC"}],"admin_password":"first"}`
+
+func TestPutConfigProfilePassword(t *testing.T) {
+	const mac = "00:1f:43:00:00:01"
+
+	for _, tt := range []struct {
+		name      string
+		escrow    bool
+		provision bool // a modem gets the password before it is changed
+		escrowed  bool // the modem has an escrowed copy of the password
+		body      string
+		status    int
+		want      []string // the passwords to log in to the modem with
+	}{
+		{"unchanged password", false, true, false,
+			`{"settings":[{"key":"system.system.timezone","value":"CET"}]}`, http.StatusOK, []string{"first", "admin"}},
+		{"same password", false, true, false,
+			`{"settings":[{"key":"system.system.timezone","value":"UTC"}],"admin_password":"first"}`, http.StatusOK, []string{"first", "admin"}},
+		{"change without modems", false, false, false,
+			`{"settings":[{"key":"system.system.timezone","value":"UTC"}],"admin_password":"second"}`, http.StatusOK, nil},
+		{"change with modems", false, true, false,
+			`{"settings":[{"key":"system.system.timezone","value":"UTC"}],"admin_password":"second"}`, http.StatusConflict, []string{"first", "admin"}},
+		{"switch to unique passwords with modems", true, true, false,
+			`{"settings":[{"key":"system.system.timezone","value":"UTC"}],"unique_password":true}`, http.StatusConflict, []string{"first", "admin"}},
+		{"change with escrowed modems", true, true, true,
+			`{"settings":[{"key":"system.system.timezone","value":"UTC"}],"admin_password":"second"}`, http.StatusOK, []string{"first", "second", "admin"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			if tt.escrow {
+				withEscrow(t, s)
+			}
+			if status := putTestProfile(t, s, "site", testProfile); status != http.StatusOK {
+				t.Fatalf("status %d saving the profile", status)
+			}
+			if tt.provision {
+				provisionTestModem(t, s, mac, "site")
+			}
+			if tt.escrowed {
+				sealed, err := s.escrow.Seal("first", mac)
+				if err != nil {
+					t.Fatal(err)
+				}
+				p, err := s.db.AddModemPassword(model.ModemPassword{MacAddress: mac, User: s.ssh.User, Sealed: sealed, Profile: "site"})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := s.db.ActivateModemPassword(p.ID); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if status := putTestProfile(t, s, "site", tt.body); status != tt.status {
+				t.Fatalf("status %d, want %d", status, tt.status)
+			}
+			if tt.want == nil {
+				return
+			}
+			if got := s.modemPasswords(mac); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("passwords %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProfilePasswordSealed(t *testing.T) {
+	s := newTestServer(t)
+	withEscrow(t, s)
+
+	if status := putTestProfile(t, s, "site", testProfile); status != http.StatusOK {
+		t.Fatalf("status %d saving the profile", status)
+	}
+	stored, err := s.db.GetConfigProfile("site")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.PasswordSealed || stored.AdminPassword == "first" {
+		t.Fatalf("stored password %q is not sealed", stored.AdminPassword)
+	}
+	if _, err := s.escrow.Open(stored.AdminPassword, "other"); err == nil {
+		t.Error("the password opens bound to another profile")
+	}
+
+	p, err := s.configProfile("site")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.AdminPassword != "first" || p.PasswordSealed {
+		t.Errorf("opened password %q, sealed %v", p.AdminPassword, p.PasswordSealed)
+	}
+
+	s.escrow = nil
+	if _, err := s.configProfile("site"); err != errNoEscrowKey {
+		t.Errorf("opened without a key: %v", err)
+	}
+}
+
+func TestSealProfilePasswords(t *testing.T) {
+	s := newTestServer(t)
+	if status := putTestProfile(t, s, "site", testProfile); status != http.StatusOK {
+		t.Fatalf("status %d saving the profile", status)
+	}
+	before, err := s.db.GetConfigProfile("site")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before.PasswordSealed {
+		t.Fatal("the password is sealed without a key")
+	}
+
+	withEscrow(t, s)
+	if err := s.sealProfilePasswords(); err != nil {
+		t.Fatal(err)
+	}
+	after, err := s.db.GetConfigProfile("site")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !after.PasswordSealed || after.UpdatedAt != before.UpdatedAt {
+		t.Errorf("sealed %v, updated at %d, want %d", after.PasswordSealed, after.UpdatedAt, before.UpdatedAt)
+	}
+	p, err := s.configProfile("site")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.AdminPassword != "first" {
+		t.Errorf("opened password %q", p.AdminPassword)
+	}
+}
+
+// passwdRunner records the commands run on a modem, passwd fails if fail is set
+type passwdRunner struct {
+	fail     bool
+	commands []string
+}
+
+func (r *passwdRunner) Run(ctx context.Context, command string) (string, error) {
+	r.commands = append(r.commands, command)
+	if r.fail {
+		return "", &provision.ExitError{Command: "passwd", Status: 1}
+	}
+	return "", nil
+}
+
+func TestRotatePassword(t *testing.T) {
+	const mac = "00:1f:43:00:00:01"
+	unique := model.ConfigProfile{Name: "site", UniquePassword: true}
+
+	t.Run("unique password is escrowed", func(t *testing.T) {
+		s := newTestServer(t)
+		withEscrow(t, s)
+		addTestModem(t, s, mac)
+
+		r := &passwdRunner{}
+		if err := s.rotatePassword(context.Background(), r, mac, unique); err != nil {
+			t.Fatal(err)
+		}
+		p, err := s.revealPassword(mac)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(p.Password) != escrow.PasswordLength || p.Status != model.PasswordActive || p.Profile != "site" {
+			t.Errorf("escrowed %+v", p)
+		}
+		if len(r.commands) != 1 || !strings.Contains(r.commands[0], p.Password) {
+			t.Errorf("ran %q, want passwd with %q", r.commands, p.Password)
+		}
+		if got := s.modemPasswords(mac); got[0] != p.Password {
+			t.Errorf("passwords %q, want the escrowed one first", got)
+		}
+
+		// a new password is generated for every rotation
+		if err := s.rotatePassword(context.Background(), r, mac, unique); err != nil {
+			t.Fatal(err)
+		}
+		if again, _ := s.revealPassword(mac); again.Password == p.Password || again.ID == p.ID {
+			t.Errorf("rotated to the same password %+v", again)
+		}
+	})
+
+	t.Run("failed passwd stays pending", func(t *testing.T) {
+		s := newTestServer(t)
+		withEscrow(t, s)
+		addTestModem(t, s, mac)
+
+		if err := s.rotatePassword(context.Background(), &passwdRunner{fail: true}, mac, unique); err == nil {
+			t.Fatal("no error")
+		}
+		if _, err := s.revealPassword(mac); !errors.Is(err, sqlitestore.ErrNotFound) {
+			t.Errorf("revealed a password that was not set: %v", err)
+		}
+		// the modem may have it anyway, so it is still tried
+		if got := s.modemPasswords(mac); len(got) != 2 {
+			t.Errorf("passwords %q, want the pending one and the configured one", got)
+		}
+	})
+
+	t.Run("unique password needs an escrow key", func(t *testing.T) {
+		s := newTestServer(t)
+		addTestModem(t, s, mac)
+
+		r := &passwdRunner{}
+		if err := s.rotatePassword(context.Background(), r, mac, unique); err == nil || len(r.commands) > 0 {
+			t.Errorf("rotated without a key: %v, %q", err, r.commands)
+		}
+	})
+}
+
+func TestGetModemPassword(t *testing.T) {
+	const mac = "00:1f:43:00:00:01"
+
+	for _, tt := range []struct {
+		name   string
+		escrow bool
+		set    bool
+		status int
+	}{
+		{"revealed", true, true, http.StatusOK},
+		{"never set", true, false, http.StatusNotFound},
+		{"no escrow key", false, true, http.StatusServiceUnavailable},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			withEscrow(t, s)
+			addTestModem(t, s, mac)
+			if tt.set {
+				err := s.rotatePassword(context.Background(), &passwdRunner{}, mac, model.ConfigProfile{Name: "site", AdminPassword: "shared"})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !tt.escrow {
+				s.escrow = nil
+			}
+
+			r := httptest.NewRequest("GET", "/api/v1/modem/"+mac+"/password", nil)
+			w := httptest.NewRecorder()
+			s.GetModemPassword(w, mux.SetURLVars(r, map[string]string{"mac": mac}))
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var p model.ModemPassword
+			if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+				t.Fatal(err)
+			}
+			if p.Password != "shared" || p.MacAddress != mac || p.User != s.ssh.User {
+				t.Errorf("got %+v", p)
+			}
+		})
+	}
+}
@@ -0,0 +1,294 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ebobo/modem_prod_go/pkg/eventbus"
+	"github.com/ebobo/modem_prod_go/pkg/model"
+	"github.com/ebobo/modem_prod_go/pkg/provision"
+	sqlitestore "github.com/ebobo/modem_prod_go/pkg/store/sqlite"
+)
+
+// provisionTimeout limits applying a profile to a modem, including reading it back
+const provisionTimeout = 2 * time.Minute
+
+// provisionRequest is the body of a provisioning request, an empty profile
+// uses the config profile of the modem's work order
+type provisionRequest struct {
+	Profile string `json:"profile"`
+}
+
+// redactProfile removes the admin password of a profile returned by the API
+func redactProfile(p model.ConfigProfile) model.ConfigProfile {
+	p.AdminPassword = ""
+	return p
+}
+
+func (s *Server) GetListConfigProfiles(w http.ResponseWriter, r *http.Request) {
+	profiles, err := s.db.ListConfigProfiles()
+	if err != nil {
+		writeStoreError(w, "failed to get config profiles", err)
+		return
+	}
+	for i := range profiles {
+		profiles[i] = redactProfile(profiles[i])
+	}
+	json.NewEncoder(w).Encode(profiles)
+}
+
+func (s *Server) GetConfigProfile(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	p, err := s.db.GetConfigProfile(name)
+	if err != nil {
+		writeStoreError(w, "failed to get config profile "+name, err)
+		return
+	}
+	json.NewEncoder(w).Encode(redactProfile(p))
+}
+
+// PutConfigProfile adds or replaces a profile. The admin password is never
+// returned, so a replaced profile keeps it unless the request sets another.
+// The password can not be changed while modems have it and it is not escrowed
+// for them, as they could not be logged in to again.
+func (s *Server) PutConfigProfile(w http.ResponseWriter, r *http.Request) {
+	var p model.ConfigProfile
+	if !readJSON(w, r, &p) {
+		return
+	}
+	p.Name = mux.Vars(r)["name"]
+
+	if err := p.Validate(); err != nil {
+		writeStoreError(w, "failed to save config profile", err)
+		return
+	}
+
+	old, err := s.configProfile(p.Name)
+	switch {
+	case errors.Is(err, errNoEscrowKey):
+		writeError(w, http.StatusServiceUnavailable, "failed to save config profile: the admin password is sealed and "+err.Error())
+		return
+	case err != nil && !errors.Is(err, sqlitestore.ErrNotFound):
+		writeStoreError(w, "failed to save config profile", err)
+		return
+	}
+	if p.AdminPassword == "" && !p.UniquePassword {
+		p.AdminPassword = old.AdminPassword
+	}
+
+	if old.AdminPassword != "" && p.AdminPassword != old.AdminPassword {
+		modems, err := s.db.CountUnescrowedProfilePasswords(p.Name)
+		if err != nil {
+			writeStoreError(w, "failed to save config profile", err)
+			return
+		}
+		if modems > 0 {
+			writeError(w, http.StatusConflict, fmt.Sprintf(
+				"failed to save config profile: %d modems have the admin password of the profile and it is not escrowed for them, it can not be changed", modems))
+			return
+		}
+	}
+
+	if err := s.sealProfilePassword(&p); err != nil {
+		writeStoreError(w, "failed to save config profile", err)
+		return
+	}
+	p, err = s.db.PutConfigProfile(p)
+	if err != nil {
+		writeStoreError(w, "failed to save config profile", err)
+		return
+	}
+	httpLog.InfoContext(r.Context(), "saved config profile", "profile", p.Name, "settings", len(p.Settings))
+	json.NewEncoder(w).Encode(redactProfile(p))
+}
+
+// DeleteConfigProfile deletes a profile, unless an open order uses it or modems
+// have its admin password
+func (s *Server) DeleteConfigProfile(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	if err := s.db.DeleteConfigProfile(name); err != nil {
+		writeStoreError(w, "failed to delete config profile "+name, err)
+		return
+	}
+	httpLog.InfoContext(r.Context(), "deleted config profile", "profile", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// modemProfile returns a modem and the profile to provision it with, the
+// named one or that of its work order. It writes an error and returns false
+// if either is not found.
+func (s *Server) modemProfile(w http.ResponseWriter, mac string, name string) (model.Modem, model.ConfigProfile, bool) {
+	modem, err := s.db.GetModem(mac)
+	if err != nil {
+		writeStoreError(w, "failed to get modem "+mac, err)
+		return modem, model.ConfigProfile{}, false
+	}
+
+	if name == "" {
+		order, err := s.db.GetModemOrder(mac)
+		if err != nil && !errors.Is(err, sqlitestore.ErrNotFound) {
+			writeStoreError(w, "failed to get the order of modem "+mac, err)
+			return modem, model.ConfigProfile{}, false
+		}
+		if order.ConfigProfile == "" {
+			writeError(w, http.StatusConflict, "modem "+mac+" is not assigned to an order with a config profile, name a profile")
+			return modem, model.ConfigProfile{}, false
+		}
+		name = order.ConfigProfile
+	}
+
+	p, err := s.configProfile(name)
+	if errors.Is(err, errNoEscrowKey) {
+		writeError(w, http.StatusServiceUnavailable, "failed to get config profile "+name+": the admin password is sealed and "+err.Error())
+		return modem, p, false
+	}
+	if err != nil {
+		writeStoreError(w, "failed to get config profile "+name, err)
+		return modem, p, false
+	}
+	return modem, p, true
+}
+
+// dialModem connects to a modem over SSH, trying the passwords it may have
+func (s *Server) dialModem(ctx context.Context, modem model.Modem) (*provision.SSH, error) {
+	if modem.IPV6 == "" || modem.IPV6 == "::" {
+		return nil, errors.New("the address of the modem is not known")
+	}
+	if s.discoveryCfg.Interface == "" {
+		return nil, errors.New("no discovery interface is configured to reach modems on")
+	}
+
+	addr := modemAddress(modem, s.discoveryCfg.Interface, s.ssh.Port)
+	conn, _, err := provision.Dial(ctx, addr, s.ssh.User, s.modemPasswords(modem.MacAddress), s.ssh.Timeout)
+	return conn, err
+}
+
+// ProvisionModem applies a config profile to a modem in the background, the
+// outcome is published as a provision.status event
+func (s *Server) ProvisionModem(w http.ResponseWriter, r *http.Request) {
+	mac := mux.Vars(r)["mac"]
+
+	req := provisionRequest{}
+	if r.ContentLength != 0 && !readJSON(w, r, &req) {
+		return
+	}
+
+	modem, profile, ok := s.modemProfile(w, mac, req.Profile)
+	if !ok {
+		return
+	}
+	if modem.IPV6 == "" || modem.IPV6 == "::" {
+		writeError(w, http.StatusConflict, "the address of modem "+mac+" is not known")
+		return
+	}
+	if profile.UniquePassword && s.escrow == nil {
+		writeError(w, http.StatusConflict, "profile "+profile.Name+" sets unique passwords, but "+errNoEscrowKey.Error())
+		return
+	}
+
+	p, err := s.db.StartProvisioning(mac, profile.Name, apiOrigin(r).Actor)
+	if err != nil {
+		writeStoreError(w, "failed to provision modem "+mac, err)
+		return
+	}
+	s.bus.Publish(eventbus.Event{Type: eventbus.Provisioning, MacAddress: mac, Data: p})
+	httpLog.InfoContext(r.Context(), "provisioning modem", "mac", mac, "profile", profile.Name)
+
+	go s.provisionModem(modem, profile)
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(p)
+}
+
+// provisionModem applies the settings of a profile, verifies them by reading
+// them back and then sets the admin password of the profile, or a unique one
+func (s *Server) provisionModem(modem model.Modem, profile model.ConfigProfile) {
+	log := provisionLog.With("mac", modem.MacAddress, "profile", profile.Name, "job", newJobID())
+	log.Info("applying config profile", "settings", len(profile.Settings))
+	start := time.Now()
+
+	ctx, cancel := context.WithTimeout(s.ctx, provisionTimeout)
+	defer cancel()
+
+	err := func() error {
+		conn, err := s.dialModem(ctx, modem)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		if err := provision.Apply(ctx, conn, profile.Settings); err != nil {
+			return err
+		}
+		if _, err := provision.Verify(ctx, conn, profile.Settings); err != nil {
+			return err
+		}
+		return s.rotatePassword(ctx, conn, modem.MacAddress, profile)
+	}()
+
+	errMsg := ""
+	if err != nil {
+		log.Warn("failed to provision modem", "err", err, "duration", time.Since(start))
+		errMsg = err.Error()
+	} else {
+		log.Info("provisioned modem", "duration", time.Since(start))
+	}
+
+	p, err := s.db.FinishProvisioning(modem.MacAddress, errMsg, err == nil && profile.AdminPassword != "")
+	if err != nil {
+		log.Error("failed to update provisioning", "err", err)
+		return
+	}
+	s.bus.Publish(eventbus.Event{Type: eventbus.Provisioning, MacAddress: modem.MacAddress, Data: p})
+}
+
+// GetModemProvisioning returns the latest provisioning of a modem
+func (s *Server) GetModemProvisioning(w http.ResponseWriter, r *http.Request) {
+	mac := mux.Vars(r)["mac"]
+
+	p, err := s.db.GetProvisioning(mac)
+	if err != nil {
+		writeStoreError(w, "failed to get provisioning of modem "+mac, err)
+		return
+	}
+	json.NewEncoder(w).Encode(p)
+}
+
+// GetModemConfigDiff reads the settings of a profile from a modem and compares
+// them with the desired values, ?profile= selects another profile than that of
+// the modem's work order
+func (s *Server) GetModemConfigDiff(w http.ResponseWriter, r *http.Request) {
+	mac := mux.Vars(r)["mac"]
+
+	modem, profile, ok := s.modemProfile(w, mac, r.URL.Query().Get("profile"))
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), provisionTimeout)
+	defer cancel()
+
+	conn, err := s.dialModem(ctx, modem)
+	if err != nil {
+		provisionLog.WarnContext(ctx, "failed to connect to modem", "mac", mac, "err", err)
+		writeError(w, http.StatusBadGateway, "failed to connect to modem "+mac+": "+err.Error())
+		return
+	}
+	defer conn.Close()
+
+	diffs, err := provision.Compare(ctx, conn, profile.Settings)
+	if err != nil {
+		provisionLog.WarnContext(ctx, "failed to read modem config", "mac", mac, "err", err)
+		writeError(w, http.StatusBadGateway, "failed to read the config of modem "+mac+": "+err.Error())
+		return
+	}
+	json.NewEncoder(w).Encode(diffs)
+}
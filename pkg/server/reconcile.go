@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/ebobo/modem_prod_go/pkg/eventbus"
+	"github.com/ebobo/modem_prod_go/pkg/model"
+
+	"github.com/ebobo/modem_prod_go/pkg/reconcile"
+	sqlitestore "github.com/ebobo/modem_prod_go/pkg/store/sqlite"
+)
+
+// reconcileBufferSize is how many modem events the watcher can fall behind
+const reconcileBufferSize = 256
+
+// reconciledFields are the modem fields compared with the expected units
+var reconciledFields = []string{"serial", "imei", "model", "firmware"}
+
+// GetReconciliation compares the expected units with the known modems and
+// reports the missing, unexpected and mismatched ones. ?batch= limits the
+// missing and mismatched units to one supplier batch.
+func (s *Server) GetReconciliation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	expected, err := s.db.ListExpectedUnits("")
+	if err != nil {
+		writeStoreError(w, "failed to get expected units", err)
+		return
+	}
+	page, err := s.db.ListModems(sqlitestore.ModemQuery{})
+	if err != nil {
+		writeStoreError(w, "failed to get modems", err)
+		return
+	}
+
+	report := reconcile.Compare(expected, page.Modems, r.URL.Query().Get("batch"))
+	json.NewEncoder(w).Encode(report)
+}
+
+// reconcileWatcher checks modems as they are added and changed, and publishes a
+// discrepancy event when a modem is not expected or a field read from it, e.g.
+// the serial read over SSH, differs from the supplier list.
+type reconcileWatcher struct {
+	db  *sqlitestore.SqliteStore
+	bus *eventbus.Bus
+}
+
+// Run checks modem events until ctx is done
+func (w *reconcileWatcher) Run(ctx context.Context) {
+	filter := eventbus.Filter{Types: []string{eventbus.ModemAdded, eventbus.ModemUpdated}}
+	for {
+		sub := w.bus.Subscribe(filter, 0, reconcileBufferSize)
+		w.watch(ctx, sub)
+		lagged := sub.Lagged()
+		sub.Close()
+		if !lagged {
+			return
+		}
+		reconcileLog.Warn("fell behind modem events, some discrepancies may not have been reported")
+	}
+}
+
+// watch handles the events of sub until ctx is done or sub is closed
+func (w *reconcileWatcher) watch(ctx context.Context, sub *eventbus.Subscription) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			switch data := e.Data.(type) {
+			case model.Modem:
+				w.checkAdded(data)
+			case model.ModemChange:
+				w.checkChanged(data)
+			}
+		}
+	}
+}
+
+// checkAdded reports a new modem that is not expected, or that differs from its expected unit
+func (w *reconcileWatcher) checkAdded(modem model.Modem) {
+	unit, err := w.db.GetExpectedUnit(modem.MacAddress)
+	if errors.Is(err, sqlitestore.ErrNotFound) {
+		// Without a supplier list every modem would be unexpected
+		count, err := w.db.CountExpectedUnits()
+		if err != nil {
+			reconcileLog.Error("failed to count expected units", "err", err)
+			return
+		}
+		if count > 0 {
+			w.publish(model.Discrepancy{MacAddress: modem.MacAddress, Kind: model.DiscrepancyUnexpected})
+		}
+		return
+	}
+	if err != nil {
+		reconcileLog.Error("failed to get expected unit", "mac", modem.MacAddress, "err", err)
+		return
+	}
+
+	for _, d := range unit.Compare(modem) {
+		w.publish(d)
+	}
+}
+
+// checkChanged reports the compared fields of a change that now differ from the expected unit
+func (w *reconcileWatcher) checkChanged(change model.ModemChange) {
+	var changed []string
+	for _, c := range change.Changes {
+		if slices.Contains(reconciledFields, c.Field) {
+			changed = append(changed, c.Field)
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+
+	unit, err := w.db.GetExpectedUnit(change.Modem.MacAddress)
+	if errors.Is(err, sqlitestore.ErrNotFound) {
+		return
+	}
+	if err != nil {
+		reconcileLog.Error("failed to get expected unit", "mac", change.Modem.MacAddress, "err", err)
+		return
+	}
+
+	for _, d := range unit.Compare(change.Modem) {
+		if slices.Contains(changed, d.Field) {
+			w.publish(d)
+		}
+	}
+}
+
+func (w *reconcileWatcher) publish(d model.Discrepancy) {
+	if d.Kind == model.DiscrepancyUnexpected {
+		reconcileLog.Warn("discovered modem is not expected", "mac", d.MacAddress)
+	} else {
+		reconcileLog.Warn(d.Field+" differs from supplier list", "mac", d.MacAddress, "batch", d.Batch, "expected", d.Expected, "actual", d.Actual)
+	}
+	w.bus.Publish(eventbus.Event{Type: eventbus.Discrepancy, MacAddress: d.MacAddress, Data: d})
+}
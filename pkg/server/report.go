@@ -0,0 +1,147 @@
+package server
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+	"github.com/ebobo/modem_prod_go/pkg/report"
+	sqlitestore "github.com/ebobo/modem_prod_go/pkg/store/sqlite"
+)
+
+// traveler gathers the report of a modem. The order is looked up if it is nil,
+// a modem that was never assigned, provisioned or tested still has a report.
+func (s *Server) traveler(modem model.Modem, order *model.Order) (report.Traveler, error) {
+	t := report.Traveler{Modem: modem, Order: order, GeneratedAt: time.Now()}
+
+	if t.Order == nil {
+		o, err := s.db.GetModemOrder(modem.MacAddress)
+		if err == nil {
+			t.Order = &o
+		} else if !errors.Is(err, sqlitestore.ErrNotFound) {
+			return t, fmt.Errorf("failed to get order: %w", err)
+		}
+	}
+	p, err := s.db.GetProvisioning(modem.MacAddress)
+	if err == nil {
+		t.Provisioning = &p
+	} else if !errors.Is(err, sqlitestore.ErrNotFound) {
+		return t, fmt.Errorf("failed to get provisioning: %w", err)
+	}
+	run, err := s.db.GetTestRun(modem.MacAddress)
+	if err == nil {
+		t.TestRun = &run
+	} else if !errors.Is(err, sqlitestore.ErrNotFound) {
+		return t, fmt.Errorf("failed to get test run: %w", err)
+	}
+	t.History, err = s.db.ListModemEvents(modem.MacAddress, 0, 0)
+	if err != nil {
+		return t, fmt.Errorf("failed to get history: %w", err)
+	}
+	return t, nil
+}
+
+// reportFormat returns ?format=, def if it is not set, writing an error if it is not supported
+func reportFormat(w http.ResponseWriter, r *http.Request, def string) (string, bool) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = def
+	}
+	if !slices.Contains(report.Formats, format) {
+		writeError(w, http.StatusBadRequest, "format must be one of "+strings.Join(report.Formats, ", "))
+		return format, false
+	}
+	return format, true
+}
+
+// GetModemReport returns the production traveler of a modem as ?format=html (default) or pdf
+func (s *Server) GetModemReport(w http.ResponseWriter, r *http.Request) {
+	mac := mux.Vars(r)["mac"]
+	format, ok := reportFormat(w, r, "html")
+	if !ok {
+		return
+	}
+
+	modem, err := s.db.GetModem(mac)
+	if err != nil {
+		writeStoreError(w, "failed to get modem "+mac, err)
+		return
+	}
+	t, err := s.traveler(modem, nil)
+	if err != nil {
+		writeStoreError(w, "failed to get report of modem "+mac, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", report.ContentType(format))
+	if format != "html" {
+		w.Header().Set("Content-Disposition", `attachment; filename="`+report.Filename(mac, format)+`"`)
+	}
+	if err := t.Write(w, format); err != nil {
+		httpLog.WarnContext(r.Context(), "report aborted", "mac", mac, "format", format, "err", err)
+	}
+}
+
+// GetOrderReports streams a zip file with the travelers of all modems of a
+// work order, as ?format=pdf (default) or html
+func (s *Server) GetOrderReports(w http.ResponseWriter, r *http.Request) {
+	id, ok := orderID(w, r)
+	if !ok {
+		return
+	}
+	format, ok := reportFormat(w, r, "pdf")
+	if !ok {
+		return
+	}
+
+	order, err := s.db.GetOrder(id)
+	if err != nil {
+		writeStoreError(w, "failed to get order", err)
+		return
+	}
+	modems, err := s.db.ListOrderModems(id)
+	if err != nil {
+		writeStoreError(w, "failed to get modems of order", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="order-%d-travelers.zip"`, id))
+
+	rc := http.NewResponseController(w)
+	zw := zip.NewWriter(w)
+	for _, modem := range modems {
+		rc.SetWriteDeadline(time.Now().Add(exportPageTimeout))
+
+		t, err := s.traveler(modem, &order)
+		if err != nil {
+			// The status is sent already, a truncated file is all that can be done
+			httpLog.ErrorContext(r.Context(), "order reports failed", "order", id, "mac", modem.MacAddress, "err", err)
+			return
+		}
+		f, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     report.Filename(modem.MacAddress, format),
+			Method:   zip.Deflate,
+			Modified: t.GeneratedAt,
+		})
+		if err == nil {
+			err = t.Write(f, format)
+		}
+		if err != nil {
+			httpLog.WarnContext(r.Context(), "order reports aborted", "order", id, "mac", modem.MacAddress, "err", err)
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		httpLog.WarnContext(r.Context(), "order reports aborted", "order", id, "err", err)
+		return
+	}
+	httpLog.InfoContext(r.Context(), "exported order reports", "order", id, "format", format, "count", len(modems))
+}
@@ -6,62 +6,42 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ebobo/modem_prod_go/pkg/model"
+	sqlitestore "github.com/ebobo/modem_prod_go/pkg/store/sqlite"
+	"github.com/ebobo/modem_prod_go/pkg/utility"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/cors"
 )
 
 func (s *Server) startHTTP() error {
-	m := mux.NewRouter()
-
-	// Add CORS
+	// Add CORS, credentials are only allowed for configured origins
 	cors := cors.New(cors.Options{
-		AllowCredentials: true,
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"POST", "GET", "OPTIONS", "PUT", "DELETE"},
+		AllowCredentials: len(s.corsOrigins) > 0,
+		AllowedOrigins:   s.corsOrigins,
+		AllowedMethods:   []string{"POST", "GET", "OPTIONS", "PUT", "PATCH", "DELETE"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-API-Key", "X-Requested-With", "If-Match", "If-None-Match", "Last-Event-ID"},
+		ExposedHeaders:   []string{"ETag", "X-Total-Count", "X-Next-Cursor"},
 		MaxAge:           31,
 		Debug:            false,
 	})
 
-	// This is where you add other stuff you want to map in the mux
-
-	// Config endpoint
-	m.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintf(w, "Hello, Welcome to the Modem Production Server !")
-	}).Methods("GET")
-
-	// Get All modems
-	m.HandleFunc("/api/v1/modems", s.GetListmodems).Methods("GET")
-
-	// Add new modem
-	m.HandleFunc("/api/v1/modem", s.Addmodem).Methods("POST")
-
-	// Get modem by MacAddress
-	m.HandleFunc("/api/v1/modem/{mac}", s.Getmodem).Methods("GET")
-
-	// Update modem by MacAddress can use PATCH or PUT
-	m.HandleFunc("/api/v1/modem/{mac}", s.Updatemodem).Methods("PUT")
-
-	// Delete modem by MacAddress
-	m.HandleFunc("/api/v1/modem/{mac}", s.Deletemodem).Methods("DELETE")
-
-	// Update modem state by MacAddress
-	m.HandleFunc("/api/v1/modem/{mac}/state", s.SetModemState).Methods("PUT")
-
-	// Update modem upgrade progress by MacAddress
-	m.HandleFunc("/api/v1/modem/{mac}/progress", s.SetModemProgress).Methods("PUT")
-
 	httpServer := &http.Server{
 		Addr:              s.httpListenAddr,
-		Handler:           handlers.ProxyHeaders(cors.Handler(m)),
-		ReadTimeout:       (10 * time.Second),
+		Handler:           handlers.ProxyHeaders(accessLog(cors.Handler(s.routes()))),
+		ReadTimeout:       s.httpReadTime,
 		ReadHeaderTimeout: (8 * time.Second),
-		WriteTimeout:      (45 * time.Second),
+		WriteTimeout:      s.httpWriteTime,
+	}
+	if s.certs != nil {
+		httpServer.TLSConfig = s.certs.tlsConfig(s.requireClient)
 	}
 
 	// Set up shutdown handler
@@ -69,56 +49,233 @@ func (s *Server) startHTTP() error {
 		<-s.ctx.Done()
 		err := httpServer.Shutdown(context.Background())
 		if err != nil {
-			log.Printf("error shutting down HTTP interface '%s': %v", s.httpListenAddr, err)
+			httpLog.Error("error shutting down HTTP interface", "addr", s.httpListenAddr, "err", err)
 		}
 	}()
 
 	// Start HTTP server
 	go func() {
-		log.Printf("starting HTTP interface '%s'", s.httpListenAddr)
+		if s.certs != nil {
+			httpLog.Info("starting HTTPS interface", "addr", s.httpListenAddr)
+		} else {
+			httpLog.Info("starting HTTP interface", "addr", s.httpListenAddr)
+		}
 
 		// This isn't entirely true and really represents a race condition, but
 		// doing this properly is a pain in the neck.
 		s.httpStarted.Done()
 
-		err := httpServer.ListenAndServe()
-		if err == http.ErrServerClosed {
-			err = errors.New("")
+		var err error
+		if s.certs != nil {
+			// The certificates come from the TLS config so they can be reloaded
+			err = httpServer.ListenAndServeTLS("", "")
+		} else {
+			err = httpServer.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			httpLog.Error("HTTP interface down", "addr", s.httpListenAddr, "err", err)
+		} else {
+			httpLog.Info("HTTP interface down", "addr", s.httpListenAddr)
 		}
-
-		log.Printf("HTTP interface '%s' down %v", s.httpListenAddr, err)
 		s.httpStopped.Done()
 	}()
 
 	return nil
 }
 
+// routes returns the router of the REST API. The routes are documented in openapi.json.
+func (s *Server) routes() *mux.Router {
+	m := mux.NewRouter()
+
+	// Unknown routes get the same JSON errors as the handlers
+	m.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "no such endpoint")
+	})
+	m.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	})
+
+	// Count and time every request by route
+	m.Use(instrumentHTTP)
+	m.Use(jsonResponses)
+
+	// This is where you add other stuff you want to map in the mux
+
+	// Health, readiness and build information
+	m.HandleFunc("/healthz", s.GetHealth).Methods("GET")
+	m.HandleFunc("/readyz", s.GetReadiness).Methods("GET")
+	m.HandleFunc("/api/v1/version", s.GetVersion).Methods("GET")
+	m.HandleFunc("/api/v1/openapi.json", s.GetOpenAPI).Methods("GET")
+
+	// Config endpoint
+	m.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "Hello, Welcome to the Modem Production Server !")
+	}).Methods("GET")
+
+	// The routes below require an api key with at least the given role
+
+	// Get modems, filtered by ?state=, ?model=, ?firmware=, ?upgraded=, ?sim_provider=,
+	// ?test_status=, ?switch_port=, ?updated_from= and ?updated_to=, sorted by ?sort= and paginated by ?limit= and ?cursor=
+	m.Handle("/api/v1/modems", s.require(model.RoleViewer, s.GetListmodems)).Methods("GET")
+	m.Handle("/api/v1/modems/export", s.require(model.RoleViewer, s.ExportModems)).Methods("GET")
+
+	// Import the expected units of a supplier batch, as CSV or JSON, ?mode=insert|upsert and ?dry_run=
+	m.Handle("/api/v1/modems/import", s.require(model.RoleEngineer, s.ImportModems)).Methods("POST")
+
+	// Compare the expected units with the known modems, ?batch= limits the report to one batch
+	m.Handle("/api/v1/reconciliation", s.require(model.RoleViewer, s.GetReconciliation)).Methods("GET")
+
+	// Add new modem
+	m.Handle("/api/v1/modem", s.require(model.RoleEngineer, s.Addmodem)).Methods("POST")
+
+	// Get modem by MacAddress
+	m.Handle("/api/v1/modem/{mac}", s.require(model.RoleViewer, s.Getmodem)).Methods("GET")
+
+	// Replace modem by MacAddress
+	m.Handle("/api/v1/modem/{mac}", s.require(model.RoleEngineer, s.Updatemodem)).Methods("PUT")
+
+	// Partially update modem by MacAddress with a JSON merge patch
+	m.Handle("/api/v1/modem/{mac}", s.require(model.RoleEngineer, s.Patchmodem)).Methods("PATCH")
+
+	// Delete modem by MacAddress
+	m.Handle("/api/v1/modem/{mac}", s.require(model.RoleEngineer, s.Deletemodem)).Methods("DELETE")
+
+	// Update modem state by MacAddress
+	m.Handle("/api/v1/modem/{mac}/state", s.require(model.RoleOperator, s.SetModemState)).Methods("PUT")
+
+	// Update modem upgrade progress by MacAddress
+	m.Handle("/api/v1/modem/{mac}/progress", s.require(model.RoleOperator, s.SetModemProgress)).Methods("PUT")
+
+	// Make discovery read or upgrade a modem again, or power cycle its switch port
+	m.Handle("/api/v1/modem/{mac}/reread", s.require(model.RoleOperator, s.RereadModem)).Methods("POST")
+	m.Handle("/api/v1/modem/{mac}/reupgrade", s.require(model.RoleOperator, s.ReupgradeModem)).Methods("POST")
+	m.Handle("/api/v1/modem/{mac}/power-cycle", s.require(model.RoleOperator, s.PowerCycleModem)).Methods("POST")
+
+	// Render the label of a modem as ZPL, or queue printing it
+	m.Handle("/api/v1/modem/{mac}/label", s.require(model.RoleViewer, s.GetModemLabel)).Methods("GET")
+	m.Handle("/api/v1/modem/{mac}/label", s.require(model.RoleOperator, s.PrintModemLabel)).Methods("POST")
+
+	// Apply a config profile to a modem, get the outcome, or compare the modem's config with the profile
+	m.Handle("/api/v1/modem/{mac}/provision", s.require(model.RoleViewer, s.GetModemProvisioning)).Methods("GET")
+	m.Handle("/api/v1/modem/{mac}/provision", s.require(model.RoleOperator, s.ProvisionModem)).Methods("POST")
+	m.Handle("/api/v1/modem/{mac}/config-diff", s.require(model.RoleViewer, s.GetModemConfigDiff)).Methods("GET")
+
+	// Reveal the escrowed admin password of a modem, every call is logged
+	m.Handle("/api/v1/modem/{mac}/password", s.require(model.RoleAdmin, s.GetModemPassword)).Methods("GET")
+
+	// Run the functional tests on a modem, get its latest test run or all of them
+	m.Handle("/api/v1/modem/{mac}/test", s.require(model.RoleViewer, s.GetModemTestRun)).Methods("GET")
+	m.Handle("/api/v1/modem/{mac}/test", s.require(model.RoleOperator, s.TestModem)).Methods("POST")
+	m.Handle("/api/v1/modem/{mac}/tests", s.require(model.RoleViewer, s.GetListModemTestRuns)).Methods("GET")
+
+	// Get the status of a modem's SIM at the carrier, queue activating or suspending it
+	m.Handle("/api/v1/modem/{mac}/sim", s.require(model.RoleViewer, s.GetModemSIM)).Methods("GET")
+	m.Handle("/api/v1/modem/{mac}/sim/activate", s.require(model.RoleOperator, s.ActivateModemSIM)).Methods("POST")
+	m.Handle("/api/v1/modem/{mac}/sim/suspend", s.require(model.RoleOperator, s.SuspendModemSIM)).Methods("POST")
+
+	// Get the production traveler of a modem as HTML or PDF
+	m.Handle("/api/v1/modem/{mac}/report", s.require(model.RoleViewer, s.GetModemReport)).Methods("GET")
+
+	// Get modem change history by MacAddress, optionally limited by ?from= and ?to= unix timestamps
+	m.Handle("/api/v1/modem/{mac}/history", s.require(model.RoleViewer, s.GetModemHistory)).Methods("GET")
+
+	// Live events as Server-Sent Events or over a WebSocket, filtered by ?mac= and ?type=
+	m.Handle("/api/v1/events", s.require(model.RoleViewer, s.GetEvents)).Methods("GET")
+	m.Handle("/api/v1/events/ws", s.require(model.RoleViewer, s.GetEventsWebSocket)).Methods("GET")
+
+	// Work orders
+	m.Handle("/api/v1/orders", s.require(model.RoleViewer, s.GetListOrders)).Methods("GET")
+	m.Handle("/api/v1/orders", s.require(model.RoleEngineer, s.AddOrder)).Methods("POST")
+	m.Handle("/api/v1/orders/{id}", s.require(model.RoleViewer, s.GetOrder)).Methods("GET")
+	m.Handle("/api/v1/orders/{id}/modems", s.require(model.RoleViewer, s.GetOrderModems)).Methods("GET")
+	m.Handle("/api/v1/orders/{id}/modems", s.require(model.RoleOperator, s.AssignOrderModems)).Methods("POST")
+	m.Handle("/api/v1/orders/{id}/modems/{mac}", s.require(model.RoleOperator, s.UnassignOrderModem)).Methods("DELETE")
+	m.Handle("/api/v1/orders/{id}/assign", s.require(model.RoleOperator, s.AutoAssignOrderModems)).Methods("POST")
+	m.Handle("/api/v1/orders/{id}/close", s.require(model.RoleEngineer, s.CloseOrder)).Methods("POST")
+	m.Handle("/api/v1/orders/{id}/reports", s.require(model.RoleViewer, s.GetOrderReports)).Methods("GET")
+
+	// Label templates and print jobs
+	m.Handle("/api/v1/labels/templates", s.require(model.RoleViewer, s.GetListLabelTemplates)).Methods("GET")
+	m.Handle("/api/v1/labels/templates/{name}", s.require(model.RoleViewer, s.GetLabelTemplate)).Methods("GET")
+	m.Handle("/api/v1/labels/templates/{name}", s.require(model.RoleEngineer, s.PutLabelTemplate)).Methods("PUT")
+	m.Handle("/api/v1/labels/templates/{name}", s.require(model.RoleEngineer, s.DeleteLabelTemplate)).Methods("DELETE")
+	m.Handle("/api/v1/labels/jobs", s.require(model.RoleViewer, s.GetListLabelJobs)).Methods("GET")
+	m.Handle("/api/v1/labels/jobs/{id}", s.require(model.RoleViewer, s.GetLabelJob)).Methods("GET")
+	m.Handle("/api/v1/labels/jobs/{id}/reprint", s.require(model.RoleOperator, s.ReprintLabelJob)).Methods("POST")
+
+	// SIM activation and suspension jobs
+	m.Handle("/api/v1/sim/jobs", s.require(model.RoleViewer, s.GetListSIMJobs)).Methods("GET")
+
+	// Config profiles, UCI settings applied to the modems of a work order
+	m.Handle("/api/v1/profiles", s.require(model.RoleViewer, s.GetListConfigProfiles)).Methods("GET")
+	m.Handle("/api/v1/profiles/{name}", s.require(model.RoleViewer, s.GetConfigProfile)).Methods("GET")
+	m.Handle("/api/v1/profiles/{name}", s.require(model.RoleEngineer, s.PutConfigProfile)).Methods("PUT")
+	m.Handle("/api/v1/profiles/{name}", s.require(model.RoleEngineer, s.DeleteConfigProfile)).Methods("DELETE")
+
+	// API keys
+	m.Handle("/api/v1/keys", s.require(model.RoleAdmin, s.GetListAPIKeys)).Methods("GET")
+	m.Handle("/api/v1/keys", s.require(model.RoleAdmin, s.AddAPIKey)).Methods("POST")
+	m.Handle("/api/v1/keys/{id}", s.require(model.RoleAdmin, s.RevokeAPIKey)).Methods("DELETE")
+
+	// Prometheus metrics
+	m.Handle("/metrics", promhttp.Handler()).Methods("GET")
+
+	return m
+}
+
+// jsonResponses makes JSON the content type of the API responses, handlers
+// writing other formats set their own
+func jsonResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// GetListmodems returns the modems matching the filters in the query string.
+// The total number of matches is returned in X-Total-Count and, when ?limit= is
+// used, the cursor for the next page in X-Next-Cursor.
 func (s *Server) GetListmodems(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	query, err := parseModemQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	modems, err := s.db.ListModems()
+	page, err := s.db.ListModems(query)
 	if err != nil {
-		log.Printf("failed to get modems %v", err)
-		http.Error(w, "failed to get modems", http.StatusBadRequest)
+		writeStoreError(w, "failed to get modems", err)
 		return
 	}
-	json.NewEncoder(w).Encode(modems)
+
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	if page.NextCursor != "" {
+		w.Header().Set("X-Next-Cursor", page.NextCursor)
+	}
+	json.NewEncoder(w).Encode(page.Modems)
 }
 
 func (s *Server) Getmodem(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 	modemMac := mux.Vars(r)["mac"]
-	log.Printf("modemMacAdress: %s", modemMac)
 	modem, err := s.db.GetModem(modemMac)
 
 	if err != nil {
-		log.Printf("failed to get modem %v by mac address %s", err, modemMac)
-		http.Error(w, "failed to get modem", http.StatusBadRequest)
+		writeStoreError(w, "failed to get modem "+modemMac, err)
+		return
+	}
+
+	w.Header().Set("ETag", modemETag(modem))
+	if r.Header.Get("If-None-Match") == modemETag(modem) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 	json.NewEncoder(w).Encode(modem)
@@ -126,88 +283,176 @@ func (s *Server) Getmodem(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) Addmodem(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 	var newmodem model.Modem
-
-	reqBody, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		fmt.Fprintf(w, "Kindly enter data with the modem detail in order to update")
+	if !readJSON(w, r, &newmodem) {
+		return
 	}
-	json.Unmarshal(reqBody, &newmodem)
 
-	err = s.db.AddModem(newmodem)
+	if err := newmodem.Validate(); err != nil {
+		writeStoreError(w, "failed to add modem", err)
+		return
+	}
 
+	modem, err := s.db.AddModem(newmodem, apiOrigin(r))
 	if err != nil {
-		log.Printf("failed to add modem %v", err)
-		http.Error(w, "failed to add modem", http.StatusBadRequest)
+		writeStoreError(w, "failed to add modem "+newmodem.MacAddress, err)
 		return
 	}
 
+	w.Header().Set("ETag", modemETag(modem))
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(newmodem)
+	json.NewEncoder(w).Encode(modem)
 }
 
+// Updatemodem replaces a modem, fields left out of the body are reset to their zero value.
+// The version to replace can be given with If-Match or the version field.
 func (s *Server) Updatemodem(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "PUT" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 	macAddress := mux.Vars(r)["mac"]
-	modem, err := s.db.GetModem(macAddress)
 
+	version, err := ifMatchVersion(r)
 	if err != nil {
-		log.Printf("failed to get modem %v by mac address %s", err, macAddress)
-		http.Error(w, "failed to update modem", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	var updateModem model.Modem
+	var modem model.Modem
+	if !readJSON(w, r, &modem) {
+		return
+	}
+	if modem.MacAddress == "" {
+		modem.MacAddress = macAddress
+	}
+	if version != 0 {
+		modem.Version = version
+	}
+
+	s.saveModem(w, r, macAddress, modem)
+}
+
+// Patchmodem applies a JSON merge patch (RFC 7396) to a modem. Fields left out of
+// the patch are kept, fields set to null are reset to their zero value. The version
+// can not be patched, the patch is only checked against a version given with If-Match.
+func (s *Server) Patchmodem(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "PATCH" {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	macAddress := mux.Vars(r)["mac"]
 
-	reqBody, err := ioutil.ReadAll(r.Body)
+	version, err := ifMatchVersion(r)
 	if err != nil {
-		fmt.Fprintf(w, "Kindly enter data with the modem detail in order to update")
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
 	}
-	json.Unmarshal(reqBody, &updateModem)
 
-	// update modem
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType != "" && contentType != "application/merge-patch+json" && contentType != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, "patch must be application/merge-patch+json")
+		return
+	}
 
-	if updateModem.Model != "" {
-		modem.Model = updateModem.Model
+	patch, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		httpLog.WarnContext(r.Context(), "failed to read request body", "err", err)
+		writeError(w, http.StatusBadRequest, "failed to read request body")
+		return
 	}
 
-	modem.State = updateModem.State
-	modem.Upgraded = updateModem.Upgraded
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &fields); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid merge patch: "+err.Error())
+		return
+	}
+	if _, ok := fields["version"]; ok {
+		writeError(w, http.StatusUnprocessableEntity, "failed to patch modem "+macAddress+": validation failed",
+			model.FieldError{Field: "version", Message: "can not be patched, give the version to patch with If-Match"})
+		return
+	}
 
-	if updateModem.Firmware != "" {
-		modem.Firmware = updateModem.Firmware
+	// The patch is applied to the modem as it is stored, so it only conflicts
+	// with concurrent changes if the client asked for a version with If-Match
+	modem, err := s.db.PatchModem(macAddress, version, apiOrigin(r), func(modem *model.Modem) error {
+		current, err := json.Marshal(modem)
+		if err != nil {
+			return err
+		}
+		patched, err := utility.MergePatch(current, patch)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errInvalidPatch, err)
+		}
+		var updated model.Modem
+		if err := json.Unmarshal(patched, &updated); err != nil {
+			return fmt.Errorf("%w: %v", errInvalidPatch, err)
+		}
+		if updated.MacAddress != macAddress {
+			return &model.ValidationError{Fields: []model.FieldError{{Field: "mac_address", Message: "can not be changed"}}}
+		}
+		if err := updated.Validate(); err != nil {
+			return err
+		}
+		*modem = updated
+		return nil
+	})
+	if errors.Is(err, errInvalidPatch) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
 	}
+	if err != nil {
+		writeStoreError(w, "failed to patch modem "+macAddress, err)
+		return
+	}
+
+	httpLog.DebugContext(r.Context(), "patched modem", "mac", modem.MacAddress, "version", modem.Version)
+	w.Header().Set("ETag", modemETag(modem))
+	json.NewEncoder(w).Encode(modem)
+}
 
-	log.Println("update modem", modem.MacAddress, modem.Model, modem.State, modem.Firmware)
+// errInvalidPatch is returned when a merge patch does not apply to a modem
+var errInvalidPatch = errors.New("invalid merge patch")
 
-	err = s.db.UpdateModem(modem)
+// saveModem validates and stores a modem updated through the API and writes it as response
+func (s *Server) saveModem(w http.ResponseWriter, r *http.Request, macAddress string, modem model.Modem) {
+	if modem.MacAddress != macAddress {
+		writeError(w, http.StatusUnprocessableEntity, "failed to update modem "+macAddress+": validation failed",
+			model.FieldError{Field: "mac_address", Message: "can not be changed"})
+		return
+	}
+
+	if err := modem.Validate(); err != nil {
+		writeStoreError(w, "failed to update modem "+macAddress, err)
+		return
+	}
 
+	httpLog.DebugContext(r.Context(), "update modem", "mac", modem.MacAddress, "model", modem.Model,
+		"state", model.StateName(modem.State), "firmware", modem.Firmware)
+
+	modem, err := s.db.UpdateModem(modem, apiOrigin(r))
 	if err != nil {
-		log.Printf("failed to get modem %v by id %s", err, macAddress)
-		http.Error(w, "failed to update modem", http.StatusBadRequest)
+		writeStoreError(w, "failed to update modem "+macAddress, err)
 		return
 	}
 
+	w.Header().Set("ETag", modemETag(modem))
 	json.NewEncoder(w).Encode(modem)
 }
 
 func (s *Server) Deletemodem(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "DELETE" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 	macAddress := mux.Vars(r)["mac"]
-	err := s.db.DeleteModem(macAddress)
+	err := s.db.DeleteModem(macAddress, apiOrigin(r))
 
 	if err != nil {
-		log.Printf("failed to delete modem %v by mac address %s", err, macAddress)
-		http.Error(w, "failed to delete modem", http.StatusBadRequest)
+		writeStoreError(w, "failed to delete modem "+macAddress, err)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
@@ -215,33 +460,28 @@ func (s *Server) Deletemodem(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) SetModemState(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "PUT" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	macAddress := mux.Vars(r)["mac"]
 
 	// Read the new state from the request body.
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		log.Printf("failed to read request body: %v", err)
-		http.Error(w, "failed to read request body", http.StatusBadRequest)
-		return
-	}
-
 	var newState struct {
 		State int `json:"state"`
 	}
-	if err := json.Unmarshal(body, &newState); err != nil {
-		log.Printf("failed to unmarshal request body: %v", err)
-		http.Error(w, "failed to unmarshal request body", http.StatusBadRequest)
+	if !readJSON(w, r, &newState) {
+		return
+	}
+
+	if err := model.ValidateState(newState.State); err != nil {
+		writeStoreError(w, "failed to set modem state", err)
 		return
 	}
 
 	// Update the state in the database.
-	if err := s.db.SetModemState(macAddress, newState.State); err != nil {
-		log.Printf("failed to set modem state: %v", err)
-		http.Error(w, "failed to set modem state", http.StatusInternalServerError)
+	if err := s.db.SetModemState(macAddress, newState.State, apiOrigin(r)); err != nil {
+		writeStoreError(w, "failed to set modem state", err)
 		return
 	}
 
@@ -250,35 +490,150 @@ func (s *Server) SetModemState(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) SetModemProgress(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "PUT" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	macAddress := mux.Vars(r)["mac"]
 
 	// Read the new progress from the request body.
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		log.Printf("failed to read request body: %v", err)
-		http.Error(w, "failed to read request body", http.StatusBadRequest)
-		return
-	}
-
 	var newProgress struct {
 		Progress int `json:"progress"`
 	}
-	if err := json.Unmarshal(body, &newProgress); err != nil {
-		log.Printf("failed to unmarshal request body: %v", err)
-		http.Error(w, "failed to unmarshal request body", http.StatusBadRequest)
+	if !readJSON(w, r, &newProgress) {
+		return
+	}
+
+	if err := model.ValidateProgress(newProgress.Progress); err != nil {
+		writeStoreError(w, "failed to set modem upgrade progress", err)
 		return
 	}
 
 	// Update the progress in the database.
-	if err := s.db.SetModemUpgradeProgress(macAddress, newProgress.Progress); err != nil {
-		log.Printf("failed to set modem upgrade progress: %v", err)
-		http.Error(w, "failed to set modem upgrade progress", http.StatusInternalServerError)
+	if err := s.db.SetModemUpgradeProgress(macAddress, newProgress.Progress, apiOrigin(r)); err != nil {
+		writeStoreError(w, "failed to set modem upgrade progress", err)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 }
+
+func (s *Server) GetModemHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	macAddress := mux.Vars(r)["mac"]
+
+	from, err := queryInt(r, "from")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid from timestamp")
+		return
+	}
+	to, err := queryInt(r, "to")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid to timestamp")
+		return
+	}
+
+	events, err := s.db.ListModemEvents(macAddress, from, to)
+	if err != nil {
+		writeStoreError(w, "failed to get modem history", err)
+		return
+	}
+	json.NewEncoder(w).Encode(events)
+}
+
+// apiOrigin describes a change made through the REST API
+func apiOrigin(r *http.Request) model.Origin {
+	actor := r.RemoteAddr
+	if id, ok := requestIdentity(r); ok {
+		actor = id.Name
+	}
+	return model.Origin{
+		Source: model.SourceAPI,
+		Actor:  actor,
+	}
+}
+
+// modemETag is the entity tag of the current version of a modem
+func modemETag(modem model.Modem) string {
+	return strconv.Quote(strconv.Itoa(modem.Version))
+}
+
+// ifMatchVersion returns the modem version required by the If-Match header,
+// or 0 if the header is missing or matches any version
+func ifMatchVersion(r *http.Request) (int, error) {
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	if ifMatch == "" || ifMatch == "*" {
+		return 0, nil
+	}
+
+	version, err := strconv.Atoi(strings.Trim(ifMatch, `"`))
+	if err != nil || version <= 0 {
+		return 0, errors.New("invalid If-Match header, expected a single ETag")
+	}
+	return version, nil
+}
+
+// queryInt returns the integer query parameter name, or 0 if it is not set
+func queryInt(r *http.Request, name string) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// parseModemQuery reads the modem list filters, sorting and pagination from the query string
+func parseModemQuery(r *http.Request) (sqlitestore.ModemQuery, error) {
+	values := r.URL.Query()
+	query := sqlitestore.ModemQuery{
+		Model:       values.Get("model"),
+		Firmware:    values.Get("firmware"),
+		SIMProvider: values.Get("sim_provider"),
+		TestStatus:  values.Get("test_status"),
+		Cursor:      values.Get("cursor"),
+	}
+
+	intParams := map[string]**int{
+		"state":       &query.State,
+		"switch_port": &query.SwitchPort,
+	}
+	for name, dst := range intParams {
+		if values.Get(name) == "" {
+			continue
+		}
+		v, err := strconv.Atoi(values.Get(name))
+		if err != nil {
+			return query, fmt.Errorf("invalid %s", name)
+		}
+		*dst = &v
+	}
+
+	if values.Get("upgraded") != "" {
+		v, err := strconv.ParseBool(values.Get("upgraded"))
+		if err != nil {
+			return query, errors.New("invalid upgraded")
+		}
+		query.Upgraded = &v
+	}
+
+	var err error
+	if query.UpdatedFrom, err = queryInt(r, "updated_from"); err != nil {
+		return query, errors.New("invalid updated_from")
+	}
+	if query.UpdatedTo, err = queryInt(r, "updated_to"); err != nil {
+		return query, errors.New("invalid updated_to")
+	}
+	if query.Limit, err = queryInt(r, "limit"); err != nil || query.Limit < 0 {
+		return query, errors.New("invalid limit")
+	}
+
+	// ?sort=column sorts ascending, ?sort=-column descending
+	query.SortBy = strings.TrimPrefix(values.Get("sort"), "-")
+	query.Descending = strings.HasPrefix(values.Get("sort"), "-")
+
+	return query, nil
+}
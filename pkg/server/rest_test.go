@@ -0,0 +1,300 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+func TestPatchmodem(t *testing.T) {
+	const mac = "00:1f:43:00:00:01"
+
+	for _, tt := range []struct {
+		name    string
+		ifMatch string
+		patch   string
+		change  bool // whether the modem is changed before the patch is applied
+		status  int
+		want    func(m *model.Modem)
+	}{
+		{"patch", "", `{"firmware":"TRB1_R_00.07.06"}`, false, http.StatusOK,
+			func(m *model.Modem) { m.Firmware = "TRB1_R_00.07.06" }},
+		{"patch without If-Match after a change", "", `{"firmware":"TRB1_R_00.07.06"}`, true, http.StatusOK,
+			func(m *model.Modem) { m.Firmware = "TRB1_R_00.07.06"; m.FailCount = 1 }},
+		{"patch the version read", `"1"`, `{"firmware":"TRB1_R_00.07.06"}`, false, http.StatusOK,
+			func(m *model.Modem) { m.Firmware = "TRB1_R_00.07.06" }},
+		{"patch a version changed since", `"1"`, `{"firmware":"TRB1_R_00.07.06"}`, true, http.StatusPreconditionFailed, nil},
+		{"patch the version", "", `{"version":7}`, false, http.StatusUnprocessableEntity, nil},
+		{"patch the mac address", "", `{"mac_address":"00:1f:43:00:00:02"}`, false, http.StatusUnprocessableEntity, nil},
+		{"patch an invalid state", "", `{"state":9}`, false, http.StatusUnprocessableEntity, nil},
+		{"patch a wrong type", "", `{"state":"busy"}`, false, http.StatusBadRequest, nil},
+		{"not an object", "", `[1]`, false, http.StatusBadRequest, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			modem := addTestModem(t, s, mac)
+			if tt.change {
+				modem, _ = s.db.ModifyModem(mac, model.Origin{Source: model.SourceDiscovery}, func(m *model.Modem) { m.FailCount++ })
+			}
+
+			r := httptest.NewRequest("PATCH", "/api/v1/modem/"+mac, strings.NewReader(tt.patch))
+			r.Header.Set("Content-Type", "application/merge-patch+json")
+			if tt.ifMatch != "" {
+				r.Header.Set("If-Match", tt.ifMatch)
+			}
+			w := httptest.NewRecorder()
+			s.Patchmodem(w, mux.SetURLVars(r, map[string]string{"mac": mac}))
+
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			stored, err := s.db.GetModem(mac)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == nil {
+				if stored != modem {
+					t.Errorf("modem changed to %+v", stored)
+				}
+				return
+			}
+
+			var patched model.Modem
+			if err := json.NewDecoder(w.Body).Decode(&patched); err != nil {
+				t.Fatal(err)
+			}
+			want := modem
+			tt.want(&want)
+			want.Version = modem.Version + 1
+			if patched != want || stored != want {
+				t.Errorf("patched %+v, stored %+v, want %+v", patched, stored, want)
+			}
+			if etag := w.Header().Get("ETag"); etag != modemETag(want) {
+				t.Errorf("ETag %s, want %s", etag, modemETag(want))
+			}
+		})
+	}
+}
+
+// setupTestModem stores a modem with most fields set
+func setupTestModem(t *testing.T, s *Server, mac string) model.Modem {
+	t.Helper()
+
+	addTestModem(t, s, mac)
+	modem, err := s.db.ModifyModem(mac, model.Origin{Source: model.SourceDiscovery}, func(m *model.Modem) {
+		m.SwitchPort = 3
+		m.Model = "TRB140"
+		m.Firmware = "TRB1_R_00.07.05"
+		m.Serial = "1100123456"
+		m.Upgraded = true
+		m.FailCount = 2
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return modem
+}
+
+func TestPatchmodemFields(t *testing.T) {
+	const mac = "00:1f:43:00:00:01"
+
+	for _, tt := range []struct {
+		name  string
+		patch string
+		want  func(m *model.Modem)
+	}{
+		{"fields omitted", `{}`, func(m *model.Modem) {}},
+		{"explicit 0 and false", `{"switch_port":0,"fail_count":0,"upgraded":false}`,
+			func(m *model.Modem) { m.SwitchPort, m.FailCount, m.Upgraded = 0, 0, false }},
+		{"explicit empty string", `{"serial":""}`, func(m *model.Modem) { m.Serial = "" }},
+		{"null resets", `{"switch_port":null,"upgraded":null,"firmware":null}`,
+			func(m *model.Modem) { m.SwitchPort, m.Upgraded, m.Firmware = 0, false, "" }},
+		{"mac address kept", `{"mac_address":"` + mac + `","serial":"1100999999"}`,
+			func(m *model.Modem) { m.Serial = "1100999999" }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			modem := setupTestModem(t, s, mac)
+
+			r := httptest.NewRequest("PATCH", "/api/v1/modem/"+mac, strings.NewReader(tt.patch))
+			r.Header.Set("Content-Type", "application/merge-patch+json")
+			w := httptest.NewRecorder()
+			s.Patchmodem(w, mux.SetURLVars(r, map[string]string{"mac": mac}))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", w.Code, w.Body)
+			}
+
+			stored, err := s.db.GetModem(mac)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := modem
+			tt.want(&want)
+			want.Version = modem.Version + 1
+			if stored != want {
+				t.Errorf("stored %+v, want %+v", stored, want)
+			}
+		})
+	}
+}
+
+// PUT replaces the whole modem, fields left out of the body are reset
+func TestUpdatemodem(t *testing.T) {
+	const mac = "00:1f:43:00:00:01"
+
+	for _, tt := range []struct {
+		name    string
+		ifMatch string
+		body    string
+		status  int
+		want    func(m *model.Modem)
+	}{
+		{"fields left out are reset", "", `{"model":"TRB145","state":1}`, http.StatusOK,
+			func(m *model.Modem) {
+				*m = model.Modem{MacAddress: mac, Model: "TRB145", State: model.StateNormal}
+			}},
+		{"explicit 0 and false", "", `{"mac_address":"` + mac + `","switch_port":0,"upgraded":false,"model":"TRB140","firmware":"TRB1_R_00.07.05","serial":"1100123456","fail_count":2}`, http.StatusOK,
+			func(m *model.Modem) { m.SwitchPort, m.Upgraded, m.State, m.IPV6 = 0, false, model.StateUnknown, "" }},
+		{"version in the body", "", `{"version":1}`, http.StatusPreconditionFailed, nil},
+		{"If-Match", `"1"`, `{}`, http.StatusPreconditionFailed, nil},
+		{"other mac address", "", `{"mac_address":"00:1f:43:00:00:02"}`, http.StatusUnprocessableEntity, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			modem := setupTestModem(t, s, mac)
+
+			r := httptest.NewRequest("PUT", "/api/v1/modem/"+mac, strings.NewReader(tt.body))
+			if tt.ifMatch != "" {
+				r.Header.Set("If-Match", tt.ifMatch)
+			}
+			w := httptest.NewRecorder()
+			s.Updatemodem(w, mux.SetURLVars(r, map[string]string{"mac": mac}))
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+
+			stored, err := s.db.GetModem(mac)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := modem
+			if tt.want != nil {
+				tt.want(&want)
+				want.Version = modem.Version + 1
+			}
+			if stored != want {
+				t.Errorf("stored %+v, want %+v", stored, want)
+			}
+		})
+	}
+}
+
+func TestAddmodem(t *testing.T) {
+	const mac = "00:1f:43:00:00:01"
+	s := newTestServer(t)
+
+	add := func(body string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest("POST", "/api/v1/modem", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		s.Addmodem(w, r)
+		return w
+	}
+
+	// the version in the body is not stored
+	w := add(`{"mac_address":"` + mac + `","state":1,"firmware":"TRB1_R_00.07.05","version":7}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var added model.Modem
+	if err := json.NewDecoder(w.Body).Decode(&added); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := s.db.GetModem(mac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != stored || added.Version != 1 {
+		t.Errorf("added %+v, stored %+v", added, stored)
+	}
+	if etag := w.Header().Get("ETag"); etag != modemETag(stored) {
+		t.Errorf("ETag %s, want %s", etag, modemETag(stored))
+	}
+
+	if w := add(`{"mac_address":"` + mac + `"}`); w.Code != http.StatusConflict {
+		t.Errorf("status %d adding it again", w.Code)
+	}
+}
+
+func TestModemHistory(t *testing.T) {
+	const mac = "00:1f:43:00:00:01"
+	s := newTestServer(t)
+
+	call := func(handler http.HandlerFunc, method string, body string, status int) {
+		t.Helper()
+		r := httptest.NewRequest(method, "/api/v1/modem/"+mac, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler(w, mux.SetURLVars(r, map[string]string{"mac": mac}))
+		if w.Code != status {
+			t.Fatalf("%s: status %d, want %d: %s", method, w.Code, status, w.Body)
+		}
+	}
+	call(s.Addmodem, "POST", `{"mac_address":"`+mac+`","state":1,"firmware":"TRB1_R_00.07.05"}`, http.StatusCreated)
+	call(s.SetModemState, "PUT", `{"state":3}`, http.StatusOK)
+	if _, err := s.db.ModifyModem(mac, model.Origin{Source: model.SourceUpgrade, Actor: "eth1"}, func(m *model.Modem) {
+		m.Firmware = "TRB1_R_00.07.06"
+	}); err != nil {
+		t.Fatal(err)
+	}
+	call(s.Deletemodem, "DELETE", "", http.StatusNoContent)
+
+	history := func(query string) []model.ModemEvent {
+		t.Helper()
+		r := httptest.NewRequest("GET", "/api/v1/modem/"+mac+"/history?"+query, nil)
+		w := httptest.NewRecorder()
+		s.GetModemHistory(w, mux.SetURLVars(r, map[string]string{"mac": mac}))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", w.Code, w.Body)
+		}
+		var events []model.ModemEvent
+		if err := json.NewDecoder(w.Body).Decode(&events); err != nil {
+			t.Fatal(err)
+		}
+		return events
+	}
+
+	// the history outlives the modem
+	events := history("")
+	var got []string
+	for _, e := range events {
+		if e.MacAddress != mac || e.Timestamp == 0 {
+			t.Errorf("event %+v", e)
+		}
+		got = append(got, strings.Join([]string{e.Field, e.OldValue, e.NewValue, e.Source, e.Actor}, " "))
+	}
+	want := []string{
+		"modem  created api 192.0.2.1:1234",
+		"state 1 3 api 192.0.2.1:1234",
+		"firmware TRB1_R_00.07.05 TRB1_R_00.07.06 upgrade eth1",
+		"modem  deleted api 192.0.2.1:1234",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("history\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if events := history(fmt.Sprintf("from=%d", events[0].Timestamp+3600)); len(events) != 0 {
+		t.Errorf("%d events after from", len(events))
+	}
+	if events := history(fmt.Sprintf("to=%d", events[0].Timestamp)); len(events) != 4 {
+		t.Errorf("%d events until to, want 4", len(events))
+	}
+}
@@ -2,45 +2,207 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
+	"time"
 
+	"github.com/ebobo/modem_prod_go/pkg/config"
+	"github.com/ebobo/modem_prod_go/pkg/escrow"
+	"github.com/ebobo/modem_prod_go/pkg/eventbus"
+	"github.com/ebobo/modem_prod_go/pkg/functest"
+	"github.com/ebobo/modem_prod_go/pkg/sim"
 	sqlitestore "github.com/ebobo/modem_prod_go/pkg/store/sqlite"
 )
 
 // Server takes care of instantiating and running service and other dependencies.
 type Server struct {
 	httpListenAddr string
+	httpReadTime   time.Duration
+	httpWriteTime  time.Duration
 	httpStarted    *sync.WaitGroup
 	httpStopped    *sync.WaitGroup
 	ctx            context.Context
 	cancel         context.CancelFunc
 	db             *sqlitestore.SqliteStore
+	bus            *eventbus.Bus
+	discovery      *discoveryStatus
+	modemRequests  chan modemRequest
+	authDisabled   bool
+	adminKey       string
+	keyTouches     *apiKeyTouches
+	corsOrigins    []string
+	tlsCertFile    string
+	tlsKeyFile     string
+	clientCAFile   string
+	requireClient  bool
+	certRoles      map[string]string
+	certs          *certReloader
+	discoveryCfg   config.Discovery
+	snmp           config.SNMP
+	ssh            config.SSH
+	upgrade        config.Upgrade
+	workflow       config.Workflow
+	label          config.Label
+	labelJobs      chan int64 // ids of queued label print jobs
+	test           config.Test
+	testPlan       functest.Plan
+	simCfg         config.SIM
+	sim            sim.Provider  // nil if no carrier API is configured
+	simJobs        chan struct{} // wakes the SIM job runner when a job is queued
+	escrowCfg      config.Escrow
+	escrow         *escrow.Sealer // nil if no escrow key is configured
 }
 
 // Config is the server configuration
 type Config struct {
 	HTTPListenAddr string
-	MSGPRCAddr     string
 	DB             *sqlitestore.SqliteStore
+
+	// HTTPReadTimeout and HTTPWriteTimeout limit reading a request and writing
+	// a response, zero means no limit
+	HTTPReadTimeout  time.Duration
+	HTTPWriteTimeout time.Duration
+
+	// AuthDisabled lets every caller use the API as admin, for development only
+	AuthDisabled bool
+
+	// AdminAPIKey is added as an admin api key if it is not stored yet
+	AdminAPIKey string
+
+	// CORSOrigins are the origins allowed to call the API from a browser, any origin
+	// is allowed without credentials if it is empty
+	CORSOrigins []string
+
+	// TLSCertFile and TLSKeyFile enable HTTPS when both are set
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// ClientCAFile enables client certificates signed by these CAs
+	ClientCAFile string
+
+	// RequireClientCert rejects TLS connections without a valid client certificate
+	RequireClientCert bool
+
+	// CertRoles maps the common name or an organizational unit of client certificates to a role
+	CertRoles map[string]string
+
+	// Settings of modem discovery, reading and upgrading
+	Discovery config.Discovery
+	SNMP      config.SNMP
+	SSH       config.SSH
+	Upgrade   config.Upgrade
+	Workflow  config.Workflow
+	Label     config.Label
+	Test      config.Test
+	SIM       config.SIM
+	Escrow    config.Escrow
 }
 
 func New(c Config) *Server {
+	bus := eventbus.New(eventHistorySize)
+	c.DB.SetEventBus(bus)
+	registerModemCollector(c.DB)
+
 	return &Server{
 		httpListenAddr: c.HTTPListenAddr,
+		httpReadTime:   c.HTTPReadTimeout,
+		httpWriteTime:  c.HTTPWriteTimeout,
 		httpStarted:    &sync.WaitGroup{},
 		httpStopped:    &sync.WaitGroup{},
 		db:             c.DB,
+		bus:            bus,
+		discovery:      newDiscoveryStatus(c.SNMP),
+		modemRequests:  make(chan modemRequest, modemRequestBuffer),
+		authDisabled:   c.AuthDisabled,
+		adminKey:       c.AdminAPIKey,
+		keyTouches:     &apiKeyTouches{last: make(map[int64]time.Time)},
+		corsOrigins:    c.CORSOrigins,
+		tlsCertFile:    c.TLSCertFile,
+		tlsKeyFile:     c.TLSKeyFile,
+		clientCAFile:   c.ClientCAFile,
+		requireClient:  c.RequireClientCert,
+		certRoles:      c.CertRoles,
+		discoveryCfg:   c.Discovery,
+		snmp:           c.SNMP,
+		ssh:            c.SSH,
+		upgrade:        c.Upgrade,
+		workflow:       c.Workflow,
+		label:          c.Label,
+		labelJobs:      make(chan int64, labelQueueSize),
+		test:           c.Test,
+		simCfg:         c.SIM,
+		simJobs:        make(chan struct{}, 1),
+		escrowCfg:      c.Escrow,
 	}
 }
 
 func (s *Server) Start() error {
+	err := s.bootstrapAdminKey()
+	if err != nil {
+		return fmt.Errorf("unable to set up admin api key: %w", err)
+	}
+
+	if s.tlsCertFile != "" || s.tlsKeyFile != "" {
+		s.certs, err = newCertReloader(s.tlsCertFile, s.tlsKeyFile, s.clientCAFile)
+		if err != nil {
+			return err
+		}
+	} else if s.clientCAFile != "" {
+		return errors.New("client certificates require a TLS certificate and key")
+	}
+
+	key, err := escrow.LoadKey(s.escrowCfg.Key, s.escrowCfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("unable to load escrow key: %w", err)
+	}
+	if key != nil {
+		if s.escrow, err = escrow.New(key); err != nil {
+			return fmt.Errorf("unable to load escrow key: %w", err)
+		}
+		if err := s.sealProfilePasswords(); err != nil {
+			return fmt.Errorf("unable to seal profile passwords: %w", err)
+		}
+	} else {
+		provisionLog.Info("no escrow key is configured, unique modem passwords can not be generated")
+	}
+
+	if s.testPlan, err = s.test.TestPlan(); err != nil {
+		return err
+	}
+	testLog.Info("loaded test plan", "file", s.test.Plan, "steps", len(s.testPlan.Steps))
+
+	if s.simCfg.Provider == "twilio" {
+		s.sim = sim.NewTwilio(s.simCfg.URL, s.simCfg.AccountSID, s.simCfg.AuthToken, s.simCfg.Timeout)
+		simLog.Info("managing SIMs", "provider", s.sim.Name(), "url", s.simCfg.URL, "auto", s.simCfg.Auto)
+	}
+
 	s.ctx, s.cancel = context.WithCancel(context.Background())
 
+	// Report modems that differ from the expected units as they are discovered
+	go (&reconcileWatcher{db: s.db, bus: s.bus}).Run(s.ctx)
+
+	// Print queued labels, including those left from the last run
+	s.requeueLabelJobs()
+	go s.runLabelPrinter(s.ctx)
+
+	// Run queued SIM jobs, including those left from the last run
+	if s.sim != nil {
+		go s.runSIMJobs(s.ctx)
+	}
+
+	// Provisioning does not resume after a restart, it has to be started again
+	if n, err := s.db.InterruptProvisioning(); err != nil {
+		provisionLog.Error("failed to update interrupted provisioning", "err", err)
+	} else if n > 0 {
+		provisionLog.Warn("provisioning was interrupted by the restart", "modems", n)
+	}
+	s.interruptTestRuns()
+
 	// Start the HTTP interface
 	s.httpStarted.Add(1)
 	s.httpStopped.Add(1)
-	err := s.startHTTP()
+	err = s.startHTTP()
 	if err != nil {
 		return err
 	}
@@ -50,7 +212,7 @@ func (s *Server) Start() error {
 }
 
 func (s *Server) Shutdown() {
-	fmt.Println("server shut down")
+	httpLog.Info("server shut down")
 	if s.cancel != nil {
 		s.cancel()
 	}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/ebobo/modem_prod_go/pkg/config"
+	"github.com/ebobo/modem_prod_go/pkg/model"
+	sqlitestore "github.com/ebobo/modem_prod_go/pkg/store/sqlite"
+)
+
+// newTestServer returns a server with the default configuration and an empty
+// database, changed by the options. It is not started.
+func newTestServer(t *testing.T, options ...func(*Config)) *Server {
+	t.Helper()
+
+	db, _, err := sqlitestore.New(filepath.Join(t.TempDir(), "modems.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	defaults := config.Default()
+	c := Config{
+		DB:        db,
+		Discovery: defaults.Discovery,
+		SNMP:      defaults.SNMP,
+		SSH:       defaults.SSH,
+		Upgrade:   defaults.Upgrade,
+		Workflow:  defaults.Workflow,
+		Label:     defaults.Label,
+		Test:      defaults.Test,
+		SIM:       defaults.SIM,
+	}
+	for _, option := range options {
+		option(&c)
+	}
+	return New(c)
+}
+
+// addTestModem stores a modem with the mac address and returns it
+func addTestModem(t *testing.T, s *Server, mac string) model.Modem {
+	t.Helper()
+
+	modem := NewModemInfo(mac)
+	modem.IPV6 = "::1"
+	modem.State = model.StateNormal
+	modem, err := s.db.AddModem(modem, model.Origin{Source: model.SourceAPI, Actor: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return modem
+}
@@ -0,0 +1,293 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ebobo/modem_prod_go/pkg/eventbus"
+	"github.com/ebobo/modem_prod_go/pkg/metrics"
+	"github.com/ebobo/modem_prod_go/pkg/model"
+	"github.com/ebobo/modem_prod_go/pkg/sim"
+	sqlitestore "github.com/ebobo/modem_prod_go/pkg/store/sqlite"
+)
+
+// simJobInterval is how often the SIM job runner looks for jobs due for a retry
+const simJobInterval = 5 * time.Second
+
+// maxSIMRetryDelay caps the doubling pause between attempts of a SIM job
+const maxSIMRetryDelay = time.Hour
+
+// simBufferSize is how many modem events the SIM job runner can fall behind
+const simBufferSize = 256
+
+// simTargets are the SIM states the actions of SIM jobs lead to
+var simTargets = map[string]string{
+	model.SIMActivate: sim.StatusActive,
+	model.SIMSuspend:  sim.StatusSuspended,
+}
+
+// queueSIMJob stores a SIM job for a modem and wakes the job runner
+func (s *Server) queueSIMJob(modem model.Modem, action string, actor string) (model.SIMJob, error) {
+	job, err := s.db.AddSIMJob(model.SIMJob{
+		MacAddress: modem.MacAddress,
+		ICCID:      modem.ICCID,
+		Provider:   s.sim.Name(),
+		Action:     action,
+		Actor:      actor,
+	})
+	if err != nil {
+		return job, err
+	}
+	s.bus.Publish(eventbus.Event{Type: eventbus.SIMJobChanged, MacAddress: job.MacAddress, Data: job})
+
+	select {
+	case s.simJobs <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// runSIMJobs runs queued SIM jobs as they become due until ctx is done. With
+// sim.auto set it queues the activation of a SIM once the ICCID of its modem is read.
+func (s *Server) runSIMJobs(ctx context.Context) {
+	filter := eventbus.Filter{Types: []string{eventbus.ModemInfoRead}}
+	var sub *eventbus.Subscription
+	var events <-chan eventbus.Event
+	if s.simCfg.Auto {
+		sub = s.bus.Subscribe(filter, 0, simBufferSize)
+		events = sub.C
+	}
+	defer func() {
+		if sub != nil {
+			sub.Close()
+		}
+	}()
+
+	ticker := time.NewTicker(simJobInterval)
+	defer ticker.Stop()
+	for {
+		s.runDueSIMJobs(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.simJobs:
+		case e, ok := <-events:
+			if !ok {
+				simLog.Warn("fell behind modem events, some SIMs may not have been activated")
+				sub.Close()
+				sub = s.bus.Subscribe(filter, 0, simBufferSize)
+				events = sub.C
+				continue
+			}
+			if modem, ok := e.Data.(model.Modem); ok {
+				s.autoActivateSIM(modem)
+			}
+		}
+	}
+}
+
+// autoActivateSIM queues the activation of a modem's SIM, unless it was
+// activated or tried before
+func (s *Server) autoActivateSIM(modem model.Modem) {
+	if modem.ICCID == "" {
+		return
+	}
+	tried, err := s.db.HasSIMJob(modem.ICCID, model.SIMActivate)
+	if err != nil {
+		simLog.Error("failed to get SIM jobs", "mac", modem.MacAddress, "iccid", modem.ICCID, "err", err)
+		return
+	}
+	if tried {
+		simLog.Debug("SIM was activated before", "mac", modem.MacAddress, "iccid", modem.ICCID)
+		return
+	}
+
+	job, err := s.queueSIMJob(modem, model.SIMActivate, s.discoveryCfg.Interface)
+	if err != nil && !errors.Is(err, sqlitestore.ErrSIMJobQueued) {
+		simLog.Error("failed to queue SIM activation", "mac", modem.MacAddress, "iccid", modem.ICCID, "err", err)
+		return
+	}
+	simLog.Info("queued SIM activation", "mac", modem.MacAddress, "iccid", modem.ICCID, "job", job.ID)
+}
+
+// runDueSIMJobs runs the queued SIM jobs that are due, one at a time
+func (s *Server) runDueSIMJobs(ctx context.Context) {
+	jobs, err := s.db.DueSIMJobs()
+	if err != nil {
+		simLog.Error("failed to get queued SIM jobs", "err", err)
+		return
+	}
+	for _, job := range jobs {
+		if ctx.Err() != nil {
+			return
+		}
+		s.runSIMJob(ctx, job)
+	}
+}
+
+// runSIMJob makes one attempt of a SIM job. A failure the carrier may recover
+// from is retried after a doubling delay until the attempts run out. The
+// outcome is saved to the job, and to the modem once the job is over.
+func (s *Server) runSIMJob(ctx context.Context, job model.SIMJob) {
+	log := simLog.With("mac", job.MacAddress, "iccid", job.ICCID, "job", job.ID, "action", job.Action)
+
+	var status string
+	var err error
+	switch job.Action {
+	case model.SIMActivate:
+		status, err = s.sim.Activate(ctx, job.ICCID)
+	case model.SIMSuspend:
+		status, err = s.sim.Suspend(ctx, job.ICCID)
+	default:
+		err = fmt.Errorf("unknown SIM action %q", job.Action)
+	}
+	temporary := sim.Temporary(err)
+	if err == nil && status != simTargets[job.Action] {
+		// The carrier is still changing the SIM, the next attempt checks on it
+		err, temporary = fmt.Errorf("the SIM is %s", status), true
+	}
+	if ctx.Err() != nil {
+		// Stopping the server is not an attempt, the job runs again after a restart
+		return
+	}
+
+	job.Attempts++
+	if status != "" {
+		job.SIMStatus = status
+	}
+	job.Error = ""
+	switch {
+	case err == nil:
+		job.Status = model.SIMJobDone
+		metrics.SIMRequests.WithLabelValues(job.Action, "ok").Inc()
+		log.Info("SIM job done", "sim_status", status, "attempts", job.Attempts)
+	case temporary && job.Attempts < s.simCfg.MaxAttempts:
+		job.Error = err.Error()
+		delay := simRetryDelay(s.simCfg.RetryDelay, job.Attempts)
+		job.NextAttemptAt = int(time.Now().Add(delay).Unix())
+		metrics.SIMRequests.WithLabelValues(job.Action, "retry").Inc()
+		log.Warn("SIM job failed, retrying", "err", err, "attempts", job.Attempts, "delay", delay)
+	default:
+		job.Status = model.SIMJobFailed
+		job.Error = err.Error()
+		metrics.SIMRequests.WithLabelValues(job.Action, "failed").Inc()
+		log.Error("SIM job failed", "err", err, "attempts", job.Attempts)
+	}
+
+	job, err = s.db.UpdateSIMJob(job)
+	if err != nil {
+		log.Error("failed to update SIM job", "err", err)
+		return
+	}
+	s.bus.Publish(eventbus.Event{Type: eventbus.SIMJobChanged, MacAddress: job.MacAddress, Data: job})
+	if job.Status == model.SIMJobQueued {
+		return
+	}
+
+	// The modem only records whether its SIM is active, the status the carrier
+	// reported (e.g. ready or suspended) stays on the job
+	_, err = s.db.ModifyModem(job.MacAddress, model.Origin{Source: model.SourceSIM, Actor: job.Actor}, func(m *model.Modem) {
+		m.SIMProvider = job.Provider
+		if job.SIMStatus != "" {
+			m.SIMStatus = job.SIMStatus == sim.StatusActive
+		}
+	})
+	if err != nil {
+		log.Error("failed to save SIM status of modem", "err", err)
+	}
+}
+
+// simRetryDelay returns the pause after a failed attempt, base doubled for every
+// attempt before it up to maxSIMRetryDelay
+func simRetryDelay(base time.Duration, attempts int) time.Duration {
+	delay := base
+	for i := 1; i < attempts && delay < maxSIMRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxSIMRetryDelay)
+}
+
+// modemICCID returns a modem whose ICCID is known, writing an error otherwise
+func (s *Server) modemICCID(w http.ResponseWriter, mac string) (model.Modem, bool) {
+	if s.sim == nil {
+		writeError(w, http.StatusServiceUnavailable, "no SIM provider is configured")
+		return model.Modem{}, false
+	}
+	modem, err := s.db.GetModem(mac)
+	if err != nil {
+		writeStoreError(w, "failed to get modem "+mac, err)
+		return modem, false
+	}
+	if modem.ICCID == "" {
+		writeError(w, http.StatusConflict, "the ICCID of modem "+mac+" is not known")
+		return modem, false
+	}
+	return modem, true
+}
+
+// GetModemSIM returns the status of the SIM of a modem as the carrier reports it now
+func (s *Server) GetModemSIM(w http.ResponseWriter, r *http.Request) {
+	mac := mux.Vars(r)["mac"]
+
+	modem, ok := s.modemICCID(w, mac)
+	if !ok {
+		return
+	}
+	status, err := s.sim.Status(r.Context(), modem.ICCID)
+	if errors.Is(err, sim.ErrUnknownSIM) {
+		writeError(w, http.StatusNotFound, "failed to get SIM status of modem "+mac+": "+err.Error())
+		return
+	}
+	if err != nil {
+		simLog.WarnContext(r.Context(), "failed to get SIM status", "mac", mac, "iccid", modem.ICCID, "err", err)
+		writeError(w, http.StatusBadGateway, "failed to get SIM status of modem "+mac+": "+err.Error())
+		return
+	}
+	json.NewEncoder(w).Encode(model.SIMState{MacAddress: mac, ICCID: modem.ICCID, Provider: s.sim.Name(), Status: status})
+}
+
+// ActivateModemSIM queues the activation of the SIM of a modem
+func (s *Server) ActivateModemSIM(w http.ResponseWriter, r *http.Request) {
+	s.queueModemSIMJob(w, r, model.SIMActivate)
+}
+
+// SuspendModemSIM queues the suspension of the SIM of a modem
+func (s *Server) SuspendModemSIM(w http.ResponseWriter, r *http.Request) {
+	s.queueModemSIMJob(w, r, model.SIMSuspend)
+}
+
+func (s *Server) queueModemSIMJob(w http.ResponseWriter, r *http.Request, action string) {
+	mac := mux.Vars(r)["mac"]
+
+	modem, ok := s.modemICCID(w, mac)
+	if !ok {
+		return
+	}
+	job, err := s.queueSIMJob(modem, action, apiOrigin(r).Actor)
+	if err != nil {
+		writeStoreError(w, "failed to queue SIM job of modem "+mac, err)
+		return
+	}
+	httpLog.InfoContext(r.Context(), "queued SIM job", "mac", mac, "iccid", modem.ICCID, "job", job.ID, "action", action)
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// GetListSIMJobs returns the most recent SIM jobs, filtered by ?mac= and ?status=
+func (s *Server) GetListSIMJobs(w http.ResponseWriter, r *http.Request) {
+	jobs, err := s.db.ListSIMJobs(r.URL.Query().Get("mac"), r.URL.Query().Get("status"))
+	if err != nil {
+		writeStoreError(w, "failed to get SIM jobs", err)
+		return
+	}
+	json.NewEncoder(w).Encode(jobs)
+}
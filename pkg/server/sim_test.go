@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+	"github.com/ebobo/modem_prod_go/pkg/sim"
+)
+
+// newSIMTestServer returns a server managing SIMs at an API served by stub,
+// with a modem that has the ICCID
+func newSIMTestServer(t *testing.T, stub *sim.Stub, iccid string) (*Server, model.Modem) {
+	t.Helper()
+
+	api := httptest.NewServer(stub)
+	t.Cleanup(api.Close)
+
+	s := newTestServer(t, func(c *Config) {
+		c.SIM.Provider = "twilio"
+		c.SIM.URL = api.URL
+		c.SIM.MaxAttempts = 3
+		c.SIM.RetryDelay = time.Minute
+	})
+	s.sim = sim.NewTwilio(api.URL, "", "", time.Second)
+
+	modem := addTestModem(t, s, "00:1f:43:00:00:01")
+	modem, err := s.db.ModifyModem(modem.MacAddress, model.Origin{Source: model.SourceDiscovery}, func(m *model.Modem) {
+		m.ICCID = iccid
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, modem
+}
+
+// runTestSIMJob makes the next attempt of a job and returns it afterwards
+func runTestSIMJob(t *testing.T, s *Server, id int64) model.SIMJob {
+	t.Helper()
+
+	job, err := s.db.GetSIMJob(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.runSIMJob(context.Background(), job)
+	job, err = s.db.GetSIMJob(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func TestRunSIMJob(t *testing.T) {
+	const iccid = "89470000000000000001"
+	stub := sim.NewStub()
+	stub.Add(iccid, sim.StatusReady)
+	s, modem := newSIMTestServer(t, stub, iccid)
+
+	for _, step := range []struct {
+		action    string
+		simStatus string
+		active    bool
+	}{
+		{model.SIMActivate, sim.StatusActive, true},
+		{model.SIMSuspend, sim.StatusSuspended, false},
+	} {
+		job, err := s.queueSIMJob(modem, step.action, "tester")
+		if err != nil {
+			t.Fatal(err)
+		}
+		job = runTestSIMJob(t, s, job.ID)
+		if job.Status != model.SIMJobDone || job.SIMStatus != step.simStatus || job.Attempts != 1 {
+			t.Errorf("%s: job %+v", step.action, job)
+		}
+
+		stored, err := s.db.GetModem(modem.MacAddress)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.SIMStatus != step.active || stored.SIMProvider != "twilio" {
+			t.Errorf("%s: modem sim_status %v, provider %q", step.action, stored.SIMStatus, stored.SIMProvider)
+		}
+	}
+}
+
+func TestRunSIMJobRetries(t *testing.T) {
+	const iccid = "89470000000000000001"
+	stub := sim.NewStub()
+	stub.FailFirst = 1
+	stub.Add(iccid, sim.StatusReady)
+	s, modem := newSIMTestServer(t, stub, iccid)
+
+	job, err := s.queueSIMJob(modem, model.SIMActivate, "tester")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := time.Now()
+	job = runTestSIMJob(t, s, job.ID)
+	if job.Status != model.SIMJobQueued || job.Attempts != 1 || job.Error == "" {
+		t.Fatalf("after a 503: job %+v", job)
+	}
+	if next := time.Unix(int64(job.NextAttemptAt), 0); next.Before(before.Add(time.Minute - time.Second)) {
+		t.Errorf("next attempt at %s, want a minute later", next)
+	}
+	if stored, _ := s.db.GetModem(modem.MacAddress); stored.SIMStatus {
+		t.Error("modem changed while the job is queued")
+	}
+
+	job = runTestSIMJob(t, s, job.ID)
+	if job.Status != model.SIMJobDone || job.Attempts != 2 || job.Error != "" {
+		t.Errorf("retry: job %+v", job)
+	}
+}
+
+func TestRunSIMJobUnknownICCID(t *testing.T) {
+	s, modem := newSIMTestServer(t, sim.NewStub(), "89470000000000000009")
+
+	job, err := s.queueSIMJob(modem, model.SIMActivate, "tester")
+	if err != nil {
+		t.Fatal(err)
+	}
+	job = runTestSIMJob(t, s, job.ID)
+	if job.Status != model.SIMJobFailed || job.Attempts != 1 || job.Error == "" {
+		t.Errorf("job %+v, want failed without retries", job)
+	}
+}
+
+func TestSIMRetryDelay(t *testing.T) {
+	for _, tt := range []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{7, 32 * time.Minute},
+		{8, maxSIMRetryDelay},
+		{64, maxSIMRetryDelay},
+		{1000, maxSIMRetryDelay},
+	} {
+		if got := simRetryDelay(30*time.Second, tt.attempts); got != tt.want {
+			t.Errorf("attempt %d: %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ebobo/modem_prod_go/pkg/eventbus"
+	"github.com/ebobo/modem_prod_go/pkg/metrics"
+	"github.com/ebobo/modem_prod_go/pkg/model"
+	sqlitestore "github.com/ebobo/modem_prod_go/pkg/store/sqlite"
+)
+
+// startTestRun records a new test run of a modem, marks the modem as being
+// tested and runs the tests in the background
+func (s *Server) startTestRun(modem model.Modem, origin model.Origin) (model.TestRun, error) {
+	run, err := s.db.StartTestRun(modem.MacAddress, origin.Actor)
+	if err != nil {
+		return run, err
+	}
+	s.setTestStatus(modem.MacAddress, model.TestRunning, origin)
+	s.bus.Publish(eventbus.Event{Type: eventbus.TestRun, MacAddress: modem.MacAddress, Data: run})
+
+	go s.testModem(modem, run, origin)
+	return run, nil
+}
+
+// setTestStatus records the outcome of the latest test run on a modem
+func (s *Server) setTestStatus(mac string, status string, origin model.Origin) {
+	_, err := s.db.ModifyModem(mac, origin, func(m *model.Modem) {
+		m.TestStatus = status
+	})
+	if err != nil {
+		testLog.Error("failed to set test status of modem", "mac", mac, "status", status, "err", err)
+	}
+}
+
+// testModem runs the test plan on a modem and records the result of each step,
+// the modem passes if all of them pass
+func (s *Server) testModem(modem model.Modem, run model.TestRun, origin model.Origin) {
+	log := testLog.With("mac", modem.MacAddress, "run", run.ID, "job", newJobID())
+	log.Info("testing modem", "steps", len(s.testPlan.Steps))
+	start := time.Now()
+
+	ctx, cancel := context.WithTimeout(s.ctx, s.test.Timeout)
+	defer cancel()
+
+	errMsg := ""
+	passed := false
+	err := func() error {
+		conn, err := s.dialModem(ctx, modem)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		passed = s.testPlan.Run(ctx, conn, func(result model.TestResult) {
+			result.RunID = run.ID
+			result.MacAddress = modem.MacAddress
+			if _, err := s.db.AddTestResult(result); err != nil {
+				log.Error("failed to save test result", "step", result.Step, "err", err)
+			}
+			log.Debug("ran test step", "step", result.Step, "passed", result.Passed, "err", result.Error,
+				"attempts", result.Attempts, "duration", time.Duration(result.DurationMs)*time.Millisecond)
+		})
+		return nil
+	}()
+	if err != nil {
+		errMsg = err.Error()
+	}
+
+	status := model.TestFailed
+	if passed {
+		status = model.TestPassed
+		log.Info("modem passed the tests", "duration", time.Since(start))
+	} else {
+		log.Warn("modem failed the tests", "err", errMsg, "duration", time.Since(start))
+	}
+	metrics.TestRuns.WithLabelValues(status).Inc()
+
+	run, err = s.db.FinishTestRun(run.ID, status, errMsg)
+	if err != nil {
+		log.Error("failed to update test run", "err", err)
+	}
+	s.setTestStatus(modem.MacAddress, status, origin)
+	s.bus.Publish(eventbus.Event{Type: eventbus.TestRun, MacAddress: modem.MacAddress, Data: run})
+}
+
+// autoTestModem tests a modem that has finished the workflow, unless it was
+// tested before
+func (s *Server) autoTestModem(modem model.Modem) {
+	stored, err := s.db.GetModem(modem.MacAddress)
+	if err != nil {
+		testLog.Error("failed to get modem to test", "mac", modem.MacAddress, "err", err)
+		return
+	}
+	if stored.TestStatus != "" {
+		testLog.Debug("modem was tested already", "mac", modem.MacAddress, "status", stored.TestStatus)
+		return
+	}
+
+	_, err = s.startTestRun(modem, model.Origin{Source: model.SourceTest, Actor: s.discoveryCfg.Interface})
+	if err != nil && !errors.Is(err, sqlitestore.ErrTesting) {
+		testLog.Error("failed to start testing modem", "mac", modem.MacAddress, "err", err)
+	}
+}
+
+// interruptTestRuns fails the test runs that were running when the server
+// stopped, they do not resume after a restart
+func (s *Server) interruptTestRuns() {
+	runs, err := s.db.InterruptTestRuns()
+	if err != nil {
+		testLog.Error("failed to update interrupted test runs", "err", err)
+		return
+	}
+	for _, run := range runs {
+		s.setTestStatus(run.MacAddress, model.TestFailed, model.Origin{Source: model.SourceTest, Actor: run.Actor})
+	}
+	if len(runs) > 0 {
+		testLog.Warn("test runs were interrupted by the restart", "modems", len(runs))
+	}
+}
+
+// TestModem runs the test plan on a modem in the background, the
+// outcome is published as a test.status event
+func (s *Server) TestModem(w http.ResponseWriter, r *http.Request) {
+	mac := mux.Vars(r)["mac"]
+
+	modem, err := s.db.GetModem(mac)
+	if err != nil {
+		writeStoreError(w, "failed to get modem "+mac, err)
+		return
+	}
+	if modem.IPV6 == "" || modem.IPV6 == "::" {
+		writeError(w, http.StatusConflict, "the address of modem "+mac+" is not known")
+		return
+	}
+
+	origin := apiOrigin(r)
+	origin.Source = model.SourceTest
+	run, err := s.startTestRun(modem, origin)
+	if err != nil {
+		writeStoreError(w, "failed to test modem "+mac, err)
+		return
+	}
+	httpLog.InfoContext(r.Context(), "testing modem", "mac", mac, "run", run.ID)
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(run)
+}
+
+// GetModemTestRun returns the latest test run of a modem with its results
+func (s *Server) GetModemTestRun(w http.ResponseWriter, r *http.Request) {
+	mac := mux.Vars(r)["mac"]
+
+	run, err := s.db.GetTestRun(mac)
+	if err != nil {
+		writeStoreError(w, "failed to get test run of modem "+mac, err)
+		return
+	}
+	json.NewEncoder(w).Encode(run)
+}
+
+// GetListModemTestRuns returns all test runs of a modem with their results, newest first
+func (s *Server) GetListModemTestRuns(w http.ResponseWriter, r *http.Request) {
+	mac := mux.Vars(r)["mac"]
+
+	runs, err := s.db.ListTestRuns(mac)
+	if err != nil {
+		writeStoreError(w, "failed to get test runs of modem "+mac, err)
+		return
+	}
+	json.NewEncoder(w).Encode(runs)
+}
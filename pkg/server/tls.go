@@ -0,0 +1,117 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+// certReloader holds the server certificate and client CAs, which are read again on Reload
+type certReloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+func newCertReloader(certFile, keyFile, clientCAFile string) (*certReloader, error) {
+	c := &certReloader{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+	}
+	return c, c.Reload()
+}
+
+// Reload reads the certificate files again. The current certificates are kept if
+// any of them fail to load.
+func (c *certReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("unable to load certificate: %w", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if c.clientCAFile != "" {
+		pem, err := os.ReadFile(c.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("unable to read client CA: %w", err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in client CA file %s", c.clientCAFile)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cert = &cert
+	c.clientCAs = clientCAs
+	return nil
+}
+
+// tlsConfig returns a TLS configuration that always uses the latest loaded certificates
+func (c *certReloader) tlsConfig(requireClientCert bool) *tls.Config {
+	clientAuth := tls.VerifyClientCertIfGiven
+	if requireClientCert {
+		clientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			c.mu.RLock()
+			defer c.mu.RUnlock()
+
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*c.cert},
+			}
+			if c.clientCAs != nil {
+				config.ClientCAs = c.clientCAs
+				config.ClientAuth = clientAuth
+			}
+			return config, nil
+		},
+	}
+}
+
+// ReloadTLS reads the TLS certificates and client CAs again, typically on SIGHUP
+func (s *Server) ReloadTLS() error {
+	if s.certs == nil {
+		return errors.New("TLS is not enabled")
+	}
+	if err := s.certs.Reload(); err != nil {
+		return err
+	}
+	httpLog.Info("reloaded TLS certificates", "file", s.certs.certFile)
+	return nil
+}
+
+// certificateIdentity maps a verified client certificate to an identity. The subject
+// common name is looked up in the role mapping first, then each organizational unit.
+func (s *Server) certificateIdentity(r *http.Request) (identity, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return identity{}, false
+	}
+
+	subject := r.TLS.VerifiedChains[0][0].Subject
+	if role, ok := s.certRoles[subject.CommonName]; ok && model.ValidRole(role) {
+		return identity{Name: "cert:" + subject.CommonName, Role: role}, true
+	}
+	for _, unit := range subject.OrganizationalUnit {
+		if role, ok := s.certRoles[unit]; ok && model.ValidRole(role) {
+			return identity{Name: "cert:" + subject.CommonName, Role: role}, true
+		}
+	}
+	return identity{}, false
+}
@@ -0,0 +1,355 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+// testCA signs the certificates of a TLS test
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	ca := &testCA{}
+	ca.cert, ca.key, ca.pem = createTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test CA"},
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, nil)
+	return ca
+}
+
+// issue returns a certificate and key signed by the CA
+func (ca *testCA) issue(t *testing.T, template *x509.Certificate) tls.Certificate {
+	t.Helper()
+
+	_, key, certPEM := createTestCert(t, template, ca)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// createTestCert creates a certificate from template signed by ca, or self-signed if ca is nil
+func createTestCert(t *testing.T, template *x509.Certificate, ca *testCA) (*x509.Certificate, *ecdsa.PrivateKey, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	parent, signer := template, key
+	if ca != nil {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// writeServerCert writes a server certificate for 127.0.0.1 signed by ca to the files
+func writeServerCert(t *testing.T, ca *testCA, certFile, keyFile string) *x509.Certificate {
+	t.Helper()
+
+	cert := ca.issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf
+}
+
+func clientCert(t *testing.T, ca *testCA, cn string, units ...string) tls.Certificate {
+	t.Helper()
+
+	return ca.issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: cn, OrganizationalUnit: units},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+}
+
+// tlsTest is a server serving handler over TLS with the certificates in dir
+type tlsTest struct {
+	s        *Server
+	ts       *httptest.Server
+	ca       *testCA
+	dir      string
+	certFile string
+	keyFile  string
+	caFile   string
+}
+
+func newTLSTest(t *testing.T, requireClientCert bool, handler func(*Server) http.Handler) *tlsTest {
+	t.Helper()
+
+	tt := &tlsTest{ca: newTestCA(t), dir: t.TempDir()}
+	tt.certFile = filepath.Join(tt.dir, "server.pem")
+	tt.keyFile = filepath.Join(tt.dir, "server-key.pem")
+	tt.caFile = filepath.Join(tt.dir, "ca.pem")
+	writeServerCert(t, tt.ca, tt.certFile, tt.keyFile)
+	if err := os.WriteFile(tt.caFile, tt.ca.pem, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tt.s = newTestServer(t, func(c *Config) {
+		c.TLSCertFile, c.TLSKeyFile, c.ClientCAFile = tt.certFile, tt.keyFile, tt.caFile
+		c.RequireClientCert = requireClientCert
+		c.CertRoles = map[string]string{
+			"bench-01":  model.RoleOperator,
+			"qa":        model.RoleEngineer,
+			"bench-02":  model.RoleViewer,
+			"printers":  "printer",
+			"line-lead": model.RoleAdmin,
+		}
+	})
+	var err error
+	tt.s.certs, err = newCertReloader(tt.certFile, tt.keyFile, tt.caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tt.ts = httptest.NewUnstartedServer(handler(tt.s))
+	tt.ts.TLS = tt.s.certs.tlsConfig(requireClientCert)
+	tt.ts.StartTLS()
+	t.Cleanup(tt.ts.Close)
+	return tt
+}
+
+// client returns a client trusting the test CA, presenting certs. Every request
+// is a new connection so it sees reloaded certificates.
+func (tt *tlsTest) client(certs ...tls.Certificate) *http.Client {
+	roots := x509.NewCertPool()
+	roots.AddCert(tt.ca.cert)
+	return &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: roots, Certificates: certs},
+		DisableKeepAlives: true,
+	}}
+}
+
+// identityHandler responds with the identity of the client certificate
+func identityHandler(s *Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := s.certificateIdentity(r)
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(id)
+	})
+}
+
+func TestCertificateIdentity(t *testing.T) {
+	tt := newTLSTest(t, false, identityHandler)
+	other := newTestCA(t)
+
+	for _, c := range []struct {
+		name  string
+		certs []tls.Certificate
+		want  identity // an empty role if the certificate is not accepted
+	}{
+		{"common name", []tls.Certificate{clientCert(t, tt.ca, "bench-01")}, identity{Name: "cert:bench-01", Role: model.RoleOperator}},
+		{"organizational unit", []tls.Certificate{clientCert(t, tt.ca, "alice", "staff", "qa")}, identity{Name: "cert:alice", Role: model.RoleEngineer}},
+		{"common name before unit", []tls.Certificate{clientCert(t, tt.ca, "bench-02", "qa")}, identity{Name: "cert:bench-02", Role: model.RoleViewer}},
+		{"unmapped", []tls.Certificate{clientCert(t, tt.ca, "bench-99", "staff")}, identity{}},
+		{"invalid role", []tls.Certificate{clientCert(t, tt.ca, "label-1", "printers")}, identity{}},
+		{"no certificate", nil, identity{}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			resp, err := tt.client(c.certs...).Get(tt.ts.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if c.want.Role == "" {
+				if resp.StatusCode != http.StatusUnauthorized {
+					t.Errorf("status %d, want no identity", resp.StatusCode)
+				}
+				return
+			}
+			var got identity
+			if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got != c.want {
+				t.Errorf("identity %+v, want %+v", got, c.want)
+			}
+		})
+	}
+
+	// a mapped name signed by another CA fails the handshake
+	if resp, err := tt.client(clientCert(t, other, "line-lead")).Get(tt.ts.URL); err == nil {
+		resp.Body.Close()
+		t.Error("certificate of another CA accepted")
+	}
+}
+
+func TestCertificateAuthentication(t *testing.T) {
+	tt := newTLSTest(t, false, func(s *Server) http.Handler { return s.routes() })
+
+	for _, c := range []struct {
+		name   string
+		certs  []tls.Certificate
+		method string
+		path   string
+		status int
+	}{
+		{"health without certificate", nil, "GET", "/healthz", http.StatusOK},
+		{"api without certificate", nil, "GET", "/api/v1/modems", http.StatusUnauthorized},
+		{"unmapped certificate", []tls.Certificate{clientCert(t, tt.ca, "bench-99")}, "GET", "/api/v1/modems", http.StatusUnauthorized},
+		{"role allows", []tls.Certificate{clientCert(t, tt.ca, "bench-01")}, "GET", "/api/v1/modems", http.StatusOK},
+		{"role does not allow", []tls.Certificate{clientCert(t, tt.ca, "bench-01")}, "DELETE", "/api/v1/modem/00:1f:43:00:00:01", http.StatusForbidden},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			req, err := http.NewRequest(c.method, tt.ts.URL+c.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := tt.client(c.certs...).Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != c.status {
+				t.Errorf("status %d, want %d", resp.StatusCode, c.status)
+			}
+		})
+	}
+}
+
+func TestRequireClientCert(t *testing.T) {
+	tt := newTLSTest(t, true, identityHandler)
+
+	if resp, err := tt.client().Get(tt.ts.URL); err == nil {
+		resp.Body.Close()
+		t.Error("connected without a certificate")
+	}
+	if resp, err := tt.client(clientCert(t, newTestCA(t), "bench-01")).Get(tt.ts.URL); err == nil {
+		resp.Body.Close()
+		t.Error("connected with a certificate of another CA")
+	}
+
+	// an unmapped certificate connects, but has no identity
+	resp, err := tt.client(clientCert(t, tt.ca, "bench-99")).Get(tt.ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("status %d", resp.StatusCode)
+	}
+}
+
+func TestReloadTLS(t *testing.T) {
+	tt := newTLSTest(t, false, identityHandler)
+
+	serverSerial := func(client *http.Client) *big.Int {
+		t.Helper()
+		resp, err := client.Get(tt.ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.TLS.PeerCertificates[0].SerialNumber
+	}
+
+	// a new server certificate is used for new connections after the reload
+	before := serverSerial(tt.client())
+	renewed := writeServerCert(t, tt.ca, tt.certFile, tt.keyFile)
+	if got := serverSerial(tt.client()); got.Cmp(before) != 0 {
+		t.Error("certificate changed before the reload")
+	}
+	if err := tt.s.ReloadTLS(); err != nil {
+		t.Fatal(err)
+	}
+	if got := serverSerial(tt.client()); got.Cmp(renewed.SerialNumber) != 0 {
+		t.Errorf("serving %s, want the renewed %s", got, renewed.SerialNumber)
+	}
+
+	// client certificates of a new CA are accepted after the reload
+	next := newTestCA(t)
+	if err := os.WriteFile(tt.caFile, append(tt.ca.pem, next.pem...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := tt.s.ReloadTLS(); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := tt.client(clientCert(t, next, "bench-01")).Get(tt.ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status %d with a certificate of the new CA", resp.StatusCode)
+	}
+
+	// a broken file keeps the loaded certificates
+	if err := os.WriteFile(tt.certFile, []byte("not a certificate"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := tt.s.ReloadTLS(); err == nil {
+		t.Error("reloaded a broken certificate")
+	}
+	if got := serverSerial(tt.client()); got.Cmp(renewed.SerialNumber) != 0 {
+		t.Errorf("serving %s after a failed reload", got)
+	}
+
+	if err := newTestServer(t).ReloadTLS(); err == nil {
+		t.Error("reloaded without TLS")
+	}
+}
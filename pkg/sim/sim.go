@@ -0,0 +1,62 @@
+// Package sim activates and suspends the SIM cards of modems at the carrier.
+//
+// A carrier API is a Provider. Twilio is the one implemented, and Stub serves
+// a Twilio compatible API locally so the workflow can be tried on a bench
+// without touching real SIMs.
+package sim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// SIM states reported by the carrier
+const (
+	StatusNew         = "new"
+	StatusReady       = "ready"
+	StatusActive      = "active"
+	StatusSuspended   = "suspended"
+	StatusDeactivated = "deactivated"
+)
+
+// Provider manages SIMs at a carrier by their ICCID. Activate and Suspend
+// return the status of the SIM after the request, a carrier may report an
+// intermediate status while it is changing.
+type Provider interface {
+	Name() string
+	Activate(ctx context.Context, iccid string) (string, error)
+	Suspend(ctx context.Context, iccid string) (string, error)
+	Status(ctx context.Context, iccid string) (string, error)
+}
+
+// ErrUnknownSIM is returned if the carrier has no SIM with the ICCID
+var ErrUnknownSIM = errors.New("the carrier does not know the SIM")
+
+// APIError is an error response of the carrier API
+type APIError struct {
+	StatusCode int
+	Code       int // error code of the carrier
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("carrier API returned %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("carrier API returned %d: %s", e.StatusCode, e.Message)
+}
+
+// Temporary reports whether err may go away if the request is sent again: the
+// carrier could not be reached, was rate limiting or had an internal error
+func Temporary(err error) bool {
+	if errors.Is(err, ErrUnknownSIM) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500
+	}
+	return true
+}
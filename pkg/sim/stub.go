@@ -0,0 +1,142 @@
+package sim
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Stub serves the part of the Twilio Programmable Wireless API that Twilio
+// uses, keeping the SIMs in memory. It is meant for bench setups and trying
+// the activation workflow, not for production.
+type Stub struct {
+	AccountSID string // credentials requests must use, not checked if empty
+	AuthToken  string
+	AutoCreate bool // unknown ICCIDs are added as ready SIMs when they are looked up
+	FailFirst  int  // status changes of each SIM that fail with 503 before one succeeds
+
+	mu       sync.Mutex
+	sims     map[string]*twilioSIM // by sid
+	bySIM    map[string]string     // sid by ICCID
+	failures map[string]int        // failed status changes by sid
+}
+
+// NewStub returns a stub with no SIMs
+func NewStub() *Stub {
+	return &Stub{
+		sims:     make(map[string]*twilioSIM),
+		bySIM:    make(map[string]string),
+		failures: make(map[string]int),
+	}
+}
+
+// Add adds a SIM with the status, e.g. ready, and returns its sid
+func (s *Stub) Add(iccid string, status string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.add(iccid, status)
+}
+
+func (s *Stub) add(iccid string, status string) string {
+	if sid, ok := s.bySIM[iccid]; ok {
+		s.sims[sid].Status = status
+		return sid
+	}
+	sid := fmt.Sprintf("DE%032x", len(s.sims)+1)
+	s.sims[sid] = &twilioSIM{SID: sid, ICCID: iccid, Status: status}
+	s.bySIM[iccid] = sid
+	return sid
+}
+
+func (s *Stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, password, _ := r.BasicAuth()
+	if s.AccountSID != "" && (user != s.AccountSID || password != s.AuthToken) {
+		writeStubError(w, http.StatusUnauthorized, 20003, "Authenticate")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	switch {
+	case r.URL.Path == "/v1/Sims" && r.Method == http.MethodGet:
+		s.listSIMs(w, r)
+	case strings.HasPrefix(r.URL.Path, "/v1/Sims/") && r.Method == http.MethodGet:
+		s.getSIM(w, strings.TrimPrefix(r.URL.Path, "/v1/Sims/"))
+	case strings.HasPrefix(r.URL.Path, "/v1/Sims/") && r.Method == http.MethodPost:
+		s.updateSIM(w, r, strings.TrimPrefix(r.URL.Path, "/v1/Sims/"))
+	default:
+		writeStubError(w, http.StatusNotFound, 20404, "The requested resource "+r.URL.Path+" was not found")
+	}
+}
+
+// listSIMs lists all SIMs, or the one with the ICCID of ?Iccid=
+func (s *Stub) listSIMs(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sims := []twilioSIM{}
+	if iccid := r.URL.Query().Get("Iccid"); iccid != "" {
+		sid, ok := s.bySIM[iccid]
+		if !ok && s.AutoCreate {
+			sid, ok = s.add(iccid, StatusReady), true
+		}
+		if ok {
+			sims = append(sims, *s.sims[sid])
+		}
+	} else {
+		for _, sim := range s.sims {
+			sims = append(sims, *sim)
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"sims": sims, "meta": map[string]interface{}{"key": "sims"}})
+}
+
+func (s *Stub) getSIM(w http.ResponseWriter, sid string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sim, ok := s.sims[sid]
+	if !ok {
+		writeStubError(w, http.StatusNotFound, 20404, "The requested resource /v1/Sims/"+sid+" was not found")
+		return
+	}
+	json.NewEncoder(w).Encode(sim)
+}
+
+// updateSIM changes the status of a SIM, only the moves between ready,
+// active and suspended are allowed
+func (s *Stub) updateSIM(w http.ResponseWriter, r *http.Request, sid string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sim, ok := s.sims[sid]
+	if !ok {
+		writeStubError(w, http.StatusNotFound, 20404, "The requested resource /v1/Sims/"+sid+" was not found")
+		return
+	}
+	if s.failures[sid] < s.FailFirst {
+		s.failures[sid]++
+		writeStubError(w, http.StatusServiceUnavailable, 20503, "Service unavailable")
+		return
+	}
+
+	status := r.PostFormValue("Status")
+	switch {
+	case status == "":
+	case status == StatusActive && sim.Status != StatusDeactivated,
+		status == StatusSuspended && (sim.Status == StatusActive || sim.Status == StatusSuspended):
+		sim.Status = status
+	default:
+		writeStubError(w, http.StatusBadRequest, 20001, fmt.Sprintf("A SIM that is %s can not be made %s", sim.Status, status))
+		return
+	}
+	json.NewEncoder(w).Encode(sim)
+}
+
+func writeStubError(w http.ResponseWriter, status int, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"code": code, "message": message, "status": status})
+}
@@ -0,0 +1,136 @@
+package sim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultTwilioURL is the base URL of the Twilio Programmable Wireless API
+const DefaultTwilioURL = "https://wireless.twilio.com"
+
+// maxErrorBody limits how much of an error response is read
+const maxErrorBody = 64 << 10
+
+// Twilio manages SIMs through the Twilio Programmable Wireless API, or an API
+// compatible with it
+type Twilio struct {
+	baseURL    string
+	accountSID string
+	authToken  string
+	client     *http.Client
+}
+
+// NewTwilio returns a provider calling the API at baseURL with the account
+// credentials, each request may take up to timeout
+func NewTwilio(baseURL string, accountSID string, authToken string, timeout time.Duration) *Twilio {
+	return &Twilio{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		accountSID: accountSID,
+		authToken:  authToken,
+		client:     &http.Client{Timeout: timeout},
+	}
+}
+
+func (t *Twilio) Name() string { return "twilio" }
+
+// twilioSIM is the part of a Twilio SIM resource used here
+type twilioSIM struct {
+	SID    string `json:"sid"`
+	ICCID  string `json:"iccid"`
+	Status string `json:"status"`
+}
+
+// twilioError is the body of a Twilio error response
+type twilioError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (t *Twilio) Status(ctx context.Context, iccid string) (string, error) {
+	s, err := t.lookup(ctx, iccid)
+	return s.Status, err
+}
+
+func (t *Twilio) Activate(ctx context.Context, iccid string) (string, error) {
+	return t.setStatus(ctx, iccid, StatusActive)
+}
+
+func (t *Twilio) Suspend(ctx context.Context, iccid string) (string, error) {
+	return t.setStatus(ctx, iccid, StatusSuspended)
+}
+
+// setStatus moves a SIM to status, a SIM already in it is left alone
+func (t *Twilio) setStatus(ctx context.Context, iccid string, status string) (string, error) {
+	s, err := t.lookup(ctx, iccid)
+	if err != nil || s.Status == status {
+		return s.Status, err
+	}
+
+	form := url.Values{"Status": {status}}
+	err = t.do(ctx, http.MethodPost, "/v1/Sims/"+url.PathEscape(s.SID), nil, form, &s)
+	return s.Status, err
+}
+
+// lookup finds the SIM with the ICCID
+func (t *Twilio) lookup(ctx context.Context, iccid string) (twilioSIM, error) {
+	var page struct {
+		SIMs []twilioSIM `json:"sims"`
+	}
+	err := t.do(ctx, http.MethodGet, "/v1/Sims", url.Values{"Iccid": {iccid}}, nil, &page)
+	if err != nil {
+		return twilioSIM{}, err
+	}
+	for _, s := range page.SIMs {
+		if s.ICCID == iccid {
+			return s, nil
+		}
+	}
+	return twilioSIM{}, ErrUnknownSIM
+}
+
+// do sends a request, form encoded if form is set, and decodes the JSON response into out
+func (t *Twilio) do(ctx context.Context, method string, path string, query url.Values, form url.Values, out interface{}) error {
+	u := t.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+
+	r, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+	r.SetBasicAuth(t.accountSID, t.authToken)
+	r.Header.Set("Accept", "application/json")
+	if form != nil {
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := t.client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		var e twilioError
+		if json.NewDecoder(io.LimitReader(resp.Body, maxErrorBody)).Decode(&e) == nil {
+			apiErr.Code, apiErr.Message = e.Code, e.Message
+		}
+		return apiErr
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("unable to decode response of %s %s: %w", method, path, err)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package sim
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const (
+	testICCID      = "89470000000000000001"
+	testAccountSID = "AC00000000000000000000000000000001"
+	testAuthToken  = "secret"
+)
+
+// newTestTwilio returns a provider calling an API served by stub
+func newTestTwilio(t *testing.T, stub *Stub) *Twilio {
+	t.Helper()
+
+	stub.AccountSID, stub.AuthToken = testAccountSID, testAuthToken
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+	return NewTwilio(server.URL, testAccountSID, testAuthToken, time.Second)
+}
+
+func TestTwilioActivateSuspend(t *testing.T) {
+	stub := NewStub()
+	stub.Add(testICCID, StatusReady)
+	p := newTestTwilio(t, stub)
+	ctx := context.Background()
+
+	for _, step := range []struct {
+		name string
+		do   func(context.Context, string) (string, error)
+		want string
+	}{
+		{"status", p.Status, StatusReady},
+		{"activate", p.Activate, StatusActive},
+		{"activate again", p.Activate, StatusActive},
+		{"status", p.Status, StatusActive},
+		{"suspend", p.Suspend, StatusSuspended},
+		{"suspend again", p.Suspend, StatusSuspended},
+		{"activate suspended", p.Activate, StatusActive},
+	} {
+		status, err := step.do(ctx, testICCID)
+		if err != nil || status != step.want {
+			t.Fatalf("%s: %q, %v, want %q", step.name, status, err, step.want)
+		}
+	}
+}
+
+func TestTwilioRetry(t *testing.T) {
+	stub := NewStub()
+	stub.FailFirst = 2
+	stub.Add(testICCID, StatusReady)
+	p := newTestTwilio(t, stub)
+
+	for i := 0; i < stub.FailFirst; i++ {
+		_, err := p.Activate(context.Background(), testICCID)
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("attempt %d: %v, want 503", i+1, err)
+		}
+		if !Temporary(err) {
+			t.Errorf("attempt %d: %v is not temporary", i+1, err)
+		}
+	}
+	status, err := p.Activate(context.Background(), testICCID)
+	if err != nil || status != StatusActive {
+		t.Errorf("last attempt: %q, %v", status, err)
+	}
+}
+
+func TestTwilioErrors(t *testing.T) {
+	stub := NewStub()
+	stub.Add("89470000000000000002", StatusDeactivated)
+	p := newTestTwilio(t, stub)
+	ctx := context.Background()
+
+	_, err := p.Activate(ctx, testICCID)
+	if !errors.Is(err, ErrUnknownSIM) || Temporary(err) {
+		t.Errorf("unknown ICCID: %v", err)
+	}
+	_, err = p.Status(ctx, testICCID)
+	if !errors.Is(err, ErrUnknownSIM) {
+		t.Errorf("status of unknown ICCID: %v", err)
+	}
+
+	// The carrier refuses, asking again does not help
+	_, err = p.Activate(ctx, "89470000000000000002")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest || Temporary(err) {
+		t.Errorf("activating a deactivated SIM: %v", err)
+	}
+
+	wrong := NewTwilio(p.baseURL, testAccountSID, "wrong", time.Second)
+	_, err = wrong.Status(ctx, "89470000000000000002")
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized || Temporary(err) {
+		t.Errorf("wrong credentials: %v", err)
+	}
+}
+
+func TestStubAutoCreate(t *testing.T) {
+	stub := NewStub()
+	stub.AutoCreate = true
+	p := newTestTwilio(t, stub)
+
+	status, err := p.Activate(context.Background(), testICCID)
+	if err != nil || status != StatusActive {
+		t.Errorf("activate: %q, %v", status, err)
+	}
+}
@@ -0,0 +1,109 @@
+package sqlitestore
+
+import (
+	"time"
+
+	"github.com/ebobo/modem_prod_go/pkg/metrics"
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+// AddAPIKey stores a new api key and returns its id. The key must have its hash set.
+func (s *SqliteStore) AddAPIKey(key model.APIKey) (int64, error) {
+	defer metrics.ObserveStore("add_api_key", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key.CreatedAt = int(time.Now().Unix())
+	key.LastUsed = 0
+	key.RevokedAt = 0
+
+	r, err := s.db.NamedExec(
+		`INSERT INTO api_keys (
+			name,
+			role,
+			prefix,
+			hash,
+			created_at,
+			last_used,
+			revoked_at)
+		 VALUES(
+			:name,
+			:role,
+			:prefix,
+			:hash,
+			:created_at,
+			:last_used,
+			:revoked_at)`, key)
+	if err != nil {
+		return 0, translateError(err)
+	}
+	return r.LastInsertId()
+}
+
+// GetAPIKeyByHash returns the active api key with the given hash
+func (s *SqliteStore) GetAPIKeyByHash(hash string) (model.APIKey, error) {
+	defer metrics.ObserveStore("get_api_key_by_hash", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var key model.APIKey
+	err := s.db.QueryRowx("SELECT * FROM api_keys WHERE hash = ? AND revoked_at = 0", hash).StructScan(&key)
+	return key, translateError(err)
+}
+
+// FindAPIKeyByHash returns the api key with the given hash, also if it is revoked
+func (s *SqliteStore) FindAPIKeyByHash(hash string) (model.APIKey, error) {
+	defer metrics.ObserveStore("find_api_key_by_hash", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var key model.APIKey
+	err := s.db.QueryRowx("SELECT * FROM api_keys WHERE hash = ?", hash).StructScan(&key)
+	return key, translateError(err)
+}
+
+// ListAPIKeys returns all api keys, including revoked ones
+func (s *SqliteStore) ListAPIKeys() ([]model.APIKey, error) {
+	defer metrics.ObserveStore("list_api_keys", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := []model.APIKey{}
+	return keys, s.db.Select(&keys, "SELECT * FROM api_keys ORDER BY id")
+}
+
+// CountActiveAPIKeys returns the number of active api keys with the given role
+func (s *SqliteStore) CountActiveAPIKeys(role string) (int, error) {
+	defer metrics.ObserveStore("count_active_api_keys", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var count int
+	return count, s.db.Get(&count, "SELECT COUNT(*) FROM api_keys WHERE role = ? AND revoked_at = 0", role)
+}
+
+// RevokeAPIKey revokes an active api key
+func (s *SqliteStore) RevokeAPIKey(id int64) error {
+	defer metrics.ObserveStore("revoke_api_key", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return CheckForZeroRowsAffected(s.db.Exec("UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at = 0", time.Now().Unix(), id))
+}
+
+// TouchAPIKey records that an api key was used at the given time
+func (s *SqliteStore) TouchAPIKey(id int64, usedAt int) error {
+	defer metrics.ObserveStore("touch_api_key", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.db.Exec("UPDATE api_keys SET last_used = ? WHERE id = ?", usedAt, id)
+	return err
+}
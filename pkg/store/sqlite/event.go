@@ -0,0 +1,112 @@
+package sqlitestore
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/ebobo/modem_prod_go/pkg/metrics"
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+// ListModemEvents returns the history of a modem ordered from oldest to newest.
+// from and to are unix timestamps, a zero value means no limit.
+func (s *SqliteStore) ListModemEvents(mac string, from int, to int) ([]model.ModemEvent, error) {
+	defer metrics.ObserveStore("list_modem_events", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	query := "SELECT * FROM modem_events WHERE mac_address = ?"
+	args := []interface{}{mac}
+	if from > 0 {
+		query += " AND timestamp >= ?"
+		args = append(args, from)
+	}
+	if to > 0 {
+		query += " AND timestamp <= ?"
+		args = append(args, to)
+	}
+	query += " ORDER BY timestamp, id"
+
+	events := []model.ModemEvent{}
+	return events, s.db.Select(&events, query, args...)
+}
+
+// insertModemEvents records the fields that differ between old and new and returns the recorded events
+func insertModemEvents(tx *sqlx.Tx, old model.Modem, new model.Modem, origin model.Origin) ([]model.ModemEvent, error) {
+	events := diffModems(old, new)
+	for i := range events {
+		if err := insertModemEvent(tx, &events[i], origin); err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+// insertModemLifecycleEvent records that a modem was created or deleted
+func insertModemLifecycleEvent(tx *sqlx.Tx, mac string, event string, origin model.Origin) error {
+	return insertModemEvent(tx, &model.ModemEvent{MacAddress: mac, Field: model.FieldModem, NewValue: event}, origin)
+}
+
+// insertModemEvent sets the origin and time of an event and records it
+func insertModemEvent(tx *sqlx.Tx, event *model.ModemEvent, origin model.Origin) error {
+	event.Source = origin.Source
+	event.Actor = origin.Actor
+	event.Timestamp = int(time.Now().Unix())
+
+	_, err := tx.NamedExec(
+		`INSERT INTO modem_events (
+			mac_address,
+			field,
+			old_value,
+			new_value,
+			source,
+			actor,
+			timestamp)
+		 VALUES(
+			:mac_address,
+			:field,
+			:old_value,
+			:new_value,
+			:source,
+			:actor,
+			:timestamp)`, event)
+	if err != nil {
+		return fmt.Errorf("unable to record %s change: %w", event.Field, err)
+	}
+	return nil
+}
+
+// diffModems returns one event per column that differs between old and new.
+// last_updated and version are left out since they change with every update.
+func diffModems(old model.Modem, new model.Modem) []model.ModemEvent {
+	var events []model.ModemEvent
+
+	oldValue := reflect.ValueOf(old)
+	newValue := reflect.ValueOf(new)
+	modemType := oldValue.Type()
+
+	for i := 0; i < modemType.NumField(); i++ {
+		column := modemType.Field(i).Tag.Get("db")
+		if column == "" || column == "mac_address" || column == "last_updated" || column == "version" {
+			continue
+		}
+
+		o := fmt.Sprint(oldValue.Field(i).Interface())
+		n := fmt.Sprint(newValue.Field(i).Interface())
+		if o == n {
+			continue
+		}
+
+		events = append(events, model.ModemEvent{
+			MacAddress: old.MacAddress,
+			Field:      column,
+			OldValue:   o,
+			NewValue:   n,
+		})
+	}
+	return events
+}
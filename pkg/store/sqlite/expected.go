@@ -0,0 +1,167 @@
+package sqlitestore
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ebobo/modem_prod_go/pkg/metrics"
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+// ImportExpectedUnits stores a batch of expected units in one transaction. In
+// insert mode units that are expected already are skipped, in upsert mode they
+// are replaced. Every unit is validated first; if any is invalid, or dryRun is
+// set, the transaction is rolled back and the result shows what would have happened.
+func (s *SqliteStore) ImportExpectedUnits(units []model.ExpectedUnit, mode string, dryRun bool) (model.ImportResult, error) {
+	defer metrics.ObserveStore("import_expected_units", time.Now())
+
+	result := model.ImportResult{Mode: mode, DryRun: dryRun, Rows: make([]model.ImportRow, len(units)), Unexpected: []string{}}
+	if !model.ValidImportMode(mode) {
+		return result, fmt.Errorf("%w: unknown import mode %q", ErrInvalidQuery, mode)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	now := int(time.Now().Unix())
+	firstRow := make(map[string]int, len(units))
+	for i, unit := range units {
+		row := &result.Rows[i]
+		row.Row = i + 1
+		row.MacAddress = unit.MacAddress
+
+		var validationErr *model.ValidationError
+		if err := unit.Validate(); errors.As(err, &validationErr) {
+			row.Action = model.ImportInvalid
+			row.Errors = validationErr.Fields
+			result.Invalid++
+			continue
+		}
+		if first, ok := firstRow[unit.MacAddress]; ok {
+			row.Action = model.ImportInvalid
+			row.Errors = []model.FieldError{{Field: "mac_address", Message: fmt.Sprintf("is already in row %d", first)}}
+			result.Invalid++
+			continue
+		}
+		firstRow[unit.MacAddress] = row.Row
+
+		var modem model.Modem
+		err := tx.QueryRowx("SELECT * FROM modems WHERE mac_address = ?", unit.MacAddress).StructScan(&modem)
+		switch err = translateError(err); {
+		case err == nil:
+			row.Discovered = true
+			row.Mismatches = unit.Mismatches(modem)
+		case !errors.Is(err, ErrNotFound):
+			return result, err
+		}
+
+		var old model.ExpectedUnit
+		err = tx.QueryRowx("SELECT * FROM expected_units WHERE mac_address = ?", unit.MacAddress).StructScan(&old)
+		switch err = translateError(err); {
+		case errors.Is(err, ErrNotFound):
+			row.Action = model.ImportInserted
+			result.Inserted++
+		case err != nil:
+			return result, err
+		case mode == model.ImportInsert:
+			row.Action = model.ImportSkipped
+			result.Skipped++
+			continue
+		case unit.Serial == old.Serial && unit.IMEI == old.IMEI && unit.Model == old.Model &&
+			unit.Firmware == old.Firmware && unit.Batch == old.Batch:
+			row.Action = model.ImportUnchanged
+			result.Unchanged++
+			continue
+		default:
+			row.Action = model.ImportUpdated
+			result.Updated++
+		}
+
+		unit.ImportedAt = now
+		_, err = tx.NamedExec(
+			`INSERT OR REPLACE INTO expected_units (
+				mac_address,
+				serial,
+				imei,
+				model,
+				firmware,
+				batch,
+				imported_at)
+			 VALUES(
+				:mac_address,
+				:serial,
+				:imei,
+				:model,
+				:firmware,
+				:batch,
+				:imported_at)`, unit)
+		if err != nil {
+			return result, translateError(err)
+		}
+	}
+
+	err = tx.Select(&result.Unexpected,
+		`SELECT mac_address FROM modems
+		 WHERE mac_address NOT IN (SELECT mac_address FROM expected_units)
+		 ORDER BY mac_address`)
+	if err != nil {
+		return result, err
+	}
+
+	if dryRun || result.Invalid > 0 {
+		return result, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return result, err
+	}
+	result.Committed = true
+	return result, nil
+}
+
+func (s *SqliteStore) GetExpectedUnit(mac string) (model.ExpectedUnit, error) {
+	defer metrics.ObserveStore("get_expected_unit", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var unit model.ExpectedUnit
+	err := s.db.QueryRowx("SELECT * FROM expected_units WHERE mac_address = ?", mac).StructScan(&unit)
+	return unit, translateError(err)
+}
+
+// ListExpectedUnits returns the expected units ordered by MAC address, or only
+// those of the given batch if it is not empty
+func (s *SqliteStore) ListExpectedUnits(batch string) ([]model.ExpectedUnit, error) {
+	defer metrics.ObserveStore("list_expected_units", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	units := []model.ExpectedUnit{}
+	var err error
+	if batch == "" {
+		err = s.db.Select(&units, "SELECT * FROM expected_units ORDER BY mac_address")
+	} else {
+		err = s.db.Select(&units, "SELECT * FROM expected_units WHERE batch = ? ORDER BY mac_address", batch)
+	}
+	return units, err
+}
+
+// CountExpectedUnits returns the number of expected units
+func (s *SqliteStore) CountExpectedUnits() (int, error) {
+	defer metrics.ObserveStore("count_expected_units", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var count int
+	err := s.db.Get(&count, "SELECT COUNT(*) FROM expected_units")
+	return count, err
+}
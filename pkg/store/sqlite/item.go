@@ -1,16 +1,32 @@
 package sqlitestore
 
 import (
-	"log"
+	"database/sql"
+	"errors"
+	"time"
 
+	"github.com/ebobo/modem_prod_go/pkg/eventbus"
+	"github.com/ebobo/modem_prod_go/pkg/metrics"
 	"github.com/ebobo/modem_prod_go/pkg/model"
 )
 
-func (s *SqliteStore) AddModem(modem model.Modem) error {
+// AddModem stores a new modem, records its creation in the history and returns
+// the modem as it is stored
+func (s *SqliteStore) AddModem(modem model.Modem, origin model.Origin) (model.Modem, error) {
+	defer metrics.ObserveStore("add_modem", time.Now())
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	_, err := s.db.NamedExec(
+	modem.Version = 1
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return model.Modem{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.NamedExec(
 		`INSERT INTO modems (
 			mac_address,
 			ipv6,
@@ -28,7 +44,9 @@ func (s *SqliteStore) AddModem(modem model.Modem) error {
 			imei,
 			iccid,
 			imsi,
-			progress)
+			progress,
+			test_status,
+			version)
 		 VALUES(
 			:mac_address,
 			:ipv6,
@@ -46,37 +64,128 @@ func (s *SqliteStore) AddModem(modem model.Modem) error {
 			:imei,
 			:iccid,
 			:imsi,
-			:progress)`, modem)
-	return err
+			:progress,
+			:test_status,
+			:version)`, modem)
+	if err != nil {
+		return model.Modem{}, translateError(err)
+	}
+	var stored model.Modem
+	err = tx.QueryRowx("SELECT * FROM modems WHERE mac_address = ?", modem.MacAddress).StructScan(&stored)
+	if err != nil {
+		return model.Modem{}, translateError(err)
+	}
+	if err := insertModemLifecycleEvent(tx, modem.MacAddress, model.EventCreated, origin); err != nil {
+		return model.Modem{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return model.Modem{}, err
+	}
+
+	s.bus.Publish(eventbus.Event{Type: eventbus.ModemAdded, MacAddress: stored.MacAddress, Data: stored})
+	return stored, nil
 }
 
 func (s *SqliteStore) GetModem(mac string) (model.Modem, error) {
+	defer metrics.ObserveStore("get_modem", time.Now())
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	var modem model.Modem
-	return modem, s.db.QueryRowx("SELECT * FROM modems WHERE mac_address = ?", mac).StructScan(&modem)
+	err := s.db.QueryRowx("SELECT * FROM modems WHERE mac_address = ?", mac).StructScan(&modem)
+	return modem, translateError(err)
 }
 
 // 0: unknown, 1: normal, 2: busy, 3: error
-func (s *SqliteStore) SetModemState(mac string, state int) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	return CheckForZeroRowsAffected(s.db.Exec("UPDATE modems SET state = ? WHERE mac_address = ?", state, mac))
+func (s *SqliteStore) SetModemState(mac string, state int, origin model.Origin) error {
+	defer metrics.ObserveStore("set_modem_state", time.Now())
+
+	_, err := s.modifyModem(mac, 0, origin, func(modem *model.Modem) error {
+		modem.State = state
+		return nil
+	})
+	return err
 }
 
 // progress is a int from 0 to 100
-func (s *SqliteStore) SetModemUpgradeProgress(mac string, progress int) error {
+func (s *SqliteStore) SetModemUpgradeProgress(mac string, progress int, origin model.Origin) error {
+	defer metrics.ObserveStore("set_modem_upgrade_progress", time.Now())
+
+	_, err := s.modifyModem(mac, 0, origin, func(modem *model.Modem) error {
+		modem.Progress = progress
+		return nil
+	})
+	return err
+}
+
+// UpdateModem replaces a modem and returns it with its new version. If modem.Version
+// is not 0 the update only succeeds if the stored modem still has that version,
+// otherwise ErrVersionConflict is returned.
+func (s *SqliteStore) UpdateModem(modem model.Modem, origin model.Origin) (model.Modem, error) {
+	defer metrics.ObserveStore("update_modem", time.Now())
+
+	return s.modifyModem(modem.MacAddress, modem.Version, origin, func(m *model.Modem) error {
+		*m = modem
+		return nil
+	})
+}
+
+// ModifyModem applies change to the stored modem and returns the result, it is used
+// to update some fields without overwriting concurrent changes to the others
+func (s *SqliteStore) ModifyModem(mac string, origin model.Origin, change func(*model.Modem)) (model.Modem, error) {
+	defer metrics.ObserveStore("modify_modem", time.Now())
+
+	return s.modifyModem(mac, 0, origin, func(m *model.Modem) error {
+		change(m)
+		return nil
+	})
+}
+
+// PatchModem applies change to the stored modem like ModifyModem, change gets the
+// modem as it is stored and the error it returns aborts the update. If expectedVersion
+// is not 0 the stored modem must have that version, otherwise ErrVersionConflict is returned.
+func (s *SqliteStore) PatchModem(mac string, expectedVersion int, origin model.Origin, change func(*model.Modem) error) (model.Modem, error) {
+	defer metrics.ObserveStore("patch_modem", time.Now())
+
+	return s.modifyModem(mac, expectedVersion, origin, change)
+}
+
+// modifyModem applies change to the stored modem and records the changed fields
+// in the modem history within the same transaction. The update is a compare-and-swap
+// on the version column, so it also fails if another process changed the modem.
+func (s *SqliteStore) modifyModem(mac string, expectedVersion int, origin model.Origin, change func(*model.Modem) error) (model.Modem, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	return CheckForZeroRowsAffected(s.db.Exec("UPDATE modems SET progress = ? WHERE mac_address = ?", progress, mac))
-}
 
-func (s *SqliteStore) UpdateModem(modem model.Modem) error {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return model.Modem{}, err
+	}
+	defer tx.Rollback()
 
-	return CheckForZeroRowsAffected(s.db.NamedExec(
+	var old model.Modem
+	err = tx.QueryRowx("SELECT * FROM modems WHERE mac_address = ?", mac).StructScan(&old)
+	if err != nil {
+		return model.Modem{}, translateError(err)
+	}
+	if expectedVersion != 0 && expectedVersion != old.Version {
+		return old, ErrVersionConflict
+	}
+
+	modem := old
+	if err := change(&modem); err != nil {
+		return old, err
+	}
+	modem.MacAddress = mac
+	modem.Version = old.Version + 1
+
+	update := struct {
+		model.Modem
+		PreviousVersion int `db:"previous_version"`
+	}{modem, old.Version}
+
+	err = CheckForZeroRowsAffected(tx.NamedExec(
 		`UPDATE modems SET 
 			ipv6 = :ipv6, 
 			switch_port = :switch_port,
@@ -93,42 +202,93 @@ func (s *SqliteStore) UpdateModem(modem model.Modem) error {
 			imei = :imei,
 			iccid = :iccid,
 			imsi = :imsi,
-			progress = :progress
+			progress = :progress,
+			test_status = :test_status,
+			version = :version
 		WHERE 
-			mac_address = :mac_address`, modem))
-}
+			mac_address = :mac_address AND version = :previous_version`, update))
+	if errors.Is(err, ErrNoRowsAffected) {
+		return old, ErrVersionConflict
+	}
+	if err != nil {
+		return old, err
+	}
 
-func (s *SqliteStore) ListModems() ([]model.Modem, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	changes, err := insertModemEvents(tx, old, modem, origin)
+	if err != nil {
+		return old, err
+	}
 
-	var modems []model.Modem
-	return modems, s.db.Select(&modems, "SELECT * FROM modems")
+	err = tx.Commit()
+	if err != nil {
+		return old, err
+	}
+
+	s.bus.Publish(eventbus.Event{
+		Type:       eventbus.ModemUpdated,
+		MacAddress: mac,
+		Data:       model.ModemChange{Modem: modem, Changes: changes},
+	})
+	return modem, nil
 }
 
-func (s *SqliteStore) DeleteModem(mac string) error {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// DeleteModem deletes a modem and its order assignment, its history is kept
+// and records the deletion
+func (s *SqliteStore) DeleteModem(mac string, origin model.Origin) error {
+	defer metrics.ObserveStore("delete_modem", time.Now())
 
-	_, err := s.db.Exec("DELETE FROM modems WHERE mac_address = ?", mac)
-	return err
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = CheckForZeroRowsAffected(tx.Exec("DELETE FROM modems WHERE mac_address = ?", mac))
+	if err != nil {
+		return err
+	}
+	var orderID int64
+	err = tx.Get(&orderID, "SELECT order_id FROM order_modems WHERE mac_address = ?", mac)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM order_modems WHERE mac_address = ?", mac); err != nil {
+		return err
+	}
+	if err := insertModemLifecycleEvent(tx, mac, model.EventDeleted, origin); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if orderID != 0 {
+		s.publishOrder(orderID)
+	}
+
+	s.bus.Publish(eventbus.Event{Type: eventbus.ModemDeleted, MacAddress: mac})
+	return nil
 }
 
 func (s *SqliteStore) PrintModems() error {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	log.Println("Printing modems")
+	storeLog.Debug("printing modems")
 
-	row, err := s.db.Query("SELECT * FROM modems ORDER BY mac_address")
+	row, err := s.db.Queryx("SELECT * FROM modems ORDER BY mac_address")
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer row.Close()
 	for row.Next() {
 		var modem model.Modem
-		row.Scan(&modem)
-		log.Println("Modem: ", modem)
+		if err := row.StructScan(&modem); err != nil {
+			return err
+		}
+		storeLog.Debug("modem", "mac", modem.MacAddress, "state", model.StateName(modem.State), "version", modem.Version)
 	}
-	return nil
+	return row.Err()
 }
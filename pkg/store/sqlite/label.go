@@ -0,0 +1,159 @@
+package sqlitestore
+
+import (
+	"time"
+
+	"github.com/ebobo/modem_prod_go/pkg/metrics"
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+// maxLabelJobs limits the label jobs ListLabelJobs returns
+const maxLabelJobs = 1000
+
+// PutLabelTemplate adds or replaces a label template
+func (s *SqliteStore) PutLabelTemplate(t model.LabelTemplate) (model.LabelTemplate, error) {
+	defer metrics.ObserveStore("put_label_template", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t.UpdatedAt = int(time.Now().Unix())
+	t.BuiltIn = false
+	_, err := s.db.NamedExec(
+		`INSERT OR REPLACE INTO label_templates (
+			name,
+			description,
+			zpl,
+			updated_at)
+		 VALUES(
+			:name,
+			:description,
+			:zpl,
+			:updated_at)`, t)
+	return t, translateError(err)
+}
+
+func (s *SqliteStore) GetLabelTemplate(name string) (model.LabelTemplate, error) {
+	defer metrics.ObserveStore("get_label_template", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var t model.LabelTemplate
+	err := s.db.QueryRowx("SELECT * FROM label_templates WHERE name = ?", name).StructScan(&t)
+	return t, translateError(err)
+}
+
+// ListLabelTemplates returns the stored label templates ordered by name
+func (s *SqliteStore) ListLabelTemplates() ([]model.LabelTemplate, error) {
+	defer metrics.ObserveStore("list_label_templates", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	templates := []model.LabelTemplate{}
+	err := s.db.Select(&templates, "SELECT * FROM label_templates ORDER BY name")
+	return templates, err
+}
+
+func (s *SqliteStore) DeleteLabelTemplate(name string) error {
+	defer metrics.ObserveStore("delete_label_template", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return CheckForZeroRowsAffected(s.db.Exec("DELETE FROM label_templates WHERE name = ?", name))
+}
+
+// AddLabelJob stores a new queued print job and returns it with its id
+func (s *SqliteStore) AddLabelJob(job model.LabelJob) (model.LabelJob, error) {
+	defer metrics.ObserveStore("add_label_job", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job.Status = model.LabelQueued
+	job.Error = ""
+	job.CreatedAt = int(time.Now().Unix())
+	job.PrintedAt = 0
+
+	r, err := s.db.NamedExec(
+		`INSERT INTO label_jobs (
+			mac_address,
+			template,
+			printer,
+			copies,
+			status,
+			error,
+			reprint_of,
+			actor,
+			created_at,
+			printed_at,
+			zpl)
+		 VALUES(
+			:mac_address,
+			:template,
+			:printer,
+			:copies,
+			:status,
+			:error,
+			:reprint_of,
+			:actor,
+			:created_at,
+			:printed_at,
+			:zpl)`, job)
+	if err != nil {
+		return job, translateError(err)
+	}
+	job.ID, err = r.LastInsertId()
+	return job, err
+}
+
+func (s *SqliteStore) GetLabelJob(id int64) (model.LabelJob, error) {
+	defer metrics.ObserveStore("get_label_job", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var job model.LabelJob
+	err := s.db.QueryRowx("SELECT * FROM label_jobs WHERE id = ?", id).StructScan(&job)
+	return job, translateError(err)
+}
+
+// ListLabelJobs returns the most recent print jobs first, filtered by modem and
+// status if they are not empty
+func (s *SqliteStore) ListLabelJobs(mac string, status string) ([]model.LabelJob, error) {
+	defer metrics.ObserveStore("list_label_jobs", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	jobs := []model.LabelJob{}
+	err := s.db.Select(&jobs,
+		`SELECT * FROM label_jobs
+		 WHERE (? = '' OR mac_address = ?) AND (? = '' OR status = ?)
+		 ORDER BY id DESC LIMIT ?`, mac, mac, status, status, maxLabelJobs)
+	return jobs, err
+}
+
+// FinishLabelJob records the outcome of a print job, errMsg is empty if it was printed
+func (s *SqliteStore) FinishLabelJob(id int64, errMsg string) (model.LabelJob, error) {
+	defer metrics.ObserveStore("finish_label_job", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status, printedAt := model.LabelPrinted, int(time.Now().Unix())
+	if errMsg != "" {
+		status, printedAt = model.LabelFailed, 0
+	}
+	err := CheckForZeroRowsAffected(s.db.Exec(
+		"UPDATE label_jobs SET status = ?, error = ?, printed_at = ? WHERE id = ?", status, errMsg, printedAt, id))
+	if err != nil {
+		return model.LabelJob{}, err
+	}
+
+	var job model.LabelJob
+	err = s.db.QueryRowx("SELECT * FROM label_jobs WHERE id = ?", id).StructScan(&job)
+	return job, translateError(err)
+}
@@ -0,0 +1,316 @@
+package sqlitestore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/ebobo/modem_prod_go/pkg/eventbus"
+	"github.com/ebobo/modem_prod_go/pkg/metrics"
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+// selectOrders selects orders together with the counts of their assigned modems.
+// A modem is completed once it is upgraded to the target firmware of the order,
+// if it has one, and is neither in error nor failed or still running its test.
+const selectOrders = `SELECT o.*,
+		COUNT(m.mac_address) AS assigned,
+		COALESCE(SUM(m.upgraded = 1 AND m.state != 3
+			AND (COALESCE(o.firmware, '') = '' OR m.firmware = o.firmware)
+			AND m.test_status NOT IN ('running', 'failed')), 0) AS completed,
+		COALESCE(SUM(m.state = 3 OR m.test_status = 'failed'), 0) AS failed
+	FROM orders o
+	LEFT JOIN order_modems om ON om.order_id = o.id
+	LEFT JOIN modems m ON m.mac_address = om.mac_address`
+
+// AddOrder creates a new open work order and returns its id
+func (s *SqliteStore) AddOrder(order model.Order) (int64, error) {
+	defer metrics.ObserveStore("add_order", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order.Status = model.OrderOpen
+	order.CreatedAt = int(time.Now().Unix())
+	order.ClosedAt = 0
+
+	r, err := s.db.NamedExec(
+		`INSERT INTO orders (
+			customer,
+			quantity,
+			model,
+			firmware,
+			config_profile,
+			bench_from,
+			bench_to,
+			status,
+			created_at,
+			closed_at)
+		 VALUES(
+			:customer,
+			:quantity,
+			:model,
+			:firmware,
+			:config_profile,
+			:bench_from,
+			:bench_to,
+			:status,
+			:created_at,
+			:closed_at)`, order)
+	if err != nil {
+		return 0, err
+	}
+	id, err := r.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	s.publishOrder(id)
+	return id, nil
+}
+
+func (s *SqliteStore) GetOrder(id int64) (model.Order, error) {
+	defer metrics.ObserveStore("get_order", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return getOrder(s.db, id)
+}
+
+// ListOrders returns all orders, or only those with the given status if it is not empty
+func (s *SqliteStore) ListOrders(status string) ([]model.Order, error) {
+	defer metrics.ObserveStore("list_orders", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	query := selectOrders
+	args := []interface{}{}
+	if status != "" {
+		query += " WHERE o.status = ?"
+		args = append(args, status)
+	}
+	query += " GROUP BY o.id ORDER BY o.id"
+
+	orders := []model.Order{}
+	return orders, s.db.Select(&orders, query, args...)
+}
+
+// ListOrderModems returns the modems assigned to an order
+func (s *SqliteStore) ListOrderModems(id int64) ([]model.Modem, error) {
+	defer metrics.ObserveStore("list_order_modems", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	modems := []model.Modem{}
+	return modems, s.db.Select(&modems,
+		`SELECT m.* FROM modems m
+		JOIN order_modems om ON om.mac_address = m.mac_address
+		WHERE om.order_id = ?
+		ORDER BY m.switch_port`, id)
+}
+
+// GetModemOrder returns the order a modem is assigned to, ErrNotFound if it is not assigned
+func (s *SqliteStore) GetModemOrder(mac string) (model.Order, error) {
+	defer metrics.ObserveStore("get_modem_order", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var id int64
+	err := s.db.Get(&id, "SELECT order_id FROM order_modems WHERE mac_address = ?", mac)
+	if err != nil {
+		return model.Order{}, translateError(err)
+	}
+	return getOrder(s.db, id)
+}
+
+// AssignModems assigns the given modems to an open order. Modems already assigned to
+// the order are skipped, modems assigned to another order cause ErrUniqueConstraintViolation.
+func (s *SqliteStore) AssignModems(id int64, macs []string) error {
+	defer metrics.ObserveStore("assign_modems", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	order, err := getOrder(tx, id)
+	if err != nil {
+		return err
+	}
+	if order.Status != model.OrderOpen {
+		return ErrOrderClosed
+	}
+
+	assigned := order.Assigned
+	now := time.Now().Unix()
+	for _, mac := range macs {
+		var modems int
+		err = tx.Get(&modems, "SELECT COUNT(*) FROM modems WHERE mac_address = ?", mac)
+		if err != nil {
+			return err
+		}
+		if modems == 0 {
+			return fmt.Errorf("modem %s: %w", mac, ErrNotFound)
+		}
+
+		var current int64
+		err = tx.Get(&current, "SELECT order_id FROM order_modems WHERE mac_address = ?", mac)
+		if err == nil {
+			if current == id {
+				continue
+			}
+			return fmt.Errorf("modem %s is assigned to order %d: %w", mac, current, ErrUniqueConstraintViolation)
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		if assigned >= order.Quantity {
+			return ErrOrderFull
+		}
+
+		_, err = tx.Exec("INSERT INTO order_modems (mac_address, order_id, assigned_at) VALUES (?, ?, ?)", mac, id, now)
+		if err != nil {
+			return err
+		}
+		assigned++
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	s.publishOrder(id)
+	return nil
+}
+
+// AutoAssignModems assigns unassigned modems on the order's bench that match the
+// order model, up to the order quantity. It returns the number of modems assigned.
+func (s *SqliteStore) AutoAssignModems(id int64) (int, error) {
+	defer metrics.ObserveStore("auto_assign_modems", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	order, err := getOrder(tx, id)
+	if err != nil {
+		return 0, err
+	}
+	if order.Status != model.OrderOpen {
+		return 0, ErrOrderClosed
+	}
+
+	r, err := tx.Exec(
+		`INSERT INTO order_modems (mac_address, order_id, assigned_at)
+		SELECT mac_address, ?, ? FROM modems
+		WHERE switch_port BETWEEN ? AND ?
+			AND (? = '' OR model = ?)
+			AND mac_address NOT IN (SELECT mac_address FROM order_modems)
+		ORDER BY switch_port
+		LIMIT ?`,
+		id, time.Now().Unix(), order.BenchFrom, order.BenchTo, order.Model, order.Model, order.Quantity-order.Assigned)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := r.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	s.publishOrder(id)
+	return int(affected), nil
+}
+
+// UnassignModem removes a modem from an open order
+func (s *SqliteStore) UnassignModem(id int64, mac string) error {
+	defer metrics.ObserveStore("unassign_modem", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, err := getOrder(s.db, id)
+	if err != nil {
+		return err
+	}
+	if order.Status != model.OrderOpen {
+		return ErrOrderClosed
+	}
+
+	err = CheckForZeroRowsAffected(s.db.Exec("DELETE FROM order_modems WHERE order_id = ? AND mac_address = ?", id, mac))
+	if err != nil {
+		return err
+	}
+
+	s.publishOrder(id)
+	return nil
+}
+
+// CloseOrder closes an open order and returns it with its final counts
+func (s *SqliteStore) CloseOrder(id int64) (model.Order, error) {
+	defer metrics.ObserveStore("close_order", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, err := getOrder(s.db, id)
+	if err != nil {
+		return order, err
+	}
+	if order.Status != model.OrderOpen {
+		return order, ErrOrderClosed
+	}
+
+	order.Status = model.OrderClosed
+	order.ClosedAt = int(time.Now().Unix())
+	err = CheckForZeroRowsAffected(s.db.Exec("UPDATE orders SET status = ?, closed_at = ? WHERE id = ?", order.Status, order.ClosedAt, id))
+	if err != nil {
+		return order, err
+	}
+
+	s.bus.Publish(eventbus.Event{Type: eventbus.OrderChanged, Data: order})
+	return order, nil
+}
+
+// publishOrder publishes the current state of an order, it must be called with the lock held
+func (s *SqliteStore) publishOrder(id int64) {
+	if s.bus == nil {
+		return
+	}
+	order, err := getOrder(s.db, id)
+	if err != nil {
+		return
+	}
+	s.bus.Publish(eventbus.Event{Type: eventbus.OrderChanged, Data: order})
+}
+
+func getOrder(q sqlx.Queryer, id int64) (model.Order, error) {
+	var order model.Order
+	err := q.QueryRowx(selectOrders+" WHERE o.id = ? GROUP BY o.id", id).StructScan(&order)
+	if errors.Is(err, sql.ErrNoRows) {
+		return order, ErrNotFound
+	}
+	return order, err
+}
@@ -0,0 +1,172 @@
+package sqlitestore
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+var testOrigin = model.Origin{Source: model.SourceAPI, Actor: "test"}
+
+// newTestStore returns an empty store in a temporary directory
+func newTestStore(t *testing.T) *SqliteStore {
+	t.Helper()
+
+	db, _, err := New(filepath.Join(t.TempDir(), "modems.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// addTestModems stores the modems, with the fields they have set
+func addTestModems(t *testing.T, db *SqliteStore, modems ...model.Modem) {
+	t.Helper()
+
+	for _, m := range modems {
+		if _, err := db.AddModem(model.Modem{MacAddress: m.MacAddress}, testOrigin); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.UpdateModem(m, testOrigin); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestOrderCounts(t *testing.T) {
+	modems := []model.Modem{
+		{MacAddress: "00:1f:43:00:00:01", Upgraded: true, State: model.StateNormal, Firmware: "B", TestStatus: model.TestPassed},
+		{MacAddress: "00:1f:43:00:00:02", Upgraded: true, State: model.StateNormal, Firmware: "B"},
+		{MacAddress: "00:1f:43:00:00:03", Upgraded: true, State: model.StateNormal, Firmware: "A"},
+		{MacAddress: "00:1f:43:00:00:04", Upgraded: true, State: model.StateError, Firmware: "B"},
+		{MacAddress: "00:1f:43:00:00:05", Upgraded: true, State: model.StateNormal, Firmware: "B", TestStatus: model.TestFailed},
+		{MacAddress: "00:1f:43:00:00:06", Upgraded: true, State: model.StateNormal, Firmware: "B", TestStatus: model.TestRunning},
+		{MacAddress: "00:1f:43:00:00:07", State: model.StateNormal, Firmware: "B"},
+	}
+
+	for _, tt := range []struct {
+		name      string
+		firmware  string
+		completed int
+	}{
+		{"target firmware", "B", 2},
+		{"no target firmware", "", 3},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestStore(t)
+			addTestModems(t, db, modems...)
+			id, err := db.AddOrder(model.Order{Customer: "acme", Quantity: 10, Firmware: tt.firmware})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var macs []string
+			for _, m := range modems {
+				macs = append(macs, m.MacAddress)
+			}
+			if err := db.AssignModems(id, macs); err != nil {
+				t.Fatal(err)
+			}
+
+			order, err := db.GetOrder(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if order.Assigned != 7 || order.Completed != tt.completed || order.Failed != 2 {
+				t.Errorf("assigned %d, completed %d, failed %d, want 7, %d, 2", order.Assigned, order.Completed, order.Failed, tt.completed)
+			}
+
+			// a deleted modem is no longer assigned
+			if err := db.DeleteModem("00:1f:43:00:00:01", testOrigin); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := db.GetModemOrder("00:1f:43:00:00:01"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("deleted modem is in an order: %v", err)
+			}
+			closed, err := db.CloseOrder(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if closed.Status != model.OrderClosed || closed.ClosedAt == 0 || closed.Assigned != 6 || closed.Completed != tt.completed-1 {
+				t.Errorf("closed order %+v", closed)
+			}
+		})
+	}
+}
+
+func TestAssignModems(t *testing.T) {
+	db := newTestStore(t)
+	addTestModems(t, db,
+		model.Modem{MacAddress: "00:1f:43:00:00:01", SwitchPort: 1, Model: "TRB140"},
+		model.Modem{MacAddress: "00:1f:43:00:00:02", SwitchPort: 2, Model: "TRB140"},
+		model.Modem{MacAddress: "00:1f:43:00:00:03", SwitchPort: 3, Model: "TRB141"},
+		model.Modem{MacAddress: "00:1f:43:00:00:04", SwitchPort: 9, Model: "TRB140"},
+	)
+	first, err := db.AddOrder(model.Order{Customer: "acme", Quantity: 2, Model: "TRB140", BenchFrom: 1, BenchTo: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := db.AddOrder(model.Order{Customer: "other", Quantity: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the bench and model of the order select the modems
+	if n, err := db.AutoAssignModems(first); err != nil || n != 2 {
+		t.Fatalf("auto assigned %d, %v", n, err)
+	}
+	modems, err := db.ListOrderModems(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(modems) != 2 || modems[0].MacAddress != "00:1f:43:00:00:01" || modems[1].MacAddress != "00:1f:43:00:00:02" {
+		t.Errorf("assigned %+v", modems)
+	}
+
+	for _, tt := range []struct {
+		name  string
+		id    int64
+		macs  []string
+		err   error
+		order int64 // the order 00:1f:43:00:00:03 is assigned to afterwards, 0 for none
+	}{
+		{"already assigned to it", first, []string{"00:1f:43:00:00:01"}, nil, 0},
+		{"full", first, []string{"00:1f:43:00:00:03"}, ErrOrderFull, 0},
+		{"assigned to another", second, []string{"00:1f:43:00:00:01"}, ErrUniqueConstraintViolation, 0},
+		{"unknown modem", second, []string{"00:1f:43:00:00:09"}, ErrNotFound, 0},
+		{"unknown order", 99, []string{"00:1f:43:00:00:03"}, ErrNotFound, 0},
+		{"rolled back", second, []string{"00:1f:43:00:00:03", "00:1f:43:00:00:09"}, ErrNotFound, 0},
+		{"assigned", second, []string{"00:1f:43:00:00:03"}, nil, second},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := db.AssignModems(tt.id, tt.macs); !errors.Is(err, tt.err) {
+				t.Fatalf("error %v, want %v", err, tt.err)
+			}
+			order, err := db.GetModemOrder("00:1f:43:00:00:03")
+			if tt.order == 0 && !errors.Is(err, ErrNotFound) || tt.order != 0 && order.ID != tt.order {
+				t.Errorf("00:1f:43:00:00:03 is in order %d, %v", order.ID, err)
+			}
+		})
+	}
+
+	if err := db.UnassignModem(first, "00:1f:43:00:00:02"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UnassignModem(first, "00:1f:43:00:00:02"); !errors.Is(err, ErrNoRowsAffected) {
+		t.Errorf("unassigned twice: %v", err)
+	}
+	if _, err := db.CloseOrder(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AssignModems(first, []string{"00:1f:43:00:00:02"}); !errors.Is(err, ErrOrderClosed) {
+		t.Errorf("assigned to a closed order: %v", err)
+	}
+	if _, err := db.CloseOrder(first); !errors.Is(err, ErrOrderClosed) {
+		t.Errorf("closed twice: %v", err)
+	}
+	if orders, err := db.ListOrders(model.OrderOpen); err != nil || len(orders) != 1 || orders[0].ID != second {
+		t.Errorf("open orders %+v, %v", orders, err)
+	}
+}
@@ -0,0 +1,86 @@
+package sqlitestore
+
+import (
+	"time"
+
+	"github.com/ebobo/modem_prod_go/pkg/metrics"
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+// AddModemPassword stores a sealed password as pending, before it is set on the
+// modem, so it is not lost if setting it succeeds but the outcome is not seen
+func (s *SqliteStore) AddModemPassword(p model.ModemPassword) (model.ModemPassword, error) {
+	defer metrics.ObserveStore("add_modem_password", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p.Status = model.PasswordPending
+	p.CreatedAt = int(time.Now().Unix())
+	p.SetAt = 0
+	r, err := s.db.NamedExec(
+		`INSERT INTO modem_passwords (
+			mac_address,
+			user,
+			sealed,
+			profile,
+			status,
+			created_at,
+			set_at)
+		 VALUES(
+			:mac_address,
+			:user,
+			:sealed,
+			:profile,
+			:status,
+			:created_at,
+			:set_at)`, p)
+	if err != nil {
+		return p, translateError(err)
+	}
+	p.ID, err = r.LastInsertId()
+	return p, err
+}
+
+// ActivateModemPassword records that a pending password was set on the modem
+func (s *SqliteStore) ActivateModemPassword(id int64) error {
+	defer metrics.ObserveStore("activate_modem_password", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return CheckForZeroRowsAffected(s.db.Exec(
+		"UPDATE modem_passwords SET status = ?, set_at = ? WHERE id = ?", model.PasswordActive, int(time.Now().Unix()), id))
+}
+
+// GetModemPassword returns the password last set on a modem
+func (s *SqliteStore) GetModemPassword(mac string) (model.ModemPassword, error) {
+	defer metrics.ObserveStore("get_modem_password", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var p model.ModemPassword
+	err := s.db.QueryRowx(
+		"SELECT * FROM modem_passwords WHERE mac_address = ? AND status = ? ORDER BY id DESC LIMIT 1",
+		mac, model.PasswordActive).StructScan(&p)
+	return p, translateError(err)
+}
+
+// CurrentModemPasswords returns the password last set on a modem and the
+// pending ones added after it, newest first. Any of them may be the one the
+// modem has.
+func (s *SqliteStore) CurrentModemPasswords(mac string) ([]model.ModemPassword, error) {
+	defer metrics.ObserveStore("current_modem_passwords", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	passwords := []model.ModemPassword{}
+	err := s.db.Select(&passwords,
+		`SELECT * FROM modem_passwords
+		 WHERE mac_address = ? AND id >= COALESCE(
+			(SELECT MAX(id) FROM modem_passwords WHERE mac_address = ? AND status = ?), 0)
+		 ORDER BY id DESC`, mac, mac, model.PasswordActive)
+	return passwords, err
+}
@@ -0,0 +1,217 @@
+package sqlitestore
+
+import (
+	"time"
+
+	"github.com/ebobo/modem_prod_go/pkg/metrics"
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+// PutConfigProfile adds or replaces a config profile
+func (s *SqliteStore) PutConfigProfile(p model.ConfigProfile) (model.ConfigProfile, error) {
+	defer metrics.ObserveStore("put_config_profile", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p.UpdatedAt = int(time.Now().Unix())
+	p.AdminPasswordSet = p.AdminPassword != ""
+	_, err := s.db.NamedExec(
+		`INSERT OR REPLACE INTO config_profiles (
+			name,
+			description,
+			settings,
+			admin_password,
+			updated_at,
+			unique_password,
+			admin_password_sealed)
+		 VALUES(
+			:name,
+			:description,
+			:settings,
+			:admin_password,
+			:updated_at,
+			:unique_password,
+			:admin_password_sealed)`, p)
+	return p, translateError(err)
+}
+
+func (s *SqliteStore) GetConfigProfile(name string) (model.ConfigProfile, error) {
+	defer metrics.ObserveStore("get_config_profile", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var p model.ConfigProfile
+	err := s.db.QueryRowx("SELECT * FROM config_profiles WHERE name = ?", name).StructScan(&p)
+	p.AdminPasswordSet = p.AdminPassword != ""
+	return p, translateError(err)
+}
+
+// ListConfigProfiles returns the config profiles ordered by name
+func (s *SqliteStore) ListConfigProfiles() ([]model.ConfigProfile, error) {
+	defer metrics.ObserveStore("list_config_profiles", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	profiles := []model.ConfigProfile{}
+	err := s.db.Select(&profiles, "SELECT * FROM config_profiles ORDER BY name")
+	for i := range profiles {
+		profiles[i].AdminPasswordSet = profiles[i].AdminPassword != ""
+	}
+	return profiles, err
+}
+
+// SetConfigProfilePassword replaces the admin password of a config profile
+// without changing its updated time, it is used to seal stored passwords
+func (s *SqliteStore) SetConfigProfilePassword(name string, password string, sealed bool) error {
+	defer metrics.ObserveStore("set_config_profile_password", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return CheckForZeroRowsAffected(s.db.Exec(
+		"UPDATE config_profiles SET admin_password = ?, admin_password_sealed = ? WHERE name = ?",
+		password, sealed, name))
+}
+
+// CountUnescrowedProfilePasswords counts the modems that got the admin
+// password of a profile without an active copy of it in the password escrow
+func (s *SqliteStore) CountUnescrowedProfilePasswords(name string) (int, error) {
+	defer metrics.ObserveStore("count_unescrowed_profile_passwords", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var count int
+	err := s.db.Get(&count,
+		`SELECT COUNT(*) FROM modem_provisioning p
+		 WHERE p.password_profile = ?
+		   AND NOT EXISTS (
+			SELECT 1 FROM modem_passwords m
+			WHERE m.mac_address = p.mac_address AND m.profile = ? AND m.status = ?)`,
+		name, name, model.PasswordActive)
+	return count, err
+}
+
+// DeleteConfigProfile deletes a config profile, unless an open order uses it
+// or modems have its admin password
+func (s *SqliteStore) DeleteConfigProfile(name string) error {
+	defer metrics.ObserveStore("delete_config_profile", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var uses int
+	err := s.db.Get(&uses,
+		`SELECT (SELECT COUNT(*) FROM orders WHERE config_profile = ? AND status = ?)
+			+ (SELECT COUNT(*) FROM modem_provisioning WHERE password_profile = ?)`, name, model.OrderOpen, name)
+	if err != nil {
+		return err
+	}
+	if uses > 0 {
+		return ErrProfileInUse
+	}
+	return CheckForZeroRowsAffected(s.db.Exec("DELETE FROM config_profiles WHERE name = ?", name))
+}
+
+// StartProvisioning records that a profile is being applied to a modem, it
+// returns ErrProvisioning if one already is
+func (s *SqliteStore) StartProvisioning(mac string, profile string, actor string) (model.Provisioning, error) {
+	defer metrics.ObserveStore("start_provisioning", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var last model.Provisioning
+	err := s.db.QueryRowx("SELECT * FROM modem_provisioning WHERE mac_address = ?", mac).StructScan(&last)
+	if err == nil && last.Status == model.ProvisionRunning {
+		return model.Provisioning{}, ErrProvisioning
+	}
+
+	p := model.Provisioning{
+		MacAddress:      mac,
+		Profile:         profile,
+		Status:          model.ProvisionRunning,
+		Actor:           actor,
+		StartedAt:       int(time.Now().Unix()),
+		PasswordProfile: last.PasswordProfile,
+	}
+	_, err = s.db.NamedExec(
+		`INSERT OR REPLACE INTO modem_provisioning (
+			mac_address,
+			profile,
+			status,
+			error,
+			actor,
+			started_at,
+			finished_at,
+			password_profile)
+		 VALUES(
+			:mac_address,
+			:profile,
+			:status,
+			:error,
+			:actor,
+			:started_at,
+			:finished_at,
+			:password_profile)`, p)
+	return p, translateError(err)
+}
+
+// FinishProvisioning records the outcome of provisioning a modem, errMsg is
+// empty if it succeeded. passwordChanged records that the modem now has the
+// admin password of the profile.
+func (s *SqliteStore) FinishProvisioning(mac string, errMsg string, passwordChanged bool) (model.Provisioning, error) {
+	defer metrics.ObserveStore("finish_provisioning", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := model.ProvisionApplied
+	if errMsg != "" {
+		status = model.ProvisionFailed
+	}
+	err := CheckForZeroRowsAffected(s.db.Exec(
+		`UPDATE modem_provisioning SET status = ?, error = ?, finished_at = ?,
+			password_profile = CASE WHEN ? THEN profile ELSE password_profile END
+		 WHERE mac_address = ?`,
+		status, errMsg, int(time.Now().Unix()), passwordChanged, mac))
+	if err != nil {
+		return model.Provisioning{}, err
+	}
+
+	var p model.Provisioning
+	err = s.db.QueryRowx("SELECT * FROM modem_provisioning WHERE mac_address = ?", mac).StructScan(&p)
+	return p, translateError(err)
+}
+
+// GetProvisioning returns the latest provisioning of a modem
+func (s *SqliteStore) GetProvisioning(mac string) (model.Provisioning, error) {
+	defer metrics.ObserveStore("get_provisioning", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var p model.Provisioning
+	err := s.db.QueryRowx("SELECT * FROM modem_provisioning WHERE mac_address = ?", mac).StructScan(&p)
+	return p, translateError(err)
+}
+
+// InterruptProvisioning marks provisioning that was running when the server
+// stopped as failed
+func (s *SqliteStore) InterruptProvisioning() (int64, error) {
+	defer metrics.ObserveStore("interrupt_provisioning", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, err := s.db.Exec(
+		"UPDATE modem_provisioning SET status = ?, error = ?, finished_at = ? WHERE status = ?",
+		model.ProvisionFailed, "interrupted by a server restart", int(time.Now().Unix()), model.ProvisionRunning)
+	if err != nil {
+		return 0, err
+	}
+	return r.RowsAffected()
+}
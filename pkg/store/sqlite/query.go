@@ -0,0 +1,217 @@
+package sqlitestore
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/ebobo/modem_prod_go/pkg/metrics"
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+// ErrInvalidQuery is returned when a modem query has an unknown sort column or a bad cursor
+var ErrInvalidQuery = errors.New("invalid query")
+
+// ModemQuery filters, sorts and paginates the modems returned by ListModems.
+// Nil and empty fields do not filter.
+type ModemQuery struct {
+	State       *int
+	Model       string
+	Firmware    string
+	Upgraded    *bool
+	SIMProvider string
+	TestStatus  string
+	SwitchPort  *int
+	UpdatedFrom int // unix timestamp, inclusive
+	UpdatedTo   int // unix timestamp, inclusive
+
+	SortBy     string // any modem column, defaults to mac_address
+	Descending bool
+	Cursor     string // NextCursor of the previous page
+	Limit      int    // 0 returns all remaining modems
+}
+
+// ModemPage is one page of modems
+type ModemPage struct {
+	Modems     []model.Modem
+	Total      int    // number of modems matching the filters
+	NextCursor string // empty on the last page
+}
+
+// ListModems returns the modems matching the query
+func (s *SqliteStore) ListModems(q ModemQuery) (ModemPage, error) {
+	defer metrics.ObserveStore("list_modems", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	page := ModemPage{Modems: []model.Modem{}}
+
+	sortBy := q.SortBy
+	if sortBy == "" {
+		sortBy = "mac_address"
+	}
+	if !isModemColumn(sortBy) {
+		return page, fmt.Errorf("unknown sort column %q: %w", sortBy, ErrInvalidQuery)
+	}
+
+	where, args := q.filters()
+
+	err := s.db.Get(&page.Total, "SELECT COUNT(*) FROM modems"+whereClause(where), args...)
+	if err != nil {
+		return page, err
+	}
+
+	direction, compare := "ASC", ">"
+	if q.Descending {
+		direction, compare = "DESC", "<"
+	}
+
+	if q.Cursor != "" {
+		value, mac, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return page, err
+		}
+		if sortBy == "mac_address" {
+			where = append(where, "mac_address "+compare+" ?")
+			args = append(args, mac)
+		} else {
+			where = append(where, fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND mac_address %[2]s ?))", sortBy, compare))
+			args = append(args, value, value, mac)
+		}
+	}
+
+	query := "SELECT * FROM modems" + whereClause(where) + fmt.Sprintf(" ORDER BY %[1]s %[2]s, mac_address %[2]s", sortBy, direction)
+	if q.Limit > 0 {
+		// Fetch one more than requested to know if there is a next page
+		query += " LIMIT ?"
+		args = append(args, q.Limit+1)
+	}
+
+	err = s.db.Select(&page.Modems, query, args...)
+	if err != nil {
+		return page, err
+	}
+
+	if q.Limit > 0 && len(page.Modems) > q.Limit {
+		page.Modems = page.Modems[:q.Limit]
+		last := page.Modems[q.Limit-1]
+		page.NextCursor = encodeCursor(modemColumnValue(last, sortBy), last.MacAddress)
+	}
+
+	return page, nil
+}
+
+func (q ModemQuery) filters() ([]string, []interface{}) {
+	var where []string
+	var args []interface{}
+
+	add := func(clause string, arg interface{}) {
+		where = append(where, clause)
+		args = append(args, arg)
+	}
+
+	if q.State != nil {
+		add("state = ?", *q.State)
+	}
+	if q.Model != "" {
+		add("model = ?", q.Model)
+	}
+	if q.Firmware != "" {
+		add("firmware = ?", q.Firmware)
+	}
+	if q.Upgraded != nil {
+		add("upgraded = ?", *q.Upgraded)
+	}
+	if q.SIMProvider != "" {
+		add("sim_provider = ?", q.SIMProvider)
+	}
+	if q.TestStatus != "" {
+		add("test_status = ?", q.TestStatus)
+	}
+	if q.SwitchPort != nil {
+		add("switch_port = ?", *q.SwitchPort)
+	}
+	if q.UpdatedFrom > 0 {
+		add("last_updated >= ?", q.UpdatedFrom)
+	}
+	if q.UpdatedTo > 0 {
+		add("last_updated <= ?", q.UpdatedTo)
+	}
+	return where, args
+}
+
+func whereClause(where []string) string {
+	if len(where) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(where, " AND ")
+}
+
+// isModemColumn reports whether column is a column of the modems table. Since
+// sort columns are put into the query text they must be checked against this.
+func isModemColumn(column string) bool {
+	t := reflect.TypeOf(model.Modem{})
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("db") == column {
+			return true
+		}
+	}
+	return false
+}
+
+func modemColumnValue(modem model.Modem, column string) interface{} {
+	v := reflect.ValueOf(modem)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("db") == column {
+			return v.Field(i).Interface()
+		}
+	}
+	return nil
+}
+
+// The cursor is the sort value and mac address of the last modem of a page
+func encodeCursor(value interface{}, mac string) string {
+	b, _ := json.Marshal([]interface{}{value, mac})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(cursor string) (interface{}, string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, "", fmt.Errorf("bad cursor: %w", ErrInvalidQuery)
+	}
+
+	var values []interface{}
+	if json.Unmarshal(b, &values) != nil || len(values) != 2 {
+		return nil, "", fmt.Errorf("bad cursor: %w", ErrInvalidQuery)
+	}
+	mac, ok := values[1].(string)
+	if !ok {
+		return nil, "", fmt.Errorf("bad cursor: %w", ErrInvalidQuery)
+	}
+	return values[0], mac, nil
+}
+
+// ModemCount is the number of modems with a state and model
+type ModemCount struct {
+	State int    `db:"state"`
+	Model string `db:"model"`
+	Count int    `db:"count"`
+}
+
+// CountModems returns the number of modems per state and model
+func (s *SqliteStore) CountModems() ([]ModemCount, error) {
+	defer metrics.ObserveStore("count_modems", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := []ModemCount{}
+	return counts, s.db.Select(&counts, "SELECT state, model, COUNT(*) AS count FROM modems GROUP BY state, model")
+}
@@ -0,0 +1,159 @@
+package sqlitestore
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+// queryTestModems have repeated values in the sort columns, so paging has to
+// break ties by MAC address
+func queryTestModems() []model.Modem {
+	ports := []int{2, 1, 2, 2, 1, 3, 2}
+	firmware := []string{"B", "A", "B", "A", "B", "B", "A"}
+	var modems []model.Modem
+	for i, port := range ports {
+		modems = append(modems, model.Modem{
+			MacAddress: fmt.Sprintf("00:1f:43:00:00:%02x", 7-i), // not in the order of the ports
+			SwitchPort: port,
+			Firmware:   firmware[i],
+			Upgraded:   i%3 == 0,
+			State:      model.StateNormal,
+			TestStatus: []string{"", model.TestPassed, model.TestFailed}[i%3],
+		})
+	}
+	return modems
+}
+
+func macs(modems []model.Modem) []string {
+	macs := []string{}
+	for _, m := range modems {
+		macs = append(macs, m.MacAddress)
+	}
+	return macs
+}
+
+func TestListModemsPaging(t *testing.T) {
+	db := newTestStore(t)
+	modems := queryTestModems()
+	addTestModems(t, db, modems...)
+
+	for _, tt := range []struct {
+		sortBy string
+		less   func(a, b model.Modem) bool
+	}{
+		{"", func(a, b model.Modem) bool { return false }},
+		{"switch_port", func(a, b model.Modem) bool { return a.SwitchPort < b.SwitchPort }},
+		{"firmware", func(a, b model.Modem) bool { return a.Firmware < b.Firmware }},
+		{"upgraded", func(a, b model.Modem) bool { return !a.Upgraded && b.Upgraded }},
+	} {
+		for _, descending := range []bool{false, true} {
+			for _, limit := range []int{1, 2, 3, 7, 10} {
+				t.Run(fmt.Sprintf("%s descending %v limit %d", tt.sortBy, descending, limit), func(t *testing.T) {
+					want := append([]model.Modem(nil), modems...)
+					sort.Slice(want, func(i, j int) bool {
+						a, b := want[i], want[j]
+						if descending {
+							a, b = b, a
+						}
+						if tt.less(a, b) != tt.less(b, a) {
+							return tt.less(a, b)
+						}
+						return a.MacAddress < b.MacAddress
+					})
+
+					var got []model.Modem
+					q := ModemQuery{SortBy: tt.sortBy, Descending: descending, Limit: limit}
+					for pages := 1; ; pages++ {
+						page, err := db.ListModems(q)
+						if err != nil {
+							t.Fatal(err)
+						}
+						if page.Total != len(modems) || len(page.Modems) > limit {
+							t.Fatalf("page of %d modems, total %d", len(page.Modems), page.Total)
+						}
+						got = append(got, page.Modems...)
+						if page.NextCursor == "" {
+							break
+						}
+						if pages > len(modems) {
+							t.Fatal("paging does not end")
+						}
+						q.Cursor = page.NextCursor
+					}
+					if !reflect.DeepEqual(macs(got), macs(want)) {
+						t.Errorf("got %v, want %v", macs(got), macs(want))
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestListModemsFilters(t *testing.T) {
+	db := newTestStore(t)
+	addTestModems(t, db, queryTestModems()...)
+	addTestModems(t, db, model.Modem{MacAddress: "00:1f:43:00:00:10", State: model.StateError, Model: "TRB141", SIMProvider: "twilio", LastUpdated: 1000})
+
+	state, port, upgraded := model.StateError, 2, true
+	for _, tt := range []struct {
+		name  string
+		query ModemQuery
+		want  []string
+	}{
+		{"all", ModemQuery{}, []string{"00:1f:43:00:00:01", "00:1f:43:00:00:02", "00:1f:43:00:00:03", "00:1f:43:00:00:04",
+			"00:1f:43:00:00:05", "00:1f:43:00:00:06", "00:1f:43:00:00:07", "00:1f:43:00:00:10"}},
+		{"state", ModemQuery{State: &state}, []string{"00:1f:43:00:00:10"}},
+		{"model", ModemQuery{Model: "TRB141"}, []string{"00:1f:43:00:00:10"}},
+		{"sim provider", ModemQuery{SIMProvider: "twilio"}, []string{"00:1f:43:00:00:10"}},
+		{"switch port", ModemQuery{SwitchPort: &port}, []string{"00:1f:43:00:00:01", "00:1f:43:00:00:04", "00:1f:43:00:00:05", "00:1f:43:00:00:07"}},
+		{"firmware and upgraded", ModemQuery{Firmware: "A", Upgraded: &upgraded}, []string{"00:1f:43:00:00:01", "00:1f:43:00:00:04"}},
+		{"test status", ModemQuery{TestStatus: model.TestFailed}, []string{"00:1f:43:00:00:02", "00:1f:43:00:00:05"}},
+		{"updated from", ModemQuery{UpdatedFrom: 1000}, []string{"00:1f:43:00:00:10"}},
+		{"updated to", ModemQuery{UpdatedFrom: 1, UpdatedTo: 999}, []string{}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := db.ListModems(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(macs(page.Modems), tt.want) || page.Total != len(tt.want) {
+				t.Errorf("got %v, total %d, want %v", macs(page.Modems), page.Total, tt.want)
+			}
+		})
+	}
+}
+
+func TestListModemsInvalid(t *testing.T) {
+	db := newTestStore(t)
+	addTestModems(t, db, queryTestModems()...)
+
+	cursor := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	for _, tt := range []struct {
+		name  string
+		query ModemQuery
+	}{
+		{"unknown sort column", ModemQuery{SortBy: "password"}},
+		{"sort injection", ModemQuery{SortBy: "mac_address; DROP TABLE modems"}},
+		{"sort by a field without a column", ModemQuery{SortBy: "Firmware"}},
+		{"cursor not base64", ModemQuery{Cursor: "not a cursor!"}},
+		{"cursor not json", ModemQuery{Cursor: cursor("{")}},
+		{"cursor object", ModemQuery{Cursor: cursor(`{"a":1}`)}},
+		{"cursor without mac", ModemQuery{Cursor: cursor(`[1]`)}},
+		{"cursor mac not a string", ModemQuery{Cursor: cursor(`[1,2]`)}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := db.ListModems(tt.query); !errors.Is(err, ErrInvalidQuery) {
+				t.Errorf("error %v, want ErrInvalidQuery", err)
+			}
+		})
+	}
+	if page, err := db.ListModems(ModemQuery{}); err != nil || page.Total != 7 {
+		t.Errorf("modems after the invalid queries: %d, %v", page.Total, err)
+	}
+}
@@ -19,5 +19,177 @@ CREATE TABLE IF NOT EXISTS modems (
     imei           	TEXT,
     iccid          	TEXT,
     imsi           	TEXT,
-	progress 		INTEGER
-);
\ No newline at end of file
+	progress 		INTEGER,
+    test_status     TEXT NOT NULL DEFAULT '',
+    version         INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE TABLE IF NOT EXISTS modem_events (
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    mac_address     TEXT NOT NULL,
+    field           TEXT NOT NULL,
+    old_value       TEXT,
+    new_value       TEXT,
+    source          TEXT NOT NULL,
+    actor           TEXT,
+    timestamp       INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS modem_events_mac_timestamp ON modem_events (mac_address, timestamp);
+
+CREATE TABLE IF NOT EXISTS orders (
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    customer        TEXT NOT NULL,
+    quantity        INTEGER NOT NULL,
+    model           TEXT,
+    firmware        TEXT,
+    config_profile  TEXT,
+    bench_from      INTEGER,
+    bench_to        INTEGER,
+    status          TEXT NOT NULL,
+    created_at      INTEGER NOT NULL,
+    closed_at       INTEGER
+);
+
+CREATE TABLE IF NOT EXISTS order_modems (
+    mac_address     TEXT NOT NULL PRIMARY KEY,
+    order_id        INTEGER NOT NULL,
+    assigned_at     INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS order_modems_order_id ON order_modems (order_id);
+
+CREATE INDEX IF NOT EXISTS modems_state ON modems (state);
+CREATE INDEX IF NOT EXISTS modems_model ON modems (model);
+CREATE INDEX IF NOT EXISTS modems_firmware ON modems (firmware);
+CREATE INDEX IF NOT EXISTS modems_switch_port ON modems (switch_port);
+CREATE INDEX IF NOT EXISTS modems_last_updated ON modems (last_updated);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    name            TEXT NOT NULL,
+    role            TEXT NOT NULL,
+    prefix          TEXT NOT NULL,
+    hash            TEXT NOT NULL UNIQUE,
+    created_at      INTEGER NOT NULL,
+    last_used       INTEGER NOT NULL DEFAULT 0,
+    revoked_at      INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS expected_units (
+    mac_address     TEXT NOT NULL PRIMARY KEY,
+    serial          TEXT NOT NULL,
+    imei            TEXT NOT NULL,
+    model           TEXT NOT NULL,
+    firmware        TEXT NOT NULL,
+    batch           TEXT NOT NULL,
+    imported_at     INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS expected_units_batch ON expected_units (batch);
+
+CREATE TABLE IF NOT EXISTS label_templates (
+    name            TEXT NOT NULL PRIMARY KEY,
+    description     TEXT NOT NULL,
+    zpl             TEXT NOT NULL,
+    updated_at      INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS label_jobs (
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    mac_address     TEXT NOT NULL,
+    template        TEXT NOT NULL,
+    printer         TEXT NOT NULL,
+    copies          INTEGER NOT NULL,
+    status          TEXT NOT NULL,
+    error           TEXT NOT NULL,
+    reprint_of      INTEGER NOT NULL,
+    actor           TEXT NOT NULL,
+    created_at      INTEGER NOT NULL,
+    printed_at      INTEGER NOT NULL,
+    zpl             TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS label_jobs_mac_address ON label_jobs (mac_address);
+CREATE INDEX IF NOT EXISTS label_jobs_status ON label_jobs (status);
+
+CREATE TABLE IF NOT EXISTS sim_jobs (
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    mac_address     TEXT NOT NULL,
+    iccid           TEXT NOT NULL,
+    provider        TEXT NOT NULL,
+    action          TEXT NOT NULL,
+    status          TEXT NOT NULL,
+    sim_status      TEXT NOT NULL,
+    attempts        INTEGER NOT NULL,
+    error           TEXT NOT NULL,
+    actor           TEXT NOT NULL,
+    created_at      INTEGER NOT NULL,
+    updated_at      INTEGER NOT NULL,
+    next_attempt_at INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS sim_jobs_mac_address ON sim_jobs (mac_address);
+CREATE INDEX IF NOT EXISTS sim_jobs_iccid ON sim_jobs (iccid);
+CREATE INDEX IF NOT EXISTS sim_jobs_status ON sim_jobs (status);
+
+CREATE TABLE IF NOT EXISTS config_profiles (
+    name            TEXT NOT NULL PRIMARY KEY,
+    description     TEXT NOT NULL,
+    settings        TEXT NOT NULL,
+    admin_password  TEXT NOT NULL,
+    updated_at      INTEGER NOT NULL,
+    unique_password BOOLEAN NOT NULL DEFAULT 0,
+    admin_password_sealed BOOLEAN NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS modem_provisioning (
+    mac_address     TEXT NOT NULL PRIMARY KEY,
+    profile         TEXT NOT NULL,
+    status          TEXT NOT NULL,
+    error           TEXT NOT NULL,
+    actor           TEXT NOT NULL,
+    started_at      INTEGER NOT NULL,
+    finished_at     INTEGER NOT NULL,
+    password_profile TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS modem_passwords (
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    mac_address     TEXT NOT NULL,
+    user            TEXT NOT NULL,
+    sealed          TEXT NOT NULL,
+    profile         TEXT NOT NULL,
+    status          TEXT NOT NULL,
+    created_at      INTEGER NOT NULL,
+    set_at          INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS modem_passwords_mac_address ON modem_passwords (mac_address);
+
+CREATE TABLE IF NOT EXISTS test_runs (
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    mac_address     TEXT NOT NULL,
+    status          TEXT NOT NULL,
+    error           TEXT NOT NULL,
+    actor           TEXT NOT NULL,
+    started_at      INTEGER NOT NULL,
+    finished_at     INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS test_runs_mac_address ON test_runs (mac_address);
+
+CREATE TABLE IF NOT EXISTS test_results (
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    run_id          INTEGER NOT NULL,
+    mac_address     TEXT NOT NULL,
+    step            TEXT NOT NULL,
+    passed          BOOLEAN NOT NULL,
+    error           TEXT NOT NULL,
+    measurements    TEXT NOT NULL,
+    started_at      INTEGER NOT NULL,
+    duration_ms     INTEGER NOT NULL,
+    attempts        INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS test_results_run_id ON test_results (run_id);
@@ -0,0 +1,149 @@
+package sqlitestore
+
+import (
+	"time"
+
+	"github.com/ebobo/modem_prod_go/pkg/metrics"
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+// maxSIMJobs limits the SIM jobs ListSIMJobs returns
+const maxSIMJobs = 1000
+
+// AddSIMJob stores a new queued SIM job, due now, and returns it with its id.
+// It returns ErrSIMJobQueued if the modem has a queued job.
+func (s *SqliteStore) AddSIMJob(job model.SIMJob) (model.SIMJob, error) {
+	defer metrics.ObserveStore("add_sim_job", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var queued int
+	err := s.db.Get(&queued, "SELECT COUNT(*) FROM sim_jobs WHERE mac_address = ? AND status = ?", job.MacAddress, model.SIMJobQueued)
+	if err != nil {
+		return job, err
+	}
+	if queued > 0 {
+		return job, ErrSIMJobQueued
+	}
+
+	now := int(time.Now().Unix())
+	job.Status = model.SIMJobQueued
+	job.SIMStatus = ""
+	job.Attempts = 0
+	job.Error = ""
+	job.CreatedAt = now
+	job.UpdatedAt = now
+	job.NextAttemptAt = now
+
+	r, err := s.db.NamedExec(
+		`INSERT INTO sim_jobs (
+			mac_address,
+			iccid,
+			provider,
+			action,
+			status,
+			sim_status,
+			attempts,
+			error,
+			actor,
+			created_at,
+			updated_at,
+			next_attempt_at)
+		 VALUES(
+			:mac_address,
+			:iccid,
+			:provider,
+			:action,
+			:status,
+			:sim_status,
+			:attempts,
+			:error,
+			:actor,
+			:created_at,
+			:updated_at,
+			:next_attempt_at)`, job)
+	if err != nil {
+		return job, translateError(err)
+	}
+	job.ID, err = r.LastInsertId()
+	return job, err
+}
+
+func (s *SqliteStore) GetSIMJob(id int64) (model.SIMJob, error) {
+	defer metrics.ObserveStore("get_sim_job", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var job model.SIMJob
+	err := s.db.QueryRowx("SELECT * FROM sim_jobs WHERE id = ?", id).StructScan(&job)
+	return job, translateError(err)
+}
+
+// ListSIMJobs returns the most recent SIM jobs first, filtered by modem and
+// status if they are not empty
+func (s *SqliteStore) ListSIMJobs(mac string, status string) ([]model.SIMJob, error) {
+	defer metrics.ObserveStore("list_sim_jobs", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	jobs := []model.SIMJob{}
+	err := s.db.Select(&jobs,
+		`SELECT * FROM sim_jobs
+		 WHERE (? = '' OR mac_address = ?) AND (? = '' OR status = ?)
+		 ORDER BY id DESC LIMIT ?`, mac, mac, status, status, maxSIMJobs)
+	return jobs, err
+}
+
+// DueSIMJobs returns the queued SIM jobs whose next attempt is due, oldest first
+func (s *SqliteStore) DueSIMJobs() ([]model.SIMJob, error) {
+	defer metrics.ObserveStore("due_sim_jobs", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	jobs := []model.SIMJob{}
+	err := s.db.Select(&jobs, "SELECT * FROM sim_jobs WHERE status = ? AND next_attempt_at <= ? ORDER BY id",
+		model.SIMJobQueued, int(time.Now().Unix()))
+	return jobs, err
+}
+
+// HasSIMJob returns whether a SIM ever had a job with the action, whatever its outcome
+func (s *SqliteStore) HasSIMJob(iccid string, action string) (bool, error) {
+	defer metrics.ObserveStore("has_sim_job", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var n int
+	err := s.db.Get(&n, "SELECT COUNT(*) FROM sim_jobs WHERE iccid = ? AND action = ?", iccid, action)
+	return n > 0, err
+}
+
+// UpdateSIMJob records the outcome of an attempt of a SIM job: its status,
+// the SIM status, attempts, error and next attempt
+func (s *SqliteStore) UpdateSIMJob(job model.SIMJob) (model.SIMJob, error) {
+	defer metrics.ObserveStore("update_sim_job", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job.UpdatedAt = int(time.Now().Unix())
+	err := CheckForZeroRowsAffected(s.db.NamedExec(
+		`UPDATE sim_jobs SET
+			status = :status,
+			sim_status = :sim_status,
+			attempts = :attempts,
+			error = :error,
+			updated_at = :updated_at,
+			next_attempt_at = :next_attempt_at
+		 WHERE id = :id`, job))
+	if err != nil {
+		return job, err
+	}
+
+	err = s.db.QueryRowx("SELECT * FROM sim_jobs WHERE id = ?", job.ID).StructScan(&job)
+	return job, translateError(err)
+}
@@ -2,10 +2,10 @@ package sqlitestore
 
 import (
 	"bufio"
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
-	"log"
 	"os"
 	"regexp"
 	"strings"
@@ -13,11 +13,17 @@ import (
 
 	_ "embed" // for side effect
 
-	_ "modernc.org/sqlite" // for side effect
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
 
 	"github.com/jmoiron/sqlx"
+
+	"github.com/ebobo/modem_prod_go/pkg/eventbus"
+	"github.com/ebobo/modem_prod_go/pkg/logging"
 )
 
+var storeLog = logging.For(logging.Store)
+
 // errors form database
 var (
 	// ErrNoRowsAffected by the operation.
@@ -32,12 +38,38 @@ var (
 	// ErrDBAlreadyClosed is returned if you call Close and the database is either already closed or it was
 	// never opened in the first place.
 	ErrDBAlreadyClosed = errors.New("database already closed")
+
+	// ErrNotFound is returned when the requested record does not exist
+	ErrNotFound = errors.New("not found")
+
+	// ErrOrderClosed is returned when modifying the modems of a closed work order
+	ErrOrderClosed = errors.New("order is closed")
+
+	// ErrVersionConflict is returned when a record was changed since the version the update is based on
+	ErrVersionConflict = errors.New("version conflict")
+
+	// ErrOrderFull is returned when assigning more modems than the work order quantity
+	ErrOrderFull = errors.New("order quantity reached")
+
+	// ErrProfileInUse is returned when deleting a config profile an open work order
+	// uses, or whose admin password modems have
+	ErrProfileInUse = errors.New("config profile is in use")
+
+	// ErrProvisioning is returned when provisioning a modem that is being provisioned
+	ErrProvisioning = errors.New("modem is being provisioned")
+
+	// ErrTesting is returned when testing a modem that is being tested
+	ErrTesting = errors.New("modem is being tested")
+
+	// ErrSIMJobQueued is returned when queueing a SIM job for a modem that has one queued
+	ErrSIMJobQueued = errors.New("a SIM job of the modem is queued")
 )
 
 type SqliteStore struct {
 	dbSpec string
 	mu     sync.RWMutex
 	db     *sqlx.DB
+	bus    *eventbus.Bus
 }
 
 var (
@@ -46,6 +78,17 @@ var (
 
 	// regexp for matching comments and empty lines
 	commentsAndEmptyLinesRegex = regexp.MustCompile("--.*?\n$|^\\s+$")
+
+	// columns added to existing tables after they were first released. Databases
+	// created before get them added on open, new databases have them in the schema.
+	addedColumns = []struct {
+		table      string
+		column     string
+		definition string
+	}{
+		{"modems", "version", "INTEGER NOT NULL DEFAULT 1"},
+		{"modems", "test_status", "TEXT NOT NULL DEFAULT ''"},
+	}
 )
 
 // New creates a new sqliteStore instance. If the database does not exist
@@ -62,6 +105,19 @@ func New(dbSpec string) (*SqliteStore, bool, error) {
 	}, created, nil
 }
 
+// SetEventBus makes the store publish every committed change on bus
+func (s *SqliteStore) SetEventBus(bus *eventbus.Bus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.bus = bus
+}
+
+// Ping checks that the database can still be reached
+func (s *SqliteStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
 // Close the sqliteStore.
 func (s *SqliteStore) Close() error {
 	return s.db.Close()
@@ -86,12 +142,18 @@ func openDB(dbSpec string) (*sqlx.DB, bool, error) {
 		return nil, false, fmt.Errorf("unable to ping database: %w", err)
 	}
 
+	// The schema only uses IF NOT EXISTS statements, so it is applied on every open
+	// to add tables introduced after the database was created.
+	err = createSchema(db)
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to create schema: %w", err)
+	}
+	err = addMissingColumns(db)
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to migrate schema: %w", err)
+	}
 	if dbNeedsCreation {
-		err := createSchema(db)
-		if err != nil {
-			return nil, false, fmt.Errorf("unable to create schema: %w", err)
-		}
-		log.Printf("created database [%s]", dbSpec)
+		storeLog.Info("created database", "file", dbSpec)
 	}
 
 	return db, dbNeedsCreation, nil
@@ -116,6 +178,35 @@ func createSchema(db *sqlx.DB) error {
 	return nil
 }
 
+// addMissingColumns adds the columns listed in addedColumns to tables lacking them
+func addMissingColumns(db *sqlx.DB) error {
+	for _, c := range addedColumns {
+		var columns []string
+		err := db.Select(&columns, "SELECT name FROM pragma_table_info(?)", c.table)
+		if err != nil {
+			return err
+		}
+
+		exists := false
+		for _, name := range columns {
+			if name == c.column {
+				exists = true
+				break
+			}
+		}
+		if exists {
+			continue
+		}
+
+		_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.column, c.definition))
+		if err != nil {
+			return fmt.Errorf("adding %s.%s failed: %w", c.table, c.column, err)
+		}
+		storeLog.Info("added column", "table", c.table, "column", c.column)
+	}
+	return nil
+}
+
 // trimCommentsAndWhitespace removes comments and superfluous whitespace
 func trimCommentsAndWhitespace(s string) string {
 	sb := strings.Builder{}
@@ -126,7 +217,7 @@ func trimCommentsAndWhitespace(s string) string {
 		b := commentsAndEmptyLinesRegex.ReplaceAll([]byte(line), nil)
 		_, err := sb.Write(b)
 		if err != nil {
-			log.Fatalf("error removing comments: %v", err)
+			panic(fmt.Sprintf("error removing comments: %v", err))
 		}
 	}
 	return sb.String()
@@ -136,7 +227,7 @@ func trimCommentsAndWhitespace(s string) string {
 // should have side-effects, an error is returned.
 func CheckForZeroRowsAffected(r sql.Result, err error) error {
 	if r == nil {
-		return err
+		return translateError(err)
 	}
 	affected, err2 := r.RowsAffected()
 	if err2 != nil {
@@ -148,3 +239,18 @@ func CheckForZeroRowsAffected(r sql.Result, err error) error {
 
 	return err
 }
+
+// translateError maps driver errors to the errors of this package
+func translateError(err error) error {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code() {
+		case sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY, sqlite3.SQLITE_CONSTRAINT_UNIQUE:
+			return fmt.Errorf("%s: %w", sqliteErr.Error(), ErrUniqueConstraintViolation)
+		}
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,40 @@
+package sqlitestore
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+func TestOpenAddsModemVersion(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "modems.db")
+	db, _, err := New(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.AddModem(model.Modem{MacAddress: "00:1f:43:00:00:01"}, model.Origin{Source: model.SourceAPI}); err != nil {
+		t.Fatal(err)
+	}
+	// As created before modems had a version
+	if _, err := db.db.Exec("ALTER TABLE modems DROP COLUMN version"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, created, err := New(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if created {
+		t.Error("database created again")
+	}
+	modem, err := db.GetModem("00:1f:43:00:00:01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if modem.Version != 1 {
+		t.Errorf("version %d, want 1", modem.Version)
+	}
+}
@@ -0,0 +1,195 @@
+package sqlitestore
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/ebobo/modem_prod_go/pkg/metrics"
+	"github.com/ebobo/modem_prod_go/pkg/model"
+)
+
+// StartTestRun records that the functional tests of a modem started, it
+// returns ErrTesting if the modem is being tested
+func (s *SqliteStore) StartTestRun(mac string, actor string) (model.TestRun, error) {
+	defer metrics.ObserveStore("start_test_run", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var running int
+	err := s.db.Get(&running, "SELECT COUNT(*) FROM test_runs WHERE mac_address = ? AND status = ?", mac, model.TestRunning)
+	if err != nil {
+		return model.TestRun{}, err
+	}
+	if running > 0 {
+		return model.TestRun{}, ErrTesting
+	}
+
+	run := model.TestRun{
+		MacAddress: mac,
+		Status:     model.TestRunning,
+		Actor:      actor,
+		StartedAt:  int(time.Now().Unix()),
+		Results:    []model.TestResult{},
+	}
+	r, err := s.db.NamedExec(
+		`INSERT INTO test_runs (
+			mac_address,
+			status,
+			error,
+			actor,
+			started_at,
+			finished_at)
+		 VALUES(
+			:mac_address,
+			:status,
+			:error,
+			:actor,
+			:started_at,
+			:finished_at)`, run)
+	if err != nil {
+		return run, translateError(err)
+	}
+	run.ID, err = r.LastInsertId()
+	return run, err
+}
+
+// AddTestResult records the outcome of one step of a test run
+func (s *SqliteStore) AddTestResult(result model.TestResult) (model.TestResult, error) {
+	defer metrics.ObserveStore("add_test_result", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, err := s.db.NamedExec(
+		`INSERT INTO test_results (
+			run_id,
+			mac_address,
+			step,
+			passed,
+			error,
+			measurements,
+			started_at,
+			duration_ms,
+			attempts)
+		 VALUES(
+			:run_id,
+			:mac_address,
+			:step,
+			:passed,
+			:error,
+			:measurements,
+			:started_at,
+			:duration_ms,
+			:attempts)`, result)
+	if err != nil {
+		return result, translateError(err)
+	}
+	result.ID, err = r.LastInsertId()
+	return result, err
+}
+
+// FinishTestRun records the outcome of a test run, errMsg says why it could not
+// be completed. It returns the run with its results.
+func (s *SqliteStore) FinishTestRun(id int64, status string, errMsg string) (model.TestRun, error) {
+	defer metrics.ObserveStore("finish_test_run", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := CheckForZeroRowsAffected(s.db.Exec(
+		"UPDATE test_runs SET status = ?, error = ?, finished_at = ? WHERE id = ?",
+		status, errMsg, int(time.Now().Unix()), id))
+	if err != nil {
+		return model.TestRun{}, err
+	}
+
+	var run model.TestRun
+	err = s.db.QueryRowx("SELECT * FROM test_runs WHERE id = ?", id).StructScan(&run)
+	if err != nil {
+		return run, translateError(err)
+	}
+	return run, addTestResults(s.db, []*model.TestRun{&run})
+}
+
+// GetTestRun returns the latest test run of a modem with its results
+func (s *SqliteStore) GetTestRun(mac string) (model.TestRun, error) {
+	defer metrics.ObserveStore("get_test_run", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var run model.TestRun
+	err := s.db.QueryRowx("SELECT * FROM test_runs WHERE mac_address = ? ORDER BY id DESC LIMIT 1", mac).StructScan(&run)
+	if err != nil {
+		return run, translateError(err)
+	}
+	return run, addTestResults(s.db, []*model.TestRun{&run})
+}
+
+// ListTestRuns returns the test runs of a modem with their results, newest first
+func (s *SqliteStore) ListTestRuns(mac string) ([]model.TestRun, error) {
+	defer metrics.ObserveStore("list_test_runs", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	runs := []model.TestRun{}
+	err := s.db.Select(&runs, "SELECT * FROM test_runs WHERE mac_address = ? ORDER BY id DESC", mac)
+	if err != nil {
+		return runs, err
+	}
+	ptrs := make([]*model.TestRun, len(runs))
+	for i := range runs {
+		ptrs[i] = &runs[i]
+	}
+	return runs, addTestResults(s.db, ptrs)
+}
+
+// InterruptTestRuns marks test runs that were running when the server stopped
+// as failed and returns them
+func (s *SqliteStore) InterruptTestRuns() ([]model.TestRun, error) {
+	defer metrics.ObserveStore("interrupt_test_runs", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	runs := []model.TestRun{}
+	err := s.db.Select(&runs, "SELECT * FROM test_runs WHERE status = ?", model.TestRunning)
+	if err != nil || len(runs) == 0 {
+		return runs, err
+	}
+	_, err = s.db.Exec(
+		"UPDATE test_runs SET status = ?, error = ?, finished_at = ? WHERE status = ?",
+		model.TestFailed, "interrupted by a server restart", int(time.Now().Unix()), model.TestRunning)
+	return runs, err
+}
+
+// addTestResults fills in the results of the runs in the order the steps ran
+func addTestResults(q sqlx.Queryer, runs []*model.TestRun) error {
+	byID := make(map[int64]*model.TestRun, len(runs))
+	ids := make([]int64, 0, len(runs))
+	for _, run := range runs {
+		run.Results = []model.TestResult{}
+		byID[run.ID] = run
+		ids = append(ids, run.ID)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	query, args, err := sqlx.In("SELECT * FROM test_results WHERE run_id IN (?) ORDER BY id", ids)
+	if err != nil {
+		return err
+	}
+	results := []model.TestResult{}
+	if err := sqlx.Select(q, &results, query, args...); err != nil {
+		return err
+	}
+	for _, result := range results {
+		run := byID[result.RunID]
+		run.Results = append(run.Results, result)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package utility
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// MergePatch applies a JSON merge patch (RFC 7396) to the JSON document target
+// and returns the patched document.
+func MergePatch(target []byte, patch []byte) ([]byte, error) {
+	var t interface{}
+	if len(bytes.TrimSpace(target)) > 0 {
+		if err := unmarshalNumbers(target, &t); err != nil {
+			return nil, err
+		}
+	}
+
+	var p interface{}
+	if err := unmarshalNumbers(patch, &p); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(mergeValue(t, p))
+}
+
+// mergeValue implements the MergePatch function of RFC 7396 section 2
+func mergeValue(target interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+			continue
+		}
+		targetObject[name] = mergeValue(targetObject[name], value)
+	}
+	return targetObject
+}
+
+// unmarshalNumbers keeps numbers as json.Number so integers survive the round trip unchanged
+func unmarshalNumbers(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
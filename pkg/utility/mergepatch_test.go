@@ -0,0 +1,80 @@
+package utility
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	const target = `{"model":"TRB140","switch_port":3,"upgraded":true,"serial":"1100123456","id":9007199254740993}`
+
+	for _, tt := range []struct {
+		name   string
+		target string
+		patch  string
+		want   string
+	}{
+		{"field omitted", target, `{}`, target},
+		{"field changed", target, `{"serial":"1100999999"}`,
+			`{"model":"TRB140","switch_port":3,"upgraded":true,"serial":"1100999999","id":9007199254740993}`},
+		{"explicit 0", target, `{"switch_port":0}`,
+			`{"model":"TRB140","switch_port":0,"upgraded":true,"serial":"1100123456","id":9007199254740993}`},
+		{"explicit false", target, `{"upgraded":false}`,
+			`{"model":"TRB140","switch_port":3,"upgraded":false,"serial":"1100123456","id":9007199254740993}`},
+		{"explicit empty string", target, `{"model":""}`,
+			`{"model":"","switch_port":3,"upgraded":true,"serial":"1100123456","id":9007199254740993}`},
+		{"null removes", target, `{"switch_port":null,"upgraded":null}`,
+			`{"model":"TRB140","serial":"1100123456","id":9007199254740993}`},
+		{"null of a missing field", target, `{"kernel":null}`, target},
+		{"field added", target, `{"kernel":"5.4"}`,
+			`{"model":"TRB140","switch_port":3,"upgraded":true,"serial":"1100123456","id":9007199254740993,"kernel":"5.4"}`},
+		{"nested objects merge", `{"a":{"b":1,"c":2}}`, `{"a":{"b":null,"d":3}}`, `{"a":{"c":2,"d":3}}`},
+		{"arrays are replaced", `{"a":[1,2,3]}`, `{"a":[4]}`, `{"a":[4]}`},
+		{"not an object replaces", target, `[1]`, `[1]`},
+		{"empty target", ``, `{"a":1,"b":null}`, `{"a":1}`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MergePatch([]byte(tt.target), []byte(tt.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var gotValue, wantValue interface{}
+			if err := unmarshalNumbers(got, &gotValue); err != nil {
+				t.Fatal(err)
+			}
+			if err := unmarshalNumbers([]byte(tt.want), &wantValue); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(gotValue, wantValue) {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergePatchInvalid(t *testing.T) {
+	for _, tt := range []struct{ target, patch string }{
+		{`{}`, `{"a":`},
+		{`{"a"}`, `{}`},
+	} {
+		if _, err := MergePatch([]byte(tt.target), []byte(tt.patch)); err == nil {
+			t.Errorf("MergePatch(%s, %s) succeeded", tt.target, tt.patch)
+		}
+	}
+}
+
+// Large integers must survive the round trip, they would lose precision as float64
+func TestMergePatchKeepsIntegers(t *testing.T) {
+	got, err := MergePatch([]byte(`{"id":9007199254740993}`), []byte(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v struct{ ID int64 }
+	if err := json.Unmarshal(got, &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.ID != 9007199254740993 {
+		t.Errorf("id %d", v.ID)
+	}
+}
@@ -43,7 +43,6 @@ func GenerateFakeModems(num int) []model.Modem {
 			Upgraded:    false,
 			LastUpdated: int(time.Now().Unix()),
 			FailCount:   0,
-			SIMProvider: "Twilio",
 			SIMStatus:   false,
 			IMEI:        generateIMEI(),
 			ICCID:       generateICCID(),
@@ -3,6 +3,13 @@ package version
 // Number holds the version number for the application.
 var Number = "0.0.2"
 
+// Commit and BuildTime are set at build time with
+// -ldflags "-X github.com/ebobo/modem_prod_go/pkg/version.Commit=..."
+var (
+	Commit    = "unknown"
+	BuildTime = "unknown"
+)
+
 // Version returns the version number
 func Version() string {
 	return Number